@@ -47,7 +47,11 @@ output can still be generated.
 
 The -e flag can be used to pass extra arguments to the converter
 which were used by the original format. Multiple arguments can be passed
-by separating them with a space.`,
+by separating them with a space.
+
+The --debug-output flag can be used to also write a variant of the
+converted file with live debugging enabled, where the components
+supporting live debugging are annotated with a comment.`,
 		Args:         cobra.RangeArgs(0, 1),
 		SilenceUsage: true,
 
@@ -78,6 +82,7 @@ by separating them with a space.`,
 	cmd.Flags().StringVarP(&f.sourceFormat, "source-format", "f", f.sourceFormat, fmt.Sprintf("The format of the source file. Supported formats: %s.", supportedFormatsList()))
 	cmd.Flags().BoolVarP(&f.bypassErrors, "bypass-errors", "b", f.bypassErrors, "Enable bypassing errors when converting")
 	cmd.Flags().StringVarP(&f.extraArgs, "extra-args", "e", f.extraArgs, "Extra arguments from the original format used by the converter. Multiple arguments can be passed by separating them with a space.")
+	cmd.Flags().StringVar(&f.debugOutput, "debug-output", f.debugOutput, "The filepath and filename where a variant of the output with live debugging enabled is written.")
 	return cmd
 }
 
@@ -87,6 +92,7 @@ type alloyConvert struct {
 	sourceFormat string
 	bypassErrors bool
 	extraArgs    string
+	debugOutput  string
 }
 
 func (fc *alloyConvert) Run(configFile string) error {
@@ -125,7 +131,16 @@ func convert(r io.Reader, fc *alloyConvert) error {
 		return err
 	}
 
-	alloyBytes, diags := converter.Convert(inputBytes, converter.Input(fc.sourceFormat), ea)
+	var (
+		alloyBytes []byte
+		debugBytes []byte
+		diags      convert_diag.Diagnostics
+	)
+	if fc.debugOutput != "" {
+		alloyBytes, debugBytes, diags = converter.ConvertWithDebugVariant(inputBytes, converter.Input(fc.sourceFormat), ea)
+	} else {
+		alloyBytes, diags = converter.Convert(inputBytes, converter.Input(fc.sourceFormat), ea)
+	}
 	err = generateConvertReport(diags, fc)
 	if err != nil {
 		return err
@@ -137,6 +152,12 @@ func convert(r io.Reader, fc *alloyConvert) error {
 		return diags
 	}
 
+	if fc.debugOutput != "" {
+		if err := os.WriteFile(fc.debugOutput, debugBytes, 0644); err != nil {
+			return err
+		}
+	}
+
 	var buf bytes.Buffer
 	buf.WriteString(string(alloyBytes))
 
@@ -81,6 +81,7 @@ func New(o component.Options, args Arguments) (*Component, error) {
 		opts:    o,
 		handler: loki.NewLogsReceiver(),
 		fanout:  args.ForwardTo,
+		metrics: kt.NewMetrics(o.Registerer),
 	}
 
 	// Call to Update() to start readers and set receivers once at the start.
@@ -98,6 +99,7 @@ type Component struct {
 	fanout  []loki.LogsReceiver
 	handler loki.LogsReceiver
 	target  *kt.TargetSyncer
+	metrics *kt.Metrics
 }
 
 // Run implements component.Component.
@@ -145,7 +147,7 @@ func (c *Component) Update(args component.Arguments) error {
 	}
 
 	entryHandler := loki.NewEntryHandler(c.handler.Chan(), func() {})
-	t, err := kt.NewSyncer(c.opts.Logger, cfg, entryHandler, &parser.AzureEventHubsTargetMessageParser{
+	t, err := kt.NewSyncer(c.metrics, c.opts.Logger, cfg, entryHandler, &parser.AzureEventHubsTargetMessageParser{
 		DisallowCustomMessages: newArgs.DisallowCustomMessages,
 	})
 	if err != nil {
@@ -0,0 +1,124 @@
+package kafkatarget
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+
+	"github.com/grafana/alloy/internal/runtime/logging/level"
+)
+
+// PartitionAssignment is a partition of a topic which is consumed directly,
+// bypassing consumer groups.
+type PartitionAssignment struct {
+	Topic     string `yaml:"topic"`
+	Partition int32  `yaml:"partition"`
+	// Offset is the offset of the first message to consume, or one of
+	// sarama.OffsetOldest and sarama.OffsetNewest.
+	Offset int64 `yaml:"offset"`
+}
+
+// ParsePartitionAssignment parses a partition assignment written as
+// topic:partition:offset, where offset can also be oldest or newest.
+func ParsePartitionAssignment(s string) (PartitionAssignment, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return PartitionAssignment{}, fmt.Errorf("invalid partition assignment %q: expected topic:partition:offset", s)
+	}
+
+	partition, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil || partition < 0 {
+		return PartitionAssignment{}, fmt.Errorf("invalid partition in partition assignment %q", s)
+	}
+
+	var offset int64
+	switch parts[2] {
+	case "oldest":
+		offset = sarama.OffsetOldest
+	case "newest":
+		offset = sarama.OffsetNewest
+	default:
+		offset, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || offset < 0 {
+			return PartitionAssignment{}, fmt.Errorf("invalid offset in partition assignment %q: expected a positive number, oldest, or newest", s)
+		}
+	}
+
+	return PartitionAssignment{
+		Topic:     parts[0],
+		Partition: int32(partition),
+		Offset:    offset,
+	}, nil
+}
+
+// consumeAssignments consumes the partitions assigned in the config with c
+// until the syncer is stopped. A target is created for each partition, like
+// for the claims of a consumer group.
+func (ts *TargetSyncer) consumeAssignments(c sarama.Consumer) error {
+	var pcs []sarama.PartitionConsumer
+	for _, a := range ts.cfg.KafkaConfig.Assignments {
+		pc, err := c.ConsumePartition(a.Topic, a.Partition, a.Offset)
+		if err != nil {
+			for _, pc := range pcs {
+				pc.AsyncClose()
+			}
+			return fmt.Errorf("error consuming partition %d of topic %s: %w", a.Partition, a.Topic, err)
+		}
+		pcs = append(pcs, pc)
+	}
+	ts.reportHealthy()
+
+	session := &assignedSession{ctx: ts.ctx}
+	for i, pc := range pcs {
+		claim := &assignedClaim{PartitionConsumer: pc, assignment: ts.cfg.KafkaConfig.Assignments[i]}
+		ts.wg.Add(1)
+		go func() {
+			defer ts.wg.Done()
+			if err := ts.ConsumeClaim(session, claim); err != nil {
+				level.Error(ts.logger).Log("msg", "error consuming assigned partition", "topic", claim.Topic(), "partition", claim.Partition(), "err", err)
+			}
+		}()
+	}
+
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		<-ts.ctx.Done()
+		// Closing the partition consumers closes their message channels,
+		// which stops the targets.
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+	}()
+	return nil
+}
+
+// assignedClaim is a sarama.ConsumerGroupClaim for a partition consumed
+// without a consumer group.
+type assignedClaim struct {
+	sarama.PartitionConsumer
+	assignment PartitionAssignment
+}
+
+func (c *assignedClaim) Topic() string        { return c.assignment.Topic }
+func (c *assignedClaim) Partition() int32     { return c.assignment.Partition }
+func (c *assignedClaim) InitialOffset() int64 { return c.assignment.Offset }
+
+// assignedSession is a sarama.ConsumerGroupSession for partitions consumed
+// without a consumer group. There's no group to commit offsets to, so marking
+// messages and offsets is a no-op.
+type assignedSession struct {
+	ctx context.Context
+}
+
+func (s *assignedSession) Claims() map[string][]int32                  { return nil }
+func (s *assignedSession) MemberID() string                            { return "" }
+func (s *assignedSession) GenerationID() int32                         { return 0 }
+func (s *assignedSession) MarkOffset(string, int32, int64, string)     {}
+func (s *assignedSession) Commit()                                     {}
+func (s *assignedSession) ResetOffset(string, int32, int64, string)    {}
+func (s *assignedSession) MarkMessage(*sarama.ConsumerMessage, string) {}
+func (s *assignedSession) Context() context.Context                    { return s.ctx }
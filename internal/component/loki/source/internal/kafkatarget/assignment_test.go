@@ -0,0 +1,117 @@
+package kafkatarget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/alloy/internal/component/common/loki/client/fake"
+)
+
+func TestParsePartitionAssignment(t *testing.T) {
+	a, err := ParsePartitionAssignment("logs:2:100")
+	require.NoError(t, err)
+	require.Equal(t, PartitionAssignment{Topic: "logs", Partition: 2, Offset: 100}, a)
+
+	a, err = ParsePartitionAssignment("logs:0:oldest")
+	require.NoError(t, err)
+	require.Equal(t, PartitionAssignment{Topic: "logs", Partition: 0, Offset: sarama.OffsetOldest}, a)
+
+	a, err = ParsePartitionAssignment("logs:0:newest")
+	require.NoError(t, err)
+	require.Equal(t, PartitionAssignment{Topic: "logs", Partition: 0, Offset: sarama.OffsetNewest}, a)
+
+	for _, invalid := range []string{"logs", "logs:0", ":0:1", "logs:x:1", "logs:-1:1", "logs:0:-5", "logs:0:latest", "logs:0:1:2"} {
+		_, err := ParsePartitionAssignment(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func Test_ConsumeAssignments(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	fc := fake.NewClient(func() {})
+	ts := &TargetSyncer{
+		ctx:     ctx,
+		cancel:  cancel,
+		metrics: NewMetrics(nil),
+		logger:  log.NewNopLogger(),
+		client:  fc,
+		close:   func() error { return nil },
+		consumer: consumer{
+			ctx:    t.Context(),
+			cancel: func() {},
+			logger: log.NewNopLogger(),
+		},
+		cfg: Config{
+			RelabelConfigs: []*relabel.Config{
+				{
+					SourceLabels: model.LabelNames{"__meta_kafka_partition"},
+					TargetLabel:  "partition",
+					Replacement:  "$1",
+					Action:       relabel.Replace,
+					Regex:        relabel.MustNewRegexp("(.*)"),
+				},
+			},
+			KafkaConfig: TargetConfig{
+				Assignments: []PartitionAssignment{{Topic: "logs", Partition: 1, Offset: 5}},
+			},
+		},
+		messageParser: &KafkaTargetMessageParser{},
+	}
+	ts.discoverer = ts
+
+	// The mock fails the test if any other partition or offset is consumed.
+	mockConsumer := mocks.NewConsumer(t, nil)
+	pc := mockConsumer.ExpectConsumePartition("logs", 1, 5)
+	pc.YieldMessage(&sarama.ConsumerMessage{Topic: "logs", Partition: 1, Offset: 5, Value: []byte("first")})
+	pc.YieldMessage(&sarama.ConsumerMessage{Topic: "logs", Partition: 1, Offset: 6, Value: []byte("second")})
+
+	require.NoError(t, ts.consumeAssignments(mockConsumer))
+	require.Eventually(t, func() bool {
+		return len(fc.Received()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, ts.Stop())
+	require.NoError(t, mockConsumer.Close())
+
+	received := fc.Received()
+	require.Equal(t, "first", received[0].Line)
+	require.Equal(t, "second", received[1].Line)
+	for _, e := range received {
+		require.Equal(t, model.LabelSet{"partition": "1"}, e.Labels)
+	}
+
+	targets := ts.getActiveTargets()
+	require.Len(t, targets, 1)
+	require.Equal(t, model.LabelSet{"__meta_kafka_topic": "logs", "__meta_kafka_partition": "1"}, targets[0].DiscoveredLabels())
+}
+
+func Test_ConsumeAssignmentsError(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	ts := &TargetSyncer{
+		ctx:    ctx,
+		cancel: cancel,
+		logger: log.NewNopLogger(),
+		cfg: Config{
+			KafkaConfig: TargetConfig{
+				Assignments: []PartitionAssignment{
+					{Topic: "logs", Partition: 0, Offset: sarama.OffsetOldest},
+					{Topic: "logs", Partition: 0, Offset: sarama.OffsetOldest},
+				},
+			},
+		},
+	}
+
+	// Consuming the same partition twice fails.
+	mockConsumer := mocks.NewConsumer(t, nil)
+	mockConsumer.ExpectConsumePartition("logs", 0, sarama.OffsetOldest)
+	require.ErrorContains(t, ts.consumeAssignments(mockConsumer), "error consuming partition 0 of topic logs")
+}
@@ -1,6 +1,9 @@
 package kafkatarget
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/IBM/sarama"
 	"github.com/grafana/alloy/internal/component/common/loki"
 	"github.com/grafana/dskit/flagext"
@@ -30,9 +33,13 @@ type TargetConfig struct {
 	// The consumer group id (Required).
 	GroupID string `yaml:"group_id"`
 
-	// Kafka Topics to consume (Required).
+	// Kafka Topics to consume (Required unless Assignments is set).
 	Topics []string `yaml:"topics"`
 
+	// Assignments are partitions consumed directly, without a consumer
+	// group. They can't be used together with Topics and GroupID.
+	Assignments []PartitionAssignment `yaml:"assignments"`
+
 	// Kafka version. Default to 2.2.1
 	Version string `yaml:"version"`
 
@@ -42,9 +49,117 @@ type TargetConfig struct {
 	// Authentication strategy with Kafka brokers
 	Authentication Authentication `yaml:"authentication"`
 
+	// Metadata configures how the cluster metadata is refreshed.
+	Metadata MetadataConfig `yaml:"metadata"`
+
+	// ConsumerGroup configures the timeouts of the consumer group session.
+	ConsumerGroup ConsumerGroupConfig `yaml:"consumer_group"`
+
 	MessageParser MessageParser
 }
 
+// Defaults and minimums of MetadataConfig. The defaults are the ones of
+// sarama.
+const (
+	DefaultMetadataRefreshFrequency = 10 * time.Minute
+	DefaultMetadataRetryMax         = 3
+	DefaultMetadataRetryBackoff     = 250 * time.Millisecond
+
+	MinMetadataRefreshFrequency = time.Second
+	MinMetadataRetryBackoff     = 10 * time.Millisecond
+)
+
+// MetadataConfig configures how the client refreshes the metadata of the
+// cluster, which tells it the leader of each partition. Refreshing it
+// frequently makes the client fail over to a new leader sooner when a broker
+// dies. Zero values use the default of each field.
+type MetadataConfig struct {
+	// RefreshFrequency is how often the metadata of the cluster is refreshed
+	// in the background. Defaults to DefaultMetadataRefreshFrequency.
+	RefreshFrequency time.Duration `yaml:"refresh_frequency"`
+
+	// RetryMax is how many times a metadata request is retried when the
+	// cluster is in the middle of a leader election. Defaults to
+	// DefaultMetadataRetryMax.
+	RetryMax int `yaml:"retry_max"`
+
+	// RetryBackoff is how long to wait before retrying a metadata request.
+	// Defaults to DefaultMetadataRetryBackoff.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+// Validate returns an error if a non-zero value of c is below its minimum.
+func (c MetadataConfig) Validate() error {
+	if c.RefreshFrequency != 0 && c.RefreshFrequency < MinMetadataRefreshFrequency {
+		return fmt.Errorf("metadata refresh frequency must be at least %s, got %s", MinMetadataRefreshFrequency, c.RefreshFrequency)
+	}
+	if c.RetryMax < 0 {
+		return fmt.Errorf("metadata retry max must not be negative, got %d", c.RetryMax)
+	}
+	if c.RetryBackoff != 0 && c.RetryBackoff < MinMetadataRetryBackoff {
+		return fmt.Errorf("metadata retry backoff must be at least %s, got %s", MinMetadataRetryBackoff, c.RetryBackoff)
+	}
+	return nil
+}
+
+// Defaults of ConsumerGroupConfig, which are the ones of sarama.
+const (
+	DefaultSessionTimeout    = 10 * time.Second
+	DefaultRebalanceTimeout  = 60 * time.Second
+	DefaultMaxProcessingTime = 100 * time.Millisecond
+)
+
+// ConsumerGroupConfig configures the timeouts of the consumer group session.
+// Slow forwarding of the consumed messages can make the consumer miss its
+// deadlines, which makes the broker evict it and rebalance the group. Zero
+// values use the default of each field.
+type ConsumerGroupConfig struct {
+	// SessionTimeout is how long the broker waits for a heartbeat of the
+	// consumer before evicting it. Defaults to DefaultSessionTimeout.
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+
+	// RebalanceTimeout is how long the consumer has to finish processing
+	// its messages and rejoin the group during a rebalance. Defaults to
+	// DefaultRebalanceTimeout.
+	RebalanceTimeout time.Duration `yaml:"rebalance_timeout"`
+
+	// MaxProcessingTime is how long the processing of a message may take
+	// before the consumer stops fetching its partition. Defaults to
+	// DefaultMaxProcessingTime.
+	MaxProcessingTime time.Duration `yaml:"max_processing_time"`
+}
+
+// withDefaults returns c with its zero values replaced by their default.
+func (c ConsumerGroupConfig) withDefaults() ConsumerGroupConfig {
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = DefaultSessionTimeout
+	}
+	if c.RebalanceTimeout == 0 {
+		c.RebalanceTimeout = DefaultRebalanceTimeout
+	}
+	if c.MaxProcessingTime == 0 {
+		c.MaxProcessingTime = DefaultMaxProcessingTime
+	}
+	return c
+}
+
+// Validate returns an error if a value of c is negative, or if the timeouts
+// are inconsistent once defaulted: the rebalance timeout must be at least the
+// session timeout, and the max processing time below the rebalance timeout.
+func (c ConsumerGroupConfig) Validate() error {
+	if c.SessionTimeout < 0 || c.RebalanceTimeout < 0 || c.MaxProcessingTime < 0 {
+		return fmt.Errorf("consumer group timeouts must not be negative")
+	}
+	c = c.withDefaults()
+	if c.RebalanceTimeout < c.SessionTimeout {
+		return fmt.Errorf("consumer group rebalance timeout (%s) must be at least the session timeout (%s)", c.RebalanceTimeout, c.SessionTimeout)
+	}
+	if c.MaxProcessingTime >= c.RebalanceTimeout {
+		return fmt.Errorf("consumer group max processing time (%s) must be below the rebalance timeout (%s)", c.MaxProcessingTime, c.RebalanceTimeout)
+	}
+	return nil
+}
+
 // AuthenticationType specifies method to authenticate with Kafka brokers
 type AuthenticationType string
 
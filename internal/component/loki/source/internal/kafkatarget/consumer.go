@@ -6,6 +6,7 @@ package kafkatarget
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/grafana/dskit/backoff"
 	"github.com/grafana/loki/v3/clients/pkg/promtail/targets/target"
 
+	"github.com/grafana/alloy/internal/component"
 	"github.com/grafana/alloy/internal/runtime/logging/level"
 )
 
@@ -24,6 +26,10 @@ var defaultBackOff = backoff.Config{
 	MaxRetries: 20,
 }
 
+// defaultRebalanceRetryDelay is how long the consumer waits before re-joining
+// the group after a rebalance interrupted it.
+const defaultRebalanceRetryDelay = 100 * time.Millisecond
+
 type RunnableTarget interface {
 	target.Target
 	run()
@@ -47,6 +53,20 @@ type consumer struct {
 	mutex          sync.Mutex // used during rebalancing setup and tear down
 	activeTargets  []target.Target
 	droppedTargets []target.Target
+
+	// health reflects whether the consumer can reach the brokers. It's
+	// unhealthy while consuming fails and is retried, and healthy once a
+	// session was set up.
+	healthMut sync.RWMutex
+	health    component.Health
+
+	// backoff configures the retries after a consumer error. Defaults to
+	// defaultBackOff if unset.
+	backoff *backoff.Config
+	// rebalanceRetryDelay is the delay before re-joining the group after a
+	// rebalance. Rebalances are expected and don't count towards backoff
+	// retries. Defaults to defaultRebalanceRetryDelay if unset.
+	rebalanceRetryDelay time.Duration
 }
 
 // start starts the consumer for a given list of topics.
@@ -59,19 +79,42 @@ func (c *consumer) start(ctx context.Context, topics []string) {
 
 	go func() {
 		defer c.wg.Done()
-		backoff := backoff.New(c.ctx, defaultBackOff)
+		backoffCfg := defaultBackOff
+		if c.backoff != nil {
+			backoffCfg = *c.backoff
+		}
+		rebalanceRetryDelay := c.rebalanceRetryDelay
+		if rebalanceRetryDelay == 0 {
+			rebalanceRetryDelay = defaultRebalanceRetryDelay
+		}
+
+		backoff := backoff.New(c.ctx, backoffCfg)
 		for {
 			// Calling Consume in an infinite loop in case rebalancing is kicking in.
 			// In which case all claims will be renewed.
 			err := c.ConsumerGroup.Consume(c.ctx, topics, c)
+			if errors.Is(err, sarama.ErrRebalanceInProgress) {
+				// A rebalance isn't a failure: re-join the group as soon as
+				// possible without backing off.
+				level.Debug(c.logger).Log("msg", "consumer group is rebalancing, re-joining", "err", err)
+				select {
+				case <-c.ctx.Done():
+					level.Info(c.logger).Log("msg", "stopping consumer", "topics", fmt.Sprintf("%+v", topics))
+					return
+				case <-time.After(rebalanceRetryDelay):
+					continue
+				}
+			}
 			if err != nil && err != context.Canceled {
 				level.Error(c.logger).Log("msg", "error from the consumer, retrying...", "err", err)
+				c.reportUnhealthy(fmt.Sprintf("error from the consumer, retrying: %s", err))
 				// backoff before re-trying.
 				backoff.Wait()
 				if backoff.Ongoing() {
 					continue
 				}
 				level.Error(c.logger).Log("msg", "maximum error from the consumer reached", "last_err", err)
+				c.reportUnhealthy(fmt.Sprintf("maximum errors from the consumer reached, stopped retrying: %s", err))
 				return
 			}
 			if c.ctx.Err() != nil || err == context.Canceled {
@@ -107,6 +150,7 @@ func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 // Setup is run at the beginning of a new session, before ConsumeClaim
 func (c *consumer) Setup(session sarama.ConsumerGroupSession) error {
 	c.resetTargets()
+	c.reportHealthy()
 	return nil
 }
 
@@ -153,3 +197,31 @@ func (c *consumer) addDroppedTarget(t target.Target) {
 	defer c.mutex.Unlock()
 	c.droppedTargets = append(c.droppedTargets, t)
 }
+
+func (c *consumer) reportUnhealthy(msg string) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = component.Health{
+		Health:     component.HealthTypeUnhealthy,
+		Message:    msg,
+		UpdateTime: time.Now(),
+	}
+}
+
+func (c *consumer) reportHealthy() {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    "consuming",
+		UpdateTime: time.Now(),
+	}
+}
+
+// CurrentHealth returns the health of the consumer. It's unknown until the
+// consumer either joins a consumer group or fails to.
+func (c *consumer) CurrentHealth() component.Health {
+	c.healthMut.RLock()
+	defer c.healthMut.RUnlock()
+	return c.health
+}
@@ -12,10 +12,13 @@ import (
 
 	"github.com/IBM/sarama"
 	"github.com/go-kit/log"
+	"github.com/grafana/dskit/backoff"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/loki/v3/clients/pkg/promtail/targets/target"
+
+	"github.com/grafana/alloy/internal/component"
 )
 
 type DiscovererFn func(sarama.ConsumerGroupSession, sarama.ConsumerGroupClaim) (RunnableTarget, error)
@@ -105,3 +108,76 @@ func Test_ConsumerRetry(t *testing.T) {
 	<-time.After(2 * time.Second)
 	c.stop()
 }
+
+func Test_ConsumerHealth(t *testing.T) {
+	var (
+		group = &testConsumerGroupHandler{
+			returnErr: sarama.ErrOutOfBrokers,
+			failCalls: 2,
+		}
+		c = &consumer{
+			logger:        log.NewNopLogger(),
+			ctx:           t.Context(),
+			cancel:        func() {},
+			ConsumerGroup: group,
+			backoff: &backoff.Config{
+				MinBackoff: 500 * time.Millisecond,
+				MaxBackoff: 500 * time.Millisecond,
+				MaxRetries: 20,
+			},
+		}
+	)
+	require.Equal(t, component.HealthTypeUnknown, c.CurrentHealth().Health)
+
+	c.start(t.Context(), []string{"foo"})
+	defer c.stop()
+
+	// The consumer is unhealthy while it can't reach the brokers.
+	require.Eventually(t, func() bool {
+		return c.CurrentHealth().Health == component.HealthTypeUnhealthy
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Contains(t, c.CurrentHealth().Message, sarama.ErrOutOfBrokers.Error())
+
+	// It recovers once it joins the group.
+	require.Eventually(t, group.consuming.Load, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, component.HealthTypeUnhealthy, c.CurrentHealth().Health)
+	require.NoError(t, group.handler.Setup(&testSession{}))
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.Equal(t, int32(3), group.calls.Load())
+}
+
+func Test_ConsumerRetryRebalance(t *testing.T) {
+	newConsumer := func(returnErr error) (*consumer, *testConsumerGroupHandler) {
+		group := &testConsumerGroupHandler{returnErr: returnErr}
+		return &consumer{
+			logger:        log.NewNopLogger(),
+			ctx:           t.Context(),
+			cancel:        func() {},
+			ConsumerGroup: group,
+			backoff: &backoff.Config{
+				MinBackoff: time.Minute,
+				MaxBackoff: time.Minute,
+				MaxRetries: 20,
+			},
+			rebalanceRetryDelay: 10 * time.Millisecond,
+		}, group
+	}
+
+	// A rebalance re-joins the group quickly, without backing off.
+	rebalancing, rebalancingGroup := newConsumer(sarama.ErrRebalanceInProgress)
+	rebalancing.start(t.Context(), []string{"foo"})
+	require.Eventually(t, func() bool {
+		return rebalancingGroup.calls.Load() >= 5
+	}, 5*time.Second, 10*time.Millisecond)
+	rebalancing.stop()
+
+	// A connection error backs off before retrying.
+	failing, failingGroup := newConsumer(sarama.ErrOutOfBrokers)
+	failing.start(t.Context(), []string{"foo"})
+	require.Eventually(t, func() bool {
+		return failingGroup.calls.Load() >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int32(1), failingGroup.calls.Load())
+	failing.stop()
+}
@@ -29,6 +29,7 @@ func (d *runnableDroppedTarget) run() {
 }
 
 type KafkaTarget struct {
+	metrics              *Metrics
 	logger               log.Logger
 	discoveredLabels     model.LabelSet
 	lbs                  model.LabelSet
@@ -42,6 +43,7 @@ type KafkaTarget struct {
 }
 
 func NewKafkaTarget(
+	metrics *Metrics,
 	logger log.Logger,
 	session sarama.ConsumerGroupSession,
 	claim sarama.ConsumerGroupClaim,
@@ -53,6 +55,7 @@ func NewKafkaTarget(
 ) *KafkaTarget {
 
 	return &KafkaTarget{
+		metrics:              metrics,
 		logger:               logger,
 		discoveredLabels:     discoveredLabels,
 		lbs:                  lbs,
@@ -74,7 +77,14 @@ const (
 
 func (t *KafkaTarget) run() {
 	defer t.client.Stop()
+
+	consumedMessages := t.metrics.consumedMessages.WithLabelValues(t.claim.Topic())
+	consumedBytes := t.metrics.consumedBytes.WithLabelValues(t.claim.Topic())
+
 	for message := range t.claim.Messages() {
+		consumedMessages.Inc()
+		consumedBytes.Add(float64(len(message.Value)))
+
 		mk := string(message.Key)
 		if len(mk) == 0 {
 			mk = defaultKafkaMessageKey
@@ -6,7 +6,10 @@ package kafkatarget
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -14,6 +17,9 @@ import (
 	"github.com/grafana/alloy/internal/component/common/loki/client/fake"
 
 	"github.com/IBM/sarama"
+	"github.com/linkedin/goavro/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/stretchr/testify/require"
@@ -27,13 +33,17 @@ type testConsumerGroupHandler struct {
 	topics  []string
 
 	returnErr error
+	// failCalls limits returnErr to the first calls, if set.
+	failCalls int32
+	calls     atomic.Int32
 
 	consuming atomic.Bool
 	mut       sync.RWMutex
 }
 
 func (c *testConsumerGroupHandler) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
-	if c.returnErr != nil {
+	calls := c.calls.Inc()
+	if c.returnErr != nil && (c.failCalls == 0 || calls <= c.failCalls) {
 		return c.returnErr
 	}
 
@@ -213,7 +223,7 @@ func Test_TargetRun(t *testing.T) {
 				},
 			)
 
-			tg := NewKafkaTarget(nil, session, claim, tt.inDiscoveredLS, tt.inLS, tt.relabels, fc, true, &KafkaTargetMessageParser{})
+			tg := NewKafkaTarget(NewMetrics(nil), nil, session, claim, tt.inDiscoveredLS, tt.inLS, tt.relabels, fc, true, &KafkaTargetMessageParser{})
 
 			var wg sync.WaitGroup
 			wg.Add(1)
@@ -243,3 +253,181 @@ func Test_TargetRun(t *testing.T) {
 		})
 	}
 }
+
+func Test_TargetMetrics(t *testing.T) {
+	session, claim := &testSession{}, newTestClaim("footopic", 10, 12)
+	fc := fake.NewClient(func() {})
+	metrics := NewMetrics(prometheus.NewRegistry())
+
+	tg := NewKafkaTarget(metrics, nil, session, claim, nil, model.LabelSet{"buzz": "bazz"}, nil, fc, true, &KafkaTargetMessageParser{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tg.run()
+	}()
+
+	for i := 0; i < 3; i++ {
+		claim.Send(&sarama.ConsumerMessage{
+			Timestamp: time.Unix(0, int64(i)),
+			Value:     []byte("0123456789"),
+		})
+	}
+	claim.Stop()
+	wg.Wait()
+
+	require.Equal(t, 3.0, testutil.ToFloat64(metrics.consumedMessages.WithLabelValues("footopic")))
+	require.Equal(t, 30.0, testutil.ToFloat64(metrics.consumedBytes.WithLabelValues("footopic")))
+}
+
+func Test_TargetTemplate(t *testing.T) {
+	session, claim := &testSession{}, newTestClaim("footopic", 10, 12)
+	fc := fake.NewClient(func() {})
+
+	parser, err := NewTemplateMessageParser(`{{ .Topic }}/{{ .Offset }} {{ index .Headers "level" }}: {{ .Value }}`)
+	require.NoError(t, err)
+
+	tg := NewKafkaTarget(NewMetrics(nil), nil, session, claim, nil, model.LabelSet{"buzz": "bazz"}, nil, fc, true, parser)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tg.run()
+	}()
+
+	claim.Send(&sarama.ConsumerMessage{
+		Timestamp: time.Unix(0, 1),
+		Topic:     "footopic",
+		Offset:    42,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("level"), Value: []byte("info")}},
+		Value:     []byte("hello"),
+	})
+	claim.Stop()
+	wg.Wait()
+
+	re := fc.Received()
+	require.Len(t, re, 1)
+	require.Equal(t, "footopic/42 info: hello", re[0].Line)
+}
+
+func Test_TemplateMessageParserInvalid(t *testing.T) {
+	_, err := NewTemplateMessageParser(`{{ .Topic `)
+	require.Error(t, err)
+}
+
+func Test_SchemaRegistryMessageParser(t *testing.T) {
+	const schema = `{
+		"type": "record",
+		"name": "Log",
+		"fields": [
+			{"name": "level", "type": "string"},
+			{"name": "message", "type": "string"}
+		]
+	}`
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Inc()
+		if r.URL.Path != "/schemas/ids/7" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(RegistrySchema{Schema: schema})
+	}))
+	defer srv.Close()
+
+	codec, err := goavro.NewCodec(schema)
+	require.NoError(t, err)
+	payload, err := codec.BinaryFromNative(nil, map[string]any{"level": "info", "message": "hello"})
+	require.NoError(t, err)
+	// The Confluent wire format prefixes the payload with a magic byte and
+	// the schema ID.
+	value := append([]byte{0, 0, 0, 0, 7}, payload...)
+
+	parser := NewSchemaRegistryMessageParser(NewSchemaRegistryClient(srv.URL, srv.Client()), &KafkaTargetMessageParser{})
+	for range 2 {
+		entries, err := parser.Parse(&sarama.ConsumerMessage{Value: value}, model.LabelSet{"foo": "bar"}, nil, false)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.JSONEq(t, `{"level": "info", "message": "hello"}`, entries[0].Line)
+		require.Equal(t, model.LabelSet{"foo": "bar"}, entries[0].Labels)
+	}
+	// The schema is only fetched once.
+	require.Equal(t, int32(1), requests.Load())
+
+	// Values which aren't in the wire format are forwarded as is.
+	entries, err := parser.Parse(&sarama.ConsumerMessage{Value: []byte("plain")}, nil, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, "plain", entries[0].Line)
+
+	// Schemas which can't be fetched fail the parsing.
+	_, err = parser.Parse(&sarama.ConsumerMessage{Value: []byte{0, 0, 0, 0, 8, 1}}, nil, nil, false)
+	require.ErrorContains(t, err, "failed to fetch schema 8")
+}
+
+func Test_SchemaRegistryClient(t *testing.T) {
+	var (
+		requests atomic.Int32
+		release  = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Inc()
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			<-release
+			_ = json.NewEncoder(w).Encode(RegistrySchema{Schema: `"string"`})
+		case "/schemas/ids/2":
+			_ = json.NewEncoder(w).Encode(RegistrySchema{Schema: "syntax = \"proto3\";", SchemaType: SchemaTypeProtobuf})
+		case "/schemas/ids/3":
+			// Never answers before the client times out.
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := NewSchemaRegistryClient(srv.URL, srv.Client())
+
+	// Concurrent fetches of the same schema share a single request.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			schema, err := client.Schema(1)
+			require.NoError(t, err)
+			require.Equal(t, SchemaTypeAvro, schema.SchemaType)
+		}()
+	}
+	require.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, int32(1), requests.Load())
+
+	// Failures are cached until the backoff elapses.
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	_, err := client.Schema(4)
+	require.ErrorContains(t, err, "failed to fetch schema 4")
+	_, err = client.Schema(4)
+	require.ErrorContains(t, err, "failed to fetch schema 4")
+	require.Equal(t, int32(2), requests.Load())
+	now = now.Add(client.failureBackoff)
+	_, err = client.Schema(4)
+	require.Error(t, err)
+	require.Equal(t, int32(3), requests.Load())
+
+	// Fetches time out.
+	client.timeout = 50 * time.Millisecond
+	_, err = client.Schema(3)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Protobuf schemas are reported as unsupported.
+	parser := NewSchemaRegistryMessageParser(client, &KafkaTargetMessageParser{})
+	_, err = parser.Parse(&sarama.ConsumerMessage{Value: []byte{0, 0, 0, 0, 2, 1}}, nil, nil, false)
+	require.ErrorContains(t, err, "unsupported type PROTOBUF of schema 2")
+}
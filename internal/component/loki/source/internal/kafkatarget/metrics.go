@@ -0,0 +1,34 @@
+package kafkatarget
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/alloy/internal/util"
+)
+
+// Metrics holds the metrics exposed by the kafka targets.
+type Metrics struct {
+	consumedMessages *prometheus.CounterVec
+	consumedBytes    *prometheus.CounterVec
+}
+
+// NewMetrics creates the kafka target metrics and registers them against reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	var m Metrics
+
+	m.consumedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loki_source_kafka_consumed_messages_total",
+		Help: "Number of messages consumed from Kafka, by topic.",
+	}, []string{"topic"})
+
+	m.consumedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loki_source_kafka_consumed_bytes_total",
+		Help: "Number of message value bytes consumed from Kafka, by topic.",
+	}, []string{"topic"})
+
+	if reg != nil {
+		m.consumedMessages = util.MustRegisterOrGet(reg, m.consumedMessages).(*prometheus.CounterVec)
+		m.consumedBytes = util.MustRegisterOrGet(reg, m.consumedBytes).(*prometheus.CounterVec)
+	}
+	return &m
+}
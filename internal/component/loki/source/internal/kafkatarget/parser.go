@@ -1,6 +1,10 @@
 package kafkatarget
 
 import (
+	"fmt"
+	"strings"
+	"text/template"
+
 	"github.com/IBM/sarama"
 	"github.com/grafana/loki/v3/pkg/logproto"
 	"github.com/prometheus/common/model"
@@ -23,3 +27,61 @@ func (p *KafkaTargetMessageParser) Parse(message *sarama.ConsumerMessage, labels
 		},
 	}, nil
 }
+
+// TemplateMessageParser implements MessageParser. It renders the log line of
+// each entry with a text/template executed against a TemplateData.
+type TemplateMessageParser struct {
+	tmpl *template.Template
+}
+
+// TemplateData is the data available to the template of a
+// TemplateMessageParser.
+type TemplateData struct {
+	Value     string
+	Key       string
+	Topic     string
+	Partition int32
+	Offset    int64
+	Headers   map[string]string
+}
+
+// NewTemplateMessageParser returns a TemplateMessageParser rendering entries
+// with text. An error is returned if text isn't a valid template.
+func NewTemplateMessageParser(text string) (*TemplateMessageParser, error) {
+	tmpl, err := template.New("entry").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entry template: %w", err)
+	}
+	return &TemplateMessageParser{tmpl: tmpl}, nil
+}
+
+func (p *TemplateMessageParser) Parse(message *sarama.ConsumerMessage, labels model.LabelSet, _ []*relabel.Config, useIncomingTimestamp bool) ([]loki.Entry, error) {
+	data := TemplateData{
+		Value:     string(message.Value),
+		Key:       string(message.Key),
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Headers:   make(map[string]string, len(message.Headers)),
+	}
+	for _, h := range message.Headers {
+		if h != nil {
+			data.Headers[string(h.Key)] = string(h.Value)
+		}
+	}
+
+	var sb strings.Builder
+	if err := p.tmpl.Execute(&sb, data); err != nil {
+		return nil, fmt.Errorf("failed to render entry template: %w", err)
+	}
+
+	return []loki.Entry{
+		{
+			Labels: labels,
+			Entry: logproto.Entry{
+				Timestamp: timestamp(useIncomingTimestamp, message.Timestamp),
+				Line:      sb.String(),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,273 @@
+package kafkatarget
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/linkedin/goavro/v2"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/grafana/alloy/internal/component/common/loki"
+)
+
+// Schema types of the Confluent Schema Registry. Schemas without a type are
+// Avro schemas.
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+)
+
+// wireFormatMagicByte is the first byte of values encoded with the Confluent
+// wire format, which is followed by the 4 bytes of the big endian schema ID
+// and by the encoded payload.
+const (
+	wireFormatMagicByte  = 0
+	wireFormatHeaderSize = 5
+)
+
+// SchemaDecoder decodes payloads written with a schema of the registry to
+// JSON.
+type SchemaDecoder interface {
+	Decode(schema string, payload []byte) ([]byte, error)
+}
+
+// Defaults of the SchemaRegistryClient.
+const (
+	// schemaFetchTimeout bounds the time spent fetching a schema.
+	schemaFetchTimeout = 10 * time.Second
+	// schemaFailureBackoff is the time during which a schema which failed to
+	// be fetched isn't fetched again, so that the messages using it don't
+	// send a request each to the registry.
+	schemaFailureBackoff = 30 * time.Second
+)
+
+// SchemaRegistryClient fetches schemas from a Confluent Schema Registry.
+// Schemas are immutable, so they're cached once fetched. Concurrent fetches
+// of the same schema share a single request, and failures are cached for a
+// while before the schema is fetched again.
+type SchemaRegistryClient struct {
+	url            string
+	client         *http.Client
+	timeout        time.Duration
+	failureBackoff time.Duration
+	now            func() time.Time
+
+	group singleflight.Group
+
+	mut      sync.Mutex
+	schemas  map[int]RegistrySchema
+	failures map[int]schemaFailure
+}
+
+// schemaFailure is a failed fetch of a schema.
+type schemaFailure struct {
+	err   error
+	until time.Time // Time until which the schema isn't fetched again.
+}
+
+// RegistrySchema is a schema of the registry.
+type RegistrySchema struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+// NewSchemaRegistryClient returns a client of the registry at url, sending
+// requests with client.
+func NewSchemaRegistryClient(url string, client *http.Client) *SchemaRegistryClient {
+	return &SchemaRegistryClient{
+		url:            url,
+		client:         client,
+		timeout:        schemaFetchTimeout,
+		failureBackoff: schemaFailureBackoff,
+		now:            time.Now,
+		schemas:        make(map[int]RegistrySchema),
+		failures:       make(map[int]schemaFailure),
+	}
+}
+
+// Schema returns the schema with the given ID.
+func (c *SchemaRegistryClient) Schema(id int) (RegistrySchema, error) {
+	c.mut.Lock()
+	if schema, ok := c.schemas[id]; ok {
+		c.mut.Unlock()
+		return schema, nil
+	}
+	if failure, ok := c.failures[id]; ok && c.now().Before(failure.until) {
+		c.mut.Unlock()
+		return RegistrySchema{}, failure.err
+	}
+	c.mut.Unlock()
+
+	res, err, _ := c.group.Do(strconv.Itoa(id), func() (any, error) {
+		schema, err := c.fetch(id)
+
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		if err != nil {
+			c.failures[id] = schemaFailure{err: err, until: c.now().Add(c.failureBackoff)}
+			return nil, err
+		}
+		delete(c.failures, id)
+		c.schemas[id] = schema
+		return schema, nil
+	})
+	if err != nil {
+		return RegistrySchema{}, err
+	}
+	return res.(RegistrySchema), nil
+}
+
+// fetch fetches the schema with the given ID from the registry.
+func (c *SchemaRegistryClient) fetch(id int) (RegistrySchema, error) {
+	u, err := url.JoinPath(c.url, "schemas", "ids", strconv.Itoa(id))
+	if err != nil {
+		return RegistrySchema{}, fmt.Errorf("invalid schema registry url: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return RegistrySchema{}, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return RegistrySchema{}, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return RegistrySchema{}, fmt.Errorf("failed to fetch schema %d: unexpected status %s: %s", id, resp.Status, body)
+	}
+	var schema RegistrySchema
+	if err := json.NewDecoder(resp.Body).Decode(&schema); err != nil {
+		return RegistrySchema{}, fmt.Errorf("failed to decode schema %d: %w", id, err)
+	}
+	if schema.SchemaType == "" {
+		schema.SchemaType = SchemaTypeAvro
+	}
+	return schema, nil
+}
+
+// SchemaRegistryMessageParser implements MessageParser. It decodes the value
+// of messages encoded with the Confluent wire format to JSON, with the
+// decoder of the type of their schema, before parsing them with the next
+// parser. Values which aren't encoded with the wire format are parsed as is.
+type SchemaRegistryMessageParser struct {
+	registry *SchemaRegistryClient
+	next     MessageParser
+
+	mut      sync.RWMutex
+	decoders map[string]SchemaDecoder
+}
+
+// NewSchemaRegistryMessageParser returns a SchemaRegistryMessageParser
+// fetching schemas from registry and parsing decoded messages with next. It
+// decodes Avro and JSON payloads. Protobuf payloads aren't supported, and
+// fail to parse with an error saying so. Decoders of other schema types can
+// be added with RegisterDecoder.
+func NewSchemaRegistryMessageParser(registry *SchemaRegistryClient, next MessageParser) *SchemaRegistryMessageParser {
+	p := &SchemaRegistryMessageParser{
+		registry: registry,
+		next:     next,
+		decoders: make(map[string]SchemaDecoder),
+	}
+	p.RegisterDecoder(SchemaTypeAvro, newAvroDecoder())
+	p.RegisterDecoder(SchemaTypeJSON, jsonDecoder{})
+	return p
+}
+
+// RegisterDecoder sets the decoder of the payloads written with schemas of
+// schemaType, replacing any previous decoder of the type.
+func (p *SchemaRegistryMessageParser) RegisterDecoder(schemaType string, decoder SchemaDecoder) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.decoders[schemaType] = decoder
+}
+
+func (p *SchemaRegistryMessageParser) Parse(message *sarama.ConsumerMessage, labels model.LabelSet, relabels []*relabel.Config, useIncomingTimestamp bool) ([]loki.Entry, error) {
+	if len(message.Value) < wireFormatHeaderSize || message.Value[0] != wireFormatMagicByte {
+		return p.next.Parse(message, labels, relabels, useIncomingTimestamp)
+	}
+
+	id := int(binary.BigEndian.Uint32(message.Value[1:wireFormatHeaderSize]))
+	schema, err := p.registry.Schema(id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mut.RLock()
+	decoder, ok := p.decoders[schema.SchemaType]
+	p.mut.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %s of schema %d: only %s and %s schemas are supported", schema.SchemaType, id, SchemaTypeAvro, SchemaTypeJSON)
+	}
+	value, err := decoder.Decode(schema.Schema, message.Value[wireFormatHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message with schema %d: %w", id, err)
+	}
+
+	decoded := *message
+	decoded.Value = value
+	return p.next.Parse(&decoded, labels, relabels, useIncomingTimestamp)
+}
+
+// avroDecoder implements SchemaDecoder for Avro schemas. Payloads are
+// decoded to the JSON encoding of Avro.
+type avroDecoder struct {
+	mut    sync.Mutex
+	codecs map[string]*goavro.Codec
+}
+
+func newAvroDecoder() *avroDecoder {
+	return &avroDecoder{codecs: make(map[string]*goavro.Codec)}
+}
+
+func (d *avroDecoder) Decode(schema string, payload []byte) ([]byte, error) {
+	codec, err := d.codec(schema)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, err
+	}
+	return codec.TextualFromNative(nil, native)
+}
+
+// codec returns the codec of schema, creating it on first use.
+func (d *avroDecoder) codec(schema string) (*goavro.Codec, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if codec, ok := d.codecs[schema]; ok {
+		return codec, nil
+	}
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	d.codecs[schema] = codec
+	return codec, nil
+}
+
+// jsonDecoder implements SchemaDecoder for JSON schemas, whose payloads are
+// already encoded as JSON.
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(_ string, payload []byte) ([]byte, error) {
+	return payload, nil
+}
@@ -29,9 +29,10 @@ type TopicManager interface {
 }
 
 type TargetSyncer struct {
-	logger log.Logger
-	cfg    Config
-	client loki.EntryHandler
+	metrics *Metrics
+	logger  log.Logger
+	cfg     Config
+	client  loki.EntryHandler
 
 	topicManager TopicManager
 	consumer
@@ -45,6 +46,7 @@ type TargetSyncer struct {
 }
 
 func NewSyncer(
+	metrics *Metrics,
 	logger log.Logger,
 	cfg Config,
 	pushClient loki.EntryHandler,
@@ -72,6 +74,8 @@ func NewSyncer(
 	default:
 		return nil, fmt.Errorf("unrecognized consumer group partition assignor: %s", cfg.KafkaConfig.Assignor)
 	}
+	config = withMetadata(*config, cfg.KafkaConfig.Metadata)
+	config = withConsumerGroup(*config, cfg.KafkaConfig.ConsumerGroup)
 	config, err = withAuthentication(*config, cfg.KafkaConfig.Authentication)
 	if err != nil {
 		return nil, fmt.Errorf("error setting up kafka authentication: %w", err)
@@ -80,6 +84,9 @@ func NewSyncer(
 	if err != nil {
 		return nil, fmt.Errorf("error creating kafka client: %w", err)
 	}
+	if len(cfg.KafkaConfig.Assignments) > 0 {
+		return newAssignmentSyncer(metrics, logger, cfg, pushClient, messageParser, client)
+	}
 	group, err := sarama.NewConsumerGroup(cfg.KafkaConfig.Brokers, cfg.KafkaConfig.GroupID, config)
 	if err != nil {
 		return nil, fmt.Errorf("error creating consumer group client: %w", err)
@@ -90,6 +97,7 @@ func NewSyncer(
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	t := &TargetSyncer{
+		metrics:      metrics,
 		logger:       logger,
 		ctx:          ctx,
 		cancel:       cancel,
@@ -115,6 +123,82 @@ func NewSyncer(
 	return t, nil
 }
 
+// newAssignmentSyncer creates a syncer consuming the partitions assigned in
+// cfg directly with client, instead of joining a consumer group.
+func newAssignmentSyncer(
+	metrics *Metrics,
+	logger log.Logger,
+	cfg Config,
+	pushClient loki.EntryHandler,
+	messageParser MessageParser,
+	client sarama.Client,
+) (*TargetSyncer, error) {
+	partitionConsumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("error creating partition consumer: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &TargetSyncer{
+		metrics: metrics,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+		cfg:     cfg,
+		client:  pushClient,
+		close: func() error {
+			if err := partitionConsumer.Close(); err != nil {
+				level.Warn(logger).Log("msg", "error while closing partition consumer", "err", err)
+			}
+			return client.Close()
+		},
+		consumer: consumer{
+			ctx:    context.Background(),
+			cancel: func() {},
+			logger: logger,
+		},
+		messageParser: messageParser,
+	}
+	t.discoverer = t
+	if err := t.consumeAssignments(partitionConsumer); err != nil {
+		cancel()
+		_ = t.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// withMetadata returns a copy of cfg with the metadata refresh settings of
+// metadataCfg, using their default for zero values.
+func withMetadata(cfg sarama.Config, metadataCfg MetadataConfig) *sarama.Config {
+	cfg.Metadata.RefreshFrequency = DefaultMetadataRefreshFrequency
+	if metadataCfg.RefreshFrequency != 0 {
+		cfg.Metadata.RefreshFrequency = metadataCfg.RefreshFrequency
+	}
+	cfg.Metadata.Retry.Max = DefaultMetadataRetryMax
+	if metadataCfg.RetryMax != 0 {
+		cfg.Metadata.Retry.Max = metadataCfg.RetryMax
+	}
+	cfg.Metadata.Retry.Backoff = DefaultMetadataRetryBackoff
+	if metadataCfg.RetryBackoff != 0 {
+		cfg.Metadata.Retry.Backoff = metadataCfg.RetryBackoff
+	}
+	return &cfg
+}
+
+func withConsumerGroup(cfg sarama.Config, groupCfg ConsumerGroupConfig) *sarama.Config {
+	if groupCfg.SessionTimeout != 0 {
+		// The heartbeat interval must be below the session timeout, and
+		// sarama recommends at most a third of it.
+		cfg.Consumer.Group.Heartbeat.Interval = groupCfg.SessionTimeout / 3
+	}
+	groupCfg = groupCfg.withDefaults()
+	cfg.Consumer.Group.Session.Timeout = groupCfg.SessionTimeout
+	cfg.Consumer.Group.Rebalance.Timeout = groupCfg.RebalanceTimeout
+	cfg.Consumer.MaxProcessingTime = groupCfg.MaxProcessingTime
+	return &cfg
+}
+
 func withAuthentication(cfg sarama.Config, authCfg Authentication) (*sarama.Config, error) {
 	if len(authCfg.Type) == 0 || authCfg.Type == AuthenticationTypeNone {
 		return &cfg, nil
@@ -271,8 +355,11 @@ func (ts *TargetSyncer) NewTarget(session sarama.ConsumerGroupSession, claim sar
 	discoveredLabels := model.LabelSet{
 		"__meta_kafka_topic":     model.LabelValue(claim.Topic()),
 		"__meta_kafka_partition": model.LabelValue(fmt.Sprintf("%d", claim.Partition())),
-		"__meta_kafka_member_id": model.LabelValue(session.MemberID()),
-		"__meta_kafka_group_id":  model.LabelValue(ts.cfg.KafkaConfig.GroupID),
+	}
+	// Manually assigned partitions aren't consumed by a consumer group.
+	if len(ts.cfg.KafkaConfig.Assignments) == 0 {
+		discoveredLabels["__meta_kafka_member_id"] = model.LabelValue(session.MemberID())
+		discoveredLabels["__meta_kafka_group_id"] = model.LabelValue(ts.cfg.KafkaConfig.GroupID)
 	}
 	details := newDetails(session, claim)
 	labelMap := make(map[string]string)
@@ -291,6 +378,7 @@ func (ts *TargetSyncer) NewTarget(session sarama.ConsumerGroupSession, claim sar
 		}, nil
 	}
 	t := NewKafkaTarget(
+		ts.metrics,
 		ts.logger,
 		session,
 		claim,
@@ -312,6 +400,16 @@ func validateConfig(cfg *Config) error {
 	if len(cfg.KafkaConfig.Brokers) == 0 {
 		return errors.New("no Kafka bootstrap brokers defined")
 	}
+	if err := cfg.KafkaConfig.ConsumerGroup.Validate(); err != nil {
+		return err
+	}
+
+	if len(cfg.KafkaConfig.Assignments) > 0 {
+		if len(cfg.KafkaConfig.Topics) > 0 || cfg.KafkaConfig.GroupID != "" {
+			return errors.New("partition assignments can't be used together with the topics or the group id of a consumer group")
+		}
+		return cfg.KafkaConfig.Metadata.Validate()
+	}
 
 	if len(cfg.KafkaConfig.Topics) == 0 {
 		return errors.New("no topics given to be consumed")
@@ -320,7 +418,7 @@ func validateConfig(cfg *Config) error {
 	if cfg.KafkaConfig.GroupID == "" {
 		cfg.KafkaConfig.GroupID = "promtail"
 	}
-	return nil
+	return cfg.KafkaConfig.Metadata.Validate()
 }
 
 // StringsContain returns true if the search value is within the list of input values.
@@ -188,6 +188,44 @@ func Test_validateConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			&Config{
+				KafkaConfig: TargetConfig{
+					Brokers:     []string{"foo"},
+					Topics:      []string{"bar"},
+					Assignments: []PartitionAssignment{{Topic: "bar"}},
+				},
+			},
+			true,
+			nil,
+		},
+		{
+			&Config{
+				KafkaConfig: TargetConfig{
+					Brokers:     []string{"foo"},
+					GroupID:     "group",
+					Assignments: []PartitionAssignment{{Topic: "bar"}},
+				},
+			},
+			true,
+			nil,
+		},
+		{
+			&Config{
+				KafkaConfig: TargetConfig{
+					Brokers:     []string{"foo"},
+					Assignments: []PartitionAssignment{{Topic: "bar"}},
+				},
+			},
+			false,
+			&Config{
+				KafkaConfig: TargetConfig{
+					Brokers:     []string{"foo"},
+					Assignments: []PartitionAssignment{{Topic: "bar"}},
+					Version:     "2.1.1",
+				},
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -204,6 +242,69 @@ func Test_validateConfig(t *testing.T) {
 	}
 }
 
+func Test_withMetadata(t *testing.T) {
+	cfg := sarama.NewConfig()
+
+	// Zero values fall back to the defaults.
+	defaultCfg := withMetadata(*cfg, MetadataConfig{})
+	assert.Equal(t, DefaultMetadataRefreshFrequency, defaultCfg.Metadata.RefreshFrequency)
+	assert.Equal(t, DefaultMetadataRetryMax, defaultCfg.Metadata.Retry.Max)
+	assert.Equal(t, DefaultMetadataRetryBackoff, defaultCfg.Metadata.Retry.Backoff)
+	assert.NoError(t, defaultCfg.Validate())
+
+	customCfg := withMetadata(*cfg, MetadataConfig{
+		RefreshFrequency: 30 * time.Second,
+		RetryMax:         5,
+		RetryBackoff:     100 * time.Millisecond,
+	})
+	assert.Equal(t, 30*time.Second, customCfg.Metadata.RefreshFrequency)
+	assert.Equal(t, 5, customCfg.Metadata.Retry.Max)
+	assert.Equal(t, 100*time.Millisecond, customCfg.Metadata.Retry.Backoff)
+	assert.NoError(t, customCfg.Validate())
+
+	// The given config isn't modified.
+	assert.Equal(t, sarama.NewConfig().Metadata, cfg.Metadata)
+}
+
+func TestMetadataConfig_Validate(t *testing.T) {
+	assert.NoError(t, MetadataConfig{}.Validate())
+	assert.NoError(t, MetadataConfig{RefreshFrequency: time.Second, RetryBackoff: 10 * time.Millisecond}.Validate())
+	assert.Error(t, MetadataConfig{RefreshFrequency: time.Millisecond}.Validate())
+	assert.Error(t, MetadataConfig{RetryMax: -1}.Validate())
+	assert.Error(t, MetadataConfig{RetryBackoff: time.Millisecond}.Validate())
+}
+
+func Test_withConsumerGroup(t *testing.T) {
+	cfg := sarama.NewConfig()
+
+	// Zero values fall back to the defaults, which are the ones of sarama.
+	defaultCfg := withConsumerGroup(*cfg, ConsumerGroupConfig{})
+	assert.Equal(t, cfg.Consumer.Group.Session.Timeout, defaultCfg.Consumer.Group.Session.Timeout)
+	assert.Equal(t, cfg.Consumer.Group.Heartbeat.Interval, defaultCfg.Consumer.Group.Heartbeat.Interval)
+	assert.Equal(t, cfg.Consumer.Group.Rebalance.Timeout, defaultCfg.Consumer.Group.Rebalance.Timeout)
+	assert.Equal(t, cfg.Consumer.MaxProcessingTime, defaultCfg.Consumer.MaxProcessingTime)
+	assert.NoError(t, defaultCfg.Validate())
+
+	customCfg := withConsumerGroup(*cfg, ConsumerGroupConfig{
+		SessionTimeout:    30 * time.Second,
+		RebalanceTimeout:  5 * time.Minute,
+		MaxProcessingTime: 10 * time.Second,
+	})
+	assert.Equal(t, 30*time.Second, customCfg.Consumer.Group.Session.Timeout)
+	assert.Equal(t, 10*time.Second, customCfg.Consumer.Group.Heartbeat.Interval)
+	assert.Equal(t, 5*time.Minute, customCfg.Consumer.Group.Rebalance.Timeout)
+	assert.Equal(t, 10*time.Second, customCfg.Consumer.MaxProcessingTime)
+	assert.NoError(t, customCfg.Validate())
+}
+
+func TestConsumerGroupConfig_Validate(t *testing.T) {
+	assert.NoError(t, ConsumerGroupConfig{}.Validate())
+	assert.NoError(t, ConsumerGroupConfig{RebalanceTimeout: 5 * time.Minute, MaxProcessingTime: time.Minute}.Validate())
+	assert.Error(t, ConsumerGroupConfig{SessionTimeout: -time.Second}.Validate())
+	assert.ErrorContains(t, ConsumerGroupConfig{SessionTimeout: 2 * time.Minute}.Validate(), "must be at least the session timeout")
+	assert.ErrorContains(t, ConsumerGroupConfig{MaxProcessingTime: 2 * time.Minute}.Validate(), "must be below the rebalance timeout")
+}
+
 func Test_withAuthentication(t *testing.T) {
 	var (
 		tlsConf = config.TLSConfig{
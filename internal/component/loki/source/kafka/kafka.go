@@ -2,7 +2,11 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
 	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/grafana/alloy/internal/component"
@@ -14,6 +18,7 @@ import (
 	"github.com/grafana/alloy/internal/runtime/logging/level"
 	"github.com/grafana/alloy/syntax/alloytypes"
 	"github.com/grafana/dskit/flagext"
+	promconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 )
 
@@ -32,14 +37,19 @@ func init() {
 // Arguments holds values which are used to configure the loki.source.kafka
 // component.
 type Arguments struct {
-	Brokers              []string            `alloy:"brokers,attr"`
-	Topics               []string            `alloy:"topics,attr"`
-	GroupID              string              `alloy:"group_id,attr,optional"`
-	Assignor             string              `alloy:"assignor,attr,optional"`
-	Version              string              `alloy:"version,attr,optional"`
-	Authentication       KafkaAuthentication `alloy:"authentication,block,optional"`
-	UseIncomingTimestamp bool                `alloy:"use_incoming_timestamp,attr,optional"`
-	Labels               map[string]string   `alloy:"labels,attr,optional"`
+	Brokers              []string             `alloy:"brokers,attr"`
+	Topics               []string             `alloy:"topics,attr,optional"`
+	PartitionAssignments []string             `alloy:"partition_assignments,attr,optional"`
+	GroupID              string               `alloy:"group_id,attr,optional"`
+	Assignor             string               `alloy:"assignor,attr,optional"`
+	Version              string               `alloy:"version,attr,optional"`
+	Authentication       KafkaAuthentication  `alloy:"authentication,block,optional"`
+	Metadata             KafkaMetadata        `alloy:"metadata,block,optional"`
+	ConsumerGroup        KafkaConsumerGroup   `alloy:"consumer_group,block,optional"`
+	SchemaRegistry       *KafkaSchemaRegistry `alloy:"schema_registry,block,optional"`
+	UseIncomingTimestamp bool                 `alloy:"use_incoming_timestamp,attr,optional"`
+	Labels               map[string]string    `alloy:"labels,attr,optional"`
+	EntryTemplate        string               `alloy:"entry_template,attr,optional"`
 
 	ForwardTo    []loki.LogsReceiver `alloy:"forward_to,attr"`
 	RelabelRules alloy_relabel.Rules `alloy:"relabel_rules,attr,optional"`
@@ -62,6 +72,42 @@ type KafkaSASLConfig struct {
 	OAuthConfig OAuthConfigConfig `alloy:"oauth_config,block,optional"`
 }
 
+// KafkaMetadata describes how the metadata of the Kafka cluster is refreshed.
+type KafkaMetadata struct {
+	RefreshFrequency time.Duration `alloy:"refresh_frequency,attr,optional"`
+	RetryMax         int           `alloy:"retry_max,attr,optional"`
+	RetryBackoff     time.Duration `alloy:"retry_backoff,attr,optional"`
+}
+
+// KafkaConsumerGroup describes the timeouts of the consumer group session.
+type KafkaConsumerGroup struct {
+	SessionTimeout    time.Duration `alloy:"session_timeout,attr,optional"`
+	RebalanceTimeout  time.Duration `alloy:"rebalance_timeout,attr,optional"`
+	MaxProcessingTime time.Duration `alloy:"max_processing_time,attr,optional"`
+}
+
+// KafkaSchemaRegistry configures the Confluent Schema Registry used to
+// decode messages.
+type KafkaSchemaRegistry struct {
+	URL              string                  `alloy:"url,attr"`
+	HTTPClientConfig config.HTTPClientConfig `alloy:",squash"`
+}
+
+// SetToDefault implements syntax.Defaulter.
+func (r *KafkaSchemaRegistry) SetToDefault() {
+	*r = KafkaSchemaRegistry{
+		HTTPClientConfig: config.DefaultHTTPClientConfig,
+	}
+}
+
+// Validate implements syntax.Validator.
+func (r *KafkaSchemaRegistry) Validate() error {
+	if _, err := url.ParseRequestURI(r.URL); err != nil {
+		return fmt.Errorf("invalid schema registry url: %w", err)
+	}
+	return r.HTTPClientConfig.Validate()
+}
+
 type OAuthConfigConfig struct {
 	TokenProvider string   `alloy:"token_provider,attr"`
 	Scopes        []string `alloy:"scopes,attr"`
@@ -87,9 +133,72 @@ func (a *Arguments) SetToDefault() {
 	*a = DefaultArguments
 }
 
+// Validate implements syntax.Validator.
+func (a *Arguments) Validate() error {
+	if len(a.PartitionAssignments) > 0 {
+		if len(a.Topics) > 0 {
+			return errors.New("topics and partition_assignments are mutually exclusive")
+		}
+		if a.GroupID != DefaultArguments.GroupID || a.Assignor != DefaultArguments.Assignor {
+			return errors.New("group_id and assignor configure consumer groups and can't be used with partition_assignments")
+		}
+		if _, err := a.partitionAssignments(); err != nil {
+			return err
+		}
+	} else if len(a.Topics) == 0 {
+		return errors.New("either topics or partition_assignments must be set")
+	}
+	if a.EntryTemplate != "" {
+		if _, err := kt.NewTemplateMessageParser(a.EntryTemplate); err != nil {
+			return err
+		}
+	}
+	if err := a.ConsumerGroup.Convert().Validate(); err != nil {
+		return err
+	}
+	return a.Metadata.Convert().Validate()
+}
+
+// partitionAssignments parses the partitions to consume without a consumer
+// group.
+func (a *Arguments) partitionAssignments() ([]kt.PartitionAssignment, error) {
+	var res []kt.PartitionAssignment
+	for _, s := range a.PartitionAssignments {
+		assignment, err := kt.ParsePartitionAssignment(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, assignment)
+	}
+	return res, nil
+}
+
+// messageParser returns the parser used to build entries from Kafka messages.
+func (a *Arguments) messageParser() (kt.MessageParser, error) {
+	var parser kt.MessageParser = &kt.KafkaTargetMessageParser{}
+	if a.EntryTemplate != "" {
+		var err error
+		parser, err = kt.NewTemplateMessageParser(a.EntryTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if a.SchemaRegistry == nil {
+		return parser, nil
+	}
+
+	client, err := promconfig.NewClientFromConfig(*a.SchemaRegistry.HTTPClientConfig.Convert(), "loki.source.kafka")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema registry client: %w", err)
+	}
+	registry := kt.NewSchemaRegistryClient(a.SchemaRegistry.URL, client)
+	return kt.NewSchemaRegistryMessageParser(registry, parser), nil
+}
+
 // Component implements the loki.source.kafka component.
 type Component struct {
-	opts component.Options
+	opts    component.Options
+	metrics *kt.Metrics
 
 	mut    sync.RWMutex
 	fanout []loki.LogsReceiver
@@ -98,10 +207,13 @@ type Component struct {
 	handler loki.LogsReceiver
 }
 
+var _ component.HealthComponent = (*Component)(nil)
+
 // New creates a new loki.source.kafka component.
 func New(o component.Options, args Arguments) (*Component, error) {
 	c := &Component{
 		opts:    o,
+		metrics: kt.NewMetrics(o.Registerer),
 		mut:     sync.RWMutex{},
 		fanout:  args.ForwardTo,
 		target:  nil,
@@ -151,6 +263,10 @@ func (c *Component) Update(args component.Arguments) error {
 	defer c.mut.Unlock()
 
 	newArgs := args.(Arguments)
+	parser, err := newArgs.messageParser()
+	if err != nil {
+		return err
+	}
 	c.fanout = newArgs.ForwardTo
 
 	if c.target != nil {
@@ -161,7 +277,7 @@ func (c *Component) Update(args component.Arguments) error {
 	}
 
 	entryHandler := loki.NewEntryHandler(c.handler.Chan(), func() {})
-	t, err := kt.NewSyncer(c.opts.Logger, newArgs.Convert(), entryHandler, &kt.KafkaTargetMessageParser{})
+	t, err := kt.NewSyncer(c.metrics, c.opts.Logger, newArgs.Convert(), entryHandler, parser)
 	if err != nil {
 		level.Error(c.opts.Logger).Log("msg", "failed to create kafka client with provided config", "err", err)
 		return err
@@ -172,6 +288,17 @@ func (c *Component) Update(args component.Arguments) error {
 	return nil
 }
 
+// CurrentHealth implements component.HealthComponent. The health reflects
+// whether the consumer can reach the brokers.
+func (c *Component) CurrentHealth() component.Health {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	if c.target == nil {
+		return component.Health{}
+	}
+	return c.target.CurrentHealth()
+}
+
 // Convert is used to bridge between the Alloy and Promtail types.
 func (args *Arguments) Convert() kt.Config {
 	lbls := make(model.LabelSet, len(args.Labels))
@@ -179,21 +306,47 @@ func (args *Arguments) Convert() kt.Config {
 		lbls[model.LabelName(k)] = model.LabelValue(v)
 	}
 
+	groupID := args.GroupID
+	// Partition assignments are validated by Validate.
+	assignments, _ := args.partitionAssignments()
+	if len(assignments) > 0 {
+		groupID = ""
+	}
+
 	return kt.Config{
 		KafkaConfig: kt.TargetConfig{
 			Labels:               lbls,
 			UseIncomingTimestamp: args.UseIncomingTimestamp,
 			Brokers:              args.Brokers,
-			GroupID:              args.GroupID,
+			GroupID:              groupID,
 			Topics:               args.Topics,
+			Assignments:          assignments,
 			Version:              args.Version,
 			Assignor:             args.Assignor,
 			Authentication:       args.Authentication.Convert(),
+			Metadata:             args.Metadata.Convert(),
+			ConsumerGroup:        args.ConsumerGroup.Convert(),
 		},
 		RelabelConfigs: alloy_relabel.ComponentToPromRelabelConfigs(args.RelabelRules),
 	}
 }
 
+func (m KafkaMetadata) Convert() kt.MetadataConfig {
+	return kt.MetadataConfig{
+		RefreshFrequency: m.RefreshFrequency,
+		RetryMax:         m.RetryMax,
+		RetryBackoff:     m.RetryBackoff,
+	}
+}
+
+func (g KafkaConsumerGroup) Convert() kt.ConsumerGroupConfig {
+	return kt.ConsumerGroupConfig{
+		SessionTimeout:    g.SessionTimeout,
+		RebalanceTimeout:  g.RebalanceTimeout,
+		MaxProcessingTime: g.MaxProcessingTime,
+	}
+}
+
 func (auth KafkaAuthentication) Convert() kt.Authentication {
 	return kt.Authentication{
 		Type:      kt.AuthenticationType(auth.Type),
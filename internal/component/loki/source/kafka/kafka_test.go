@@ -2,9 +2,13 @@ package kafka
 
 import (
 	"testing"
+	"time"
 
-	"github.com/grafana/alloy/syntax"
+	"github.com/IBM/sarama"
 	"github.com/stretchr/testify/require"
+
+	kt "github.com/grafana/alloy/internal/component/loki/source/internal/kafkatarget"
+	"github.com/grafana/alloy/syntax"
 )
 
 func TestAlloyConfig(t *testing.T) {
@@ -84,3 +88,171 @@ func TestSASLOAuthAlloyConfig(t *testing.T) {
 	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
 	require.NoError(t, err)
 }
+
+func TestEntryTemplateAlloyConfig(t *testing.T) {
+	var exampleAlloyConfig = `
+	brokers        = ["localhost:9092"]
+	topics         = ["quickstart-events"]
+	forward_to     = []
+	entry_template = "{{ .Topic }}: {{ .Value }}"
+`
+
+	var args Arguments
+	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
+	require.NoError(t, err)
+
+	var invalidAlloyConfig = `
+	brokers        = ["localhost:9092"]
+	topics         = ["quickstart-events"]
+	forward_to     = []
+	entry_template = "{{ .Topic "
+`
+	err = syntax.Unmarshal([]byte(invalidAlloyConfig), &args)
+	require.ErrorContains(t, err, "invalid entry template")
+}
+
+func TestMetadataAlloyConfig(t *testing.T) {
+	var exampleAlloyConfig = `
+	brokers    = ["localhost:9092","localhost:23456"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	metadata {
+		refresh_frequency = "30s"
+		retry_max         = 5
+		retry_backoff     = "100ms"
+	}
+`
+
+	var args Arguments
+	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
+	require.NoError(t, err)
+	require.Equal(t, kt.MetadataConfig{
+		RefreshFrequency: 30 * time.Second,
+		RetryMax:         5,
+		RetryBackoff:     100 * time.Millisecond,
+	}, args.Convert().KafkaConfig.Metadata)
+
+	var invalidAlloyConfig = `
+	brokers    = ["localhost:9092"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	metadata {
+		refresh_frequency = "10ms"
+	}
+`
+	err = syntax.Unmarshal([]byte(invalidAlloyConfig), &args)
+	require.ErrorContains(t, err, "metadata refresh frequency must be at least 1s")
+}
+
+func TestConsumerGroupAlloyConfig(t *testing.T) {
+	var exampleAlloyConfig = `
+	brokers    = ["localhost:9092"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	consumer_group {
+		session_timeout     = "30s"
+		rebalance_timeout   = "5m"
+		max_processing_time = "10s"
+	}
+`
+
+	var args Arguments
+	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
+	require.NoError(t, err)
+	require.Equal(t, kt.ConsumerGroupConfig{
+		SessionTimeout:    30 * time.Second,
+		RebalanceTimeout:  5 * time.Minute,
+		MaxProcessingTime: 10 * time.Second,
+	}, args.Convert().KafkaConfig.ConsumerGroup)
+
+	var invalidAlloyConfig = `
+	brokers    = ["localhost:9092"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	consumer_group {
+		max_processing_time = "2m"
+	}
+`
+	err = syntax.Unmarshal([]byte(invalidAlloyConfig), &args)
+	require.ErrorContains(t, err, "consumer group max processing time (2m0s) must be below the rebalance timeout (1m0s)")
+}
+
+func TestPartitionAssignmentsAlloyConfig(t *testing.T) {
+	var exampleAlloyConfig = `
+	brokers               = ["localhost:9092"]
+	partition_assignments = ["quickstart-events:1:42", "other-events:0:oldest"]
+	forward_to            = []
+`
+
+	var args Arguments
+	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
+	require.NoError(t, err)
+
+	cfg := args.Convert().KafkaConfig
+	require.Empty(t, cfg.GroupID)
+	require.Empty(t, cfg.Topics)
+	require.Equal(t, []kt.PartitionAssignment{
+		{Topic: "quickstart-events", Partition: 1, Offset: 42},
+		{Topic: "other-events", Partition: 0, Offset: sarama.OffsetOldest},
+	}, cfg.Assignments)
+
+	for _, invalid := range []string{
+		`
+	brokers               = ["localhost:9092"]
+	topics                = ["quickstart-events"]
+	partition_assignments = ["quickstart-events:1:42"]
+	forward_to            = []
+`, `
+	brokers               = ["localhost:9092"]
+	group_id              = "my-group"
+	partition_assignments = ["quickstart-events:1:42"]
+	forward_to            = []
+`, `
+	brokers               = ["localhost:9092"]
+	partition_assignments = ["quickstart-events:1"]
+	forward_to            = []
+`, `
+	brokers    = ["localhost:9092"]
+	forward_to = []
+`,
+	} {
+		var args Arguments
+		require.Error(t, syntax.Unmarshal([]byte(invalid), &args), invalid)
+	}
+}
+
+func TestSchemaRegistryAlloyConfig(t *testing.T) {
+	var exampleAlloyConfig = `
+	brokers    = ["localhost:9092"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	schema_registry {
+		url = "http://localhost:8081"
+		basic_auth {
+			username = "user"
+			password = "pass"
+		}
+	}
+`
+
+	var args Arguments
+	err := syntax.Unmarshal([]byte(exampleAlloyConfig), &args)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8081", args.SchemaRegistry.URL)
+	require.Equal(t, "user", args.SchemaRegistry.HTTPClientConfig.BasicAuth.Username)
+
+	parser, err := args.messageParser()
+	require.NoError(t, err)
+	require.IsType(t, &kt.SchemaRegistryMessageParser{}, parser)
+
+	var invalidAlloyConfig = `
+	brokers    = ["localhost:9092"]
+	topics     = ["quickstart-events"]
+	forward_to = []
+	schema_registry {
+		url = "localhost"
+	}
+`
+	err = syntax.Unmarshal([]byte(invalidAlloyConfig), &args)
+	require.ErrorContains(t, err, "invalid schema registry url")
+}
@@ -6,16 +6,27 @@ import (
 	"fmt"
 
 	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/internal/converter/internal/datadogconvert"
+	"github.com/grafana/alloy/internal/converter/internal/fluentbitconvert"
 	"github.com/grafana/alloy/internal/converter/internal/otelcolconvert"
 	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert"
 	"github.com/grafana/alloy/internal/converter/internal/promtailconvert"
 	"github.com/grafana/alloy/internal/converter/internal/staticconvert"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/token/builder"
 )
 
 // Input represents the type of config file being fed into the converter.
 type Input string
 
 const (
+	// InputDatadog indicates that the input file is a Datadog agent checks YAML file.
+	InputDatadog Input = "datadog"
+	// InputFluentBit indicates that the input file is a Fluent Bit config file
+	// in the classic format.
+	InputFluentBit Input = "fluentbit"
 	// InputOtelCol indicates that the input file is an OpenTelemetry Collector YAML file.
 	InputOtelCol Input = "otelcol"
 	// InputPrometheus indicates that the input file is a prometheus YAML file.
@@ -27,6 +38,8 @@ const (
 )
 
 var SupportedFormats = []string{
+	string(InputDatadog),
+	string(InputFluentBit),
 	string(InputOtelCol),
 	string(InputPrometheus),
 	string(InputPromtail),
@@ -50,6 +63,10 @@ var SupportedFormats = []string{
 // returned alongside the resulting config.
 func Convert(in []byte, kind Input, extraArgs []string) ([]byte, diag.Diagnostics) {
 	switch kind {
+	case InputDatadog:
+		return datadogconvert.Convert(in, extraArgs)
+	case InputFluentBit:
+		return fluentbitconvert.Convert(in, extraArgs)
 	case InputOtelCol:
 		return otelcolconvert.Convert(in, extraArgs)
 	case InputPrometheus:
@@ -64,3 +81,162 @@ func Convert(in []byte, kind Input, extraArgs []string) ([]byte, diag.Diagnostic
 	diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("unrecognized kind %q given to the config converter", kind))
 	return nil, diags
 }
+
+// PostProcess transforms the Alloy file generated by a conversion before
+// it's rendered, for example to inject defaults specific to an organization.
+// It returns diagnostics about the transformation.
+type PostProcess func(f *builder.File) diag.Diagnostics
+
+// Converter converts config files like [Convert], and runs its registered
+// PostProcess hooks on the generated file before it's rendered and
+// pretty-printed. The zero value is ready to use and runs no hooks.
+type Converter struct {
+	postProcess []PostProcess
+}
+
+// RegisterPostProcess registers hook to run on the files generated by c.
+// Hooks run in the order they were registered.
+func (c *Converter) RegisterPostProcess(hook PostProcess) {
+	c.postProcess = append(c.postProcess, hook)
+}
+
+// Convert is like [Convert], but runs the PostProcess hooks of c on the
+// generated file before rendering it. The hooks don't run if the conversion
+// failed, and the config isn't returned if a hook reports a critical
+// diagnostic.
+func (c *Converter) Convert(in []byte, kind Input, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, kind, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+	for _, hook := range c.postProcess {
+		newDiags := hook(f)
+		diags.AddAll(newDiags)
+		if newDiags.HasSeverityLevel(diag.SeverityLevelCritical) {
+			return nil, diags
+		}
+	}
+
+	out, newDiags := render(f, kind)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// render renders the file generated by the converter for kind, like
+// [Convert] does.
+func render(f *builder.File, kind Input) ([]byte, diag.Diagnostics) {
+	switch kind {
+	case InputDatadog:
+		return datadogconvert.Render(f)
+	case InputFluentBit:
+		return fluentbitconvert.Render(f)
+	case InputOtelCol:
+		return otelcolconvert.Render(f)
+	case InputPrometheus:
+		return prometheusconvert.Render(f)
+	case InputPromtail:
+		return promtailconvert.Render(f)
+	case InputStatic:
+		return staticconvert.Render(f)
+	}
+
+	var diags diag.Diagnostics
+	diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("unrecognized kind %q given to the config converter", kind))
+	return nil, diags
+}
+
+// ConvertWithMigrationSteps is like [Convert], but also returns the manual
+// steps required to complete the migration to the generated Alloy config,
+// such as moving secrets written in plain text out of the config. Unlike
+// diagnostics, steps don't report issues with the conversion itself, and can
+// be used as a checklist for the operator.
+func ConvertWithMigrationSteps(in []byte, kind Input, extraArgs []string) ([]byte, []diag.MigrationStep, diag.Diagnostics) {
+	out, diags := Convert(in, kind, extraArgs)
+	if len(out) == 0 {
+		return out, nil, diags
+	}
+
+	f, err := parser.ParseFile("", out)
+	if err != nil {
+		// Convert already reports configs which can't be parsed.
+		return out, nil, diags
+	}
+	return out, common.MigrationSteps(f), diags
+}
+
+// ConvertWithDebugVariant is like [Convert], but also returns a debug variant
+// of the generated Alloy config which enables live debugging. In the debug
+// variant, every component supporting live debugging is annotated with a
+// comment, so that the data flowing through it can be inspected while
+// validating the migration. The config returned first is left unchanged.
+func ConvertWithDebugVariant(in []byte, kind Input, extraArgs []string) ([]byte, []byte, diag.Diagnostics) {
+	out, diags := Convert(in, kind, extraArgs)
+	if len(out) == 0 {
+		return out, nil, diags
+	}
+
+	debugOut, newDiags := common.LiveDebuggingVariant(out)
+	diags.AddAll(newDiags)
+	return out, debugOut, diags
+}
+
+// ConvertFile is like [Convert], but returns the generated Alloy file instead
+// of its rendered bytes. This allows callers to add, remove, or modify blocks
+// before writing the file out with [builder.File.Bytes]. A nil file is
+// returned if the conversion failed.
+//
+// Unlike [Convert], the file is not pretty-printed, and environment variable
+// references produced by the otelcol converter are not rewritten into sys.env
+// calls.
+func ConvertFile(in []byte, kind Input, extraArgs []string) (*builder.File, diag.Diagnostics) {
+	switch kind {
+	case InputDatadog:
+		return datadogconvert.ConvertFile(in, extraArgs)
+	case InputFluentBit:
+		return fluentbitconvert.ConvertFile(in, extraArgs)
+	case InputOtelCol:
+		return otelcolconvert.ConvertFile(in, extraArgs)
+	case InputPrometheus:
+		return prometheusconvert.ConvertFile(in, extraArgs)
+	case InputPromtail:
+		return promtailconvert.ConvertFile(in, extraArgs)
+	case InputStatic:
+		return staticconvert.ConvertFile(in, extraArgs)
+	}
+
+	var diags diag.Diagnostics
+	diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("unrecognized kind %q given to the config converter", kind))
+	return nil, diags
+}
+
+// Validate runs the conversion of an input configuration file like
+// [Convert], but only returns the diagnostics of the conversion without
+// rendering the Alloy config. It can be used to check whether a config can
+// be converted, e.g. in CI.
+func Validate(in []byte, kind Input, extraArgs []string) diag.Diagnostics {
+	switch kind {
+	case InputDatadog:
+		return datadogconvert.Validate(in, extraArgs)
+	case InputFluentBit:
+		return fluentbitconvert.Validate(in, extraArgs)
+	case InputOtelCol:
+		return otelcolconvert.Validate(in, extraArgs)
+	case InputPrometheus:
+		return prometheusconvert.Validate(in, extraArgs)
+	case InputPromtail:
+		return promtailconvert.Validate(in, extraArgs)
+	case InputStatic:
+		return staticconvert.Validate(in, extraArgs)
+	}
+
+	var diags diag.Diagnostics
+	diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("unrecognized kind %q given to the config converter", kind))
+	return diags
+}
+
+// LintDeprecations returns a warning diagnostic, including the suggested
+// replacement, for every deprecated component used in file. [Convert] already
+// runs this check on the configs it generates.
+func LintDeprecations(file *ast.File) diag.Diagnostics {
+	return common.LintDeprecations(file)
+}
@@ -0,0 +1,210 @@
+package converter_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/token/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertFile(t *testing.T) {
+	in := []byte(`
+scrape_configs:
+  - job_name: "prometheus"
+    static_configs:
+      - targets: ["localhost:9090"]
+remote_write:
+  - url: "http://localhost:9009/api/prom/push"
+`)
+
+	f, diags := converter.ConvertFile(in, converter.InputPrometheus, nil)
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError), diags.Error())
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelCritical), diags.Error())
+	require.NotNil(t, f)
+
+	block := builder.NewBlock([]string{"logging"}, "")
+	block.Body().SetAttributeValue("level", "debug")
+	f.Body().AppendBlock(block)
+
+	out := f.Bytes()
+	require.Contains(t, string(out), `prometheus.scrape "prometheus"`)
+	require.Contains(t, string(out), `level = "debug"`)
+
+	// The printed file must still be valid Alloy syntax.
+	_, err := parser.ParseFile("", out)
+	require.NoError(t, err)
+}
+
+func TestConvertFile_UnknownInput(t *testing.T) {
+	f, diags := converter.ConvertFile(nil, converter.Input("unknown"), nil)
+	require.Nil(t, f)
+	require.Len(t, diags, 1)
+	require.Equal(t, diag.SeverityLevelCritical, diags[0].Severity)
+}
+
+func TestValidate(t *testing.T) {
+	in := []byte(`
+metrics:
+  global:
+    remote_write:
+      - url: http://localhost:9009/api/prom/push
+  configs:
+    - name: "test"
+`)
+
+	_, convertDiags := converter.Convert(in, converter.InputStatic, nil)
+	var warnings int
+	for _, d := range convertDiags {
+		if d.Severity == diag.SeverityLevelWarn {
+			warnings++
+		}
+	}
+	require.Equal(t, 1, warnings)
+	require.False(t, convertDiags.HasSeverityLevel(diag.SeverityLevelError), convertDiags.Error())
+
+	require.Equal(t, convertDiags, converter.Validate(in, converter.InputStatic, nil))
+}
+
+func TestValidate_UnknownInput(t *testing.T) {
+	diags := converter.Validate(nil, converter.Input("unknown"), nil)
+	require.Len(t, diags, 1)
+	require.Equal(t, diag.SeverityLevelCritical, diags[0].Severity)
+}
+
+func TestConvertWithMigrationSteps(t *testing.T) {
+	in := []byte(`
+scrape_configs:
+  - job_name: "prometheus"
+    static_configs:
+      - targets: ["localhost:9090"]
+    basic_auth:
+      username: "user"
+      password_file: "/etc/password"
+remote_write:
+  - url: "http://localhost:9009/api/prom/push"
+    basic_auth:
+      username: "user"
+      password: "plain-text-password"
+`)
+
+	out, steps, diags := converter.ConvertWithMigrationSteps(in, converter.InputPrometheus, nil)
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError), diags.Error())
+	require.Contains(t, string(out), "plain-text-password")
+
+	// Only the secret written in plain text must be moved out of the config.
+	require.Equal(t, []diag.MigrationStep{{
+		Component:   "prometheus.remote_write.default",
+		Description: `Move the secret "endpoint.basic_auth.password" out of the config, for example with sys.env or local.file.`,
+	}}, steps)
+}
+
+func TestConvertWithDebugVariant(t *testing.T) {
+	in := []byte(`
+scrape_configs:
+  - job_name: "prometheus"
+    static_configs:
+      - targets: ["localhost:9090"]
+remote_write:
+  - url: "http://localhost:9009/api/prom/push"
+`)
+
+	out, debugOut, diags := converter.ConvertWithDebugVariant(in, converter.InputPrometheus, nil)
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError), diags.Error())
+
+	// The regular config must not be annotated.
+	require.NotContains(t, string(out), "livedebugging")
+	require.NotContains(t, string(out), "Live debugging is available")
+
+	require.Contains(t, string(debugOut), "livedebugging {\n\tenabled = true\n}")
+	require.Contains(t, string(debugOut), "// Live debugging is available for this component.\nprometheus.scrape \"prometheus\" {")
+	require.Contains(t, string(debugOut), "// Live debugging is available for this component.\nprometheus.remote_write \"default\" {")
+
+	// The debug variant must still be valid Alloy syntax.
+	_, err := parser.ParseFile("", debugOut)
+	require.NoError(t, err)
+}
+
+func TestConverter_PostProcess(t *testing.T) {
+	in := []byte(`
+scrape_configs:
+  - job_name: "prometheus"
+    static_configs:
+      - targets: ["localhost:9090"]
+remote_write:
+  - url: "http://localhost:9009/api/prom/push"
+`)
+
+	var c converter.Converter
+	var calls []string
+	// Set a default scrape interval on every scrape component.
+	c.RegisterPostProcess(func(f *builder.File) diag.Diagnostics {
+		calls = append(calls, "defaults")
+		for _, node := range f.Body().Nodes() {
+			block, ok := node.(*builder.Block)
+			if !ok || strings.Join(block.Name, ".") != "prometheus.scrape" {
+				continue
+			}
+			block.Body().SetAttributeValue("scrape_interval", "30s")
+		}
+		return nil
+	})
+	c.RegisterPostProcess(func(f *builder.File) diag.Diagnostics {
+		calls = append(calls, "report")
+		var diags diag.Diagnostics
+		diags.Add(diag.SeverityLevelInfo, "post-processed")
+		return diags
+	})
+
+	out, diags := c.Convert(in, converter.InputPrometheus, nil)
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError), diags.Error())
+	require.Equal(t, []string{"defaults", "report"}, calls)
+	require.Equal(t, "post-processed", diags[len(diags)-1].Summary)
+	require.Contains(t, string(out), `scrape_interval = "30s"`)
+
+	// The post-processed config is still valid Alloy syntax.
+	_, err := parser.ParseFile("", out)
+	require.NoError(t, err)
+
+	// Without hooks, the output matches Convert.
+	expected, _ := converter.Convert(in, converter.InputPrometheus, nil)
+	out, _ = (&converter.Converter{}).Convert(in, converter.InputPrometheus, nil)
+	require.Equal(t, expected, out)
+}
+
+func TestConvertByTenant(t *testing.T) {
+	in := []byte(`
+scrape_configs:
+  - job_name: "team-a"
+    static_configs:
+      - targets: ["localhost:9090"]
+        labels:
+          tenant: "a"
+  - job_name: "team-b"
+    static_configs:
+      - targets: ["localhost:9091"]
+        labels:
+          tenant: "b"
+remote_write:
+  - url: "http://localhost:9009/api/prom/push"
+`)
+
+	fragments, diags := converter.ConvertByTenant(in, converter.InputPrometheus, nil, "tenant")
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError), diags.Error())
+	require.Len(t, fragments, 3)
+
+	require.Contains(t, string(fragments["a"]), `prometheus.scrape "team_a"`)
+	require.NotContains(t, string(fragments["a"]), `prometheus.scrape "team_b"`)
+	require.Contains(t, string(fragments["b"]), `prometheus.scrape "team_b"`)
+	require.NotContains(t, string(fragments["b"]), `prometheus.scrape "team_a"`)
+	require.Contains(t, string(fragments[converter.SharedFragment]), `prometheus.remote_write "default"`)
+	require.NotContains(t, string(fragments[converter.SharedFragment]), `prometheus.scrape`)
+
+	for tenant, fragment := range fragments {
+		_, err := parser.ParseFile("", fragment)
+		require.NoError(t, err, "fragment of tenant %q", tenant)
+	}
+}
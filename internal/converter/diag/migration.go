@@ -0,0 +1,18 @@
+package diag
+
+import "fmt"
+
+// MigrationStep is a manual step required to complete the migration to a
+// converted config, which the converter can't take by itself.
+type MigrationStep struct {
+	// Component is the component of the converted config affected by the
+	// step, e.g. prometheus.remote_write.default.
+	Component string
+	// Description describes the step to take.
+	Description string
+}
+
+// String returns the step as a line of a checklist.
+func (s MigrationStep) String() string {
+	return fmt.Sprintf("%s: %s", s.Component, s.Description)
+}
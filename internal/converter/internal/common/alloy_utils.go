@@ -7,6 +7,7 @@ import (
 
 	"github.com/grafana/alloy/syntax"
 	"github.com/grafana/alloy/syntax/alloytypes"
+	"github.com/grafana/alloy/syntax/ast"
 	"github.com/grafana/alloy/syntax/parser"
 	"github.com/grafana/alloy/syntax/printer"
 	"github.com/grafana/alloy/syntax/scanner"
@@ -92,30 +93,54 @@ func LabelWithIndex(index int, parts ...interface{}) string {
 }
 
 // PrettyPrint parses Alloy config and returns it in a standardize format.
-// If PrettyPrint fails, the input is returned unmodified.
+// If PrettyPrint fails, the input is returned unmodified. Warnings are
+// returned for deprecated components found in the config.
 func PrettyPrint(in []byte) ([]byte, diag.Diagnostics) {
-	var diags diag.Diagnostics
-
 	// Return early if there was no file.
 	if len(in) == 0 {
-		return in, diags
+		return in, nil
 	}
 
-	f, err := parser.ParseFile("", in)
-	if err != nil {
-		diags.Add(diag.SeverityLevelError, err.Error())
+	f, diags := parseAndLint(in)
+	if f == nil {
 		return in, diags
 	}
 
 	var buf bytes.Buffer
-	if err = printer.Fprint(&buf, f); err != nil {
+	if err := printer.Fprint(&buf, f); err != nil {
 		diags.Add(diag.SeverityLevelError, err.Error())
 		return in, diags
 	}
 
 	// Add a trailing newline at the end of the file, which is omitted by Fprint.
 	_, _ = buf.WriteString("\n")
-	return buf.Bytes(), nil
+	return buf.Bytes(), diags
+}
+
+// LintConfig parses Alloy config and returns the diagnostics [PrettyPrint]
+// would report for it, without formatting it.
+func LintConfig(in []byte) diag.Diagnostics {
+	if len(in) == 0 {
+		return nil
+	}
+	_, diags := parseAndLint(in)
+	return diags
+}
+
+// parseAndLint parses Alloy config and lints it for deprecated components and
+// conflicting remote_write external labels. A nil file is returned if the
+// config couldn't be parsed.
+func parseAndLint(in []byte) (*ast.File, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	f, err := parser.ParseFile("", in)
+	if err != nil {
+		diags.Add(diag.SeverityLevelError, err.Error())
+		return nil, diags
+	}
+	diags.AddAll(LintDeprecations(f))
+	diags.AddAll(LintRemoteWriteExternalLabels(f))
+	return f, diags
 }
 
 func SanitizeIdentifierPanics(in string) string {
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/ast"
+)
+
+// CompactPrint parses Alloy config and returns it with as little whitespace
+// as possible, for example to embed it in an environment variable. Alloy
+// requires statements to be separated by newlines, so each statement starts
+// on a new line, but expressions are written on a single line. Comments are
+// dropped.
+//
+// Like [PrettyPrint], the input is returned unmodified if it can't be parsed,
+// and warnings are returned for deprecated components found in the config.
+func CompactPrint(in []byte) ([]byte, diag.Diagnostics) {
+	// Return early if there was no file.
+	if len(in) == 0 {
+		return in, nil
+	}
+
+	f, diags := parseAndLint(in)
+	if f == nil {
+		return in, diags
+	}
+
+	var sb strings.Builder
+	writeCompactBody(&sb, f.Body)
+	return []byte(sb.String()), diags
+}
+
+func writeCompactBody(sb *strings.Builder, body ast.Body) {
+	for i, stmt := range body {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		switch stmt := stmt.(type) {
+		case *ast.AttributeStmt:
+			sb.WriteString(stmt.Name.Name)
+			sb.WriteByte('=')
+			writeCompactExpr(sb, stmt.Value)
+		case *ast.BlockStmt:
+			sb.WriteString(strings.Join(stmt.Name, "."))
+			if stmt.Label != "" {
+				fmt.Fprintf(sb, " %q", stmt.Label)
+			}
+			sb.WriteByte('{')
+			writeCompactBody(sb, stmt.Body)
+			sb.WriteByte('}')
+		default:
+			panic(fmt.Sprintf("unexpected statement type %T", stmt))
+		}
+	}
+}
+
+func writeCompactExpr(sb *strings.Builder, expr ast.Expr) {
+	switch expr := expr.(type) {
+	case *ast.LiteralExpr:
+		sb.WriteString(expr.Value)
+	case *ast.IdentifierExpr:
+		sb.WriteString(expr.Ident.Name)
+	case *ast.ArrayExpr:
+		sb.WriteByte('[')
+		for i, elem := range expr.Elements {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeCompactExpr(sb, elem)
+		}
+		sb.WriteByte(']')
+	case *ast.ObjectExpr:
+		sb.WriteByte('{')
+		for i, field := range expr.Fields {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if field.Quoted {
+				fmt.Fprintf(sb, "%q", field.Name.Name)
+			} else {
+				sb.WriteString(field.Name.Name)
+			}
+			sb.WriteByte('=')
+			writeCompactExpr(sb, field.Value)
+		}
+		sb.WriteByte('}')
+	case *ast.AccessExpr:
+		writeCompactExpr(sb, expr.Value)
+		sb.WriteByte('.')
+		sb.WriteString(expr.Name.Name)
+	case *ast.IndexExpr:
+		writeCompactExpr(sb, expr.Value)
+		sb.WriteByte('[')
+		writeCompactExpr(sb, expr.Index)
+		sb.WriteByte(']')
+	case *ast.CallExpr:
+		writeCompactExpr(sb, expr.Value)
+		sb.WriteByte('(')
+		for i, arg := range expr.Args {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeCompactExpr(sb, arg)
+		}
+		sb.WriteByte(')')
+	case *ast.UnaryExpr:
+		sb.WriteString(expr.Kind.String())
+		writeCompactExpr(sb, expr.Value)
+	case *ast.BinaryExpr:
+		writeCompactExpr(sb, expr.Left)
+		sb.WriteString(expr.Kind.String())
+		writeCompactExpr(sb, expr.Right)
+	case *ast.ParenExpr:
+		sb.WriteByte('(')
+		writeCompactExpr(sb, expr.Inner)
+		sb.WriteByte(')')
+	default:
+		panic(fmt.Sprintf("unexpected expression type %T", expr))
+	}
+}
@@ -0,0 +1,53 @@
+package common_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/token"
+)
+
+func TestCompactPrint(t *testing.T) {
+	in := `
+// Scrape the local exporter.
+prometheus.scrape "default" {
+	targets = [{
+		__address__ = "localhost:9100",
+		"job"       = "node",
+	}]
+	forward_to      = [prometheus.remote_write.default.receiver]
+	scrape_interval = "1m"
+	sample_limit    = (-1 + 2) * 500
+	enabled         = !false && env("ENABLED") != "false"
+	extra           = [1, 2, 3][0]
+}
+
+prometheus.remote_write "default" {
+	endpoint {
+		url = "http://localhost:9009/api/prom/push"
+
+		queue_config { }
+	}
+}
+`
+	pretty, diags := common.PrettyPrint([]byte(in))
+	require.Empty(t, diags)
+	compact, diags := common.CompactPrint([]byte(in))
+	require.Empty(t, diags)
+
+	// Each statement is on its own line, without indentation.
+	require.Equal(t, 7, strings.Count(string(compact), "\n"))
+	require.NotContains(t, string(compact), "\t")
+
+	prettyFile, err := parser.ParseFile("", pretty)
+	require.NoError(t, err)
+	compactFile, err := parser.ParseFile("", compact)
+	require.NoError(t, err)
+	require.Empty(t, cmp.Diff(prettyFile.Body, compactFile.Body, cmpopts.IgnoreTypes(token.Pos{})))
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/ast"
+)
+
+// DeprecatedComponents maps the names of deprecated or removed components to
+// the name of the component replacing them.
+var DeprecatedComponents = map[string]string{
+	"otelcol.exporter.logging":    "otelcol.exporter.debug",
+	"prometheus.exporter.vsphere": "otelcol.receiver.vcenter",
+}
+
+// LintDeprecations returns a warning diagnostic for every component in file
+// whose name is listed in DeprecatedComponents. Components defined inside
+// declare blocks are checked as well.
+func LintDeprecations(file *ast.File) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if file != nil {
+		lintDeprecations(file.Body, &diags)
+	}
+	return diags
+}
+
+func lintDeprecations(body ast.Body, diags *diag.Diagnostics) {
+	for _, stmt := range body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+
+		name := strings.Join(block.Name, ".")
+		if name == "declare" {
+			lintDeprecations(block.Body, diags)
+			continue
+		}
+
+		if replacement, ok := DeprecatedComponents[name]; ok {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The component %s is deprecated, use %s instead.", name, replacement))
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintDeprecations(t *testing.T) {
+	in := `
+otelcol.exporter.logging "default" { }
+
+otelcol.exporter.debug "default" { }
+
+declare "custom" {
+	prometheus.exporter.vsphere "default" {
+		vsphere_url = "https://localhost/sdk"
+	}
+}
+`
+	f, err := parser.ParseFile("", []byte(in))
+	require.NoError(t, err)
+
+	diags := common.LintDeprecations(f)
+	require.Len(t, diags, 2)
+	require.Equal(t, diag.SeverityLevelWarn, diags[0].Severity)
+	require.Equal(t, "The component otelcol.exporter.logging is deprecated, use otelcol.exporter.debug instead.", diags[0].Summary)
+	require.Equal(t, diag.SeverityLevelWarn, diags[1].Severity)
+	require.Contains(t, diags[1].Summary, "use otelcol.receiver.vcenter instead")
+}
@@ -1,7 +1,9 @@
 package common
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/grafana/alloy/internal/component/common/config"
 	"github.com/grafana/alloy/internal/converter/diag"
@@ -24,19 +26,93 @@ func ToHttpClientConfig(httpClientConfig *prom_config.HTTPClientConfig) *config.
 		TLSConfig:       *ToTLSConfig(&httpClientConfig.TLSConfig),
 		FollowRedirects: httpClientConfig.FollowRedirects,
 		EnableHTTP2:     httpClientConfig.EnableHTTP2,
+		HTTPHeaders:     toHTTPHeaders(httpClientConfig.HTTPHeaders),
 	}
 }
 
 // ValidateHttpClientConfig returns [diag.Diagnostics] for currently
-// unsupported Alloy features available in Prometheus.
-func ValidateHttpClientConfig(httpClientConfig *prom_config.HTTPClientConfig) diag.Diagnostics {
+// unsupported Alloy features available in Prometheus. componentName is the
+// name of the Alloy component the config is converted to, such as
+// prometheus.scrape.
+func ValidateHttpClientConfig(componentName string, httpClientConfig *prom_config.HTTPClientConfig) diag.Diagnostics {
 	var diags diag.Diagnostics
 
 	diags.AddAll(ValidateSupported(NotEquals, httpClientConfig.TLSConfig.MaxVersion, prom_config.TLSVersion(0), "HTTP Client max_version", ""))
+	diags.AddAll(validateHttpClientAuth(componentName, httpClientConfig))
 
 	return diags
 }
 
+// validateHttpClientAuth returns an error for each authentication setting
+// which has no Alloy equivalent, and would otherwise be dropped from the
+// converted config.
+func validateHttpClientAuth(componentName string, httpClientConfig *prom_config.HTTPClientConfig) diag.Diagnostics {
+	var unsupported []string
+	if basicAuth := httpClientConfig.BasicAuth; basicAuth != nil {
+		if basicAuth.UsernameFile != "" {
+			unsupported = append(unsupported, "basic_auth username_file")
+		}
+		if basicAuth.UsernameRef != "" {
+			unsupported = append(unsupported, "basic_auth username_ref")
+		}
+		if basicAuth.PasswordRef != "" {
+			unsupported = append(unsupported, "basic_auth password_ref")
+		}
+	}
+	if authorization := httpClientConfig.Authorization; authorization != nil && authorization.CredentialsRef != "" {
+		unsupported = append(unsupported, "authorization credentials_ref")
+	}
+	if oAuth2 := httpClientConfig.OAuth2; oAuth2 != nil && oAuth2.ClientSecretRef != "" {
+		unsupported = append(unsupported, "oauth2 client_secret_ref")
+	}
+	if headers := httpClientConfig.HTTPHeaders; headers != nil {
+		names := make([]string, 0, len(headers.Headers))
+		for name, header := range headers.Headers {
+			if len(header.Files) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			unsupported = append(unsupported, fmt.Sprintf("http_headers %q files", name))
+		}
+	}
+
+	var diags diag.Diagnostics
+	for _, setting := range unsupported {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the provided %s config of %s: it can't be represented in Alloy, so the component wouldn't authenticate the same way.", setting, componentName))
+	}
+	return diags
+}
+
+// toHTTPHeaders converts the values and secrets of the headers. Header files
+// are reported by validateHttpClientAuth.
+func toHTTPHeaders(headers *prom_config.Headers) *config.Headers {
+	if headers == nil || len(headers.Headers) == 0 {
+		return nil
+	}
+
+	res := &config.Headers{
+		Headers: make(map[string][]alloytypes.Secret, len(headers.Headers)),
+	}
+	for name, header := range headers.Headers {
+		values := make([]alloytypes.Secret, 0, len(header.Values)+len(header.Secrets))
+		for _, value := range header.Values {
+			values = append(values, alloytypes.Secret(value))
+		}
+		for _, secret := range header.Secrets {
+			values = append(values, alloytypes.Secret(secret))
+		}
+		if len(values) > 0 {
+			res.Headers[name] = values
+		}
+	}
+	if len(res.Headers) == 0 {
+		return nil
+	}
+	return res
+}
+
 func toBasicAuth(basicAuth *prom_config.BasicAuth) *config.BasicAuth {
 	if basicAuth == nil {
 		return nil
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/printer"
+)
+
+// LiveDebuggingComment is the comment added above the components supporting
+// live debugging in the debug variant of a converted config.
+const LiveDebuggingComment = "// Live debugging is available for this component."
+
+// liveDebuggingComponents lists the components supporting live debugging.
+// Names ending with a dot match every component in that namespace.
+var liveDebuggingComponents = []string{
+	"discovery.",
+	"loki.process",
+	"loki.relabel",
+	"loki.secretfilter",
+	"otelcol.connector.",
+	"otelcol.processor.",
+	"otelcol.receiver.",
+	"prometheus.relabel",
+	"prometheus.remote_write",
+	"prometheus.scrape",
+}
+
+// SupportsLiveDebugging returns whether the component with the given name
+// supports live debugging.
+func SupportsLiveDebugging(name string) bool {
+	for _, c := range liveDebuggingComponents {
+		if name == c || (strings.HasSuffix(c, ".") && strings.HasPrefix(name, c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LiveDebuggingVariant returns a copy of the converted config in with live
+// debugging enabled, where each top-level component supporting live
+// debugging is annotated with [LiveDebuggingComment]. in is expected to be
+// pretty-printed already, and is returned unmodified if it can't be parsed.
+// If in already has a livedebugging block, it's enabled rather than
+// appending another one.
+func LiveDebuggingVariant(in []byte) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if len(in) == 0 {
+		return in, diags
+	}
+
+	f, err := parser.ParseFile("", in)
+	if err != nil {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the converted config: %s", err))
+		return in, diags
+	}
+
+	enabled := false
+	if block := findLiveDebuggingBlock(f); block != nil {
+		out, err := enableLiveDebugging(in, block)
+		if err != nil {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to enable live debugging in the converted config: %s", err))
+			return in, diags
+		}
+		in = out
+		// The block was edited, so the file is parsed again to find the
+		// components to annotate.
+		if f, err = parser.ParseFile("", in); err != nil {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the converted config: %s", err))
+			return in, diags
+		}
+		enabled = true
+	}
+
+	annotated := map[int]bool{}
+	for _, stmt := range f.Body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if ok && SupportsLiveDebugging(strings.Join(block.Name, ".")) {
+			annotated[ast.StartPos(block).Position().Line] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range bytes.SplitAfter(in, []byte("\n")) {
+		if annotated[i+1] {
+			buf.WriteString(LiveDebuggingComment + "\n")
+		}
+		buf.Write(line)
+	}
+	if !bytes.HasSuffix(in, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	if !enabled {
+		buf.WriteString("\nlivedebugging {\n\tenabled = true\n}\n")
+	}
+	return buf.Bytes(), diags
+}
+
+// findLiveDebuggingBlock returns the top-level livedebugging block of f, or
+// nil if there's none.
+func findLiveDebuggingBlock(f *ast.File) *ast.BlockStmt {
+	for _, stmt := range f.Body {
+		if block, ok := stmt.(*ast.BlockStmt); ok && block.GetBlockName() == "livedebugging" {
+			return block
+		}
+	}
+	return nil
+}
+
+// enableLiveDebugging sets the enabled attribute of the livedebugging block
+// of in to true, and returns the formatted config.
+func enableLiveDebugging(in []byte, block *ast.BlockStmt) ([]byte, error) {
+	var out []byte
+	for _, stmt := range block.Body {
+		attr, ok := stmt.(*ast.AttributeStmt)
+		if !ok || attr.Name.Name != "enabled" {
+			continue
+		}
+		start, end := ast.StartPos(attr.Value).Offset(), ast.EndPos(attr.Value).Offset()+1
+		out = append(out, in[:start]...)
+		out = append(out, "true"...)
+		out = append(out, in[end:]...)
+		break
+	}
+	if out == nil {
+		// The attribute is added at the start of the block.
+		at := block.LCurlyPos.Offset() + 1
+		out = append(out, in[:at]...)
+		out = append(out, "\n\tenabled = true\n"...)
+		out = append(out, in[at:]...)
+	}
+
+	f, err := parser.ParseFile("", out)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, f); err != nil {
+		return nil, err
+	}
+	// Add a trailing newline at the end of the file, which is omitted by Fprint.
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveDebuggingVariant(t *testing.T) {
+	in := `prometheus.remote_write "default" {
+	endpoint {
+		url = "http://localhost:9009/api/prom/push"
+	}
+}
+`
+	out, diags := common.LiveDebuggingVariant([]byte(in))
+	require.Empty(t, diags)
+	require.Equal(t, common.LiveDebuggingComment+"\n"+in+`
+livedebugging {
+	enabled = true
+}
+`, string(out))
+}
+
+func TestLiveDebuggingVariant_ExistingBlock(t *testing.T) {
+	tt := []struct {
+		name string
+		in   string
+	}{
+		{
+			name: "disabled",
+			in: `livedebugging {
+	enabled = false
+}
+
+prometheus.remote_write "default" { }
+`,
+		},
+		{
+			name: "empty",
+			in: `livedebugging { }
+
+prometheus.remote_write "default" { }
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			out, diags := common.LiveDebuggingVariant([]byte(tc.in))
+			require.Empty(t, diags)
+			// The existing block is enabled instead of appending another one.
+			require.Equal(t, `livedebugging {
+	enabled = true
+}
+
+`+common.LiveDebuggingComment+`
+prometheus.remote_write "default" { }
+`, string(out))
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/alloytypes"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/token"
+)
+
+var (
+	secretType         = reflect.TypeOf(alloytypes.Secret(""))
+	optionalSecretType = reflect.TypeOf(alloytypes.OptionalSecret{})
+)
+
+// MigrationSteps returns the manual steps required to complete the migration
+// to the converted config file: secrets written in plain text in the
+// arguments of components must be moved out of the config.
+func MigrationSteps(file *ast.File) []diag.MigrationStep {
+	var steps []diag.MigrationStep
+	if file != nil {
+		migrationSteps(file.Body, &steps)
+	}
+	return steps
+}
+
+func migrationSteps(body ast.Body, steps *[]diag.MigrationStep) {
+	for _, stmt := range body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+
+		name := strings.Join(block.Name, ".")
+		if name == "declare" {
+			migrationSteps(block.Body, steps)
+			continue
+		}
+
+		reg, ok := component.Get(name)
+		if !ok || reg.Args == nil {
+			continue
+		}
+		componentName := name
+		if block.Label != "" {
+			componentName += "." + block.Label
+		}
+		for _, path := range plainTextSecrets(block.Body, reflect.TypeOf(reg.Args), nil) {
+			*steps = append(*steps, diag.MigrationStep{
+				Component:   componentName,
+				Description: fmt.Sprintf("Move the secret %q out of the config, for example with sys.env or local.file.", strings.Join(path, ".")),
+			})
+		}
+	}
+}
+
+// plainTextSecrets returns the path of the attributes of body holding a
+// string literal for a secret argument of the arguments type t.
+func plainTextSecrets(body ast.Body, t reflect.Type, path []string) [][]string {
+	var res [][]string
+	for _, stmt := range body {
+		switch stmt := stmt.(type) {
+		case *ast.AttributeStmt:
+			field, ok := alloyField(t, stmt.Name.Name)
+			if !ok || (field != secretType && field != optionalSecretType) {
+				continue
+			}
+			if lit, ok := stmt.Value.(*ast.LiteralExpr); ok && lit.Kind == token.STRING {
+				res = append(res, append(slices.Clone(path), stmt.Name.Name))
+			}
+		case *ast.BlockStmt:
+			name := strings.Join(stmt.Name, ".")
+			if field, ok := alloyField(t, name); ok {
+				res = append(res, plainTextSecrets(stmt.Body, field, append(slices.Clone(path), name))...)
+			}
+		}
+	}
+	return res
+}
+
+// alloyField returns the type of the field of the struct type t decoding the
+// attribute or block name, looking into squashed fields. Pointers, slices
+// and arrays are dereferenced.
+func alloyField(t reflect.Type, name string) (reflect.Type, bool) {
+	t = elemType(t)
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("alloy")
+		if !ok {
+			continue
+		}
+		fieldName, options, _ := strings.Cut(tag, ",")
+		if fieldName == "" && slices.Contains(strings.Split(options, ","), "squash") {
+			if res, ok := alloyField(field.Type, name); ok {
+				return res, true
+			}
+			continue
+		}
+		if fieldName == name {
+			return elemType(field.Type), true
+		}
+	}
+	return nil, false
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/vm"
+)
+
+// remoteWrite holds the endpoints and external labels of a
+// prometheus.remote_write component.
+type remoteWrite struct {
+	name           string
+	urls           []string
+	externalLabels map[string]string
+}
+
+// LintRemoteWriteExternalLabels returns a warning diagnostic for every pair
+// of prometheus.remote_write components in file which write to the same
+// endpoint URL, but set different values for the same external label. This
+// happens when the jobs of several configs are merged into a single Alloy
+// config, and can cause series to be silently overwritten downstream.
+//
+// Only values written as literals are compared.
+func LintRemoteWriteExternalLabels(file *ast.File) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if file == nil {
+		return diags
+	}
+
+	var remoteWrites []remoteWrite
+	for _, stmt := range file.Body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok || strings.Join(block.Name, ".") != "prometheus.remote_write" {
+			continue
+		}
+		if rw, ok := toRemoteWrite(block); ok {
+			remoteWrites = append(remoteWrites, rw)
+		}
+	}
+
+	for i, a := range remoteWrites {
+		for _, b := range remoteWrites[i+1:] {
+			for _, url := range a.urls {
+				if !slices.Contains(b.urls, url) {
+					continue
+				}
+				for _, name := range slices.Sorted(maps.Keys(a.externalLabels)) {
+					valueB, ok := b.externalLabels[name]
+					if !ok || valueB == a.externalLabels[name] {
+						continue
+					}
+					diags.Add(
+						diag.SeverityLevelWarn,
+						fmt.Sprintf("The components %s and %s both write to %s, but set the external label %q to conflicting values %q and %q.", a.name, b.name, url, name, a.externalLabels[name], valueB),
+					)
+				}
+			}
+		}
+	}
+	return diags
+}
+
+// toRemoteWrite reads the endpoint URLs and external labels of a
+// prometheus.remote_write block. It returns false if the block doesn't set
+// any external labels which can be compared.
+func toRemoteWrite(block *ast.BlockStmt) (remoteWrite, bool) {
+	rw := remoteWrite{name: strings.Join(block.Name, ".") + "." + block.Label}
+	for _, stmt := range block.Body {
+		switch stmt := stmt.(type) {
+		case *ast.AttributeStmt:
+			if stmt.Name.Name != "external_labels" {
+				continue
+			}
+			if err := vm.New(stmt.Value).Evaluate(nil, &rw.externalLabels); err != nil {
+				return rw, false
+			}
+		case *ast.BlockStmt:
+			if strings.Join(stmt.Name, ".") != "endpoint" {
+				continue
+			}
+			for _, endpointStmt := range stmt.Body {
+				attr, ok := endpointStmt.(*ast.AttributeStmt)
+				if !ok || attr.Name.Name != "url" {
+					continue
+				}
+				var url string
+				if err := vm.New(attr.Value).Evaluate(nil, &url); err == nil {
+					rw.urls = append(rw.urls, url)
+				}
+			}
+		}
+	}
+	return rw, len(rw.externalLabels) > 0 && len(rw.urls) > 0
+}
@@ -0,0 +1,67 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintRemoteWriteExternalLabels(t *testing.T) {
+	in := `
+prometheus.scrape "job_a" {
+	targets    = [{"__address__" = "localhost:9090"}]
+	forward_to = [prometheus.remote_write.a.receiver]
+}
+
+prometheus.scrape "job_b" {
+	targets    = [{"__address__" = "localhost:9091"}]
+	forward_to = [prometheus.remote_write.b.receiver]
+}
+
+prometheus.remote_write "a" {
+	external_labels = {
+		cluster = "east",
+		env     = "prod",
+	}
+
+	endpoint {
+		url = "http://mimir:9009/api/v1/push"
+	}
+}
+
+prometheus.remote_write "b" {
+	external_labels = {
+		cluster = "west",
+		env     = "prod",
+	}
+
+	endpoint {
+		url = "http://mimir:9009/api/v1/push"
+	}
+}
+
+prometheus.remote_write "other_endpoint" {
+	external_labels = {
+		cluster = "north",
+	}
+
+	endpoint {
+		url = "http://other:9009/api/v1/push"
+	}
+}
+`
+	f, err := parser.ParseFile("", []byte(in))
+	require.NoError(t, err)
+
+	diags := common.LintRemoteWriteExternalLabels(f)
+	require.Len(t, diags, 1)
+	require.Equal(t, diag.SeverityLevelWarn, diags[0].Severity)
+	require.Equal(t, `The components prometheus.remote_write.a and prometheus.remote_write.b both write to http://mimir:9009/api/v1/push, but set the external label "cluster" to conflicting values "east" and "west".`, diags[0].Summary)
+
+	// The warning is reported when the converted config is pretty-printed.
+	_, diags = common.PrettyPrint([]byte(in))
+	require.Len(t, diags, 1)
+}
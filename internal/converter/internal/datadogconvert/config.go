@@ -0,0 +1,48 @@
+package datadogconvert
+
+// Config is a set of Datadog agent check configurations, keyed by the name of
+// the check. Each value holds the content of the check's conf.yaml file from
+// the conf.d directory of the agent.
+type Config map[string]CheckConfig
+
+// CheckConfig is the configuration of a Datadog agent check.
+type CheckConfig struct {
+	InitConfig map[string]any   `yaml:"init_config"`
+	Instances  []map[string]any `yaml:"instances"`
+}
+
+// OpenMetricsInstance is an instance of the openmetrics check.
+type OpenMetricsInstance struct {
+	OpenMetricsEndpoint   string   `yaml:"openmetrics_endpoint"`
+	Namespace             string   `yaml:"namespace"`
+	Metrics               []any    `yaml:"metrics"`
+	Tags                  []string `yaml:"tags"`
+	MinCollectionInterval float64  `yaml:"min_collection_interval"`
+	Timeout               float64  `yaml:"timeout"`
+}
+
+// HTTPCheckInstance is an instance of the http_check check.
+type HTTPCheckInstance struct {
+	Name                   string            `yaml:"name"`
+	URL                    string            `yaml:"url"`
+	Method                 string            `yaml:"method"`
+	Timeout                float64           `yaml:"timeout"`
+	Headers                map[string]string `yaml:"headers"`
+	TLSVerify              *bool             `yaml:"tls_verify"`
+	ContentMatch           string            `yaml:"content_match"`
+	HTTPResponseStatusCode string            `yaml:"http_response_status_code"`
+	Tags                   []string          `yaml:"tags"`
+	MinCollectionInterval  float64           `yaml:"min_collection_interval"`
+}
+
+const (
+	// defaultMinCollectionInterval is the default interval in seconds at which
+	// the Datadog agent runs checks.
+	defaultMinCollectionInterval = 15
+	// defaultOpenMetricsTimeout is the default timeout in seconds of the
+	// openmetrics check.
+	defaultOpenMetricsTimeout = 10
+	// defaultHTTPCheckTimeout is the default timeout in seconds of the
+	// http_check check.
+	defaultHTTPCheckTimeout = 10
+)
@@ -0,0 +1,233 @@
+package datadogconvert
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	prom_config "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+
+	"github.com/grafana/alloy/internal/component/discovery"
+	"github.com/grafana/alloy/internal/component/prometheus/remotewrite"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// Convert implements a Datadog agent checks config converter.
+//
+// The openmetrics check is converted into prometheus.scrape components, and
+// the http_check check into a prometheus.exporter.blackbox component. Other
+// checks aren't supported and generate a warning. extraArgs can be used to
+// set the remote write URL, see [Options] for the supported flags.
+func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// Render renders the Alloy file f returned by [ConvertFile] and
+// pretty-prints it, like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
+		return nil, diags
+	}
+
+	if len(buf.Bytes()) == 0 {
+		return nil, diags
+	}
+
+	return common.PrettyPrint(buf.Bytes())
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig(f.Bytes()))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	opts, err := parseOptions(extraArgs)
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse extra arguments for the datadog converter %s: %s", extraArgs, err))
+		return nil, diags
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(in, &cfg); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse Datadog checks config: %s", err))
+		return nil, diags
+	}
+
+	f := builder.NewFile()
+	diags.AddAll(AppendAll(f, cfg, opts))
+	diags.AddAll(common.ValidateNodes(f))
+	return f, diags
+}
+
+// AppendAll analyzes the Datadog checks config in memory and transforms it
+// into Alloy components. It then appends each component to the file builder.
+func AppendAll(f *builder.File, cfg Config, opts Options) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	remoteWriteURL := opts.RemoteWriteURL
+	if remoteWriteURL == "" {
+		remoteWriteURL = placeholderRemoteWriteURL
+		diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("No remote write URL was provided with the -remote-write-url extra argument, the placeholder %s is used.", placeholderRemoteWriteURL))
+	}
+
+	checkNames := make([]string, 0, len(cfg))
+	for name := range cfg {
+		checkNames = append(checkNames, name)
+	}
+	slices.Sort(checkNames)
+
+	var (
+		scrapeConfigs []map[string]any
+		httpChecks    []HTTPCheckInstance
+	)
+	for _, name := range checkNames {
+		check := cfg[name]
+		switch name {
+		case "openmetrics":
+			for i, instance := range check.Instances {
+				var om OpenMetricsInstance
+				diags.AddAll(decodeInstance(name, i, instance, &om))
+				sc, newDiags := toScrapeConfig(i, om)
+				diags.AddAll(newDiags)
+				if sc != nil {
+					scrapeConfigs = append(scrapeConfigs, sc)
+				}
+			}
+		case "http_check":
+			for i, instance := range check.Instances {
+				var hc HTTPCheckInstance
+				diags.AddAll(decodeInstance(name, i, instance, &hc))
+				httpChecks = append(httpChecks, hc)
+			}
+		default:
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the %s Datadog check.", name))
+		}
+	}
+
+	promConfig, err := loadPromConfig(scrapeConfigs, remoteWriteURL)
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to build Prometheus config from Datadog checks: %s", err))
+		return diags
+	}
+	diags.AddAll(prometheusconvert.AppendAllNested(f, promConfig, nil, []discovery.Target{}, nil))
+
+	if len(httpChecks) > 0 {
+		diags.AddAll(appendHTTPChecks(f, httpChecks, &remotewrite.Exports{
+			Receiver: common.ConvertAppendable{Expr: "prometheus.remote_write.default.receiver"},
+		}))
+	}
+
+	return diags
+}
+
+// loadPromConfig builds a Prometheus config from the given scrape configs
+// sending metrics to remoteWriteURL. No remote write config is added when
+// remoteWriteURL is empty. The config is loaded from YAML so that Prometheus
+// defaults are applied.
+func loadPromConfig(scrapeConfigs []map[string]any, remoteWriteURL string) (*prom_config.Config, error) {
+	cfg := map[string]any{
+		"scrape_configs": scrapeConfigs,
+	}
+	if remoteWriteURL != "" {
+		cfg["remote_write"] = []map[string]any{{"url": remoteWriteURL}}
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return prom_config.Load(string(out), false, log.NewNopLogger())
+}
+
+// decodeInstance decodes a check instance into out, a pointer to a struct
+// with yaml tags. A warning is returned for every option of the instance
+// which isn't a field of out.
+func decodeInstance(check string, index int, instance map[string]any, out any) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	raw, err := yaml.Marshal(instance)
+	if err == nil {
+		err = yaml.Unmarshal(raw, out)
+	}
+	if err != nil {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse instance %d of the %s Datadog check: %s", index, check, err))
+		return diags
+	}
+
+	supported := map[string]struct{}{}
+	t := reflect.TypeOf(out).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		supported[name] = struct{}{}
+	}
+
+	var unsupported []string
+	for key := range instance {
+		if _, ok := supported[key]; !ok {
+			unsupported = append(unsupported, key)
+		}
+	}
+	slices.Sort(unsupported)
+	for _, key := range unsupported {
+		diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the provided %s option of instance %d of the %s Datadog check.", key, index, check))
+	}
+	return diags
+}
+
+// tagsToLabels converts Datadog "key:value" tags into labels. Tags which
+// can't be converted generate a warning.
+func tagsToLabels(tags []string) (map[string]string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	if len(tags) == 0 {
+		return nil, diags
+	}
+
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the Datadog tag %q without a value.", tag))
+			continue
+		}
+		labels[common.SanitizeIdentifierPanics(key)] = value
+	}
+	return labels, diags
+}
+
+// seconds formats a number of seconds as a Prometheus duration.
+func seconds(s float64) string {
+	return model.Duration(time.Duration(s * float64(time.Second))).String()
+}
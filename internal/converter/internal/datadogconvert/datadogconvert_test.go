@@ -0,0 +1,12 @@
+package datadogconvert_test
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter/internal/datadogconvert"
+	"github.com/grafana/alloy/internal/converter/internal/test_common"
+)
+
+func TestConvert(t *testing.T) {
+	test_common.TestDirectory(t, "testdata", ".yaml", true, []string{"-remote-write-url", "http://mimir:9009/api/v1/push"}, map[string]struct{}{}, datadogconvert.Convert)
+}
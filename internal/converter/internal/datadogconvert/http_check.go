@@ -0,0 +1,143 @@
+package datadogconvert
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/grafana/alloy/internal/component/prometheus/exporter/blackbox"
+	"github.com/grafana/alloy/internal/component/prometheus/remotewrite"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert"
+	"github.com/grafana/alloy/syntax/alloytypes"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// httpCheckLabel is the label of the components generated for the instances
+// of the http_check check.
+const httpCheckLabel = "http_check"
+
+// appendHTTPChecks converts the instances of the http_check check into a
+// prometheus.exporter.blackbox component with one module per instance, and a
+// prometheus.scrape component forwarding the probes to remoteWriteExports.
+func appendHTTPChecks(f *builder.File, checks []HTTPCheckInstance, remoteWriteExports *remotewrite.Exports) diag.Diagnostics {
+	var (
+		diags    diag.Diagnostics
+		modules  = make(map[string]any, len(checks))
+		targets  blackbox.TargetsList
+		interval = 0.0
+	)
+	for i, hc := range checks {
+		if hc.URL == "" {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting instance %d of the http_check Datadog check without a url.", i))
+			continue
+		}
+
+		module := fmt.Sprintf("http_check_%d", i)
+		m, newDiags := toBlackboxModule(i, hc)
+		diags.AddAll(newDiags)
+		modules[module] = m
+
+		name := hc.Name
+		if name == "" {
+			name = module
+		}
+		labels, newDiags := tagsToLabels(hc.Tags)
+		diags.AddAll(newDiags)
+		target := map[string]string{
+			"name":    name,
+			"address": hc.URL,
+			"module":  module,
+		}
+		for k, v := range labels {
+			if _, ok := target[k]; ok {
+				diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the Datadog tag %q of instance %d of the http_check Datadog check, as it conflicts with a blackbox target field.", k, i))
+				continue
+			}
+			target[k] = v
+		}
+		targets = append(targets, target)
+
+		// A single scrape component probes every instance, so use the shortest
+		// collection interval.
+		instanceInterval := hc.MinCollectionInterval
+		if instanceInterval <= 0 {
+			instanceInterval = defaultMinCollectionInterval
+		}
+		if interval == 0 || instanceInterval < interval {
+			interval = instanceInterval
+		}
+	}
+	if len(targets) == 0 {
+		return diags
+	}
+
+	config, err := yaml.Marshal(map[string]any{"modules": modules})
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to build blackbox config from the http_check Datadog check: %s", err))
+		return diags
+	}
+	args := &blackbox.Arguments{
+		Config: alloytypes.OptionalSecret{
+			Value: string(config),
+		},
+		TargetsList:        targets,
+		ProbeTimeoutOffset: blackbox.DefaultArguments.ProbeTimeoutOffset,
+	}
+	f.Body().AppendBlock(common.NewBlockWithOverride([]string{"prometheus", "exporter", "blackbox"}, httpCheckLabel, args))
+
+	promConfig, err := loadPromConfig([]map[string]any{{
+		"job_name":        httpCheckLabel,
+		"scrape_interval": seconds(interval),
+		"scrape_timeout":  seconds(interval),
+	}}, "")
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to build Prometheus config from the http_check Datadog check: %s", err))
+		return diags
+	}
+	scrapeTargets := common.NewDiscoveryTargets(fmt.Sprintf("prometheus.exporter.blackbox.%s.targets", httpCheckLabel))
+	diags.AddAll(prometheusconvert.AppendAllNested(f, promConfig, nil, scrapeTargets, remoteWriteExports))
+	return diags
+}
+
+// toBlackboxModule converts an instance of the http_check check into a
+// blackbox exporter HTTP module.
+func toBlackboxModule(index int, hc HTTPCheckInstance) (map[string]any, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPCheckTimeout
+	}
+
+	probe := map[string]any{}
+	if hc.Method != "" {
+		probe["method"] = hc.Method
+	}
+	if len(hc.Headers) > 0 {
+		probe["headers"] = hc.Headers
+	}
+	if hc.TLSVerify != nil && !*hc.TLSVerify {
+		probe["tls_config"] = map[string]any{"insecure_skip_verify": true}
+	}
+	if hc.ContentMatch != "" {
+		probe["fail_if_body_not_matches_regexp"] = []string{hc.ContentMatch}
+	}
+	if hc.HTTPResponseStatusCode != "" {
+		// Datadog accepts a regular expression, while the blackbox exporter
+		// only accepts a list of status codes.
+		if code, err := strconv.Atoi(hc.HTTPResponseStatusCode); err == nil {
+			probe["valid_status_codes"] = []int{code}
+		} else {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the http_response_status_code %q of instance %d of the http_check Datadog check, only a single status code is supported.", hc.HTTPResponseStatusCode, index))
+		}
+	}
+
+	return map[string]any{
+		"prober":  "http",
+		"timeout": seconds(timeout),
+		"http":    probe,
+	}, diags
+}
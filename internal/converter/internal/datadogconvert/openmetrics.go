@@ -0,0 +1,144 @@
+package datadogconvert
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+)
+
+// toScrapeConfig converts an instance of the openmetrics check into a
+// Prometheus scrape config. A nil scrape config is returned if the instance
+// can't be converted.
+func toScrapeConfig(index int, om OpenMetricsInstance) (map[string]any, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	endpoint, err := url.Parse(om.OpenMetricsEndpoint)
+	if err != nil || endpoint.Host == "" {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting instance %d of the openmetrics Datadog check without a valid openmetrics_endpoint: %q.", index, om.OpenMetricsEndpoint))
+		return nil, diags
+	}
+
+	interval := om.MinCollectionInterval
+	if interval <= 0 {
+		interval = defaultMinCollectionInterval
+	}
+	timeout := om.Timeout
+	if timeout <= 0 {
+		timeout = defaultOpenMetricsTimeout
+	}
+	// Prometheus doesn't allow a scrape timeout greater than the scrape
+	// interval.
+	timeout = min(timeout, interval)
+
+	target := map[string]any{
+		"targets": []string{endpoint.Host},
+	}
+	labels, newDiags := tagsToLabels(om.Tags)
+	diags.AddAll(newDiags)
+	if len(labels) > 0 {
+		target["labels"] = labels
+	}
+
+	sc := map[string]any{
+		"job_name":        jobName(index, om.Namespace),
+		"scrape_interval": seconds(interval),
+		"scrape_timeout":  seconds(timeout),
+		"scheme":          endpoint.Scheme,
+		"static_configs":  []map[string]any{target},
+	}
+	if endpoint.Path != "" {
+		sc["metrics_path"] = endpoint.Path
+	}
+	if len(endpoint.Query()) > 0 {
+		sc["params"] = endpoint.Query()
+	}
+
+	relabelConfigs, newDiags := toMetricRelabelConfigs(index, om)
+	diags.AddAll(newDiags)
+	if len(relabelConfigs) > 0 {
+		sc["metric_relabel_configs"] = relabelConfigs
+	}
+
+	return sc, diags
+}
+
+// jobName returns the job name of the scrape config for the instance at index
+// of the openmetrics check.
+func jobName(index int, namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("openmetrics_%d", index)
+	}
+	return fmt.Sprintf("openmetrics_%d_%s", index, namespace)
+}
+
+// toMetricRelabelConfigs converts the metrics filters, renames, and namespace
+// of an openmetrics instance into Prometheus metric relabel configs.
+//
+// Datadog treats plain metric names as regular expressions, while the keys of
+// rename mappings are exact names.
+func toMetricRelabelConfigs(index int, om OpenMetricsInstance) ([]map[string]any, diag.Diagnostics) {
+	var (
+		diags   diag.Diagnostics
+		keep    []string
+		renames = map[string]string{}
+	)
+	for _, metric := range om.Metrics {
+		switch m := metric.(type) {
+		case string:
+			keep = append(keep, m)
+		case map[any]any:
+			for name, rename := range m {
+				name, nameOk := name.(string)
+				rename, renameOk := rename.(string)
+				if !nameOk || !renameOk {
+					diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the metric %v of instance %d of the openmetrics Datadog check.", metric, index))
+					continue
+				}
+				keep = append(keep, regexp.QuoteMeta(name))
+				renames[name] = rename
+			}
+		default:
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the metric %v of instance %d of the openmetrics Datadog check.", metric, index))
+		}
+	}
+
+	var configs []map[string]any
+	if len(keep) > 0 {
+		configs = append(configs, map[string]any{
+			"source_labels": []string{"__name__"},
+			"regex":         strings.Join(keep, "|"),
+			"action":        "keep",
+		})
+	}
+
+	names := make([]string, 0, len(renames))
+	for name := range renames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		configs = append(configs, map[string]any{
+			"source_labels": []string{"__name__"},
+			"regex":         regexp.QuoteMeta(name),
+			"target_label":  "__name__",
+			"replacement":   renames[name],
+			"action":        "replace",
+		})
+	}
+
+	if om.Namespace != "" {
+		configs = append(configs, map[string]any{
+			"source_labels": []string{"__name__"},
+			"regex":         "(.+)",
+			"target_label":  "__name__",
+			"replacement":   om.Namespace + "_$1",
+			"action":        "replace",
+		})
+	}
+
+	return configs, diags
+}
@@ -0,0 +1,37 @@
+package datadogconvert
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Options holds optional behaviors of the Datadog converter. They can be set
+// by passing extra arguments to [Convert].
+type Options struct {
+	// RemoteWriteURL is the URL of the endpoint the converted metrics are
+	// sent to.
+	RemoteWriteURL string
+}
+
+// placeholderRemoteWriteURL is used when no remote write URL is given to the
+// converter.
+const placeholderRemoteWriteURL = "http://localhost:9009/api/v1/push"
+
+// parseOptions parses the extra arguments given to the converter into
+// Options.
+func parseOptions(extraArgs []string) (Options, error) {
+	var opts Options
+
+	fs := flag.NewFlagSet("datadog", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.RemoteWriteURL, "remote-write-url", "", "URL of the endpoint the converted metrics are sent to.")
+
+	if err := fs.Parse(extraArgs); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %s", fs.Args())
+	}
+	return opts, nil
+}
@@ -0,0 +1,98 @@
+package fluentbitconvert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Config is a Fluent Bit config in the classic format, made of sections
+// such as [INPUT], [FILTER], or [OUTPUT].
+type Config struct {
+	Sections []Section
+	// Includes holds the @INCLUDE directives of the config.
+	Includes []Include
+	// Directives holds the other directives of the config, such as @SET,
+	// which aren't supported by the converter.
+	Directives []string
+}
+
+// Include is an @INCLUDE directive, which includes the sections of the files
+// matching Pattern.
+type Include struct {
+	Pattern string
+	// Index is the number of sections defined before the directive.
+	Index int
+}
+
+// Section is a section of a Fluent Bit config.
+type Section struct {
+	// Name is the upper-cased name of the section, such as INPUT.
+	Name       string
+	Properties []Property
+	// Include is the path of the file the section was included from, empty
+	// for the sections of the main config.
+	Include string
+}
+
+// Property is a key/value entry of a section. Keys are case-insensitive.
+type Property struct {
+	Key   string
+	Value string
+}
+
+// Get returns the value of the last property of s with the given key.
+func (s Section) Get(key string) (string, bool) {
+	for i := len(s.Properties) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.Properties[i].Key, key) {
+			return s.Properties[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Plugin returns the lower-cased name of the plugin configured by s.
+func (s Section) Plugin() string {
+	name, _ := s.Get("Name")
+	return strings.ToLower(name)
+}
+
+// parseConfig parses a Fluent Bit config in the classic format.
+func parseConfig(in []byte) (Config, error) {
+	var cfg Config
+
+	scanner := bufio.NewScanner(bytes.NewReader(in))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "@"):
+			name, pattern, _ := strings.Cut(line, " ")
+			if strings.EqualFold(name, "@INCLUDE") && strings.TrimSpace(pattern) != "" {
+				cfg.Includes = append(cfg.Includes, Include{Pattern: strings.TrimSpace(pattern), Index: len(cfg.Sections)})
+				continue
+			}
+			cfg.Directives = append(cfg.Directives, line)
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return cfg, fmt.Errorf("line %d: invalid section header %q", lineNum, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			cfg.Sections = append(cfg.Sections, Section{Name: strings.ToUpper(name)})
+		default:
+			if len(cfg.Sections) == 0 {
+				return cfg, fmt.Errorf("line %d: property %q is outside of a section", lineNum, line)
+			}
+			key, value := line, ""
+			if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+				key, value = line[:i], strings.TrimSpace(line[i:])
+			}
+			section := &cfg.Sections[len(cfg.Sections)-1]
+			section.Properties = append(section.Properties, Property{Key: key, Value: value})
+		}
+	}
+	return cfg, scanner.Err()
+}
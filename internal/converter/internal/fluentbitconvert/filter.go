@@ -0,0 +1,79 @@
+package fluentbitconvert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/alloy/internal/component/loki/process/stages"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+)
+
+// toStages converts a filter section into loki.process stages. No stages are
+// returned for unsupported filters.
+func toStages(section Section) ([]stages.StageConfig, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	switch section.Plugin() {
+	case "modify":
+		return modifyStages(section)
+	default:
+		diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the %s Fluent Bit filter plugin.", section.Plugin()))
+		return nil, diags
+	}
+}
+
+// modifyStages converts the rules of a modify filter into loki.process
+// stages. Loki log lines aren't structured, so the Add and Set rules are
+// converted into static labels, and the Remove rule drops labels.
+func modifyStages(section Section) ([]stages.StageConfig, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var (
+		result       []stages.StageConfig
+		staticLabels map[string]*string
+	)
+	flushStaticLabels := func() {
+		if len(staticLabels) > 0 {
+			result = append(result, stages.StageConfig{StaticLabelsConfig: &stages.StaticLabelsConfig{Values: staticLabels}})
+			staticLabels = nil
+		}
+	}
+
+	for _, p := range section.Properties {
+		switch key := strings.ToLower(p.Key); key {
+		case "name", "alias", "match", "match_regex":
+		case "add", "set":
+			name, value, ok := cutRule(p.Value)
+			if !ok {
+				diags.Add(diag.SeverityLevelError, fmt.Sprintf("The %s rule %q of the %s must have a key and a value.", p.Key, p.Value, describe(section)))
+				continue
+			}
+			if key == "add" {
+				diags.Add(diag.SeverityLevelInfo, fmt.Sprintf("The Add rule %q of the %s was converted into a static label, which overrides any existing label with the same name.", p.Value, describe(section)))
+			}
+			if staticLabels == nil {
+				staticLabels = map[string]*string{}
+			}
+			staticLabels[common.SanitizeIdentifierPanics(name)] = &value
+		case "remove":
+			flushStaticLabels()
+			result = append(result, stages.StageConfig{LabelDropConfig: &stages.LabelDropConfig{
+				Values: []string{common.SanitizeIdentifierPanics(p.Value)},
+			}})
+		default:
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the %s rule of the %s.", p.Key, describe(section)))
+		}
+	}
+	flushStaticLabels()
+	return result, diags
+}
+
+// cutRule splits the value of a modify rule into its key and value.
+func cutRule(rule string) (key, value string, ok bool) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 {
+		return "", "", false
+	}
+	return fields[0], strings.TrimSpace(strings.TrimPrefix(rule, fields[0])), true
+}
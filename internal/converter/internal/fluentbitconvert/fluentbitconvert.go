@@ -0,0 +1,325 @@
+package fluentbitconvert
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/grafana/alloy/internal/component/common/loki"
+	"github.com/grafana/alloy/internal/component/loki/process"
+	"github.com/grafana/alloy/internal/component/loki/process/stages"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// Convert implements a Fluent Bit config converter for configs in the
+// classic format.
+//
+// The tail input is converted into local.file_match and loki.source.file
+// components, the modify filter into a loki.process component, the loki
+// output into a loki.write component, and the opentelemetry output into
+// otelcol.receiver.loki and otelcol.exporter.otlphttp components. Records are
+// routed from inputs to filters and outputs following their Tag and Match
+// properties. Other plugins aren't supported and generate a warning.
+//
+// Files included with @INCLUDE directives are converted into modules, which
+// are imported with import.string blocks and instantiated from the main
+// config. Relative include paths are resolved from the directory set with the
+// -include-dir extra argument, or from the working directory.
+func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// Render renders the Alloy file f returned by [ConvertFile] and
+// pretty-prints it, like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
+		return nil, diags
+	}
+
+	if len(buf.Bytes()) == 0 {
+		return nil, diags
+	}
+
+	return common.PrettyPrint(buf.Bytes())
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig(f.Bytes()))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	opts, err := parseOptions(extraArgs)
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse extra arguments for the fluentbit converter %s: %s", extraArgs, err))
+		return nil, diags
+	}
+
+	cfg, err := parseConfig(in)
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse Fluent Bit config: %s", err))
+		return nil, diags
+	}
+	diags.AddAll(resolveIncludes(&cfg, opts.IncludeDir))
+
+	f := builder.NewFile()
+	diags.AddAll(AppendAll(f, cfg))
+	diags.AddAll(common.ValidateNodes(f))
+	return f, diags
+}
+
+// outputRoute is an output which records are routed to.
+type outputRoute struct {
+	match func(tag string) bool
+	// expr is the expression referencing the receiver of the output from
+	// its own unit.
+	expr        string
+	label       string
+	description string
+	unit        *unit
+}
+
+// filterRoute is a filter which records are routed through.
+type filterRoute struct {
+	match  func(tag string) bool
+	stages []stages.StageConfig
+}
+
+// AppendAll analyzes the Fluent Bit config in memory and transforms it into
+// Alloy components. It then appends each component to the file builder. The
+// components converted from included files are appended as modules, see
+// [Convert]; cfg must not hold unresolved @INCLUDE directives.
+func AppendAll(f *builder.File, cfg Config) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	for _, include := range cfg.Includes {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The Fluent Bit @INCLUDE %s directive was not resolved.", include.Pattern))
+	}
+	for _, directive := range cfg.Directives {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the Fluent Bit directive %q.", directive))
+	}
+
+	var (
+		mainUnit   = &unit{file: f}
+		units      = map[string]*unit{"": mainUnit}
+		unitOrder  []*unit
+		namespaces = map[string]int{}
+	)
+	unitFor := func(section Section) *unit {
+		u, ok := units[section.Include]
+		if !ok {
+			u = &unit{
+				file:      builder.NewFile(),
+				namespace: namespaceFor(section.Include, namespaces),
+				include:   section.Include,
+			}
+			units[section.Include] = u
+			unitOrder = append(unitOrder, u)
+		}
+		return u
+	}
+
+	// Create the units in the order in which files are included.
+	for _, section := range cfg.Sections {
+		unitFor(section)
+	}
+
+	var (
+		inputs       []Section
+		filters      []filterRoute
+		outputs      []*outputRoute
+		outputBlocks []*builder.Block
+		outputUnits  []*unit
+		labels       = map[string]int{}
+	)
+	for _, section := range cfg.Sections {
+		switch section.Name {
+		case "SERVICE":
+			diags.Add(diag.SeverityLevelInfo, "The [SERVICE] section of the Fluent Bit config has no equivalent and was skipped.")
+		case "INPUT":
+			inputs = append(inputs, section)
+		case "FILTER":
+			st, newDiags := toStages(section)
+			diags.AddAll(newDiags)
+			if len(st) == 0 {
+				continue
+			}
+			match, newDiags := newMatcher(section)
+			diags.AddAll(newDiags)
+			filters = append(filters, filterRoute{match: match, stages: st})
+		case "OUTPUT":
+			label := componentLabel(section, labels)
+			blocks, receiver, newDiags := toOutput(section, label)
+			diags.AddAll(newDiags)
+			if len(blocks) == 0 {
+				continue
+			}
+			match, newDiags := newMatcher(section)
+			diags.AddAll(newDiags)
+			u := unitFor(section)
+			for range blocks {
+				outputUnits = append(outputUnits, u)
+			}
+			outputBlocks = append(outputBlocks, blocks...)
+			outputs = append(outputs, &outputRoute{
+				match:       match,
+				expr:        receiver.(common.ConvertLogsReceiver).Expr,
+				label:       label,
+				description: describe(section),
+				unit:        u,
+			})
+		default:
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the Fluent Bit [%s] section.", section.Name))
+		}
+	}
+
+	for i, section := range inputs {
+		appendInput, ok := inputConverters[section.Plugin()]
+		if !ok {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the %s Fluent Bit input plugin.", section.Plugin()))
+			continue
+		}
+
+		u := unitFor(section)
+		label := componentLabel(section, labels)
+		tag, ok := section.Get("Tag")
+		if !ok {
+			// Fluent Bit tags records with the name of the input instance
+			// by default.
+			tag = fmt.Sprintf("%s.%d", section.Plugin(), i)
+		}
+
+		var forwardTo []loki.LogsReceiver
+		for _, output := range outputs {
+			if output.match(tag) {
+				forwardTo = append(forwardTo, u.receiver(output))
+			}
+		}
+
+		var st []stages.StageConfig
+		for _, filter := range filters {
+			if filter.match(tag) {
+				st = append(st, filter.stages...)
+			}
+		}
+		if len(st) == 0 {
+			diags.AddAll(appendInput(u.file.Body(), section, label, forwardTo))
+			continue
+		}
+
+		diags.AddAll(appendInput(u.file.Body(), section, label, []loki.LogsReceiver{common.ConvertLogsReceiver{
+			Expr: fmt.Sprintf("loki.process.%s.receiver", label),
+		}}))
+		u.file.Body().AppendBlock(common.NewBlockWithOverride([]string{"loki", "process"}, label, &process.Arguments{
+			ForwardTo: forwardTo,
+			Stages:    st,
+		}))
+	}
+
+	for i, block := range outputBlocks {
+		outputUnits[i].file.Body().AppendBlock(block)
+	}
+	for _, u := range unitOrder {
+		diags.AddAll(u.appendModule(f))
+	}
+	return diags
+}
+
+// newMatcher returns a function matching the tags of the records routed to
+// section, following its Match or Match_Regex property. Sections without any
+// of them don't match any record.
+func newMatcher(section Section) (func(tag string) bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	matchNone := func(string) bool { return false }
+	if pattern, ok := section.Get("Match_Regex"); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the Match_Regex property of the %s: %s", describe(section), err))
+			return matchNone, diags
+		}
+		return re.MatchString, diags
+	}
+	if pattern, ok := section.Get("Match"); ok {
+		return wildcardMatcher(pattern), diags
+	}
+
+	diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The %s has no Match property and doesn't receive any record.", describe(section)))
+	return matchNone, diags
+}
+
+// describe returns a description of section for diagnostics, such as
+// "tail input".
+func describe(section Section) string {
+	return section.Plugin() + " " + strings.ToLower(section.Name)
+}
+
+// wildcardMatcher returns a function matching tags against a Fluent Bit
+// Match pattern, where * matches any sequence of characters.
+func wildcardMatcher(pattern string) func(tag string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re := regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+	return re.MatchString
+}
+
+// componentLabel returns the label of the component converted from section:
+// its Alias property if set, or its plugin name suffixed with an index when
+// the plugin is used more than once in the same kind of section. labels
+// counts the uses of each plugin.
+func componentLabel(section Section, labels map[string]int) string {
+	if alias, ok := section.Get("Alias"); ok {
+		return common.SanitizeIdentifierPanics(alias)
+	}
+
+	name := common.SanitizeIdentifierPanics(section.Plugin())
+	key := section.Name + "." + name
+	index := labels[key]
+	labels[key]++
+	return common.LabelWithIndex(index, name)
+}
+
+// unsupportedProperties returns a warning for every property of section
+// which isn't one of the supported keys.
+func unsupportedProperties(section Section, supported ...string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	generic := []string{"name", "alias", "tag", "match", "match_regex"}
+	for _, p := range section.Properties {
+		key := strings.ToLower(p.Key)
+		if slices.Contains(generic, key) || slices.Contains(supported, key) {
+			continue
+		}
+		diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the provided %s property of the %s.", p.Key, describe(section)))
+	}
+	return diags
+}
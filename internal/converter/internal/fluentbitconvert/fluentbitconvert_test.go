@@ -0,0 +1,12 @@
+package fluentbitconvert_test
+
+import (
+	"testing"
+
+	"github.com/grafana/alloy/internal/converter/internal/fluentbitconvert"
+	"github.com/grafana/alloy/internal/converter/internal/test_common"
+)
+
+func TestConvert(t *testing.T) {
+	test_common.TestDirectory(t, "testdata", ".conf", true, []string{"-include-dir", "testdata"}, map[string]struct{}{}, fluentbitconvert.Convert)
+}
@@ -0,0 +1,211 @@
+package fluentbitconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/alloy/internal/component/common/loki"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/token"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// moduleComponent is the name of the custom component declared by the
+// module converted from an included file.
+const moduleComponent = "pipeline"
+
+// resolveIncludes reads the files included by the @INCLUDE directives of cfg
+// and inserts their sections where the directives were defined. Relative
+// patterns are resolved from dir.
+func resolveIncludes(cfg *Config, dir string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	var (
+		sections []Section
+		next     int
+	)
+	for _, include := range cfg.Includes {
+		sections = append(sections, cfg.Sections[next:include.Index]...)
+		next = include.Index
+
+		pattern := include.Pattern
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil || len(paths) == 0 {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to resolve the Fluent Bit @INCLUDE %s directive: no file matches %q", include.Pattern, pattern))
+			continue
+		}
+
+		for _, path := range paths {
+			in, err := os.ReadFile(path)
+			if err != nil {
+				diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to read the Fluent Bit file %s included by @INCLUDE: %s", path, err))
+				continue
+			}
+			included, err := parseConfig(in)
+			if err != nil {
+				diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the Fluent Bit file %s included by @INCLUDE: %s", path, err))
+				continue
+			}
+			if len(included.Includes) > 0 {
+				diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the @INCLUDE directives of the included Fluent Bit file %s.", path))
+			}
+			for _, directive := range included.Directives {
+				diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the Fluent Bit directive %q of the included file %s.", directive, path))
+			}
+			// Keep relative paths as written, so the converted config
+			// doesn't depend on the directory it was converted from.
+			name := path
+			if !filepath.IsAbs(include.Pattern) {
+				if rel, err := filepath.Rel(filepath.Join(dir, "."), path); err == nil {
+					name = rel
+				}
+			}
+			for _, section := range included.Sections {
+				section.Include = name
+				sections = append(sections, section)
+			}
+		}
+	}
+	cfg.Sections = append(sections, cfg.Sections[next:]...)
+	cfg.Includes = nil
+	return diags
+}
+
+// unit holds the components converted from the main config, or from an
+// included file. The components of an included file are converted into a
+// module, imported with an import.string block and instantiated from the
+// main config, which preserves the layout of the Fluent Bit config.
+type unit struct {
+	file *builder.File
+	// namespace is the label of the import.string block importing the
+	// module, empty for the main config.
+	namespace string
+	include   string
+
+	// args are the outputs of other units which the components of the
+	// module send records to, and exports the outputs of the module which
+	// components of other units send records to.
+	args    []*outputRoute
+	exports []*outputRoute
+}
+
+// receiver returns the receiver of output to which the components of u send
+// records, adding the arguments and exports required to reference an output
+// of another unit.
+func (u *unit) receiver(output *outputRoute) loki.LogsReceiver {
+	if output.unit == u {
+		return common.ConvertLogsReceiver{Expr: output.expr}
+	}
+
+	output.unit.export(output)
+	if u.namespace == "" {
+		return common.ConvertLogsReceiver{Expr: output.unit.exportExpr(output)}
+	}
+	if !containsRoute(u.args, output) {
+		u.args = append(u.args, output)
+	}
+	return common.ConvertLogsReceiver{Expr: fmt.Sprintf("argument.%s.value", output.label)}
+}
+
+// export exports the receiver of output from the module of u. Outputs of
+// the main config don't need to be exported.
+func (u *unit) export(output *outputRoute) {
+	if u.namespace != "" && !containsRoute(u.exports, output) {
+		u.exports = append(u.exports, output)
+	}
+}
+
+// exportExpr returns the expression referencing the receiver of output from
+// the main config.
+func (u *unit) exportExpr(output *outputRoute) string {
+	if u.namespace == "" {
+		return output.expr
+	}
+	return fmt.Sprintf("%s.%s.default.%s", u.namespace, moduleComponent, output.label)
+}
+
+func containsRoute(routes []*outputRoute, route *outputRoute) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// appendModule appends to f the import.string block importing the module
+// converted from u, and the instance of its custom component. Nothing is
+// appended if no component was converted from u.
+func (u *unit) appendModule(f *builder.File) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if len(u.file.Body().Nodes()) == 0 {
+		return diags
+	}
+
+	declare := builder.NewBlock([]string{"declare"}, moduleComponent)
+	for _, arg := range u.args {
+		block := builder.NewBlock([]string{"argument"}, arg.label)
+		block.Body().SetAttributeValue("comment", fmt.Sprintf("Receiver of the %s %s.", arg.label, arg.description))
+		declare.Body().AppendBlock(block)
+	}
+	for _, node := range u.file.Body().Nodes() {
+		if block, ok := node.(*builder.Block); ok {
+			declare.Body().AppendBlock(block)
+		} else {
+			declare.Body().AppendTokens(node.Tokens())
+		}
+	}
+	for _, export := range u.exports {
+		block := builder.NewBlock([]string{"export"}, export.label)
+		block.Body().SetAttributeTokens("value", []builder.Token{{Tok: token.STRING, Lit: export.expr}})
+		declare.Body().AppendBlock(block)
+	}
+
+	module := builder.NewFile()
+	module.Body().AppendBlock(declare)
+	content, newDiags := common.PrettyPrint(module.Bytes())
+	diags.AddAll(newDiags)
+
+	// Raw strings can't contain backticks, so fall back to a quoted string
+	// if the module does.
+	contentLit := "`" + string(content) + "`"
+	if strings.Contains(string(content), "`") {
+		contentLit = strconv.Quote(string(content))
+	}
+
+	comment := fmt.Sprintf("// Converted from the Fluent Bit file %s included by @INCLUDE.", u.include)
+	f.Body().AppendTokens([]builder.Token{
+		// Separate the comment from the previous block.
+		{Tok: token.LITERAL, Lit: "\n"},
+		{Tok: token.COMMENT, Lit: comment},
+	})
+	importBlock := builder.NewBlock([]string{"import", "string"}, u.namespace)
+	importBlock.Body().SetAttributeTokens("content", []builder.Token{{Tok: token.STRING, Lit: contentLit}})
+	f.Body().AppendBlock(importBlock)
+
+	instance := builder.NewBlock([]string{u.namespace, moduleComponent}, "default")
+	for _, arg := range u.args {
+		instance.Body().SetAttributeTokens(arg.label, []builder.Token{{Tok: token.STRING, Lit: arg.unit.exportExpr(arg)}})
+	}
+	f.Body().AppendBlock(instance)
+	return diags
+}
+
+// namespaceFor returns the label of the import.string block importing the
+// module converted from the included file at path. The label is prefixed so
+// that it doesn't shadow the namespace of builtin components, such as loki
+// for a loki.conf file. namespaces counts the uses of each label.
+func namespaceFor(path string, namespaces map[string]int) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name = common.SanitizeIdentifierPanics("include_" + name)
+	index := namespaces[name]
+	namespaces[name]++
+	return common.LabelWithIndex(index, name)
+}
@@ -0,0 +1,116 @@
+package fluentbitconvert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/alloy/internal/component/common/loki"
+	"github.com/grafana/alloy/internal/component/discovery"
+	filematch "github.com/grafana/alloy/internal/component/local/file_match"
+	lokisourcefile "github.com/grafana/alloy/internal/component/loki/source/file"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// inputConverters holds the functions appending the components converted
+// from an input section to body, sending the records they read to forwardTo,
+// for each supported input plugin.
+var inputConverters = map[string]func(body *builder.Body, section Section, label string, forwardTo []loki.LogsReceiver) diag.Diagnostics{
+	"tail": appendTail,
+}
+
+// appendTail converts a tail input into local.file_match and
+// loki.source.file components.
+func appendTail(body *builder.Body, section Section, label string, forwardTo []loki.LogsReceiver) diag.Diagnostics {
+	diags := unsupportedProperties(section, "path", "exclude_path", "refresh_interval", "read_from_head", "db")
+
+	paths := splitList(section, "Path")
+	if len(paths) == 0 {
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The %s has no Path property.", describe(section)))
+		return diags
+	}
+	if _, ok := section.Get("DB"); ok {
+		diags.Add(diag.SeverityLevelInfo, fmt.Sprintf("The DB property of the %s was skipped, loki.source.file keeps track of the positions of the files it reads in its own storage.", describe(section)))
+	}
+
+	var exclude string
+	switch excludes := splitList(section, "Exclude_Path"); len(excludes) {
+	case 0:
+	case 1:
+		exclude = excludes[0]
+	default:
+		exclude = "{" + strings.Join(excludes, ",") + "}"
+	}
+
+	var fileMatchArgs filematch.Arguments
+	fileMatchArgs.SetToDefault()
+	for _, path := range paths {
+		target := map[string]string{"__path__": path}
+		if exclude != "" {
+			target["__path_exclude__"] = exclude
+		}
+		fileMatchArgs.PathTargets = append(fileMatchArgs.PathTargets, discovery.NewTargetFromMap(target))
+	}
+	if interval, ok := section.Get("Refresh_Interval"); ok {
+		seconds, err := strconv.Atoi(interval)
+		if err != nil {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the Refresh_Interval property of the %s: %s", describe(section), err))
+		} else {
+			fileMatchArgs.SyncPeriod = time.Duration(seconds) * time.Second
+		}
+	}
+	body.AppendBlock(common.NewBlockWithOverride([]string{"local", "file_match"}, label, fileMatchArgs))
+
+	// Unlike loki.source.file, Fluent Bit starts reading new files from
+	// their end unless Read_from_Head is set.
+	readFromHead, newDiags := boolProperty(section, "Read_from_Head")
+	diags.AddAll(newDiags)
+
+	sourceArgs := lokisourcefile.DefaultArguments
+	sourceArgs.ForwardTo = forwardTo
+	sourceArgs.TailFromEnd = !readFromHead
+	targets := fmt.Sprintf("local.file_match.%s.targets", label)
+	body.AppendBlock(common.NewBlockWithOverrideFn([]string{"loki", "source", "file"}, label, sourceArgs, func(val interface{}) interface{} {
+		if _, ok := val.([]discovery.Target); ok {
+			return common.CustomTokenizer{Expr: targets}
+		}
+		return val
+	}))
+	return diags
+}
+
+// splitList returns the comma-separated values of the property of section
+// with the given key.
+func splitList(section Section, key string) []string {
+	value, _ := section.Get(key)
+
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// boolProperty parses the boolean property of section with the given key,
+// which defaults to false.
+func boolProperty(section Section, key string) (bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	value, ok := section.Get(key)
+	if !ok {
+		return false, diags
+	}
+	switch strings.ToLower(value) {
+	case "on", "true", "yes":
+		return true, diags
+	case "off", "false", "no":
+		return false, diags
+	}
+	diags.Add(diag.SeverityLevelError, fmt.Sprintf("failed to parse the %s property of the %s: invalid boolean %q", key, describe(section), value))
+	return false, diags
+}
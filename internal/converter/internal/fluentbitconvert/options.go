@@ -0,0 +1,34 @@
+package fluentbitconvert
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Options holds optional behaviors of the Fluent Bit converter, set by
+// passing extra arguments to [Convert].
+type Options struct {
+	// IncludeDir is the directory from which the relative paths of @INCLUDE
+	// directives are resolved. It should be the directory of the converted
+	// config, and defaults to the working directory.
+	IncludeDir string
+}
+
+// parseOptions parses the extra arguments given to the converter into
+// Options.
+func parseOptions(extraArgs []string) (Options, error) {
+	var opts Options
+
+	fs := flag.NewFlagSet("fluentbit", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.IncludeDir, "include-dir", "", "Directory from which the relative paths of @INCLUDE directives are resolved.")
+
+	if err := fs.Parse(extraArgs); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %s", fs.Args())
+	}
+	return opts, nil
+}
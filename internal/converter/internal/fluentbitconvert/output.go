@@ -0,0 +1,180 @@
+package fluentbitconvert
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/grafana/alloy/internal/component/common/config"
+	"github.com/grafana/alloy/internal/component/common/loki"
+	lokiwrite "github.com/grafana/alloy/internal/component/loki/write"
+	"github.com/grafana/alloy/internal/component/otelcol"
+	"github.com/grafana/alloy/internal/component/otelcol/exporter/otlphttp"
+	otelcolloki "github.com/grafana/alloy/internal/component/otelcol/receiver/loki"
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/syntax/alloytypes"
+	"github.com/grafana/alloy/syntax/token"
+	"github.com/grafana/alloy/syntax/token/builder"
+)
+
+// toOutput converts an output section into Alloy blocks, and returns the
+// receiver of the records sent to the output. No blocks are returned for
+// unsupported outputs.
+func toOutput(section Section, label string) ([]*builder.Block, loki.LogsReceiver, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	switch section.Plugin() {
+	case "loki":
+		return toLokiWrite(section, label)
+	case "opentelemetry":
+		return toOTLPHTTP(section, label)
+	default:
+		diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the %s Fluent Bit output plugin.", section.Plugin()))
+		return nil, nil, diags
+	}
+}
+
+// toLokiWrite converts a loki output into a loki.write component.
+func toLokiWrite(section Section, label string) ([]*builder.Block, loki.LogsReceiver, diag.Diagnostics) {
+	diags := unsupportedProperties(section, "host", "port", "uri", "tls", "tls.verify", "http_user", "http_passwd", "tenant_id", "labels")
+
+	endpoint := lokiwrite.GetDefaultEndpointOptions()
+	url, newDiags := endpointURL(section, "3100", "Uri", "/loki/api/v1/push")
+	diags.AddAll(newDiags)
+	endpoint.URL = url
+	endpoint.TenantID, _ = section.Get("Tenant_ID")
+	if user, ok := section.Get("HTTP_User"); ok {
+		password, _ := section.Get("HTTP_Passwd")
+		endpoint.HTTPClientConfig.BasicAuth = &config.BasicAuth{
+			Username: user,
+			Password: alloytypes.Secret(password),
+		}
+	}
+	verify, newDiags := tlsVerify(section)
+	diags.AddAll(newDiags)
+	endpoint.HTTPClientConfig.TLSConfig.InsecureSkipVerify = !verify
+
+	externalLabels := map[string]string{}
+	if _, ok := section.Get("Labels"); !ok {
+		// Fluent Bit sets the job label by default.
+		externalLabels["job"] = "fluent-bit"
+	}
+	for _, kv := range splitList(section, "Labels") {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || strings.HasPrefix(strings.TrimSpace(value), "$") {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The converter does not support converting the label %q of the %s, only static labels are supported.", kv, describe(section)))
+			continue
+		}
+		externalLabels[common.SanitizeIdentifierPanics(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	args := lokiwrite.Arguments{
+		Endpoints: []lokiwrite.EndpointOptions{endpoint},
+	}
+	if len(externalLabels) > 0 {
+		args.ExternalLabels = externalLabels
+	}
+	block := common.NewBlockWithOverride([]string{"loki", "write"}, label, args)
+	return []*builder.Block{block}, common.ConvertLogsReceiver{
+		Expr: fmt.Sprintf("loki.write.%s.receiver", label),
+	}, diags
+}
+
+// toOTLPHTTP converts an opentelemetry output into an otelcol.receiver.loki
+// component sending logs to an otelcol.exporter.otlphttp component.
+func toOTLPHTTP(section Section, label string) ([]*builder.Block, loki.LogsReceiver, diag.Diagnostics) {
+	diags := unsupportedProperties(section, "host", "port", "logs_uri", "tls", "tls.verify", "header")
+
+	var exporterArgs otlphttp.Arguments
+	exporterArgs.SetToDefault()
+	endpoint, newDiags := endpointURL(section, "80", "", "")
+	diags.AddAll(newDiags)
+	exporterArgs.Client.Endpoint = endpoint
+	if uri, ok := section.Get("Logs_URI"); ok && uri != "/v1/logs" {
+		exporterArgs.LogsEndpoint = exporterArgs.Client.Endpoint + uri
+	}
+	verify, newDiags := tlsVerify(section)
+	diags.AddAll(newDiags)
+	exporterArgs.Client.TLS.InsecureSkipVerify = !verify
+	for _, p := range section.Properties {
+		if !strings.EqualFold(p.Key, "Header") {
+			continue
+		}
+		name, value, ok := cutRule(p.Value)
+		if !ok {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("The Header %q of the %s must have a name and a value.", p.Value, describe(section)))
+			continue
+		}
+		if exporterArgs.Client.Headers == nil {
+			exporterArgs.Client.Headers = map[string]string{}
+		}
+		exporterArgs.Client.Headers[name] = value
+	}
+
+	receiverArgs := otelcolloki.Arguments{
+		Output: &otelcol.ConsumerArguments{
+			Logs: []otelcol.Consumer{tokenizedConsumer{Expr: fmt.Sprintf("otelcol.exporter.otlphttp.%s.input", label)}},
+		},
+	}
+	blocks := []*builder.Block{
+		common.NewBlockWithOverride([]string{"otelcol", "receiver", "loki"}, label, receiverArgs),
+		common.NewBlockWithOverride([]string{"otelcol", "exporter", "otlphttp"}, label, exporterArgs),
+	}
+	return blocks, common.ConvertLogsReceiver{
+		Expr: fmt.Sprintf("otelcol.receiver.loki.%s.receiver", label),
+	}, diags
+}
+
+// endpointURL returns the URL of the endpoint of an output from its Host,
+// Port, and TLS properties, and the property holding its path, if any.
+func endpointURL(section Section, defaultPort, pathKey, defaultPath string) (string, diag.Diagnostics) {
+	host, ok := section.Get("Host")
+	if !ok {
+		host = "127.0.0.1"
+	}
+	port, ok := section.Get("Port")
+	if !ok {
+		port = defaultPort
+	}
+
+	scheme := "http"
+	tls, diags := boolProperty(section, "TLS")
+	if tls {
+		scheme = "https"
+	}
+
+	path := defaultPath
+	if pathKey != "" {
+		if p, ok := section.Get(pathKey); ok {
+			path = p
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + path, diags
+}
+
+// tlsVerify returns whether the certificate of the endpoint of an output is
+// verified, which is the default.
+func tlsVerify(section Section) (bool, diag.Diagnostics) {
+	if _, ok := section.Get("TLS.Verify"); !ok {
+		return true, nil
+	}
+	return boolProperty(section, "TLS.Verify")
+}
+
+// tokenizedConsumer is an otelcol.Consumer rendered as the expression
+// referencing it.
+type tokenizedConsumer struct {
+	otelcol.Consumer
+
+	Expr string
+}
+
+func (tc tokenizedConsumer) AlloyCapsule() {}
+
+func (tc tokenizedConsumer) AlloyTokenize() []builder.Token {
+	return []builder.Token{{
+		Tok: token.STRING,
+		Lit: tc.Expr,
+	}}
+}
@@ -126,6 +126,12 @@ func (state *State) alloyLabelForComponent(c groupedInstanceID) string {
 		componentName = c.ComponentID().Name()
 	)
 
+	// Connectors link pipelines together, so a single Alloy component is
+	// created for each of them regardless of the pipelines referencing it.
+	if _, isConnector := state.cfg.Connectors[c.ComponentID()]; isConnector {
+		groupName = ""
+	}
+
 	// We want to make the component label as idiomatic as possible. If both the
 	// group and component name are empty, we'll name it "default," aligning
 	// with standard Alloy naming conventions.
@@ -196,9 +202,11 @@ func (state *State) nextInstances(c componentstatus.InstanceID, signal pipeline.
 
 	groups := make([]*pipelineGroup, 0)
 
-	if c.Kind() == component.KindReceiver {
+	if c.Kind() == component.KindReceiver || c.Kind() == component.KindConnector {
 		// For receivers we need to check all groups because the same receiver might be used in multiple groups.
-		// TODO: should we also dedup exporters and connectors?
+		// Connectors also need to check all groups, since they send data to the
+		// pipelines using them as a receiver, which may be in other groups.
+		// TODO: should we also dedup exporters?
 		for _, group := range state.groups {
 			groups = append(groups, &group)
 		}
@@ -2,6 +2,7 @@ package otelcolconvert
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"fmt"
 	"regexp"
@@ -23,6 +24,7 @@ import (
 	"go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/service/pipelines"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 // This package is split into a set of [componentConverter] implementations
@@ -56,6 +58,56 @@ var envvarRegexp *regexp.Regexp = regexp.MustCompile(
 // but unused, and a critical error diagnostic is returned if extraArgs is
 // non-empty.
 func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// Render renders the Alloy file f returned by [ConvertFile], rewriting
+// environment variable references into sys.env calls, and pretty-prints it,
+// like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
+		return nil, diags
+	}
+
+	if len(buf.Bytes()) == 0 {
+		return nil, diags
+	}
+
+	converted := convertEnvvars(buf.String())
+
+	return common.PrettyPrint([]byte(converted))
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig([]byte(convertEnvvars(string(f.Bytes())))))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+//
+// Environment variable references in the returned file are not yet rewritten
+// into sys.env calls; [Convert] does that when rendering the file.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	if len(extraArgs) > 0 {
@@ -77,22 +129,7 @@ func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
 
 	diags.AddAll(AppendConfig(f, cfg, "", nil, true))
 	diags.AddAll(common.ValidateNodes(f))
-
-	var buf bytes.Buffer
-	if _, err := f.WriteTo(&buf); err != nil {
-		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
-		return nil, diags
-	}
-
-	if len(buf.Bytes()) == 0 {
-		return nil, diags
-	}
-
-	converted := convertEnvvars(buf.String())
-
-	prettyByte, newDiags := common.PrettyPrint([]byte(converted))
-	diags.AddAll(newDiags)
-	return prettyByte, diags
+	return f, diags
 }
 
 // convertEnvvars converts envvar-like strings into alloy sys.env() calls.
@@ -254,7 +291,6 @@ func AppendConfig(file *builder.File, cfg *otelcol.Config, labelPrefix string, e
 			{component.KindReceiver, receiverIDs, cfg.Receivers},
 			{component.KindProcessor, processorIDs, cfg.Processors},
 			{component.KindExporter, exporterIDs, cfg.Exporters},
-			{component.KindConnector, connectorIDs, cfg.Connectors},
 		}
 
 		for _, componentSet := range componentSets {
@@ -287,6 +323,37 @@ func AppendConfig(file *builder.File, cfg *otelcol.Config, labelPrefix string, e
 		}
 	}
 
+	// Connectors are converted once, after the pipelines, since they can
+	// link pipelines of different groups together.
+	slices.SortFunc(connectorIDs, func(a, b component.ID) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+	for _, id := range connectorIDs {
+		componentID := *componentstatus.NewInstanceID(id, component.KindConnector)
+
+		state := &State{
+			cfg:    cfg,
+			file:   file,
+			groups: groups, // use unfiltered groups
+			group:  &pipelineGroup{},
+
+			converterLookup: converterTable,
+			extensionLookup: extensionTable,
+
+			componentConfig:      cfg.Connectors[id],
+			componentID:          componentID,
+			componentLabelPrefix: labelPrefix,
+		}
+
+		key := converterKey{Kind: component.KindConnector, Type: id.Type()}
+		conv, ok := converterTable[key]
+		if !ok {
+			panic(fmt.Sprintf("otelcolconvert: no converter found for key %v", key))
+		}
+
+		diags.AddAll(conv.ConvertAndAppend(state, componentID, cfg.Connectors[id]))
+	}
+
 	return diags
 }
 
@@ -12,6 +12,7 @@ import (
 // them to an Alloy file. This gives control over the order they are written
 // versus appending them in the order the Blocks are created.
 type PrometheusBlocks struct {
+	PrometheusExporterBlocks    []prometheusBlock
 	DiscoveryBlocks             []prometheusBlock
 	DiscoveryRelabelBlocks      []prometheusBlock
 	PrometheusScrapeBlocks      []prometheusBlock
@@ -21,6 +22,7 @@ type PrometheusBlocks struct {
 
 func NewPrometheusBlocks() *PrometheusBlocks {
 	return &PrometheusBlocks{
+		PrometheusExporterBlocks:    []prometheusBlock{},
 		DiscoveryBlocks:             []prometheusBlock{},
 		DiscoveryRelabelBlocks:      []prometheusBlock{},
 		PrometheusScrapeBlocks:      []prometheusBlock{},
@@ -32,12 +34,17 @@ func NewPrometheusBlocks() *PrometheusBlocks {
 // AppendToBody attaches prometheus blocks in a specific order.
 //
 // Order of blocks:
-// 1. Discovery component(s)
-// 2. Discovery relabel component(s) (if any)
-// 3. Prometheus scrape component(s)
-// 4. Prometheus relabel component(s) (if any)
-// 5. Prometheus remote_write
+// 1. Prometheus exporter component(s) (if any)
+// 2. Discovery component(s)
+// 3. Discovery relabel component(s) (if any)
+// 4. Prometheus scrape component(s)
+// 5. Prometheus relabel component(s) (if any)
+// 6. Prometheus remote_write
 func (pb *PrometheusBlocks) AppendToBody(body *builder.Body) {
+	for _, promBlock := range pb.PrometheusExporterBlocks {
+		body.AppendBlock(promBlock.block)
+	}
+
 	for _, promBlock := range pb.DiscoveryBlocks {
 		body.AppendBlock(promBlock.block)
 	}
@@ -65,6 +72,12 @@ func (pb *PrometheusBlocks) GetScrapeInfo() diag.Diagnostics {
 	for _, promScrapeBlock := range pb.PrometheusScrapeBlocks {
 		detail := promScrapeBlock.detail
 
+		for _, promExporterBlock := range pb.PrometheusExporterBlocks {
+			if strings.HasPrefix(promExporterBlock.label, promScrapeBlock.label) {
+				detail = fmt.Sprintln(detail) + fmt.Sprintf("	A %s.%s component", strings.Join(promExporterBlock.name, "."), promExporterBlock.label)
+			}
+		}
+
 		for _, promDiscoveryBlock := range pb.DiscoveryBlocks {
 			if strings.HasPrefix(promDiscoveryBlock.label, promScrapeBlock.label) {
 				detail = fmt.Sprintln(detail) + fmt.Sprintf("	A %s.%s component", strings.Join(promDiscoveryBlock.name, "."), promDiscoveryBlock.label)
@@ -110,3 +123,9 @@ func NewPrometheusBlock(block *builder.Block, name []string, label string, summa
 		detail:  detail,
 	}
 }
+
+// Body returns the body of the block, to override the converted value of its
+// attributes.
+func (b prometheusBlock) Body() *builder.Body {
+	return b.block.Body()
+}
@@ -41,7 +41,7 @@ func toDiscoveryAzure(sdConfig *prom_azure.SDConfig) *azure.Arguments {
 }
 
 func ValidateDiscoveryAzure(sdConfig *prom_azure.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.azure", &sdConfig.HTTPClientConfig)
 }
 
 func toManagedIdentity(sdConfig *prom_azure.SDConfig) *azure.ManagedIdentity {
@@ -21,7 +21,7 @@ func appendDiscoveryConsul(pb *build.PrometheusBlocks, label string, sdConfig *p
 }
 
 func ValidateDiscoveryConsul(sdConfig *prom_consul.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.consul", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryConsul(sdConfig *prom_consul.SDConfig) *consul.Arguments {
@@ -33,7 +33,7 @@ func ValidateDiscoveryDigitalOcean(sdConfig *prom_digitalocean.SDConfig) diag.Di
 	diags.AddAll(common.ValidateSupported(common.NotEquals, sdConfig.HTTPClientConfig.OAuth2, nilOAuth2, "digitalocean_sd_configs oauth2", ""))
 	diags.AddAll(common.ValidateSupported(common.NotDeepEquals, sdConfig.HTTPClientConfig.TLSConfig, prom_config.TLSConfig{}, "digitalocean_sd_configs tls_config", ""))
 
-	diags.AddAll(common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig))
+	diags.AddAll(common.ValidateHttpClientConfig("discovery.digitalocean", &sdConfig.HTTPClientConfig))
 
 	return diags
 }
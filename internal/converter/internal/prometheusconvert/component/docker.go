@@ -20,7 +20,7 @@ func appendDiscoveryDocker(pb *build.PrometheusBlocks, label string, sdConfig *p
 }
 
 func ValidateDiscoveryDocker(sdConfig *prom_moby.DockerSDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.docker", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryDocker(sdConfig *prom_moby.DockerSDConfig) *docker.Arguments {
@@ -20,7 +20,7 @@ func appendDiscoveryDockerswarm(pb *build.PrometheusBlocks, label string, sdConf
 }
 
 func ValidateDiscoveryDockerswarm(sdConfig *prom_moby.DockerSwarmSDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.dockerswarm", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryDockerswarm(sdConfig *prom_moby.DockerSwarmSDConfig) *dockerswarm.Arguments {
@@ -0,0 +1,82 @@
+package component
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	prom_config "github.com/prometheus/prometheus/config"
+	prom_discovery "github.com/prometheus/prometheus/discovery"
+
+	"github.com/grafana/alloy/internal/component/discovery"
+	"github.com/grafana/alloy/internal/component/prometheus/exporter/unix"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert/build"
+)
+
+// nodeExporterPort is the default port node_exporter listens on.
+const nodeExporterPort = "9100"
+
+// AppendPrometheusExporter appends a native prometheus.exporter.* component
+// replacing the targets of scrapeConfig, if the scrape config only targets a
+// co-located exporter that Alloy can embed. The exports of the new component
+// are returned, or nil if the scrape config is left untouched.
+func AppendPrometheusExporter(pb *build.PrometheusBlocks, scrapeConfig *prom_config.ScrapeConfig, label string) *discovery.Exports {
+	if !isLocalNodeExporter(scrapeConfig) {
+		return nil
+	}
+
+	args := unix.DefaultArguments
+	name := []string{"prometheus", "exporter", "unix"}
+	block := common.NewBlockWithOverride(name, label, &args)
+	summary := fmt.Sprintf("Converted scrape_configs job_name %q node_exporter target into...", scrapeConfig.JobName)
+	detail := fmt.Sprintf("	A %s.%s component", strings.Join(name, "."), label)
+	pb.PrometheusExporterBlocks = append(pb.PrometheusExporterBlocks, build.NewPrometheusBlock(block, name, label, summary, detail))
+
+	exports := common.NewDiscoveryExports(fmt.Sprintf("prometheus.exporter.unix.%s.targets", label))
+	return &exports
+}
+
+// isLocalNodeExporter returns true if the scrape config is statically
+// configured to only scrape a node_exporter listening on the loopback
+// interface. Targets carrying extra labels are not considered, as they would
+// be lost by the replacement.
+func isLocalNodeExporter(scrapeConfig *prom_config.ScrapeConfig) bool {
+	if len(scrapeConfig.ServiceDiscoveryConfigs) == 0 {
+		return false
+	}
+	if scrapeConfig.MetricsPath != "/metrics" || scrapeConfig.Scheme != "http" {
+		return false
+	}
+
+	for _, sdConfig := range scrapeConfig.ServiceDiscoveryConfigs {
+		staticConfig, ok := sdConfig.(prom_discovery.StaticConfig)
+		if !ok {
+			return false
+		}
+		for _, group := range staticConfig {
+			if len(group.Labels) > 0 || len(group.Targets) == 0 {
+				return false
+			}
+			for _, target := range group.Targets {
+				if len(target) != 1 || !isLoopbackAddress(string(target[model.AddressLabel]), nodeExporterPort) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func isLoopbackAddress(address string, port string) bool {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil || p != port {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
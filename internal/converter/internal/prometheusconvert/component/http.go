@@ -22,7 +22,7 @@ func appendDiscoveryHttp(pb *build.PrometheusBlocks, label string, sdConfig *pro
 }
 
 func ValidateDiscoveryHttp(sdConfig *prom_http.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.http", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryHttp(sdConfig *prom_http.SDConfig) *http.Arguments {
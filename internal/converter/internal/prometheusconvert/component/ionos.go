@@ -20,7 +20,7 @@ func appendDiscoveryIonos(pb *build.PrometheusBlocks, label string, sdConfig *pr
 }
 
 func ValidateDiscoveryIonos(sdConfig *prom_ionos.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.ionos", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryIonos(sdConfig *prom_ionos.SDConfig) *ionos.Arguments {
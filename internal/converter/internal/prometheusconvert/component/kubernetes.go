@@ -19,7 +19,7 @@ func appendDiscoveryKubernetes(pb *build.PrometheusBlocks, label string, sdConfi
 }
 
 func ValidateDiscoveryKubernetes(sdConfig *prom_kubernetes.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.kubernetes", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryKubernetes(sdConfig *prom_kubernetes.SDConfig) *kubernetes.Arguments {
@@ -20,7 +20,7 @@ func appendDiscoveryKuma(pb *build.PrometheusBlocks, label string, sdConfig *pro
 }
 
 func ValidateDiscoveryKuma(sdConfig *prom_kuma.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.kuma", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryKuma(sdConfig *prom_kuma.SDConfig) *kuma.Arguments {
@@ -20,7 +20,7 @@ func appendDiscoveryLinode(pb *build.PrometheusBlocks, label string, sdConfig *p
 }
 
 func ValidateDiscoveryLinode(sdConfig *prom_linode.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.linode", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryLinode(sdConfig *prom_linode.SDConfig) *linode.Arguments {
@@ -21,7 +21,7 @@ func appendDiscoveryMarathon(pb *build.PrometheusBlocks, label string, sdConfig
 }
 
 func ValidateDiscoveryMarathon(sdConfig *prom_marathon.SDConfig) diag.Diagnostics {
-	return common.ValidateHttpClientConfig(&sdConfig.HTTPClientConfig)
+	return common.ValidateHttpClientConfig("discovery.marathon", &sdConfig.HTTPClientConfig)
 }
 
 func toDiscoveryMarathon(sdConfig *prom_marathon.SDConfig) *marathon.Arguments {
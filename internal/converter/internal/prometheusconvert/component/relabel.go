@@ -2,11 +2,14 @@ package component
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	alloy_relabel "github.com/grafana/alloy/internal/component/common/relabel"
 	"github.com/grafana/alloy/internal/component/discovery"
 	disc_relabel "github.com/grafana/alloy/internal/component/discovery/relabel"
 	"github.com/grafana/alloy/internal/component/prometheus/relabel"
+	"github.com/grafana/alloy/internal/converter/diag"
 	"github.com/grafana/alloy/internal/converter/internal/common"
 	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert/build"
 	prom_relabel "github.com/prometheus/prometheus/model/relabel"
@@ -35,7 +38,7 @@ func toRelabelArguments(relabelConfigs []*prom_relabel.Config, forwardTo []stora
 
 	return &relabel.Arguments{
 		ForwardTo:            forwardTo,
-		MetricRelabelConfigs: ToAlloyRelabelConfigs(relabelConfigs),
+		MetricRelabelConfigs: removeRedundantRelabelConfigs(ToAlloyRelabelConfigs(relabelConfigs)),
 		CacheSize:            100_000,
 	}
 }
@@ -58,7 +61,7 @@ func AppendDiscoveryRelabel(pb *build.PrometheusBlocks, relabelConfigs []*prom_r
 func toDiscoveryRelabelArguments(relabelConfigs []*prom_relabel.Config, targets []discovery.Target) *disc_relabel.Arguments {
 	return &disc_relabel.Arguments{
 		Targets:        targets,
-		RelabelConfigs: ToAlloyRelabelConfigs(relabelConfigs),
+		RelabelConfigs: removeRedundantRelabelConfigs(ToAlloyRelabelConfigs(relabelConfigs)),
 	}
 }
 
@@ -90,3 +93,130 @@ func ToAlloyRelabelConfigs(relabelConfigs []*prom_relabel.Config) []*alloy_relab
 
 	return metricRelabelConfigs
 }
+
+// removeRedundantRelabelConfigs removes the relabel steps which provably have
+// no effect on the relabeled labels. It's conservative and only removes:
+//
+//   - replace steps which set a label to its own value, and
+//   - steps writing a label which is dropped by a later labeldrop step,
+//     without being read in between.
+func removeRedundantRelabelConfigs(relabelConfigs []*alloy_relabel.Config) []*alloy_relabel.Config {
+	var res []*alloy_relabel.Config
+	for i, relabelConfig := range relabelConfigs {
+		if isNoopRelabelConfig(relabelConfig) || isDroppedRelabelConfig(relabelConfig, relabelConfigs[i+1:]) {
+			continue
+		}
+		res = append(res, relabelConfig)
+	}
+	return res
+}
+
+// isNoopRelabelConfig returns true if relabelConfig replaces a label with
+// its own value.
+func isNoopRelabelConfig(relabelConfig *alloy_relabel.Config) bool {
+	return relabelConfig.Action == alloy_relabel.Replace &&
+		len(relabelConfig.SourceLabels) == 1 &&
+		relabelConfig.SourceLabels[0] == relabelConfig.TargetLabel &&
+		relabelConfig.Regex.Regexp != nil && relabelConfig.Regex.String() == "(.*)" &&
+		relabelConfig.Replacement == "$1"
+}
+
+// isDroppedRelabelConfig returns true if relabelConfig only writes a label
+// which is dropped by a labeldrop step of next, and isn't read before it.
+func isDroppedRelabelConfig(relabelConfig *alloy_relabel.Config, next []*alloy_relabel.Config) bool {
+	target := relabelConfig.TargetLabel
+	switch relabelConfig.Action {
+	case alloy_relabel.Replace, alloy_relabel.HashMod, alloy_relabel.Lowercase, alloy_relabel.Uppercase:
+		// The label written by replace steps can depend on the labels.
+		if target == "" || strings.Contains(target, "$") {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for _, nextConfig := range next {
+		switch nextConfig.Action {
+		case alloy_relabel.LabelDrop:
+			if nextConfig.Regex.Regexp != nil && nextConfig.Regex.MatchString(target) {
+				return true
+			}
+		case alloy_relabel.LabelMap, alloy_relabel.LabelKeep:
+			// These steps read every label name.
+			return false
+		case alloy_relabel.KeepEqual, alloy_relabel.DropEqual:
+			if nextConfig.TargetLabel == target {
+				return false
+			}
+		}
+		if slices.Contains(nextConfig.SourceLabels, target) {
+			return false
+		}
+	}
+	return false
+}
+
+// highCardinalityLabels are discovered labels whose values change for every
+// instance of a workload, so keeping them as target labels creates new series
+// on each restart or rollout.
+var highCardinalityLabels = []string{
+	"__meta_kubernetes_pod_name",
+	"__meta_kubernetes_pod_uid",
+	"__meta_kubernetes_pod_ip",
+	"__meta_kubernetes_pod_container_id",
+	"__meta_kubernetes_endpointslice_endpoint_hostname",
+	"__meta_docker_container_id",
+	"__meta_docker_container_name",
+	"__meta_dockerswarm_task_id",
+	"__meta_ec2_instance_id",
+	"__meta_ec2_private_ip",
+}
+
+// ValidateRelabelCardinality returns a warning for each relabel rule of the
+// scrape job jobName which keeps a high-cardinality discovered label as a
+// target label.
+func ValidateRelabelCardinality(jobName string, relabelConfigs []*prom_relabel.Config) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for i, relabelConfig := range relabelConfigs {
+		for _, kept := range keptHighCardinalityLabels(relabelConfig) {
+			diags.Add(diag.SeverityLevelWarn, fmt.Sprintf(
+				"The relabel rule %d of scrape job %q keeps the high-cardinality label %s as %q. Its value changes for every instance, which creates new series on each restart and can cause a cardinality explosion. Drop the rule unless the label is needed.",
+				i+1, jobName, kept[0], kept[1]))
+		}
+	}
+	return diags
+}
+
+// keptHighCardinalityLabels returns the high-cardinality labels which
+// relabelConfig copies to a label kept after relabeling, as pairs of source
+// and target label names.
+func keptHighCardinalityLabels(relabelConfig *prom_relabel.Config) [][2]string {
+	var kept [][2]string
+	switch relabelConfig.Action {
+	case prom_relabel.Replace:
+		target := relabelConfig.TargetLabel
+		// Targets depending on the label values can't be known statically.
+		if strings.HasPrefix(target, "__") || strings.Contains(target, "$") {
+			return nil
+		}
+		for _, source := range relabelConfig.SourceLabels {
+			if slices.Contains(highCardinalityLabels, string(source)) {
+				kept = append(kept, [2]string{string(source), target})
+			}
+		}
+	case prom_relabel.LabelMap:
+		if relabelConfig.Regex.Regexp == nil {
+			return nil
+		}
+		for _, name := range highCardinalityLabels {
+			if !relabelConfig.Regex.MatchString(name) {
+				continue
+			}
+			target := relabelConfig.Regex.ReplaceAllString(name, relabelConfig.Replacement)
+			if !strings.HasPrefix(target, "__") {
+				kept = append(kept, [2]string{name, target})
+			}
+		}
+	}
+	return kept
+}
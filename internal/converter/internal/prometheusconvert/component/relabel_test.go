@@ -0,0 +1,63 @@
+package component
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	prom_relabel "github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/require"
+
+	alloy_relabel "github.com/grafana/alloy/internal/component/common/relabel"
+)
+
+func TestRemoveRedundantRelabelConfigs(t *testing.T) {
+	rule := func(action alloy_relabel.Action, sourceLabels []string, regex, targetLabel string) *alloy_relabel.Config {
+		var c alloy_relabel.Config
+		c.SetToDefault()
+		c.Action = action
+		c.SourceLabels = sourceLabels
+		c.TargetLabel = targetLabel
+		if regex != "" {
+			require.NoError(t, c.Regex.UnmarshalText([]byte(regex)))
+		}
+		return &c
+	}
+
+	relabelConfigs := []*alloy_relabel.Config{
+		// Replaces the instance label with its own value.
+		rule(alloy_relabel.Replace, []string{"instance"}, "", "instance"),
+		// Writes a temporary label dropped without being read.
+		rule(alloy_relabel.Replace, []string{"__name__"}, "", "tmp_name"),
+		rule(alloy_relabel.Lowercase, []string{"job"}, "", "tmp_lower"),
+		rule(alloy_relabel.Replace, []string{"job"}, "", "team"),
+		rule(alloy_relabel.LabelDrop, nil, "tmp_.*", ""),
+		// Writes a temporary label read before being dropped.
+		rule(alloy_relabel.Replace, []string{"job"}, "", "tmp_job"),
+		rule(alloy_relabel.KeepEqual, []string{"job"}, "", "tmp_job"),
+		rule(alloy_relabel.LabelDrop, nil, "tmp_.*", ""),
+		// Writes a label which is never dropped.
+		rule(alloy_relabel.Replace, []string{"job"}, "(.+)", "service"),
+	}
+
+	actual := removeRedundantRelabelConfigs(relabelConfigs)
+	require.Equal(t, []*alloy_relabel.Config{
+		relabelConfigs[3],
+		relabelConfigs[4],
+		relabelConfigs[5],
+		relabelConfigs[6],
+		relabelConfigs[7],
+		relabelConfigs[8],
+	}, actual)
+
+	// The remaining steps relabel like the original ones.
+	for _, lbls := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "instance", "localhost:9090", "job", "Prometheus"),
+		labels.FromStrings("__name__", "up", "job", "Prometheus", "tmp_job", "other"),
+		labels.FromStrings("__name__", "up"),
+	} {
+		expected, expectedKeep := prom_relabel.Process(lbls, alloy_relabel.ComponentToPromRelabelConfigs(relabelConfigs)...)
+		got, keep := prom_relabel.Process(lbls, alloy_relabel.ComponentToPromRelabelConfigs(actual)...)
+		require.Equal(t, expectedKeep, keep)
+		require.Equal(t, expected, got)
+	}
+}
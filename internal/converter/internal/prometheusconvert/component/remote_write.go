@@ -44,7 +44,7 @@ func AppendPrometheusRemoteWrite(pb *build.PrometheusBlocks, globalConfig prom_c
 func ValidateRemoteWriteConfig(remoteWriteConfig *prom_config.RemoteWriteConfig) diag.Diagnostics {
 	var diags diag.Diagnostics
 
-	diags.AddAll(common.ValidateHttpClientConfig(&remoteWriteConfig.HTTPClientConfig))
+	diags.AddAll(common.ValidateHttpClientConfig("prometheus.remote_write", &remoteWriteConfig.HTTPClientConfig))
 	return diags
 }
 
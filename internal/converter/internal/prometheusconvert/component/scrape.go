@@ -19,8 +19,8 @@ import (
 	"github.com/grafana/alloy/internal/service/cluster"
 )
 
-func AppendPrometheusScrape(pb *build.PrometheusBlocks, scrapeConfig *prom_config.ScrapeConfig, forwardTo []storage.Appendable, targets []discovery.Target, label string) {
-	scrapeArgs := toScrapeArguments(scrapeConfig, forwardTo, targets)
+func AppendPrometheusScrape(pb *build.PrometheusBlocks, globalConfig prom_config.GlobalConfig, scrapeConfig *prom_config.ScrapeConfig, forwardTo []storage.Appendable, targets []discovery.Target, label string) {
+	scrapeArgs := toScrapeArguments(withGlobalLimits(scrapeConfig, globalConfig), forwardTo, targets)
 	name := []string{"prometheus", "scrape"}
 	block := common.NewBlockWithOverride(name, label, scrapeArgs)
 	summary := fmt.Sprintf("Converted scrape_configs job_name %q into...", scrapeConfig.JobName)
@@ -37,7 +37,7 @@ func ValidatePrometheusScrape(scrapeConfig *prom_config.ScrapeConfig) diag.Diagn
 	diags.AddAll(common.ValidateSupported(common.NotEquals, scrapeConfig.NativeHistogramBucketLimit, uint(0), "scrape_configs native_histogram_bucket_limit", ""))
 	// https://github.com/prometheus/prometheus/pull/12647
 	diags.AddAll(common.ValidateSupported(common.NotEquals, scrapeConfig.KeepDroppedTargets, uint(0), "scrape_configs keep_dropped_targets", ""))
-	diags.AddAll(common.ValidateHttpClientConfig(&scrapeConfig.HTTPClientConfig))
+	diags.AddAll(common.ValidateHttpClientConfig("prometheus.scrape", &scrapeConfig.HTTPClientConfig))
 
 	return diags
 }
@@ -76,6 +76,37 @@ func toScrapeArguments(scrapeConfig *prom_config.ScrapeConfig, forwardTo []stora
 	}
 }
 
+// withGlobalLimits returns a copy of scrapeConfig where the scrape limits
+// which aren't set are inherited from globalConfig. Prometheus does the same
+// when loading its config, but configs built by other converters don't go
+// through that step.
+func withGlobalLimits(scrapeConfig *prom_config.ScrapeConfig, globalConfig prom_config.GlobalConfig) *prom_config.ScrapeConfig {
+	if scrapeConfig == nil {
+		return nil
+	}
+
+	sc := *scrapeConfig
+	if sc.BodySizeLimit == 0 {
+		sc.BodySizeLimit = globalConfig.BodySizeLimit
+	}
+	if sc.SampleLimit == 0 {
+		sc.SampleLimit = globalConfig.SampleLimit
+	}
+	if sc.TargetLimit == 0 {
+		sc.TargetLimit = globalConfig.TargetLimit
+	}
+	if sc.LabelLimit == 0 {
+		sc.LabelLimit = globalConfig.LabelLimit
+	}
+	if sc.LabelNameLengthLimit == 0 {
+		sc.LabelNameLengthLimit = globalConfig.LabelNameLengthLimit
+	}
+	if sc.LabelValueLengthLimit == 0 {
+		sc.LabelValueLengthLimit = globalConfig.LabelValueLengthLimit
+	}
+	return &sc
+}
+
 func getScrapeTargets(staticConfig prom_discovery.StaticConfig) []discovery.Target {
 	targets := []discovery.Target{}
 
@@ -0,0 +1,143 @@
+package prometheusconvert
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/internal/converter/internal/common"
+	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert/build"
+	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert/component"
+	"github.com/grafana/alloy/syntax/token"
+	"github.com/grafana/alloy/syntax/token/builder"
+	prom_config "github.com/prometheus/prometheus/config"
+	prom_discover "github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// minForeachJobs is the minimum number of similar scrape jobs converted into
+// a foreach block when Options.ForeachJobs is set.
+const minForeachJobs = 2
+
+// foreachVar is the name of the variable holding the current job in the
+// template of the foreach blocks.
+const foreachVar = "each"
+
+// foreachJob is an item of the collection of a foreach block, holding what
+// differs between the scrape jobs it iterates over.
+type foreachJob struct {
+	JobName string                `alloy:"job_name,attr"`
+	Targets common.ConvertTargets `alloy:"targets,attr"`
+}
+
+// groupForeachJobs groups the scrape configs which only differ by their job
+// name and static targets, so that they can be converted into a foreach
+// block. Groups of less than minForeachJobs scrape configs aren't kept, and
+// their scrape configs are returned with the other ones, in their original
+// order.
+func groupForeachJobs(scrapeConfigs []*prom_config.ScrapeConfig, opts Options) (groups [][]*prom_config.ScrapeConfig, rest []*prom_config.ScrapeConfig) {
+	var candidates [][]*prom_config.ScrapeConfig
+	for _, scrapeConfig := range scrapeConfigs {
+		if !isForeachCandidate(scrapeConfig, opts) {
+			continue
+		}
+		i := 0
+		for ; i < len(candidates); i++ {
+			if sameExceptTargets(candidates[i][0], scrapeConfig) {
+				break
+			}
+		}
+		if i == len(candidates) {
+			candidates = append(candidates, nil)
+		}
+		candidates[i] = append(candidates[i], scrapeConfig)
+	}
+
+	grouped := make(map[*prom_config.ScrapeConfig]struct{})
+	for _, candidate := range candidates {
+		if len(candidate) < minForeachJobs {
+			continue
+		}
+		groups = append(groups, candidate)
+		for _, scrapeConfig := range candidate {
+			grouped[scrapeConfig] = struct{}{}
+		}
+	}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		if _, ok := grouped[scrapeConfig]; !ok {
+			rest = append(rest, scrapeConfig)
+		}
+	}
+	return groups, rest
+}
+
+// isForeachCandidate returns true if the scrape config only has static
+// targets, which can be iterated over by a foreach block.
+func isForeachCandidate(scrapeConfig *prom_config.ScrapeConfig, opts Options) bool {
+	if len(scrapeConfig.ServiceDiscoveryConfigs) == 0 {
+		return false
+	}
+	for _, sd := range scrapeConfig.ServiceDiscoveryConfigs {
+		if _, ok := sd.(prom_discover.StaticConfig); !ok {
+			return false
+		}
+	}
+
+	// Jobs replaced by a native exporter depend on their targets.
+	if opts.NativeExporters && component.AppendPrometheusExporter(build.NewPrometheusBlocks(), scrapeConfig, "") != nil {
+		return false
+	}
+	return true
+}
+
+// sameExceptTargets returns true if a and b only differ by their job name and
+// service discovery configs.
+func sameExceptTargets(a, b *prom_config.ScrapeConfig) bool {
+	ca, cb := *a, *b
+	ca.JobName, cb.JobName = "", ""
+	ca.ServiceDiscoveryConfigs, cb.ServiceDiscoveryConfigs = nil, nil
+	return reflect.DeepEqual(ca, cb)
+}
+
+// appendForeachJobs appends a foreach block to body converting the scrape
+// configs of group, which only differ by their job name and static targets.
+// The block iterates over the job names and targets, and its template holds
+// the components converted from the first scrape config, using them.
+func appendForeachJobs(body *builder.Body, globalConfig prom_config.GlobalConfig, group []*prom_config.ScrapeConfig, forwardTo []storage.Appendable) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	jobs := make([]foreachJob, 0, len(group))
+	jobNames := make([]string, 0, len(group))
+	for _, scrapeConfig := range group {
+		jobs = append(jobs, foreachJob{
+			JobName: scrapeConfig.JobName,
+			Targets: common.ConvertTargets{Targets: AppendServiceDiscoveryConfigs(nil, scrapeConfig.ServiceDiscoveryConfigs, "")},
+		})
+		jobNames = append(jobNames, fmt.Sprintf("%q", scrapeConfig.JobName))
+	}
+
+	pb := build.NewPrometheusBlocks()
+	appendScrapeJob(pb, globalConfig, group[0], forwardTo, common.NewDiscoveryTargets(foreachVar+".targets"), "default")
+	for _, scrapeBlock := range pb.PrometheusScrapeBlocks {
+		scrapeBlock.Body().SetAttributeTokens("job_name", []builder.Token{{Tok: token.LITERAL, Lit: foreachVar + ".job_name"}})
+	}
+
+	label := common.SanitizeIdentifierPanics(group[0].JobName)
+	block := builder.NewBlock([]string{"foreach"}, label)
+	block.Body().SetAttributeValue("collection", jobs)
+	block.Body().SetAttributeValue("var", foreachVar)
+	template := builder.NewBlock([]string{"template"}, "")
+	pb.AppendToBody(template.Body())
+	block.Body().AppendBlock(template)
+	body.AppendBlock(block)
+
+	diags.AddWithDetail(
+		diag.SeverityLevelInfo,
+		fmt.Sprintf("Converted scrape_configs job_names %s into...", strings.Join(jobNames, ", ")),
+		fmt.Sprintf("	A foreach.%s block", label),
+	)
+	diags.Add(diag.SeverityLevelWarn, fmt.Sprintf("The foreach.%s block is an experimental feature of Alloy, which must be run with --stability.level=experimental to load it.", label))
+	return diags
+}
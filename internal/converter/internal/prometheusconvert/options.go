@@ -0,0 +1,80 @@
+package prometheusconvert
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Options holds optional behaviors of the Prometheus converter. They are
+// disabled by default and can be enabled by passing extra arguments to
+// [Convert].
+type Options struct {
+	// NativeExporters replaces scrape jobs targeting a co-located exporter
+	// with the equivalent prometheus.exporter.* component.
+	NativeExporters bool
+
+	// NativeHistograms converts the config as loaded by Prometheus with the
+	// native-histograms feature enabled, which makes scrape jobs negotiate
+	// protobuf by default so that native histograms can be scraped.
+	NativeHistograms bool
+
+	// IncludeJobs only converts the scrape jobs whose name matches the
+	// regular expression, if set.
+	IncludeJobs *regexp.Regexp
+	// ExcludeJobs doesn't convert the scrape jobs whose name matches the
+	// regular expression, if set.
+	ExcludeJobs *regexp.Regexp
+
+	// ForeachJobs converts scrape jobs which only differ by their name and
+	// static targets into a single foreach block iterating over them,
+	// rather than into components for each job.
+	ForeachJobs bool
+}
+
+// parseOptions parses the extra arguments given to the converter into
+// Options.
+func parseOptions(extraArgs []string) (Options, error) {
+	var opts Options
+
+	fs := flag.NewFlagSet("prometheus", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&opts.NativeExporters, "native-exporters", false, "Replace scrape jobs for co-located exporters with prometheus.exporter.* components.")
+	fs.BoolVar(&opts.NativeHistograms, "native-histograms", false, "Convert scrape jobs as if Prometheus ran with the native-histograms feature, which negotiates protobuf by default.")
+	fs.Func("include-jobs", "Only convert the scrape jobs whose name fully matches this regular expression.", jobsRegexpFlag(&opts.IncludeJobs))
+	fs.Func("exclude-jobs", "Don't convert the scrape jobs whose name fully matches this regular expression.", jobsRegexpFlag(&opts.ExcludeJobs))
+	fs.BoolVar(&opts.ForeachJobs, "foreach-jobs", false, "Convert scrape jobs which only differ by their name and static targets into a single foreach block.")
+
+	if err := fs.Parse(extraArgs); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %s", fs.Args())
+	}
+	return opts, nil
+}
+
+// jobsRegexpFlag returns a flag parsing function which compiles the flag
+// value into an anchored regular expression stored in re.
+func jobsRegexpFlag(re **regexp.Regexp) func(string) error {
+	return func(value string) error {
+		compiled, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return err
+		}
+		*re = compiled
+		return nil
+	}
+}
+
+// includesJob returns true if the scrape job jobName should be converted.
+func (opts Options) includesJob(jobName string) bool {
+	if opts.IncludeJobs != nil && !opts.IncludeJobs.MatchString(jobName) {
+		return false
+	}
+	if opts.ExcludeJobs != nil && opts.ExcludeJobs.MatchString(jobName) {
+		return false
+	}
+	return true
+}
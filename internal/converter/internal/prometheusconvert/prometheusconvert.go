@@ -3,6 +3,7 @@ package prometheusconvert
 import (
 	"bytes"
 	"fmt"
+	"slices"
 
 	"github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/component/discovery"
@@ -21,25 +22,23 @@ import (
 
 // Convert implements a Prometheus config converter.
 //
-// extraArgs are supported to mirror the other converter params due to shared
-// testing code but they should be passed empty to this converter.
+// extraArgs can be used to enable optional behaviors of the converter, see
+// [Options] for the supported flags.
 func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
-	var diags diag.Diagnostics
-
-	if len(extraArgs) > 0 {
-		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("extra arguments are not supported for the prometheus converter: %s", extraArgs))
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
 		return nil, diags
 	}
 
-	promConfig, err := prom_config.Load(string(in), false, log.NewNopLogger())
-	if err != nil {
-		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse Prometheus config: %s", err))
-		return nil, diags
-	}
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
 
-	f := builder.NewFile()
-	diags = AppendAll(f, promConfig)
-	diags.AddAll(common.ValidateNodes(f))
+// Render renders the Alloy file f returned by [ConvertFile] and
+// pretty-prints it, like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
 
 	var buf bytes.Buffer
 	if _, err := f.WriteTo(&buf); err != nil {
@@ -51,9 +50,48 @@ func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
 		return nil, diags
 	}
 
-	prettyByte, newDiags := common.PrettyPrint(buf.Bytes())
-	diags.AddAll(newDiags)
-	return prettyByte, diags
+	return common.PrettyPrint(buf.Bytes())
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig(f.Bytes()))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	opts, err := parseOptions(extraArgs)
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse extra arguments for the prometheus converter %s: %s", extraArgs, err))
+		return nil, diags
+	}
+
+	promConfig, err := prom_config.Load(string(in), false, log.NewNopLogger())
+	if err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to parse Prometheus config: %s", err))
+		return nil, diags
+	}
+
+	diags.AddAll(filterJobs(promConfig, opts))
+	if opts.NativeHistograms {
+		useProtoFirstScrapeProtocols(promConfig)
+	}
+
+	f := builder.NewFile()
+	diags.AddAll(appendAllNested(f, promConfig, opts, nil, []discovery.Target{}, nil))
+	diags.AddAll(common.ValidateNodes(f))
+	return f, diags
 }
 
 // AppendAll analyzes the entire prometheus config in memory and transforms it
@@ -70,6 +108,10 @@ func AppendAll(f *builder.File, promConfig *prom_config.Config) diag.Diagnostics
 // pipeline. Additional options can be provided overriding the job name, extra
 // scrape targets, and predefined remote write exports.
 func AppendAllNested(f *builder.File, promConfig *prom_config.Config, jobNameToCompLabelsFunc func(string) string, extraScrapeTargets []discovery.Target, remoteWriteExports *remotewrite.Exports) diag.Diagnostics {
+	return appendAllNested(f, promConfig, Options{}, jobNameToCompLabelsFunc, extraScrapeTargets, remoteWriteExports)
+}
+
+func appendAllNested(f *builder.File, promConfig *prom_config.Config, opts Options, jobNameToCompLabelsFunc func(string) string, extraScrapeTargets []discovery.Target, remoteWriteExports *remotewrite.Exports) diag.Diagnostics {
 	pb := build.NewPrometheusBlocks()
 
 	if remoteWriteExports == nil {
@@ -84,31 +126,34 @@ func AppendAllNested(f *builder.File, promConfig *prom_config.Config, jobNameToC
 	}
 	remoteWriteForwardTo := []storage.Appendable{remoteWriteExports.Receiver}
 
-	for _, scrapeConfig := range promConfig.ScrapeConfigs {
-		scrapeForwardTo := remoteWriteForwardTo
+	scrapeConfigs := promConfig.ScrapeConfigs
+	var foreachGroups [][]*prom_config.ScrapeConfig
+	if opts.ForeachJobs {
+		foreachGroups, scrapeConfigs = groupForeachJobs(scrapeConfigs, opts)
+	}
+
+	for _, scrapeConfig := range scrapeConfigs {
 		label := scrapeConfig.JobName
 		if jobNameToCompLabelsFunc != nil {
 			label = jobNameToCompLabelsFunc(scrapeConfig.JobName)
 		}
 		label = common.SanitizeIdentifierPanics(label)
 
-		promMetricsRelabelExports := component.AppendPrometheusRelabel(pb, scrapeConfig.MetricRelabelConfigs, remoteWriteForwardTo, label)
-		if promMetricsRelabelExports != nil {
-			scrapeForwardTo = []storage.Appendable{promMetricsRelabelExports.Receiver}
+		var scrapeTargets []discovery.Target
+		if exporterExports := appendNativeExporter(pb, opts, scrapeConfig, label); exporterExports != nil {
+			scrapeTargets = exporterExports.Targets
+		} else {
+			scrapeTargets = AppendServiceDiscoveryConfigs(pb, scrapeConfig.ServiceDiscoveryConfigs, label)
 		}
-
-		scrapeTargets := AppendServiceDiscoveryConfigs(pb, scrapeConfig.ServiceDiscoveryConfigs, label)
 		scrapeTargets = append(scrapeTargets, extraScrapeTargets...)
 
-		promDiscoveryRelabelExports := component.AppendDiscoveryRelabel(pb, scrapeConfig.RelabelConfigs, scrapeTargets, label)
-		if promDiscoveryRelabelExports != nil {
-			scrapeTargets = promDiscoveryRelabelExports.Output
-		}
-
-		component.AppendPrometheusScrape(pb, scrapeConfig, scrapeForwardTo, scrapeTargets, label)
+		appendScrapeJob(pb, promConfig.GlobalConfig, scrapeConfig, remoteWriteForwardTo, scrapeTargets, label)
 	}
 
 	diags := validate(promConfig)
+	for _, group := range foreachGroups {
+		diags.AddAll(appendForeachJobs(f.Body(), promConfig.GlobalConfig, group, remoteWriteForwardTo))
+	}
 	diags.AddAll(pb.GetScrapeInfo())
 
 	pb.AppendToBody(f.Body())
@@ -116,6 +161,73 @@ func AppendAllNested(f *builder.File, promConfig *prom_config.Config, jobNameToC
 	return diags
 }
 
+// appendScrapeJob appends the components scraping scrapeTargets for the
+// scrape config, relabeling the targets and the scraped metrics if needed,
+// and forwarding the metrics to forwardTo.
+func appendScrapeJob(pb *build.PrometheusBlocks, globalConfig prom_config.GlobalConfig, scrapeConfig *prom_config.ScrapeConfig, forwardTo []storage.Appendable, scrapeTargets []discovery.Target, label string) {
+	scrapeForwardTo := forwardTo
+	promMetricsRelabelExports := component.AppendPrometheusRelabel(pb, scrapeConfig.MetricRelabelConfigs, forwardTo, label)
+	if promMetricsRelabelExports != nil {
+		scrapeForwardTo = []storage.Appendable{promMetricsRelabelExports.Receiver}
+	}
+
+	promDiscoveryRelabelExports := component.AppendDiscoveryRelabel(pb, scrapeConfig.RelabelConfigs, scrapeTargets, label)
+	if promDiscoveryRelabelExports != nil {
+		scrapeTargets = promDiscoveryRelabelExports.Output
+	}
+
+	component.AppendPrometheusScrape(pb, globalConfig, scrapeConfig, scrapeForwardTo, scrapeTargets, label)
+}
+
+// filterJobs removes the scrape configs of promConfig which aren't included
+// by the job filters of opts, and reports each of them as skipped.
+func filterJobs(promConfig *prom_config.Config, opts Options) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	scrapeConfigs := promConfig.ScrapeConfigs[:0]
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		if !opts.includesJob(scrapeConfig.JobName) {
+			diags.Add(diag.SeverityLevelInfo, fmt.Sprintf("Skipped scrape job %q, which doesn't match the job filters.", scrapeConfig.JobName))
+			continue
+		}
+		scrapeConfigs = append(scrapeConfigs, scrapeConfig)
+	}
+	promConfig.ScrapeConfigs = scrapeConfigs
+
+	return diags
+}
+
+// useProtoFirstScrapeProtocols makes the scrape configs of promConfig which
+// use the default scrape protocols negotiate protobuf first, like Prometheus
+// does when the native-histograms feature is enabled. Scrape protocols set in
+// the config are kept.
+func useProtoFirstScrapeProtocols(promConfig *prom_config.Config) {
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		if isDefaultScrapeProtocols(scrapeConfig.ScrapeProtocols) {
+			scrapeConfig.ScrapeProtocols = slices.Clone(prom_config.DefaultProtoFirstScrapeProtocols)
+		}
+	}
+}
+
+// isDefaultScrapeProtocols returns true if protocols are the default ones
+// given by prom_config.Load to configs which don't set them. Load shares the
+// default slice rather than copying it, which tells it apart from the same
+// protocols set explicitly.
+func isDefaultScrapeProtocols(protocols []prom_config.ScrapeProtocol) bool {
+	defaults := prom_config.DefaultGlobalConfig.ScrapeProtocols
+	return len(protocols) > 0 && len(protocols) == len(defaults) && &protocols[0] == &defaults[0]
+}
+
+// appendNativeExporter appends a prometheus.exporter.* component replacing the
+// targets of the scrape config when native exporters are enabled and the
+// scrape config targets a co-located exporter.
+func appendNativeExporter(pb *build.PrometheusBlocks, opts Options, scrapeConfig *prom_config.ScrapeConfig, label string) *discovery.Exports {
+	if !opts.NativeExporters {
+		return nil
+	}
+	return component.AppendPrometheusExporter(pb, scrapeConfig, label)
+}
+
 // AppendServiceDiscoveryConfigs will loop through the service discovery
 // configs and append them to the file. This returns the scrape targets
 // and discovery targets as a result.
@@ -1,13 +1,141 @@
 package prometheusconvert_test
 
 import (
+	"context"
+	"maps"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/alloy/internal/component/prometheus/scrape"
+	"github.com/grafana/alloy/internal/converter/diag"
 	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert"
 	"github.com/grafana/alloy/internal/converter/internal/test_common"
 	_ "github.com/grafana/alloy/internal/static/metrics/instance"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/vm"
 )
 
 func TestConvert(t *testing.T) {
 	test_common.TestDirectory(t, "testdata", ".yaml", true, []string{}, map[string]struct{}{}, prometheusconvert.Convert)
 }
+
+func TestConvertNativeExporters(t *testing.T) {
+	test_common.TestDirectory(t, "testdata_native_exporters", ".yaml", true, []string{"-native-exporters"}, map[string]struct{}{}, prometheusconvert.Convert)
+}
+
+func TestConvertJobFilters(t *testing.T) {
+	test_common.TestDirectory(t, "testdata_job_filters", ".yaml", true, []string{"-include-jobs=api"}, map[string]struct{}{}, prometheusconvert.Convert)
+}
+
+func TestConvertJobFilters_SkippedJobs(t *testing.T) {
+	in, err := os.ReadFile("testdata_job_filters/include.yaml")
+	require.NoError(t, err)
+
+	_, diags := prometheusconvert.Convert(in, []string{"-include-jobs=api|db", "-exclude-jobs=db"})
+
+	var skipped []string
+	for _, d := range diags {
+		if d.Severity == diag.SeverityLevelInfo && strings.HasPrefix(d.Summary, "Skipped") {
+			skipped = append(skipped, d.Summary)
+		}
+	}
+	require.Equal(t, []string{
+		`Skipped scrape job "db", which doesn't match the job filters.`,
+		`Skipped scrape job "cache", which doesn't match the job filters.`,
+	}, skipped)
+}
+
+func TestConvertNativeHistograms(t *testing.T) {
+	test_common.TestDirectory(t, "testdata_native_histograms", ".yaml", true, []string{"-native-histograms"}, map[string]struct{}{}, prometheusconvert.Convert)
+}
+
+func TestConvertForeachJobs(t *testing.T) {
+	test_common.TestDirectory(t, "testdata_foreach", ".yaml", true, []string{"-foreach-jobs"}, map[string]struct{}{}, prometheusconvert.Convert)
+}
+
+func TestConvertForeachJobs_Equivalent(t *testing.T) {
+	in, err := os.ReadFile("testdata_foreach/jobs.yaml")
+	require.NoError(t, err)
+
+	foreachOut, diags := prometheusconvert.Convert(in, []string{"-foreach-jobs"})
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError))
+	plainOut, diags := prometheusconvert.Convert(in, nil)
+	require.False(t, diags.HasSeverityLevel(diag.SeverityLevelError))
+
+	foreachFile, err := parser.ParseFile("foreach.alloy", foreachOut)
+	require.NoError(t, err)
+	plainFile, err := parser.ParseFile("plain.alloy", plainOut)
+	require.NoError(t, err)
+
+	var foreachBlocks []*ast.BlockStmt
+	for _, stmt := range foreachFile.Body {
+		if block, ok := stmt.(*ast.BlockStmt); ok && block.GetBlockName() == "foreach" {
+			foreachBlocks = append(foreachBlocks, block)
+		}
+	}
+	require.Len(t, foreachBlocks, 1)
+
+	var (
+		collection   ast.Expr
+		templateBody ast.Body
+	)
+	for _, stmt := range foreachBlocks[0].Body {
+		switch stmt := stmt.(type) {
+		case *ast.AttributeStmt:
+			if stmt.Name.Name == "collection" {
+				collection = stmt.Value
+			}
+		case *ast.BlockStmt:
+			if stmt.GetBlockName() == "template" {
+				templateBody = stmt.Body
+			}
+		}
+	}
+	require.NotNil(t, collection)
+	require.Len(t, templateBody, 1, "the template should only hold the scrape component")
+
+	receiver := &nopAppendable{}
+	exports := map[string]any{
+		"prometheus": map[string]any{
+			"remote_write": map[string]any{
+				"default": map[string]any{"receiver": receiver},
+			},
+		},
+	}
+
+	var jobs []map[string]any
+	require.NoError(t, vm.New(collection).Evaluate(vm.NewScope(exports), &jobs))
+	require.Len(t, jobs, 5)
+
+	// Each iteration of the foreach block must evaluate to the same
+	// arguments as the component converted for the job without it.
+	for _, job := range jobs {
+		jobName := job["job_name"].(string)
+
+		scope := vm.NewScope(maps.Clone(exports))
+		scope.Variables["each"] = job
+		var fromForeach scrape.Arguments
+		require.NoError(t, vm.New(templateBody[0].(*ast.BlockStmt).Body).Evaluate(scope, &fromForeach))
+
+		var plainBlock *ast.BlockStmt
+		for _, stmt := range plainFile.Body {
+			if block, ok := stmt.(*ast.BlockStmt); ok && block.GetBlockName() == "prometheus.scrape" && block.Label == strings.ReplaceAll(jobName, "-", "_") {
+				plainBlock = block
+			}
+		}
+		require.NotNil(t, plainBlock, "no component converted for job %q", jobName)
+		var fromPlain scrape.Arguments
+		require.NoError(t, vm.New(plainBlock.Body).Evaluate(vm.NewScope(exports), &fromPlain))
+
+		require.Equal(t, fromPlain, fromForeach, "job %q", jobName)
+	}
+}
+
+type nopAppendable struct{}
+
+func (*nopAppendable) Appender(context.Context) storage.Appender { return nil }
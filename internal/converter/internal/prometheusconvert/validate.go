@@ -1,9 +1,14 @@
 package prometheusconvert
 
 import (
+	"fmt"
+	"net/url"
+	"path"
+
 	"github.com/grafana/alloy/internal/converter/diag"
 	"github.com/grafana/alloy/internal/converter/internal/common"
 	"github.com/grafana/alloy/internal/converter/internal/prometheusconvert/component"
+	"github.com/prometheus/common/model"
 	prom_config "github.com/prometheus/prometheus/config"
 	prom_discover "github.com/prometheus/prometheus/discovery"
 
@@ -33,8 +38,43 @@ func validateGlobalConfig(globalConfig *prom_config.GlobalConfig) diag.Diagnosti
 }
 
 func validateAlertingConfig(alertingConfig *prom_config.AlertingConfig) diag.Diagnostics {
-	hasAlerting := len(alertingConfig.AlertmanagerConfigs) > 0 || len(alertingConfig.AlertRelabelConfigs) > 0
-	return common.ValidateSupported(common.Equals, hasAlerting, true, "alerting", "")
+	var diags diag.Diagnostics
+
+	for _, alertmanagerConfig := range alertingConfig.AlertmanagerConfigs {
+		for _, alertmanager := range alertmanagerEndpoints(alertmanagerConfig) {
+			diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the provided alerting config: Alloy doesn't evaluate rules, so no alert can be sent to the Alertmanager %s. Configure the Alertmanager in the ruler of the remote storage instead, such as with the -ruler.alertmanager-url flag of Mimir, and load the rules into the ruler, for example with mimir.rules.kubernetes.", alertmanager))
+		}
+	}
+	diags.AddAll(common.ValidateSupported(common.Equals, len(alertingConfig.AlertRelabelConfigs) > 0, true, "alerting alert_relabel_configs", ""))
+
+	return diags
+}
+
+// alertmanagerEndpoints describes the Alertmanagers of alertmanagerConfig:
+// the URLs of the static ones, and the service discoveries of the others.
+func alertmanagerEndpoints(alertmanagerConfig *prom_config.AlertmanagerConfig) []string {
+	var endpoints []string
+	for _, sd := range alertmanagerConfig.ServiceDiscoveryConfigs {
+		staticConfig, ok := sd.(prom_discover.StaticConfig)
+		if !ok {
+			endpoints = append(endpoints, fmt.Sprintf("discovered by %s service discovery", sd.Name()))
+			continue
+		}
+		for _, group := range staticConfig {
+			for _, target := range group.Targets {
+				u := url.URL{
+					Scheme: alertmanagerConfig.Scheme,
+					Host:   string(target[model.AddressLabel]),
+					Path:   path.Join("/", alertmanagerConfig.PathPrefix),
+				}
+				endpoints = append(endpoints, u.String())
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = append(endpoints, "without targets")
+	}
+	return endpoints
 }
 
 func validateRuleFilesConfig(ruleFilesConfig []string) diag.Diagnostics {
@@ -46,6 +86,7 @@ func validateScrapeConfigs(scrapeConfigs []*prom_config.ScrapeConfig) diag.Diagn
 
 	for _, scrapeConfig := range scrapeConfigs {
 		diags.AddAll(component.ValidatePrometheusScrape(scrapeConfig))
+		diags.AddAll(component.ValidateRelabelCardinality(scrapeConfig.JobName, scrapeConfig.RelabelConfigs))
 		diags.AddAll(ValidateServiceDiscoveryConfigs(scrapeConfig.ServiceDiscoveryConfigs))
 	}
 	return diags
@@ -80,6 +121,19 @@ func validateRemoteWriteConfigs(remoteWriteConfigs []*prom_config.RemoteWriteCon
 	return diags
 }
 
+// validateRemoteReadConfigs reports every remote_read config as unsupported:
+// Alloy collects and forwards data, but doesn't serve queries which could be
+// answered from a remote storage.
 func validateRemoteReadConfigs(remoteReadConfigs []*prom_config.RemoteReadConfig) diag.Diagnostics {
-	return common.ValidateSupported(common.Equals, len(remoteReadConfigs) > 0, true, "remote_read", "")
+	var diags diag.Diagnostics
+
+	for _, remoteReadConfig := range remoteReadConfigs {
+		endpoint := remoteReadConfig.URL.String()
+		if remoteReadConfig.Name != "" {
+			endpoint = fmt.Sprintf("%s (%s)", remoteReadConfig.Name, endpoint)
+		}
+		diags.Add(diag.SeverityLevelError, fmt.Sprintf("The converter does not support converting the provided remote_read config: Alloy doesn't serve queries, so the remote storage %s can't be read through it. Query the remote storage directly instead, for example by adding it as a Prometheus data source in Grafana.", endpoint))
+	}
+
+	return diags
 }
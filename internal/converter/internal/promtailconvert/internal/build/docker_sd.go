@@ -20,6 +20,10 @@ func (s *ScrapeConfigBuilder) AppendDockerPipeline() {
 	for i, sd := range s.cfg.DockerSDConfigs {
 		compLabel := common.LabelWithIndex(i, s.globalCtx.LabelPrefix, s.cfg.JobName)
 
+		// The HTTP client config is shared by both components below, so it's
+		// only validated once.
+		s.diags.AddAll(common.ValidateHttpClientConfig("discovery.docker", &sd.HTTPClientConfig))
+
 		// Add discovery.docker
 		s.f.Body().AppendBlock(common.NewBlockWithOverride(
 			[]string{"discovery", "docker"},
@@ -38,6 +38,50 @@ func (c *Config) Clone() flagext.Registerer {
 // extraArgs are supported to mirror the other converter params due to shared
 // testing code but they should be passed empty to this converter.
 func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// Render renders the Alloy file f returned by [ConvertFile] and
+// pretty-prints it, like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
+		return nil, diags
+	}
+
+	if len(buf.Bytes()) == 0 {
+		return nil, diags
+	}
+
+	return common.PrettyPrint(buf.Bytes())
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig(f.Bytes()))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
 	var (
 		diags diag.Diagnostics
 		cfg   Config
@@ -73,20 +117,7 @@ func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
 	f := builder.NewFile()
 	diags = AppendAll(f, &cfg.Config, "", diags)
 	diags.AddAll(common.ValidateNodes(f))
-
-	var buf bytes.Buffer
-	if _, err := f.WriteTo(&buf); err != nil {
-		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
-		return nil, diags
-	}
-
-	if len(buf.Bytes()) == 0 {
-		return nil, diags
-	}
-
-	prettyByte, newDiags := common.PrettyPrint(buf.Bytes())
-	diags.AddAll(newDiags)
-	return prettyByte, diags
+	return f, diags
 }
 
 // AppendAll analyzes the entire promtail config in memory and transforms it
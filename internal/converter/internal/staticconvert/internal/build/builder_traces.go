@@ -101,20 +101,21 @@ func (b *ConfigBuilder) translateSpanMetrics(otelCfg *otelcol.Config, cfg traces
 		return
 	}
 
-	// Add the spanmetrics connector to the otel config with the converted configuration
 	if otelCfg.Connectors == nil {
 		otelCfg.Connectors = map[otel_component.ID]otel_component.Config{}
 	}
-	otelCfg.Connectors[otel_component.NewID(otel_component.MustNewType("spanmetrics"))] = toSpanmetricsConnector(cfg.SpanMetrics)
 
-	// Add the spanmetrics connector to each traces pipelines as an exporter and create metrics pipelines.
+	// Add a spanmetrics connector to each traces pipelines as an exporter and create metrics pipelines.
+	// Static mode runs a spanmetrics processor in each traces pipeline, so each pipeline gets its own
+	// connector instead of sharing a single one which would merge the metrics of every pipeline.
 	// The processing ordering for the span metrics connector differs from the static pipelines since tail sampling
 	// in static mode processes after the custom span metrics processor. This is ok because the tail sampling
 	// processor is not processing metrics.
-	spanmetricsID := otel_component.NewID(otel_component.MustNewType("spanmetrics"))
 	remoteWriteID := otel_component.NewID(otel_component.MustNewType("remote_write"))
 	for ix, pipeline := range otelCfg.Service.Pipelines {
 		if ix.Signal() == p.SignalTraces {
+			spanmetricsID := otel_component.NewIDWithName(otel_component.MustNewType("spanmetrics"), ix.Name())
+			otelCfg.Connectors[spanmetricsID] = toSpanmetricsConnector(cfg.SpanMetrics)
 			pipeline.Exporters = append(pipeline.Exporters, spanmetricsID)
 
 			metricsId := p.NewIDWithName(p.SignalMetrics, ix.Name())
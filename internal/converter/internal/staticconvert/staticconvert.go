@@ -29,6 +29,50 @@ import (
 // extraArgs are supported to be passed along to the Static config parser such
 // as enabling integrations-next.
 func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return nil, diags
+	}
+
+	out, newDiags := Render(f)
+	diags.AddAll(newDiags)
+	return out, diags
+}
+
+// Render renders the Alloy file f returned by [ConvertFile] and
+// pretty-prints it, like [Convert] does.
+func Render(f *builder.File) ([]byte, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
+		return nil, diags
+	}
+
+	if len(buf.Bytes()) == 0 {
+		return nil, diags
+	}
+
+	return common.PrettyPrint(buf.Bytes())
+}
+
+// Validate is like [Convert] but only returns the diagnostics of the
+// conversion, without rendering the converted config.
+func Validate(in []byte, extraArgs []string) diag.Diagnostics {
+	f, diags := ConvertFile(in, extraArgs)
+	if f == nil {
+		return diags
+	}
+
+	diags.AddAll(common.LintConfig(f.Bytes()))
+	return diags
+}
+
+// ConvertFile is like [Convert] but returns the generated Alloy file before
+// it is rendered, so callers can modify it. A nil file is returned if the
+// conversion failed.
+func ConvertFile(in []byte, extraArgs []string) (*builder.File, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
@@ -46,20 +90,7 @@ func Convert(in []byte, extraArgs []string) ([]byte, diag.Diagnostics) {
 	f := builder.NewFile()
 	diags = AppendAll(f, staticConfig)
 	diags.AddAll(common.ValidateNodes(f))
-
-	var buf bytes.Buffer
-	if _, err := f.WriteTo(&buf); err != nil {
-		diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render Alloy config: %s", err.Error()))
-		return nil, diags
-	}
-
-	if len(buf.Bytes()) == 0 {
-		return nil, diags
-	}
-
-	prettyByte, newDiags := common.PrettyPrint(buf.Bytes())
-	diags.AddAll(newDiags)
-	return prettyByte, diags
+	return f, diags
 }
 
 // AppendAll analyzes the entire static config in memory and transforms it into
@@ -0,0 +1,215 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/alloy/internal/converter/diag"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/printer"
+	"github.com/grafana/alloy/syntax/vm"
+)
+
+// SharedFragment is the key of the fragment returned by ConvertByTenant which
+// holds the components shared by tenants.
+const SharedFragment = ""
+
+// ConvertByTenant is like [Convert], but splits the generated config into a
+// fragment for each tenant, keyed by tenant. Tenants are identified by the
+// value of tenantLabel, which components set as a target label or an
+// external label, for example. Components which don't set tenantLabel
+// belong to the tenant of the components referencing them, such as the
+// discovery and relabel components of a scrape job. Components referenced
+// by several tenants, or by none, go into the SharedFragment.
+//
+// Fragments reference each other's components, so they must be loaded
+// together, for example as files of the same directory.
+func ConvertByTenant(in []byte, kind Input, extraArgs []string, tenantLabel string) (map[string][]byte, diag.Diagnostics) {
+	out, diags := Convert(in, kind, extraArgs)
+	if len(out) == 0 || diags.HasSeverityLevel(diag.SeverityLevelCritical) {
+		return nil, diags
+	}
+
+	f, err := parser.ParseFile("", out)
+	if err != nil {
+		// Convert already reports configs which can't be parsed.
+		return nil, diags
+	}
+
+	tenants := assignTenants(f.Body, tenantLabel)
+	bodies := make(map[string]ast.Body)
+	for _, stmt := range f.Body {
+		tenant := SharedFragment
+		if block, ok := stmt.(*ast.BlockStmt); ok {
+			tenant = tenants[blockID(block)]
+		}
+		bodies[tenant] = append(bodies[tenant], stmt)
+	}
+
+	fragments := make(map[string][]byte, len(bodies))
+	for tenant, body := range bodies {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, &ast.File{Body: body}); err != nil {
+			diags.Add(diag.SeverityLevelCritical, fmt.Sprintf("failed to render the Alloy config of tenant %q: %s", tenant, err))
+			return nil, diags
+		}
+		// Add a trailing newline at the end of the file, which is omitted by Fprint.
+		_, _ = buf.WriteString("\n")
+		fragments[tenant] = buf.Bytes()
+	}
+	return fragments, diags
+}
+
+// assignTenants returns the tenant of every block of body, keyed by block ID.
+// Blocks without a tenant are assigned the SharedFragment.
+func assignTenants(body ast.Body, tenantLabel string) map[string]string {
+	var (
+		ids        []string
+		tenants    = make(map[string]string)
+		referrers  = make(map[string][]string)
+		isAssigned = make(map[string]bool)
+	)
+	for _, stmt := range body {
+		if block, ok := stmt.(*ast.BlockStmt); ok {
+			ids = append(ids, blockID(block))
+		}
+	}
+
+	for _, stmt := range body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+		id := blockID(block)
+
+		if tenant, ok := blockTenant(block, tenantLabel); ok {
+			tenants[id] = tenant
+			isAssigned[id] = true
+		}
+		for _, ref := range blockReferences(block, ids) {
+			if ref != id {
+				referrers[ref] = append(referrers[ref], id)
+			}
+		}
+	}
+
+	// Blocks without a tenant belong to the tenant of their referrers, if
+	// they're all assigned to the same tenant. Assigning a block can assign
+	// the blocks it references, so repeat until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for _, id := range ids {
+			if isAssigned[id] || len(referrers[id]) == 0 {
+				continue
+			}
+
+			tenant, ok := "", true
+			for i, referrer := range referrers[id] {
+				if !isAssigned[referrer] || tenants[referrer] == SharedFragment || (i > 0 && tenants[referrer] != tenant) {
+					ok = false
+					break
+				}
+				tenant = tenants[referrer]
+			}
+			if ok {
+				tenants[id] = tenant
+				isAssigned[id] = true
+				changed = true
+			}
+		}
+	}
+	return tenants
+}
+
+// blockTenant returns the literal value given to tenantLabel by an attribute
+// or an object field of block. It returns false if block doesn't set
+// tenantLabel, or sets it to different values.
+func blockTenant(block *ast.BlockStmt, tenantLabel string) (string, bool) {
+	var values []ast.Expr
+	ast.Walk(visitorFunc(func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.AttributeStmt:
+			if node.Name.Name == tenantLabel {
+				values = append(values, node.Value)
+			}
+		case *ast.ObjectExpr:
+			for _, field := range node.Fields {
+				if field.Name.Name == tenantLabel {
+					values = append(values, field.Value)
+				}
+			}
+		}
+		return true
+	}), block.Body)
+
+	var tenant string
+	for i, value := range values {
+		var s string
+		if err := vm.New(value).Evaluate(nil, &s); err != nil {
+			return "", false
+		}
+		if i > 0 && s != tenant {
+			return "", false
+		}
+		tenant = s
+	}
+	return tenant, len(values) > 0 && tenant != SharedFragment
+}
+
+// blockReferences returns the IDs of ids which are referenced by block.
+func blockReferences(block *ast.BlockStmt, ids []string) []string {
+	var refs []string
+	ast.Walk(visitorFunc(func(node ast.Node) bool {
+		access, ok := node.(*ast.AccessExpr)
+		if !ok {
+			return true
+		}
+		path, ok := accessPath(access)
+		if !ok {
+			return true
+		}
+		for _, id := range ids {
+			if path == id || strings.HasPrefix(path, id+".") {
+				refs = append(refs, id)
+			}
+		}
+		return false
+	}), block.Body)
+	return refs
+}
+
+// accessPath returns the dotted form of a chain of field accesses from an
+// identifier, such as prometheus.remote_write.default.receiver.
+func accessPath(expr ast.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr:
+		return expr.Ident.Name, true
+	case *ast.AccessExpr:
+		prefix, ok := accessPath(expr.Value)
+		return prefix + "." + expr.Name.Name, ok
+	default:
+		return "", false
+	}
+}
+
+// blockID returns the ID of block, such as prometheus.scrape.default.
+func blockID(block *ast.BlockStmt) string {
+	id := block.GetBlockName()
+	if block.Label != "" {
+		id += "." + block.Label
+	}
+	return id
+}
+
+// visitorFunc implements ast.Visitor, visiting the children of the nodes for
+// which it returns true.
+type visitorFunc func(node ast.Node) bool
+
+func (f visitorFunc) Visit(node ast.Node) ast.Visitor {
+	if node == nil || !f(node) {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,45 @@
+package module
+
+import (
+	"maps"
+
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/vm"
+)
+
+// effectiveArgs returns args along with the default value of every argument
+// declared by content which isn't set in args. Defaults which can't be
+// evaluated without the scope of the module are left out.
+func effectiveArgs(content string, args map[string]any) map[string]any {
+	effective := maps.Clone(args)
+	if effective == nil {
+		effective = make(map[string]any)
+	}
+
+	f, err := parser.ParseFile("", []byte(content))
+	if err != nil {
+		return effective
+	}
+	for _, stmt := range f.Body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok || block.GetBlockName() != "argument" {
+			continue
+		}
+		if _, ok := effective[block.Label]; ok {
+			continue
+		}
+
+		for _, blockStmt := range block.Body {
+			attr, ok := blockStmt.(*ast.AttributeStmt)
+			if !ok || attr.Name.Name != "default" {
+				continue
+			}
+			var value any
+			if err := vm.New(attr.Value).Evaluate(nil, &value); err == nil {
+				effective[block.Label] = value
+			}
+		}
+	}
+	return effective
+}
@@ -0,0 +1,81 @@
+package module
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/printer"
+)
+
+// ReloadDiff describes the components which changed between two loads of
+// the module content. Components are identified by their block name and
+// label, such as "local.file.example".
+type ReloadDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+
+	// Downgrade is set when the content is identical to a version of the
+	// content which was loaded and then replaced by another one, meaning
+	// that the load rolled the module back rather than changing it forward.
+	Downgrade bool
+}
+
+// diffContent compares the top-level blocks of the previous and the new
+// module content. Content which can't be parsed is treated as having no
+// components.
+func diffContent(previous, content string) ReloadDiff {
+	oldBlocks, newBlocks := contentBlocks(previous), contentBlocks(content)
+
+	var diff ReloadDiff
+	for id, block := range newBlocks {
+		oldBlock, ok := oldBlocks[id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, id)
+		case oldBlock != block:
+			diff.Changed = append(diff.Changed, id)
+		}
+	}
+	for id := range oldBlocks {
+		if _, ok := newBlocks[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Changed)
+	return diff
+}
+
+// contentBlocks returns the formatted top-level blocks of content, keyed by
+// their ID.
+func contentBlocks(content string) map[string]string {
+	blocks := make(map[string]string)
+
+	f, err := parser.ParseFile("", []byte(content))
+	if err != nil {
+		return blocks
+	}
+	for _, stmt := range f.Body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+
+		id := block.GetBlockName()
+		if block.Label != "" {
+			id += "." + block.Label
+		}
+
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, block); err != nil {
+			continue
+		}
+		blocks[id] = buf.String()
+	}
+	return blocks
+}
@@ -0,0 +1,37 @@
+package module
+
+import (
+	"crypto/sha256"
+	"slices"
+)
+
+// maxContentHistory is the number of superseded versions of the module
+// content remembered to detect downgrades.
+const maxContentHistory = 16
+
+// contentHistory holds the hashes of the most recently superseded versions
+// of the module content, oldest first.
+type contentHistory struct {
+	hashes [][sha256.Size]byte
+}
+
+// supersede records that content was replaced by next, and returns true if
+// next is a version of the content which was superseded before, in which
+// case the load is a downgrade. Empty content, loaded when the module is
+// disabled, isn't considered a version of the content.
+func (h *contentHistory) supersede(content, next string) bool {
+	if content == "" || next == "" || content == next {
+		return false
+	}
+
+	nextHash := sha256.Sum256([]byte(next))
+	downgrade := slices.Contains(h.hashes, nextHash)
+
+	hash := sha256.Sum256([]byte(content))
+	h.hashes = slices.DeleteFunc(h.hashes, func(other [sha256.Size]byte) bool { return other == hash })
+	h.hashes = append(h.hashes, hash)
+	if len(h.hashes) > maxContentHistory {
+		h.hashes = h.hashes[len(h.hashes)-maxContentHistory:]
+	}
+	return downgrade
+}
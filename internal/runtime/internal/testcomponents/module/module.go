@@ -3,12 +3,18 @@ package module
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"maps"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/featuregate"
 	"github.com/grafana/alloy/internal/runtime/equality"
 	"github.com/grafana/alloy/internal/runtime/logging/level"
+	"github.com/grafana/alloy/syntax/encoding/alloyjson"
 )
 
 // ModuleComponent holds the common properties for module components.
@@ -16,10 +22,55 @@ type ModuleComponent struct {
 	opts component.Options
 	mod  component.Module
 
+	// loadMut serializes loads of the module, including the restoration of the
+	// previous content after a cancelled load.
+	loadMut sync.Mutex
+	// disabled is set while the module is disabled, in which case pending
+	// holds the source to load once it's enabled again. Both are guarded by
+	// loadMut.
+	disabled bool
+	pending  *moduleSource
+	// loadGen is incremented by every load of the module content, so that
+	// the late result of an abandoned load can tell whether later loads
+	// happened meanwhile. It's guarded by loadMut.
+	loadGen uint64
+
 	mut           sync.RWMutex
 	health        component.Health
 	latestContent string
 	latestArgs    map[string]any
+	effectiveArgs map[string]any
+	lastDiff      ReloadDiff
+	history       contentHistory
+	minStability  featuregate.Stability
+	maxLoad       time.Duration
+
+	// tasks are the background tasks started by the module children.
+	tasks childTasks
+
+	// exportMut serializes the delivery of exports to exportHandler.
+	exportMut     sync.Mutex
+	exportHandler func(map[string]any)
+	latestExports map[string]any
+	// exportTTLs holds the TTL of the exports which expire, expiries their
+	// pending expiry, and expiriesStopped whether the module stopped running
+	// and nothing expires anymore. They're guarded by exportMut.
+	exportTTLs      map[string]time.Duration
+	expiries        map[string]*exportExpiry
+	expiriesStopped bool
+
+	reloads        prometheus.Counter
+	reloadFailures prometheus.Counter
+	exportsSize    prometheus.Gauge
+	// registered holds the metrics registered by the component, rather than
+	// shared with another component of the same registerer.
+	registered []prometheus.Collector
+}
+
+// moduleSource is the content of a module along with its arguments.
+type moduleSource struct {
+	args    map[string]any
+	content string
 }
 
 // Exports holds values which are exported from the run module.
@@ -28,39 +79,210 @@ type Exports struct {
 	Exports map[string]any `alloy:"exports,block"`
 }
 
-// NewModuleComponent initializes a new ModuleComponent.
+// NewModuleComponent initializes a new ModuleComponent. Its metrics are
+// registered against o.Registerer if it's set, which labels them with the
+// component ID.
 func NewModuleComponent(o component.Options) (*ModuleComponent, error) {
+	return newModuleComponent(o, func(export component.ExportFunc) (component.Module, error) {
+		return o.ModuleController.NewModule("", export)
+	})
+}
+
+// newModuleComponent initializes a ModuleComponent running the module
+// created by newModule, which sends its exports to the given function.
+func newModuleComponent(o component.Options, newModule func(component.ExportFunc) (component.Module, error)) (*ModuleComponent, error) {
 	c := &ModuleComponent{
 		opts: o,
+		reloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "module_reloads_total",
+			Help: "Total number of times the module content was successfully loaded.",
+		}),
+		reloadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "module_reload_failures_total",
+			Help: "Total number of times the module content failed to load.",
+		}),
+		exportsSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "module_exports_size_bytes",
+			Help: "Approximate size of the current exports of the module, serialized to JSON.",
+		}),
 	}
-	var err error
-	c.mod, err = o.ModuleController.NewModule("", func(exports map[string]any) {
+	if err := c.registerMetrics(); err != nil {
+		return nil, err
+	}
+
+	c.exportHandler = func(exports map[string]any) {
 		c.opts.OnStateChange(Exports{Exports: exports})
-	})
+	}
+	var err error
+	c.mod, err = newModule(c.onExportsChange)
 	return c, err
 }
 
+// registerMetrics registers the metrics of the module against the
+// registerer of the component, if any. Components registering against the
+// same registerer share the metrics registered by the first one.
+func (c *ModuleComponent) registerMetrics() error {
+	if c.opts.Registerer == nil {
+		return nil
+	}
+
+	var err error
+	if c.reloads, err = registerOrGet(c, c.reloads); err != nil {
+		return err
+	}
+	if c.reloadFailures, err = registerOrGet(c, c.reloadFailures); err != nil {
+		return err
+	}
+	c.exportsSize, err = registerOrGet(c, c.exportsSize)
+	return err
+}
+
+// registerOrGet registers m against the registerer of c, returning the
+// metric already registered in its place if any.
+func registerOrGet[T prometheus.Collector](c *ModuleComponent, m T) (T, error) {
+	if err := c.opts.Registerer.Register(m); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return m, err
+		}
+		existing, ok := are.ExistingCollector.(T)
+		if !ok {
+			return m, err
+		}
+		return existing, nil
+	}
+	c.registered = append(c.registered, m)
+	return m, nil
+}
+
+// unregisterMetrics unregisters the metrics registered by the module, so
+// that a module with the same registerer can be created again.
+func (c *ModuleComponent) unregisterMetrics() {
+	for _, m := range c.registered {
+		c.opts.Registerer.Unregister(m)
+	}
+	c.registered = nil
+}
+
+// SetExportHandler replaces the function receiving the exports of the
+// module, which defaults to calling OnStateChange. The latest exports of the
+// module, if any, are immediately sent to the new handler.
+func (c *ModuleComponent) SetExportHandler(handler func(map[string]any)) {
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+
+	c.exportHandler = handler
+	if c.latestExports != nil {
+		handler(c.latestExports)
+	}
+}
+
+// onExportsChange records the new exports of the module and sends them to
+// the export handler.
+func (c *ModuleComponent) onExportsChange(exports map[string]any) {
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+
+	c.latestExports = exports
+	c.refreshExpiries(exports)
+	c.updateExportsSize(exports)
+	c.exportHandler(exports)
+}
+
+// updateExportsSize sets the exports size gauge to the size of exports once
+// serialized. The gauge is left unchanged if they can't be serialized.
+func (c *ModuleComponent) updateExportsSize(exports map[string]any) {
+	b, err := alloyjson.MarshalValue(exports)
+	if err != nil {
+		level.Debug(c.opts.Logger).Log("msg", "failed to compute the size of the module exports", "id", c.opts.ID, "err", err)
+		return
+	}
+	c.exportsSize.Set(float64(len(b)))
+}
+
 // LoadAlloySource loads the controller with the current component source.
 // It will set the component health in addition to return the error so that the consumer can rely on either or both.
 // If the content is the same as the last time it was successfully loaded, it will not be reloaded.
 func (c *ModuleComponent) LoadAlloySource(args map[string]any, contentValue string) error {
+	return c.LoadAlloySourceContext(context.Background(), args, contentValue)
+}
+
+// LoadAlloySourceContext is like LoadAlloySource, but stops waiting for the
+// load to complete once ctx is canceled and returns the context error. The
+// component health is left untouched, and the previously loaded content is
+// restored as soon as the abandoned load completes.
+func (c *ModuleComponent) LoadAlloySourceContext(ctx context.Context, args map[string]any, contentValue string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.loadMut.Lock()
+	if c.disabled {
+		// The source is loaded once the module is enabled again.
+		c.pending = &moduleSource{args: args, content: contentValue}
+		c.loadMut.Unlock()
+		return nil
+	}
 	if equality.DeepEqual(args, c.getLatestArgs()) && contentValue == c.getLatestContent() {
+		c.loadMut.Unlock()
 		return nil
 	}
 
-	err := c.mod.LoadConfig([]byte(contentValue), args)
-	if err != nil {
-		c.setHealth(component.Health{
-			Health:     component.HealthTypeUnhealthy,
-			Message:    fmt.Sprintf("failed to load module content: %s", err),
-			UpdateTime: time.Now(),
-		})
+	if err := checkStability(contentValue, c.getMinStability()); err != nil {
+		c.loadMut.Unlock()
+		return c.loadFailed(err)
+	}
 
+	// Lingering tasks of the children would delay the load, so they're
+	// canceled beforehand, and restarted if the load doesn't go through.
+	canceled, err := c.cancelChildren(ctx)
+	if err != nil {
+		c.restartChildTasks(canceled)
+		c.loadMut.Unlock()
 		return err
 	}
 
+	var timeout <-chan time.Time
+	if maxLoad := c.getMaxLoadDuration(); maxLoad > 0 {
+		timer := time.NewTimer(maxLoad)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	c.loadGen++
+	gen := c.loadGen
+	done := make(chan error, 1)
+	go func() {
+		done <- c.mod.LoadConfig([]byte(contentValue), args)
+	}()
+
+	// An abandoned load releases loadMut right away, so that a load which
+	// never completes doesn't block later loads.
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		c.loadMut.Unlock()
+		go c.restoreAfterLoad(done, gen, canceled)
+		return ctx.Err()
+	case <-timeout:
+		c.loadMut.Unlock()
+		go c.restoreAfterLoad(done, gen, canceled)
+		return c.loadFailed(fmt.Errorf("module content didn't load within %s, rolling back to the previous content", c.getMaxLoadDuration()))
+	}
+
+	if err != nil {
+		c.restartChildTasks(canceled)
+		c.loadMut.Unlock()
+		return c.loadFailed(err)
+	}
+
+	// The latest content is recorded before releasing loadMut, so that the
+	// restoration of an abandoned load can't load back older content.
+	c.reloads.Inc()
 	c.setLatestArgs(args)
 	c.setLatestContent(contentValue)
+	c.setEffectiveArgs(effectiveArgs(contentValue, args))
+	c.loadMut.Unlock()
 	c.setHealth(component.Health{
 		Health:     component.HealthTypeHealthy,
 		Message:    "module content loaded",
@@ -70,8 +292,155 @@ func (c *ModuleComponent) LoadAlloySource(args map[string]any, contentValue stri
 	return nil
 }
 
+// LoadAlloySourceFromFS is like LoadAlloySource, but reads the module content
+// from the file at path in fsys, such as an embed.FS bundling modules into the
+// binary. Failing to read the file is handled like a failed load.
+func (c *ModuleComponent) LoadAlloySourceFromFS(fsys fs.FS, path string, args map[string]any) error {
+	content, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return c.loadFailed(fmt.Errorf("failed to read module %s: %w", path, err))
+	}
+	return c.LoadAlloySource(args, string(content))
+}
+
+// loadFailed records the failure of a load of the module content and
+// returns err.
+func (c *ModuleComponent) loadFailed(err error) error {
+	c.reloadFailures.Inc()
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeUnhealthy,
+		Message:    fmt.Sprintf("failed to load module content: %s", err),
+		UpdateTime: time.Now(),
+	})
+	return err
+}
+
+// SetEnabled enables or disables the module, allowing a module component to
+// be toggled on and off with a single argument. Disabling the module tears it
+// down by loading empty content, which stops all of its components, and
+// reports the component as healthy with a "module disabled" message, as a
+// disabled module isn't failing. Content loaded while the module is
+// disabled isn't loaded until the module is enabled again. Modules are
+// enabled by default.
+func (c *ModuleComponent) SetEnabled(enabled bool) error {
+	c.loadMut.Lock()
+	if enabled {
+		if !c.disabled {
+			c.loadMut.Unlock()
+			return nil
+		}
+		c.disabled = false
+		pending := c.pending
+		c.pending = nil
+		c.loadMut.Unlock()
+
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeUnknown,
+			Message:    "module enabled",
+			UpdateTime: time.Now(),
+		})
+		if pending == nil {
+			return nil
+		}
+		return c.LoadAlloySource(pending.args, pending.content)
+	}
+
+	defer c.loadMut.Unlock()
+	if c.disabled {
+		return nil
+	}
+	if err := c.mod.LoadConfig(nil, nil); err != nil {
+		return c.loadFailed(err)
+	}
+
+	c.disabled = true
+	if args := c.getLatestArgs(); args != nil {
+		c.pending = &moduleSource{args: args, content: c.getLatestContent()}
+	}
+	c.setLatestContent("")
+	c.mut.Lock()
+	c.latestArgs = nil
+	c.effectiveArgs = nil
+	c.mut.Unlock()
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    "module disabled",
+		UpdateTime: time.Now(),
+	})
+	return nil
+}
+
+// SetMinStability sets the minimum stability level of the components the
+// module content may use. Loading content using a component below this level
+// fails without loading the module. No level is enforced by default, besides
+// the stability level the runtime is started with.
+func (c *ModuleComponent) SetMinStability(stability featuregate.Stability) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.minStability = stability
+}
+
+func (c *ModuleComponent) getMinStability() featuregate.Stability {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.minStability
+}
+
+// SetMaxLoadDuration sets how long loading the module content may take. A
+// load taking longer fails and marks the component unhealthy, and the
+// previous content is restored as soon as the load completes. Loads aren't
+// limited by default.
+func (c *ModuleComponent) SetMaxLoadDuration(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.maxLoad = d
+}
+
+func (c *ModuleComponent) getMaxLoadDuration() time.Duration {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.maxLoad
+}
+
+// restoreAfterLoad waits for the abandoned load of generation gen to
+// complete and discards its result, loading the latest successfully loaded
+// content back into the module. If later loads happened meanwhile and the
+// abandoned load failed, the module still runs the content of the later
+// loads and is left untouched.
+func (c *ModuleComponent) restoreAfterLoad(done <-chan error, gen uint64, canceled []*childTask) {
+	err := <-done
+
+	c.loadMut.Lock()
+	defer c.loadMut.Unlock()
+
+	if c.loadGen != gen && err != nil {
+		return
+	}
+	args := c.getLatestArgs()
+	if c.disabled || args == nil {
+		// The module is disabled, or nothing was loaded before the abandoned
+		// load, so it must not run the abandoned content either.
+		if err == nil {
+			if err := c.mod.LoadConfig(nil, nil); err != nil {
+				level.Error(c.opts.Logger).Log("msg", "failed to tear down module after an abandoned load", "id", c.opts.ID, "err", err)
+			}
+		}
+		return
+	}
+	// The child tasks canceled for the abandoned load belong to the content
+	// it's rolled back to, unless a later load replaced it.
+	if c.loadGen == gen {
+		defer c.restartChildTasks(canceled)
+	}
+	if err := c.mod.LoadConfig([]byte(c.getLatestContent()), args); err != nil {
+		level.Error(c.opts.Logger).Log("msg", "failed to restore module content after an abandoned load", "id", c.opts.ID, "err", err)
+	}
+}
+
 // RunAlloyController runs the controller that all module components start.
 func (c *ModuleComponent) RunAlloyController(ctx context.Context) {
+	defer c.stopAllExpiries()
+
 	err := c.mod.Run(ctx)
 	if err != nil {
 		level.Error(c.opts.Logger).Log("msg", "error running module", "id", c.opts.ID, "err", err)
@@ -92,12 +461,25 @@ func (c *ModuleComponent) setHealth(h component.Health) {
 	c.health = h
 }
 
+// setLatestContent records content as the latest loaded content, along with
+// the diff of its components against the previous content.
 func (c *ModuleComponent) setLatestContent(content string) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
+	c.lastDiff = diffContent(c.latestContent, content)
+	c.lastDiff.Downgrade = c.history.supersede(c.latestContent, content)
 	c.latestContent = content
 }
 
+// LastReloadDiff returns the components added, removed, or changed by the
+// latest successful load of the module content, compared to the content
+// loaded before it.
+func (c *ModuleComponent) LastReloadDiff() ReloadDiff {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.lastDiff
+}
+
 func (c *ModuleComponent) getLatestContent() string {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
@@ -119,3 +501,18 @@ func (c *ModuleComponent) getLatestArgs() map[string]any {
 	defer c.mut.RUnlock()
 	return c.latestArgs
 }
+
+func (c *ModuleComponent) setEffectiveArgs(args map[string]any) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.effectiveArgs = args
+}
+
+// EffectiveArgs returns the arguments the module is running with: the
+// arguments of the latest successful load, along with the default values of
+// the arguments it didn't set. It returns nil if no content is loaded.
+func (c *ModuleComponent) EffectiveArgs() map[string]any {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return maps.Clone(c.effectiveArgs)
+}
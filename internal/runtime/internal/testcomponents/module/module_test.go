@@ -0,0 +1,1050 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/featuregate"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+)
+
+func TestLoadAlloySourceContext_Canceled(t *testing.T) {
+	mod := &slowModule{release: make(chan struct{})}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.LoadAlloySource(nil, "previous"))
+	previousHealth := c.CurrentHealth()
+
+	// Cancel the load while the module is still loading the slow content.
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = c.LoadAlloySourceContext(ctx, nil, "slow")
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.Equal(t, "previous", c.getLatestContent())
+	require.Equal(t, previousHealth, c.CurrentHealth())
+
+	// Once the slow load completes, the previous content is loaded back.
+	close(mod.release)
+	require.Eventually(t, func() bool {
+		return mod.Content() == "previous"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"previous", "slow", "previous"}, mod.Loads())
+}
+
+func TestLoadAlloySourceContext_AlreadyCanceled(t *testing.T) {
+	mod := &slowModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, c.LoadAlloySourceContext(ctx, nil, "content"), context.Canceled)
+	require.Empty(t, mod.Loads())
+}
+
+func TestLoadAlloySource_MaxLoadDuration(t *testing.T) {
+	mod := &slowModule{release: make(chan struct{})}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+	c.SetMaxLoadDuration(50 * time.Millisecond)
+
+	require.NoError(t, c.LoadAlloySource(nil, "previous"))
+
+	err = c.LoadAlloySource(nil, "slow")
+	require.ErrorContains(t, err, "module content didn't load within 50ms")
+	health := c.CurrentHealth()
+	require.Equal(t, component.HealthTypeUnhealthy, health.Health)
+	require.Contains(t, health.Message, "didn't load within 50ms")
+	require.Equal(t, "previous", c.getLatestContent())
+	require.Equal(t, 1.0, testutil.ToFloat64(c.reloadFailures))
+
+	// Once the slow load completes, the previous content is loaded back.
+	close(mod.release)
+	require.Eventually(t, func() bool {
+		return mod.Content() == "previous"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"previous", "slow", "previous"}, mod.Loads())
+}
+
+func TestLoadAlloySource_MaxLoadDurationNeverReturns(t *testing.T) {
+	mod := &slowModule{release: make(chan struct{})}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+	c.SetMaxLoadDuration(50 * time.Millisecond)
+
+	require.NoError(t, c.LoadAlloySource(nil, "previous"))
+
+	// The slow load doesn't return until the end of the test, and mustn't
+	// block the next loads.
+	require.ErrorContains(t, c.LoadAlloySource(nil, "slow"), "module content didn't load within 50ms")
+	require.NoError(t, c.LoadAlloySource(nil, "next"))
+	require.Equal(t, "next", c.getLatestContent())
+	require.Equal(t, "next", mod.Content())
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+
+	// The late result of the abandoned load is discarded, and the content
+	// of the later load is loaded back.
+	close(mod.release)
+	require.Eventually(t, func() bool {
+		return len(mod.Loads()) == 4
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"previous", "slow", "next", "next"}, mod.Loads())
+	require.Eventually(t, func() bool {
+		return mod.Content() == "next"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSetExportHandler(t *testing.T) {
+	var stateChanges []component.Exports
+	controller := &fakeModuleController{mod: &slowModule{}}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange: func(e component.Exports) {
+			stateChanges = append(stateChanges, e)
+		},
+	})
+	require.NoError(t, err)
+
+	controller.exports(map[string]any{"a": 1})
+	require.Equal(t, []component.Exports{Exports{Exports: map[string]any{"a": 1}}}, stateChanges)
+
+	// The new handler receives the current exports as soon as it's set.
+	var received []map[string]any
+	c.SetExportHandler(func(exports map[string]any) {
+		received = append(received, exports)
+	})
+	require.Equal(t, []map[string]any{{"a": 1}}, received)
+
+	controller.exports(map[string]any{"a": 2})
+	require.Equal(t, []map[string]any{{"a": 1}, {"a": 2}}, received)
+	require.Len(t, stateChanges, 1)
+}
+
+func TestSetExportTTL(t *testing.T) {
+	var (
+		mut          sync.Mutex
+		stateChanges []map[string]any
+	)
+	controller := &fakeModuleController{mod: &slowModule{}}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange: func(e component.Exports) {
+			mut.Lock()
+			defer mut.Unlock()
+			stateChanges = append(stateChanges, e.(Exports).Exports)
+		},
+	})
+	require.NoError(t, err)
+	latest := func() map[string]any {
+		mut.Lock()
+		defer mut.Unlock()
+		return stateChanges[len(stateChanges)-1]
+	}
+
+	c.SetExportTTL("target", 50*time.Millisecond)
+	controller.exports(map[string]any{"target": "localhost:9090", "static": 1})
+	require.Equal(t, map[string]any{"target": "localhost:9090", "static": 1}, latest())
+
+	// Only the export with a TTL is cleared once it elapses.
+	require.Eventually(t, func() bool {
+		_, ok := latest()["target"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]any{"static": 1}, latest())
+
+	// Updating the export restarts its TTL.
+	c.SetExportTTL("target", 300*time.Millisecond)
+	controller.exports(map[string]any{"target": "localhost:9091", "static": 1})
+	time.Sleep(200 * time.Millisecond)
+	controller.exports(map[string]any{"target": "localhost:9092", "static": 1})
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, map[string]any{"target": "localhost:9092", "static": 1}, latest())
+	require.Eventually(t, func() bool {
+		_, ok := latest()["target"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSetExportTTL_StoppedWithController(t *testing.T) {
+	var (
+		mut          sync.Mutex
+		stateChanges int
+	)
+	controller := &fakeModuleController{mod: &slowModule{}}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange: func(component.Exports) {
+			mut.Lock()
+			defer mut.Unlock()
+			stateChanges++
+		},
+	})
+	require.NoError(t, err)
+
+	c.SetExportTTL("target", 50*time.Millisecond)
+	controller.exports(map[string]any{"target": "localhost:9090"})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	c.RunAlloyController(ctx)
+
+	// Exports don't expire once the controller stopped running.
+	time.Sleep(150 * time.Millisecond)
+	mut.Lock()
+	defer mut.Unlock()
+	require.Equal(t, 1, stateChanges)
+
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+	require.Empty(t, c.expiries)
+}
+
+func TestExportsSizeMetric(t *testing.T) {
+	controller := &fakeModuleController{mod: &slowModule{}}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange:    func(component.Exports) {},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0.0, testutil.ToFloat64(c.exportsSize))
+
+	exports := make(map[string]any)
+	for i := 0; i < 100; i++ {
+		exports[fmt.Sprintf("export_%d", i)] = strings.Repeat("x", 1000)
+	}
+	controller.exports(exports)
+	require.Greater(t, testutil.ToFloat64(c.exportsSize), 100_000.0)
+
+	controller.exports(map[string]any{"a": 1})
+	require.Less(t, testutil.ToFloat64(c.exportsSize), 100.0)
+}
+
+func TestLoadAlloySource_Metrics(t *testing.T) {
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: &slowModule{}},
+	})
+	require.NoError(t, err)
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, "a"))
+	require.Error(t, c.LoadAlloySource(args, "fail"))
+	// Loading the same content again is a no-op and isn't counted.
+	require.NoError(t, c.LoadAlloySource(args, "a"))
+	require.NoError(t, c.LoadAlloySource(args, "b"))
+
+	require.Equal(t, 2.0, testutil.ToFloat64(c.reloads))
+	require.Equal(t, 1.0, testutil.ToFloat64(c.reloadFailures))
+}
+
+func TestLoadAlloySource_SharedRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	newComponent := func(id string) *ModuleComponent {
+		c, err := NewModuleComponent(component.Options{
+			ID:               id,
+			Logger:           log.NewNopLogger(),
+			Registerer:       reg,
+			ModuleController: &fakeModuleController{mod: &slowModule{}},
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	// Components sharing a registerer share its metrics.
+	a, b := newComponent("module.a"), newComponent("module.b")
+	require.NoError(t, a.LoadAlloySource(nil, "a"))
+	require.NoError(t, b.LoadAlloySource(nil, "b"))
+	require.Equal(t, 2.0, testutil.ToFloat64(a.reloads))
+	require.Same(t, a.reloads, b.reloads)
+
+	// Components without a registerer don't register their metrics.
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.c",
+		Logger:           log.NewNopLogger(),
+		ModuleController: &fakeModuleController{mod: &slowModule{}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.LoadAlloySource(nil, "c"))
+	require.Equal(t, 1.0, testutil.ToFloat64(c.reloads))
+}
+
+func TestLastReloadDiff(t *testing.T) {
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: &slowModule{}},
+	})
+	require.NoError(t, err)
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, `
+		local.file "a" { filename = "a" }
+		local.file "b" { filename = "b" }
+	`))
+	require.Equal(t, ReloadDiff{Added: []string{"local.file.a", "local.file.b"}}, c.LastReloadDiff())
+
+	require.NoError(t, c.LoadAlloySource(args, `
+		local.file "a" { filename = "a" }
+		local.file "c" { filename = "c" }
+	`))
+	require.Equal(t, ReloadDiff{
+		Added:   []string{"local.file.c"},
+		Removed: []string{"local.file.b"},
+	}, c.LastReloadDiff())
+
+	require.NoError(t, c.LoadAlloySource(args, `
+		local.file "a" { filename = "changed" }
+		local.file "c" { filename = "c" }
+	`))
+	require.Equal(t, ReloadDiff{Changed: []string{"local.file.a"}}, c.LastReloadDiff())
+
+	// A failed load leaves the diff of the last successful load untouched.
+	require.Error(t, c.LoadAlloySource(args, "fail"))
+	require.Equal(t, ReloadDiff{Changed: []string{"local.file.a"}}, c.LastReloadDiff())
+}
+
+func TestLastReloadDiff_Downgrade(t *testing.T) {
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: &slowModule{}},
+	})
+	require.NoError(t, err)
+
+	var (
+		args     = map[string]any{"arg": 1}
+		contentA = `local.file "a" { filename = "a" }`
+		contentB = `local.file "a" { filename = "b" }`
+	)
+
+	require.NoError(t, c.LoadAlloySource(args, contentA))
+	require.False(t, c.LastReloadDiff().Downgrade)
+
+	require.NoError(t, c.LoadAlloySource(args, contentB))
+	require.False(t, c.LastReloadDiff().Downgrade)
+
+	require.NoError(t, c.LoadAlloySource(args, contentA))
+	require.Equal(t, ReloadDiff{Changed: []string{"local.file.a"}, Downgrade: true}, c.LastReloadDiff())
+
+	// Disabling and enabling the module again isn't a downgrade.
+	require.NoError(t, c.SetEnabled(false))
+	require.NoError(t, c.SetEnabled(true))
+	require.False(t, c.LastReloadDiff().Downgrade)
+}
+
+func TestEffectiveArgs(t *testing.T) {
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: &slowModule{}},
+	})
+	require.NoError(t, err)
+	require.Nil(t, c.EffectiveArgs())
+
+	content := `
+		argument "address" { }
+		argument "port" {
+			optional = true
+			default  = 8080
+		}
+		argument "scheme" {
+			optional = true
+			default  = "http"
+		}
+	`
+	require.NoError(t, c.LoadAlloySource(map[string]any{"address": "localhost", "scheme": "https"}, content))
+	require.Equal(t, map[string]any{
+		"address": "localhost",
+		"port":    8080,
+		"scheme":  "https",
+	}, c.EffectiveArgs())
+
+	// A failed load leaves the effective arguments untouched.
+	require.Error(t, c.LoadAlloySource(nil, "fail"))
+	require.Equal(t, "localhost", c.EffectiveArgs()["address"])
+}
+
+func TestLoadAlloySourceFromFS(t *testing.T) {
+	mod := &slowModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"modules/standard.alloy": &fstest.MapFile{Data: []byte(`local.file "a" { filename = "a" }`)},
+	}
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySourceFromFS(fsys, "modules/standard.alloy", args))
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.Equal(t, `local.file "a" { filename = "a" }`, mod.Content())
+
+	// Loading the same content again is a no-op.
+	require.NoError(t, c.LoadAlloySourceFromFS(fsys, "modules/standard.alloy", args))
+	require.Len(t, mod.Loads(), 1)
+
+	// A missing file makes the component unhealthy.
+	require.ErrorIs(t, c.LoadAlloySourceFromFS(fsys, "modules/missing.alloy", args), fs.ErrNotExist)
+	require.Equal(t, component.HealthTypeUnhealthy, c.CurrentHealth().Health)
+	require.Equal(t, 1.0, testutil.ToFloat64(c.reloadFailures))
+}
+
+func TestCancelChildren(t *testing.T) {
+	mod := &taskModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+	mod.c = c
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, "blocking"))
+	tasks := c.ChildTasks()
+	require.Len(t, tasks, 1)
+	require.Equal(t, "blocking", tasks[0].Name)
+
+	// The task of the previous content is canceled before the next load.
+	require.NoError(t, c.LoadAlloySource(args, "next"))
+	require.True(t, mod.stoppedBeforeLoad.Load())
+	require.Empty(t, c.ChildTasks())
+
+	require.NoError(t, c.LoadAlloySource(args, "blocking"))
+	require.Len(t, c.ChildTasks(), 1)
+	c.CancelChildren()
+	require.Empty(t, c.ChildTasks())
+}
+
+func TestCancelChildren_FailedLoad(t *testing.T) {
+	mod := &taskModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+	mod.c = c
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, "blocking"))
+	require.Eventually(t, func() bool { return mod.runs.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// The task is canceled before the load, and started again once it
+	// fails, since the children still run the previous content.
+	require.Error(t, c.LoadAlloySource(args, "fail"))
+	require.True(t, mod.stoppedBeforeLoad.Load())
+	require.Eventually(t, func() bool { return mod.runs.Load() == 2 }, time.Second, 10*time.Millisecond)
+	tasks := c.ChildTasks()
+	require.Len(t, tasks, 1)
+	require.Equal(t, "blocking", tasks[0].Name)
+}
+
+// taskModule is a component.Module whose "blocking" content starts a child
+// task running until it's canceled, and whose "fail" content fails to load.
+type taskModule struct {
+	c *ModuleComponent
+
+	runs              atomic.Int32
+	stopped           atomic.Bool
+	stoppedBeforeLoad atomic.Bool
+}
+
+func (m *taskModule) LoadConfig(config []byte, _ map[string]any) error {
+	if string(config) != "blocking" {
+		m.stoppedBeforeLoad.Store(m.stopped.Load())
+		if string(config) == "fail" {
+			return errors.New("load failed")
+		}
+		return nil
+	}
+	m.stopped.Store(false)
+	m.c.StartChildTask("blocking", func(ctx context.Context) {
+		m.runs.Add(1)
+		<-ctx.Done()
+		m.stopped.Store(true)
+	})
+	return nil
+}
+
+func (m *taskModule) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func init() {
+	for name, stability := range map[string]featuregate.Stability{
+		"module_test.experimental": featuregate.StabilityExperimental,
+		"module_test.stable":       featuregate.StabilityGenerallyAvailable,
+	} {
+		component.Register(component.Registration{
+			Name:      name,
+			Stability: stability,
+			Args:      struct{}{},
+			Build: func(component.Options, component.Arguments) (component.Component, error) {
+				return nil, errors.New("not implemented")
+			},
+		})
+	}
+}
+
+func TestLoadAlloySource_MinStability(t *testing.T) {
+	mod := &slowModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+	c.SetMinStability(featuregate.StabilityGenerallyAvailable)
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, `module_test.stable "a" {}`))
+
+	experimental := `
+		declare "custom" {
+			module_test.experimental "a" {}
+		}
+	`
+	err = c.LoadAlloySource(args, experimental)
+	require.ErrorContains(t, err, `component "module_test.experimental" is at stability level "experimental", which is below the minimum allowed stability level "generally-available"`)
+	require.Equal(t, component.HealthTypeUnhealthy, c.CurrentHealth().Health)
+	// The rejected content is never loaded into the module.
+	require.Equal(t, []string{`module_test.stable "a" {}`}, mod.Loads())
+	require.Equal(t, 1.0, testutil.ToFloat64(c.reloadFailures))
+
+	c.SetMinStability(featuregate.StabilityExperimental)
+	require.NoError(t, c.LoadAlloySource(args, experimental))
+}
+
+type fakeModuleController struct {
+	mod     component.Module
+	exports component.ExportFunc
+}
+
+func (f *fakeModuleController) NewModule(_ string, exports component.ExportFunc) (component.Module, error) {
+	f.exports = exports
+	return f.mod, nil
+}
+
+// slowModule is a component.Module which blocks when loading the "slow"
+// content until release is closed, and fails to load the "fail" content.
+type slowModule struct {
+	release chan struct{}
+
+	mut     sync.Mutex
+	loads   []string
+	content string
+}
+
+func (m *slowModule) LoadConfig(config []byte, _ map[string]any) error {
+	m.mut.Lock()
+	m.loads = append(m.loads, string(config))
+	m.mut.Unlock()
+
+	if string(config) == "slow" {
+		<-m.release
+	}
+	if string(config) == "fail" {
+		return errors.New("failed to load")
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.content = string(config)
+	return nil
+}
+
+func (m *slowModule) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (m *slowModule) Content() string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.content
+}
+
+func (m *slowModule) Loads() []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return append([]string(nil), m.loads...)
+}
+
+func TestSetEnabled(t *testing.T) {
+	mod := &slowModule{}
+	c, err := NewModuleComponent(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: &fakeModuleController{mod: mod},
+	})
+	require.NoError(t, err)
+
+	args := map[string]any{"arg": 1}
+	require.NoError(t, c.LoadAlloySource(args, "a"))
+	require.Equal(t, "a", mod.Content())
+
+	// Disabling the module tears it down.
+	require.NoError(t, c.SetEnabled(false))
+	require.Equal(t, "", mod.Content())
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.Equal(t, "module disabled", c.CurrentHealth().Message)
+
+	// Content loaded while the module is disabled isn't loaded.
+	require.NoError(t, c.LoadAlloySource(args, "b"))
+	require.NoError(t, c.SetEnabled(false))
+	require.Equal(t, "", mod.Content())
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.Equal(t, "module disabled", c.CurrentHealth().Message)
+
+	// Enabling the module loads the latest content.
+	require.NoError(t, c.SetEnabled(true))
+	require.Equal(t, "b", mod.Content())
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.NotEqual(t, "module disabled", c.CurrentHealth().Message)
+	require.Equal(t, []string{"a", "", "b"}, mod.Loads())
+
+	// Toggling the module again restores the same content.
+	require.NoError(t, c.SetEnabled(false))
+	require.NoError(t, c.SetEnabled(true))
+	require.Equal(t, "b", mod.Content())
+	require.Equal(t, []string{"a", "", "b", "", "b"}, mod.Loads())
+}
+
+func TestModuleSet(t *testing.T) {
+	controller := &fakeSetController{}
+	var (
+		mut       sync.Mutex
+		lastState SetExports
+	)
+	reg := prometheus.NewRegistry()
+	s, err := NewModuleSet(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       reg,
+		ModuleController: controller,
+		OnStateChange: func(e component.Exports) {
+			mut.Lock()
+			defer mut.Unlock()
+			lastState = e.(SetExports)
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		s.RunAlloyController(ctx)
+	}()
+
+	const (
+		contentA = `export "value" { value = "a" }`
+		contentB = `export "value" { value = "b" }`
+		contentC = `export "value" { value = "c" }`
+	)
+	controller.setFailing("c")
+	require.NoError(t, s.LoadAlloySource("a", nil, contentA))
+	require.NoError(t, s.LoadAlloySource("b", nil, contentB))
+	require.Error(t, s.LoadAlloySource("c", nil, contentC))
+	require.ErrorContains(t, s.LoadAlloySource("not valid", nil, contentA), "not a valid identifier")
+	require.Equal(t, []string{"a", "b", "c"}, s.Names())
+
+	require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth("a").Health)
+	require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth("b").Health)
+	require.Equal(t, component.HealthTypeUnhealthy, s.ModuleHealth("c").Health)
+	require.Equal(t, component.HealthTypeUnhealthy, s.CurrentHealth().Health)
+	// The shared module is loaded back without the failed module.
+	require.Equal(t, contentA, controller.content("a"))
+	require.Equal(t, contentB, controller.content("b"))
+	require.Empty(t, controller.content("c"))
+
+	controller.export("a", map[string]any{"value": 1})
+	controller.export("b", map[string]any{"value": 2})
+	require.Equal(t, map[string]any{"value": 1}, s.Exports("a"))
+	require.Equal(t, map[string]any{"value": 2}, s.Exports("b"))
+	require.Nil(t, s.Exports("c"))
+	mut.Lock()
+	require.Equal(t, SetExports{Modules: map[string]map[string]any{
+		"a": {"value": 1},
+		"b": {"value": 2},
+	}}, lastState)
+	mut.Unlock()
+
+	// Fixing the failed module doesn't affect the others.
+	controller.setFailing("")
+	require.NoError(t, s.LoadAlloySource("c", nil, contentC))
+	require.Equal(t, component.HealthTypeHealthy, s.CurrentHealth().Health)
+	require.Equal(t, []string{"a", "b", "c"}, controller.loadedModules())
+	require.Equal(t, map[string]any{"value": 1}, s.Exports("a"))
+
+	require.Equal(t, 1.0, testutil.ToFloat64(s.Module("c").reloadFailures))
+	require.Equal(t, 0.0, testutil.ToFloat64(s.Module("a").reloadFailures))
+	count, err := testutil.GatherAndCount(reg, "module_reloads_total")
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	// All the modules are run by a single shared module.
+	require.Eventually(t, func() bool {
+		return controller.running.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Removing a module removes it from the shared module, along with its
+	// exports and metrics.
+	s.Remove("b")
+	require.Equal(t, []string{"a", "c"}, s.Names())
+	require.Empty(t, controller.content("b"))
+	require.Equal(t, contentA, controller.content("a"))
+	require.Nil(t, s.Exports("b"))
+	mut.Lock()
+	require.Equal(t, SetExports{Modules: map[string]map[string]any{"a": {"value": 1}}}, lastState)
+	mut.Unlock()
+	count, err = testutil.GatherAndCount(reg, "module_reloads_total")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	s.Remove("b")
+
+	cancel()
+	<-runDone
+	require.Equal(t, int32(0), controller.running.Load())
+}
+
+// fakeSetController is a component.ModuleController creating the shared
+// module of a set, which records the content and arguments of each module of
+// the set found in the content it's loaded with.
+type fakeSetController struct {
+	mut     sync.Mutex
+	exports component.ExportFunc
+	// modules holds the source of each module of the latest content loaded,
+	// and latestExports the exports of each module, prefixed with its name.
+	modules       map[string]moduleSource
+	latestExports map[string]any
+	// loads holds the names of the modules in the order their source changed,
+	// and onLoad is called after each change if set.
+	loads  []string
+	onLoad func(name string, args map[string]any)
+	// failing is the name of a module whose new content fails the load.
+	failing string
+
+	running atomic.Int32
+}
+
+func (f *fakeSetController) NewModule(_ string, exports component.ExportFunc) (component.Module, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.exports = exports
+	return &fakeSharedModule{controller: f}, nil
+}
+
+func (f *fakeSetController) setFailing(name string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.failing = name
+}
+
+func (f *fakeSetController) content(name string) string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.modules[name].content
+}
+
+func (f *fakeSetController) loadedModules() []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return slices.Clone(f.loads)
+}
+
+// export sets the exports of the module name, and sends the exports of all
+// the modules.
+func (f *fakeSetController) export(name string, exports map[string]any) {
+	f.mut.Lock()
+	if f.latestExports == nil {
+		f.latestExports = make(map[string]any)
+	}
+	maps.DeleteFunc(f.latestExports, func(key string, _ any) bool {
+		return strings.HasPrefix(key, name+"__")
+	})
+	for key, value := range exports {
+		f.latestExports[name+"__"+key] = value
+	}
+	latest := maps.Clone(f.latestExports)
+	export := f.exports
+	f.mut.Unlock()
+	export(latest)
+}
+
+// fakeSharedModule is the shared module of a fakeSetController.
+type fakeSharedModule struct {
+	controller *fakeSetController
+}
+
+func (m *fakeSharedModule) LoadConfig(config []byte, args map[string]any) error {
+	file, err := parser.ParseFile("", config)
+	if err != nil {
+		return err
+	}
+	modules := make(map[string]moduleSource)
+	for _, stmt := range file.Body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok || block.GetBlockName() != "declare" {
+			continue
+		}
+		body := config[block.LCurlyPos.Offset()+1 : block.RCurlyPos.Offset()]
+		modules[strings.TrimPrefix(block.Label, "module_")] = moduleSource{
+			content: strings.TrimSpace(string(body)),
+			args:    make(map[string]any),
+		}
+	}
+	for key, value := range args {
+		name, arg, _ := strings.Cut(key, "__")
+		modules[name].args[arg] = value
+	}
+
+	f := m.controller
+	f.mut.Lock()
+	if failing, ok := modules[f.failing]; ok && failing.content != f.modules[f.failing].content {
+		f.mut.Unlock()
+		return errors.New("failed to load")
+	}
+	var changed []string
+	for _, name := range slices.Sorted(maps.Keys(modules)) {
+		prev, ok := f.modules[name]
+		if !ok || prev.content != modules[name].content || !reflect.DeepEqual(prev.args, modules[name].args) {
+			changed = append(changed, name)
+		}
+	}
+	f.modules = modules
+	f.loads = append(f.loads, changed...)
+	onLoad := f.onLoad
+	f.mut.Unlock()
+
+	if onLoad != nil {
+		for _, name := range changed {
+			onLoad(name, modules[name].args)
+		}
+	}
+	return nil
+}
+
+func (m *fakeSharedModule) Run(ctx context.Context) error {
+	m.controller.running.Add(1)
+	defer m.controller.running.Add(-1)
+	<-ctx.Done()
+	return nil
+}
+
+func TestModuleSet_LoadAll(t *testing.T) {
+	controller := &fakeSetController{}
+	var argsB map[string]any
+	controller.onLoad = func(name string, args map[string]any) {
+		switch name {
+		case "a":
+			controller.export("a", map[string]any{"address": "localhost:9090"})
+		case "b":
+			argsB = args
+		}
+	}
+	s, err := NewModuleSet(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange:    func(component.Exports) {},
+	})
+	require.NoError(t, err)
+
+	const contentA = `export "address" { value = "" }`
+
+	// b sorts before c but imports the export of c, which imports the
+	// export of a.
+	require.NoError(t, s.LoadAll(map[string]ModuleSource{
+		"b": {
+			Content: "// content b",
+			Args:    map[string]any{"port": 8080},
+			Imports: map[string]string{"target": "c.address"},
+		},
+		"c": {
+			Content: "// content c",
+			Imports: map[string]string{"upstream": "a.address"},
+		},
+		"a": {Content: contentA},
+	}))
+	require.Equal(t, []string{"a", "c", "b"}, controller.loadedModules())
+	// c doesn't export anything, so the imported argument of b is unset.
+	require.Equal(t, map[string]any{"port": 8080}, argsB)
+
+	require.NoError(t, s.LoadAll(map[string]ModuleSource{
+		"b": {Content: "// content b2", Imports: map[string]string{"target": "a.address"}},
+		"a": {Content: contentA},
+	}))
+	// a is already loaded with the same content, so it isn't loaded again.
+	require.Equal(t, []string{"a", "c", "b", "b"}, controller.loadedModules())
+	require.Equal(t, map[string]any{"target": "localhost:9090"}, argsB)
+
+	// Cycles and unknown modules are rejected without loading anything.
+	err = s.LoadAll(map[string]ModuleSource{
+		"a": {Content: "// content a2", Imports: map[string]string{"x": "c.y"}},
+		"b": {Content: "// content b3"},
+		"c": {Content: "// content c2", Imports: map[string]string{"x": "a.y"}},
+	})
+	require.ErrorIs(t, err, ErrDependencyCycle)
+	require.ErrorContains(t, err, "a, c")
+	require.ErrorContains(t, s.LoadAll(map[string]ModuleSource{
+		"a": {Content: "// content a2", Imports: map[string]string{"x": "d.y"}},
+	}), "unknown module d")
+	require.Len(t, controller.loadedModules(), 4)
+}
+
+func TestModuleSet_ReloadAll(t *testing.T) {
+	controller := &fakeSetController{}
+	s, err := NewModuleSet(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: controller,
+		OnStateChange:    func(component.Exports) {},
+	})
+	require.NoError(t, err)
+
+	v1 := map[string]string{
+		"a": `module_test.stable "a" {}`,
+		"b": `module_test.stable "b" {}`,
+		"c": `module_test.stable "c" {}`,
+	}
+	require.NoError(t, s.ReloadAll(v1, map[string]map[string]any{"a": {"port": 8080}}))
+	for name, content := range v1 {
+		require.Equal(t, content, controller.content(name))
+	}
+	require.Equal(t, []string{"a", "b", "c"}, controller.loadedModules())
+
+	// One of the new configs doesn't parse, so none of them is loaded.
+	err = s.ReloadAll(map[string]string{
+		"a": `module_test.stable "a2" {}`,
+		"b": `module_test.stable "b2" {`,
+		"c": `module_test.stable "c2" {}`,
+	}, nil)
+	require.ErrorContains(t, err, "module b")
+	for name, content := range v1 {
+		require.Equal(t, content, controller.content(name))
+		require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth(name).Health)
+	}
+	require.Equal(t, []string{"a", "b", "c"}, controller.loadedModules())
+}
+
+func TestModuleSet_ReloadAllFailedLoad(t *testing.T) {
+	controller := &fakeSetController{}
+	reg := prometheus.NewRegistry()
+	s, err := NewModuleSet(component.Options{
+		ID:               "module.test",
+		Logger:           log.NewNopLogger(),
+		Registerer:       reg,
+		ModuleController: controller,
+		OnStateChange:    func(component.Exports) {},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go s.RunAlloyController(ctx)
+
+	const v1 = `module_test.stable "a" {}`
+	require.NoError(t, s.ReloadAll(map[string]string{"a": v1}, nil))
+
+	// The new configs are valid, but the second module fails to load: the
+	// first one is rolled back, and the one created by the reload removed.
+	controller.setFailing("b")
+	err = s.ReloadAll(map[string]string{
+		"a":  `module_test.stable "a2" {}`,
+		"aa": `module_test.stable "aa" {}`,
+		"b":  `module_test.stable "b" {}`,
+	}, nil)
+	require.ErrorContains(t, err, "failed to load module b")
+	require.Equal(t, v1, controller.content("a"))
+	require.Equal(t, []string{"a"}, s.Names())
+	require.Equal(t, []string{"a", "a", "aa", "a"}, controller.loadedModules())
+	require.Eventually(t, func() bool { return controller.running.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// The removed modules can be created again.
+	controller.setFailing("")
+	require.NoError(t, s.ReloadAll(map[string]string{"aa": `module_test.stable "aa" {}`, "b": `module_test.stable "b" {}`}, nil))
+	require.Equal(t, []string{"a", "aa", "b"}, s.Names())
+
+	// An existing module failing to load is rolled back to its previous
+	// content and health along with the others.
+	controller.setFailing("b")
+	err = s.ReloadAll(map[string]string{
+		"a": `module_test.stable "a2" {}`,
+		"b": `module_test.stable "b2" {}`,
+	}, nil)
+	require.ErrorContains(t, err, "failed to load module b")
+	require.Equal(t, v1, controller.content("a"))
+	require.Equal(t, `module_test.stable "b" {}`, s.Module("b").getLatestContent())
+	for _, name := range []string{"a", "aa", "b"} {
+		require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth(name).Health, name)
+	}
+	require.Equal(t, component.HealthTypeHealthy, s.CurrentHealth().Health)
+}
@@ -0,0 +1,404 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/runtime/logging/level"
+	"github.com/grafana/alloy/syntax/parser"
+	"github.com/grafana/alloy/syntax/scanner"
+)
+
+// ModuleSet manages several named modules under a single module, shared by
+// all of them and run by a single call to RunAlloyController. Each module is
+// loaded, reports its health, and exports values independently of the
+// others: its content runs in a namespace of its own within the shared
+// module, and a failed load only affects the health of the module loaded.
+//
+// Loads of the modules of a set are serialized, since each of them loads the
+// shared module.
+type ModuleSet struct {
+	opts   component.Options
+	shared *sharedModule
+
+	mut     sync.Mutex
+	modules map[string]*ModuleComponent
+
+	exportMut sync.Mutex
+	exports   map[string]map[string]any
+}
+
+// SetExports holds values which are exported from the modules of a set.
+type SetExports struct {
+	// Exports exported from each module, by module name.
+	Modules map[string]map[string]any `alloy:"modules,attr"`
+}
+
+// ErrDependencyCycle is returned by LoadAll when modules import exports of
+// each other in a cycle.
+var ErrDependencyCycle = errors.New("modules import each other's exports in a cycle")
+
+// ModuleSource is the source of a module of a set loaded by LoadAll.
+type ModuleSource struct {
+	Args    map[string]any
+	Content string
+	// Imports sets arguments of the module to exports of other modules of
+	// the set, by argument name. Exports are referenced as
+	// "<module>.<export>". Imported arguments override the ones in Args, and
+	// are left unset while the export doesn't exist.
+	Imports map[string]string
+}
+
+// NewModuleSet initializes a new, empty ModuleSet, creating its shared
+// module with o.ModuleController. Modules are created by LoadAlloySource.
+// Their metrics are registered against o.Registerer, if it's set, with a
+// module label holding their name.
+func NewModuleSet(o component.Options) (*ModuleSet, error) {
+	shared, err := newSharedModule(o)
+	if err != nil {
+		return nil, err
+	}
+	return &ModuleSet{
+		opts:    o,
+		shared:  shared,
+		modules: make(map[string]*ModuleComponent),
+		exports: make(map[string]map[string]any),
+	}, nil
+}
+
+// LoadAlloySource loads the module name with the given source, creating the
+// module if it doesn't exist. It behaves like [ModuleComponent.LoadAlloySource]
+// and only affects the health of the module name.
+func (s *ModuleSet) LoadAlloySource(name string, args map[string]any, contentValue string) error {
+	m, _, err := s.getOrCreate(name)
+	if err != nil {
+		return err
+	}
+	return m.LoadAlloySource(args, contentValue)
+}
+
+// LoadAll loads the modules of sources, creating the modules which don't
+// exist. Modules are loaded after the modules whose exports they import, so
+// that they don't start with missing arguments. Nothing is loaded if the
+// imports of the modules form a cycle, in which case an error wrapping
+// ErrDependencyCycle is returned, or if they import a module which isn't in
+// sources. Loading stops at the first module which fails to load.
+func (s *ModuleSet) LoadAll(sources map[string]ModuleSource) error {
+	order, err := loadOrder(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range order {
+		source := sources[name]
+		args := make(map[string]any, len(source.Args)+len(source.Imports))
+		maps.Copy(args, source.Args)
+		for arg, ref := range source.Imports {
+			module, export, _ := strings.Cut(ref, ".")
+			if value, ok := s.Exports(module)[export]; ok {
+				args[arg] = value
+			}
+		}
+		if err := s.LoadAlloySource(name, args, source.Content); err != nil {
+			return fmt.Errorf("failed to load module %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ReloadAll loads the content of configs into the modules of the same name,
+// creating the modules which don't exist, with the arguments of args by
+// module name. Either all the modules are loaded or none is: the content of
+// every module is validated before loading any, and if a module fails to load
+// anyway, the modules loaded up to it are rolled back to their previous
+// content, arguments and health. Modules which didn't exist before are
+// removed.
+func (s *ModuleSet) ReloadAll(configs map[string]string, args map[string]map[string]any) error {
+	names := slices.Sorted(maps.Keys(configs))
+
+	var errs []error
+	for _, name := range names {
+		if err := s.validate(name, configs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("module %s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("no module was reloaded: %w", errors.Join(errs...))
+	}
+
+	var applied []rollbackSource
+	for _, name := range names {
+		m, created, err := s.getOrCreate(name)
+		if err != nil {
+			err = fmt.Errorf("failed to create module %s: %w", name, err)
+			return errors.Join(err, s.rollback(applied))
+		}
+
+		prev := rollbackSource{name: name, module: m, created: created, args: map[string]any{}, health: m.CurrentHealth()}
+		if prevArgs := m.getLatestArgs(); prevArgs != nil {
+			prev.args = prevArgs
+			prev.content = m.getLatestContent()
+		}
+		applied = append(applied, prev)
+
+		moduleArgs := args[name]
+		if moduleArgs == nil {
+			moduleArgs = map[string]any{}
+		}
+		if err := m.LoadAlloySource(moduleArgs, configs[name]); err != nil {
+			err = fmt.Errorf("failed to load module %s: %w", name, err)
+			return errors.Join(err, s.rollback(applied))
+		}
+	}
+	return nil
+}
+
+// validate checks content can be loaded into the module name without
+// loading it: it must parse, and abide by the stability policy of the module.
+// component.Module has no way to validate content without loading it, so
+// content which fails to evaluate is only caught when it's loaded, and rolled
+// back by ReloadAll.
+func (s *ModuleSet) validate(name string, content string) error {
+	if _, err := parser.ParseFile(name, []byte(content)); err != nil {
+		return err
+	}
+	if m := s.Module(name); m != nil {
+		return checkStability(content, m.getMinStability())
+	}
+	return nil
+}
+
+// rollbackSource is the source a module was running before ReloadAll along
+// with its health, or whether it was created by ReloadAll.
+type rollbackSource struct {
+	name    string
+	module  *ModuleComponent
+	created bool
+	args    map[string]any
+	content string
+	health  component.Health
+}
+
+// rollback loads back the previous sources of the modules of applied, in
+// reverse order, and removes the modules created by ReloadAll. A module whose
+// load failed still holds its previous source, so loading it back is a no-op:
+// its previous health is restored too so it doesn't keep reporting the
+// failure.
+func (s *ModuleSet) rollback(applied []rollbackSource) error {
+	var errs []error
+	for _, prev := range slices.Backward(applied) {
+		if prev.created {
+			s.Remove(prev.name)
+			continue
+		}
+		if err := prev.module.LoadAlloySource(prev.args, prev.content); err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back module %s: %w", prev.name, err))
+			continue
+		}
+		prev.module.setHealth(prev.health)
+	}
+	return errors.Join(errs...)
+}
+
+// loadOrder returns the names of the modules of sources sorted so that
+// modules come after the modules they import. Independent modules are sorted
+// by name.
+func loadOrder(sources map[string]ModuleSource) ([]string, error) {
+	// dependents holds the modules importing each module, and pending the
+	// number of modules each module imports which aren't ordered yet.
+	dependents := make(map[string][]string, len(sources))
+	pending := make(map[string]int, len(sources))
+	for name, source := range sources {
+		deps := make(map[string]struct{})
+		for arg, ref := range source.Imports {
+			module, export, ok := strings.Cut(ref, ".")
+			if !ok || export == "" {
+				return nil, fmt.Errorf("module %s imports invalid export %q for argument %s", name, ref, arg)
+			}
+			if _, ok := sources[module]; !ok {
+				return nil, fmt.Errorf("module %s imports export %q of unknown module %s", name, ref, module)
+			}
+			deps[module] = struct{}{}
+		}
+		for module := range deps {
+			dependents[module] = append(dependents[module], name)
+		}
+		pending[name] = len(deps)
+	}
+
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	order := make([]string, 0, len(sources))
+	for len(ready) > 0 {
+		slices.Sort(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		delete(pending, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		cycle := slices.Sorted(maps.Keys(pending))
+		return nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+// getOrCreate returns the module name, creating it if it doesn't exist, and
+// whether it was created.
+func (s *ModuleSet) getOrCreate(name string) (*ModuleComponent, bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if m, ok := s.modules[name]; ok {
+		return m, false, nil
+	}
+
+	if !scanner.IsValidIdentifier(name) {
+		return nil, false, fmt.Errorf("module name %q is not a valid identifier", name)
+	}
+
+	o := s.opts
+	if s.opts.Registerer != nil {
+		o.Registerer = prometheus.WrapRegistererWith(prometheus.Labels{"module": name}, s.opts.Registerer)
+	}
+	m, err := newModuleComponent(o, func(export component.ExportFunc) (component.Module, error) {
+		return s.shared.register(name, export), nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	m.SetExportHandler(func(exports map[string]any) {
+		s.onExportsChange(name, exports)
+	})
+
+	s.modules[name] = m
+	return m, true, nil
+}
+
+// Remove removes the module name from the shared module, along with its
+// exports and metrics, so that a module of the same name can be created
+// again. Removing a module which doesn't exist is a no-op.
+func (s *ModuleSet) Remove(name string) {
+	s.mut.Lock()
+	m, ok := s.modules[name]
+	if !ok {
+		s.mut.Unlock()
+		return
+	}
+	delete(s.modules, name)
+	s.mut.Unlock()
+
+	// Exports sent by the module while it's removed are discarded.
+	m.SetExportHandler(func(map[string]any) {})
+	if err := s.shared.unregister(name); err != nil {
+		level.Error(s.opts.Logger).Log("msg", "failed to remove module from the set", "id", s.opts.ID, "module", name, "err", err)
+	}
+	m.stopAllExpiries()
+	m.unregisterMetrics()
+
+	s.exportMut.Lock()
+	defer s.exportMut.Unlock()
+	if _, ok := s.exports[name]; ok {
+		delete(s.exports, name)
+		if s.opts.OnStateChange != nil {
+			s.opts.OnStateChange(SetExports{Modules: maps.Clone(s.exports)})
+		}
+	}
+}
+
+// onExportsChange records the new exports of the module name and sends the
+// exports of all the modules to OnStateChange.
+func (s *ModuleSet) onExportsChange(name string, exports map[string]any) {
+	s.exportMut.Lock()
+	defer s.exportMut.Unlock()
+
+	s.exports[name] = exports
+	if s.opts.OnStateChange != nil {
+		s.opts.OnStateChange(SetExports{Modules: maps.Clone(s.exports)})
+	}
+}
+
+// Exports returns the latest exports of the module name, or nil if it
+// didn't export any value.
+func (s *ModuleSet) Exports(name string) map[string]any {
+	s.exportMut.Lock()
+	defer s.exportMut.Unlock()
+	return s.exports[name]
+}
+
+// Names returns the sorted names of the modules of the set.
+func (s *ModuleSet) Names() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return slices.Sorted(maps.Keys(s.modules))
+}
+
+// Module returns the module name, or nil if it doesn't exist. It gives
+// access to the per-module settings of ModuleComponent, such as
+// SetMaxLoadDuration.
+func (s *ModuleSet) Module(name string) *ModuleComponent {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.modules[name]
+}
+
+// RunAlloyController runs the shared module, and so all the modules of the
+// set, including the ones created while it's running, until ctx is canceled.
+func (s *ModuleSet) RunAlloyController(ctx context.Context) {
+	err := s.shared.Run(ctx)
+	if err != nil {
+		level.Error(s.opts.Logger).Log("msg", "error running module set", "id", s.opts.ID, "err", err)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, m := range s.modules {
+		m.stopAllExpiries()
+	}
+}
+
+// ModuleHealth returns the health of the module name. Modules which don't
+// exist have an unknown health.
+func (s *ModuleSet) ModuleHealth(name string) component.Health {
+	m := s.Module(name)
+	if m == nil {
+		return component.Health{}
+	}
+	return m.CurrentHealth()
+}
+
+// CurrentHealth returns the least healthy health of the modules of the set.
+func (s *ModuleSet) CurrentHealth() component.Health {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var hh []component.Health
+	for _, m := range s.modules {
+		hh = append(hh, m.CurrentHealth())
+	}
+	if len(hh) == 0 {
+		return component.Health{}
+	}
+	return component.LeastHealthy(hh[0], hh[1:]...)
+}
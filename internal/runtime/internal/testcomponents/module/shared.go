@@ -0,0 +1,298 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/runtime/equality"
+	"github.com/grafana/alloy/internal/runtime/logging/level"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+)
+
+// sharedModule is the single module of a ModuleSet, running the content of
+// all the modules of the set.
+//
+// The content of each module is wrapped in a declare block of its own, which
+// gives it its own namespace, and is instantiated with the arguments of the
+// module. Arguments are passed to the shared module prefixed with the module
+// name, and each export of a module is exported by the shared module under a
+// name prefixed the same way, so that it can be routed back to the module.
+type sharedModule struct {
+	opts component.Options
+	mod  component.Module
+
+	// loadMut serializes loads of the shared module. sources holds the
+	// content and arguments each module is running, by module name.
+	loadMut sync.Mutex
+	sources map[string]moduleSource
+
+	// exportMut guards the routing of the exports of the shared module.
+	// exportKeys maps each export of the shared module to the module and the
+	// export it was exported from, handlers holds the export function of each
+	// module, and exports the latest exports sent to each module.
+	exportMut  sync.Mutex
+	exportKeys map[string]moduleExport
+	handlers   map[string]component.ExportFunc
+	exports    map[string]map[string]any
+	// loading is the module being loaded, whose exports are held in pending
+	// until its load succeeds, so that a failed load doesn't export anything.
+	loading string
+	pending map[string]any
+}
+
+// moduleExport is an export of a module of a set.
+type moduleExport struct {
+	module string
+	export string
+}
+
+// newSharedModule creates the shared module of a set with the module
+// controller of o.
+func newSharedModule(o component.Options) (*sharedModule, error) {
+	s := &sharedModule{
+		opts:       o,
+		sources:    make(map[string]moduleSource),
+		exportKeys: make(map[string]moduleExport),
+		handlers:   make(map[string]component.ExportFunc),
+		exports:    make(map[string]map[string]any),
+	}
+	var err error
+	s.mod, err = o.ModuleController.NewModule("", s.onExportsChange)
+	return s, err
+}
+
+// register returns the view of the shared module for the module name, whose
+// exports are sent to export.
+func (s *sharedModule) register(name string, export component.ExportFunc) component.Module {
+	s.exportMut.Lock()
+	defer s.exportMut.Unlock()
+	s.handlers[name] = export
+	return &moduleView{shared: s, name: name}
+}
+
+// unregister removes the module name from the shared module. Later loads of
+// the module fail.
+func (s *sharedModule) unregister(name string) error {
+	s.exportMut.Lock()
+	delete(s.handlers, name)
+	delete(s.exports, name)
+	s.exportMut.Unlock()
+
+	s.loadMut.Lock()
+	defer s.loadMut.Unlock()
+	if _, ok := s.sources[name]; !ok {
+		return nil
+	}
+	return s.apply(name, nil)
+}
+
+// load loads config into the module name with args, and tears the module
+// down if config is empty. If the shared module fails to load, it's loaded
+// back with the previous content of the module, so that the other modules
+// keep running.
+func (s *sharedModule) load(name string, config []byte, args map[string]any) error {
+	s.exportMut.Lock()
+	_, ok := s.handlers[name]
+	s.exportMut.Unlock()
+	if !ok {
+		return fmt.Errorf("module %s was removed from the set", name)
+	}
+
+	s.loadMut.Lock()
+	defer s.loadMut.Unlock()
+	if len(config) == 0 {
+		return s.apply(name, nil)
+	}
+	return s.apply(name, &moduleSource{args: args, content: string(config)})
+}
+
+// apply sets the source of the module name, removing the module if source is
+// nil, and loads the shared module. s.loadMut must be held by the caller.
+func (s *sharedModule) apply(name string, source *moduleSource) error {
+	prev, hadPrev := s.sources[name]
+	if source == nil {
+		delete(s.sources, name)
+	} else {
+		s.sources[name] = *source
+	}
+
+	s.exportMut.Lock()
+	s.loading, s.pending = name, nil
+	s.exportMut.Unlock()
+
+	err := s.loadSources()
+
+	s.exportMut.Lock()
+	if err == nil && s.pending != nil {
+		s.send(name, s.pending)
+	}
+	s.loading, s.pending = "", nil
+	s.exportMut.Unlock()
+	if err == nil {
+		return nil
+	}
+
+	if hadPrev {
+		s.sources[name] = prev
+	} else {
+		delete(s.sources, name)
+	}
+	if restoreErr := s.loadSources(); restoreErr != nil {
+		level.Error(s.opts.Logger).Log("msg", "failed to restore module set after a failed load", "id", s.opts.ID, "module", name, "err", restoreErr)
+	}
+	return err
+}
+
+// loadSources loads the sources of all the modules into the shared module.
+// s.loadMut must be held by the caller.
+func (s *sharedModule) loadSources() error {
+	config, args, exportKeys, err := buildSharedConfig(s.sources)
+	if err != nil {
+		return err
+	}
+
+	// The exports of the new content are routed as soon as it's loaded.
+	s.exportMut.Lock()
+	prevKeys := s.exportKeys
+	s.exportKeys = exportKeys
+	s.exportMut.Unlock()
+
+	if err := s.mod.LoadConfig([]byte(config), args); err != nil {
+		s.exportMut.Lock()
+		s.exportKeys = prevKeys
+		s.exportMut.Unlock()
+		return err
+	}
+	return nil
+}
+
+// buildSharedConfig returns the content of the shared module running
+// sources, the arguments to load it with, and the module and export of each
+// of its exports.
+func buildSharedConfig(sources map[string]moduleSource) (string, map[string]any, map[string]moduleExport, error) {
+	var (
+		b          strings.Builder
+		args       = make(map[string]any)
+		exportKeys = make(map[string]moduleExport)
+	)
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		source := sources[name]
+		f, err := parser.ParseFile(name, []byte(source.content))
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		declared := "module_" + name
+		fmt.Fprintf(&b, "declare %q {\n%s\n}\n", declared, source.content)
+
+		argNames := slices.Sorted(maps.Keys(source.args))
+		for _, arg := range argNames {
+			key := name + "__" + arg
+			if _, ok := args[key]; ok {
+				return "", nil, nil, fmt.Errorf("argument %s of module %s conflicts with another module", arg, name)
+			}
+			args[key] = source.args[arg]
+			fmt.Fprintf(&b, "argument %q {}\n", key)
+		}
+		fmt.Fprintf(&b, "%s \"default\" {\n", declared)
+		for _, arg := range argNames {
+			fmt.Fprintf(&b, "\t%s = argument.%s__%s.value\n", arg, name, arg)
+		}
+		b.WriteString("}\n")
+
+		for _, stmt := range f.Body {
+			block, ok := stmt.(*ast.BlockStmt)
+			if !ok || block.GetBlockName() != "export" {
+				continue
+			}
+			key := name + "__" + block.Label
+			if _, ok := exportKeys[key]; ok {
+				return "", nil, nil, fmt.Errorf("export %s of module %s conflicts with another module", block.Label, name)
+			}
+			exportKeys[key] = moduleExport{module: name, export: block.Label}
+			fmt.Fprintf(&b, "export %q {\n\tvalue = %s.default.%s\n}\n", key, declared, block.Label)
+		}
+	}
+	return b.String(), args, exportKeys, nil
+}
+
+// onExportsChange splits the exports of the shared module by module, and
+// sends the new exports of each module which changed to the module.
+func (s *sharedModule) onExportsChange(exports map[string]any) {
+	s.exportMut.Lock()
+	defer s.exportMut.Unlock()
+
+	byModule := make(map[string]map[string]any)
+	for key, value := range exports {
+		e, ok := s.exportKeys[key]
+		if !ok {
+			continue
+		}
+		if byModule[e.module] == nil {
+			byModule[e.module] = make(map[string]any)
+		}
+		byModule[e.module][e.export] = value
+	}
+
+	for name := range s.handlers {
+		moduleExports, ok := byModule[name]
+		if !ok {
+			if _, hadPrev := s.exports[name]; !hadPrev {
+				continue
+			}
+			moduleExports = map[string]any{}
+		}
+		if name == s.loading {
+			s.pending = moduleExports
+			continue
+		}
+		s.send(name, moduleExports)
+	}
+}
+
+// send sends exports to the module name if they changed. s.exportMut must be
+// held by the caller.
+func (s *sharedModule) send(name string, exports map[string]any) {
+	handler, ok := s.handlers[name]
+	if !ok {
+		return
+	}
+	if prev, ok := s.exports[name]; ok && equality.DeepEqual(prev, exports) {
+		return
+	}
+	s.exports[name] = exports
+	handler(exports)
+}
+
+// Run runs the shared module until ctx is canceled.
+func (s *sharedModule) Run(ctx context.Context) error {
+	return s.mod.Run(ctx)
+}
+
+// moduleView is the component.Module of a module of a set, loading its
+// content into the shared module of the set.
+type moduleView struct {
+	shared *sharedModule
+	name   string
+}
+
+var _ component.Module = (*moduleView)(nil)
+
+// LoadConfig loads config into the shared module as the content of the
+// module.
+func (v *moduleView) LoadConfig(config []byte, args map[string]any) error {
+	return v.shared.load(v.name, config, args)
+}
+
+// Run blocks until ctx is canceled. The content of the module is run by the
+// shared module.
+func (v *moduleView) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
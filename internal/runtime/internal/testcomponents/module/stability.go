@@ -0,0 +1,57 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/featuregate"
+	"github.com/grafana/alloy/syntax/ast"
+	"github.com/grafana/alloy/syntax/parser"
+)
+
+// checkStability returns an error for every component used by content whose
+// stability level is below minStability, including components of custom
+// components declared by content. Community components aren't subject to
+// stability levels. No error is returned if minStability is undefined.
+func checkStability(content string, minStability featuregate.Stability) error {
+	if minStability == featuregate.StabilityUndefined {
+		return nil
+	}
+
+	f, err := parser.ParseFile("", []byte(content))
+	if err != nil {
+		// The module reports invalid content when loading it.
+		return nil
+	}
+
+	var errs []error
+	checkBodyStability(f.Body, minStability, &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("module content rejected by the stability policy: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func checkBodyStability(body ast.Body, minStability featuregate.Stability, errs *[]error) {
+	for _, stmt := range body {
+		block, ok := stmt.(*ast.BlockStmt)
+		if !ok {
+			continue
+		}
+
+		name := block.GetBlockName()
+		if name == "declare" {
+			checkBodyStability(block.Body, minStability, errs)
+			continue
+		}
+
+		reg, ok := component.Get(name)
+		if !ok || reg.Community {
+			continue
+		}
+		if reg.Stability < minStability {
+			*errs = append(*errs, fmt.Errorf("component %q is at stability level %s, which is below the minimum allowed stability level %s", name, reg.Stability, minStability))
+		}
+	}
+}
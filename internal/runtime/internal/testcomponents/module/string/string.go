@@ -30,6 +30,14 @@ type Arguments struct {
 
 	// Arguments to pass into the module.
 	Arguments map[string]any `alloy:"arguments,block,optional"`
+
+	// Enabled toggles the module. A disabled module isn't loaded.
+	Enabled bool `alloy:"enabled,attr,optional"`
+}
+
+// SetToDefault implements syntax.Defaulter.
+func (a *Arguments) SetToDefault() {
+	*a = Arguments{Enabled: true}
 }
 
 // Component implements the module.string component.
@@ -68,7 +76,18 @@ func (c *Component) Run(ctx context.Context) error {
 func (c *Component) Update(args component.Arguments) error {
 	newArgs := args.(Arguments)
 
-	return c.mod.LoadAlloySource(newArgs.Arguments, newArgs.Content.Value)
+	// Content loaded into a disabled module is only loaded once the module is
+	// enabled, so the module is disabled before loading the content, and
+	// enabled after.
+	if !newArgs.Enabled {
+		if err := c.mod.SetEnabled(false); err != nil {
+			return err
+		}
+	}
+	if err := c.mod.LoadAlloySource(newArgs.Arguments, newArgs.Content.Value); err != nil {
+		return err
+	}
+	return c.mod.SetEnabled(newArgs.Enabled)
 }
 
 // CurrentHealth implements component.HealthComponent.
@@ -0,0 +1,123 @@
+package module
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChildTask describes a background task started by a child of the module
+// with StartChildTask.
+type ChildTask struct {
+	Name    string
+	Started time.Time
+}
+
+// childTasks tracks the running background tasks of the module children.
+type childTasks struct {
+	mut    sync.Mutex
+	nextID uint64
+	tasks  map[uint64]*childTask
+}
+
+type childTask struct {
+	info   ChildTask
+	fn     func(ctx context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartChildTask runs fn in the background on behalf of a child of the
+// module, until it returns. The context given to fn is canceled by
+// CancelChildren, which runs before each load of new module content, so
+// tasks must return promptly once it's done. If that load fails or is rolled
+// back, the children keep running with the previous content, so fn is
+// called again with a new context.
+func (c *ModuleComponent) StartChildTask(name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &childTask{
+		info:   ChildTask{Name: name, Started: time.Now()},
+		fn:     fn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	c.tasks.mut.Lock()
+	if c.tasks.tasks == nil {
+		c.tasks.tasks = make(map[uint64]*childTask)
+	}
+	id := c.tasks.nextID
+	c.tasks.nextID++
+	c.tasks.tasks[id] = task
+	c.tasks.mut.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			c.tasks.mut.Lock()
+			delete(c.tasks.tasks, id)
+			c.tasks.mut.Unlock()
+			close(task.done)
+		}()
+		fn(ctx)
+	}()
+}
+
+// ChildTasks returns the running background tasks of the module children,
+// sorted by start time.
+func (c *ModuleComponent) ChildTasks() []ChildTask {
+	c.tasks.mut.Lock()
+	defer c.tasks.mut.Unlock()
+
+	res := make([]ChildTask, 0, len(c.tasks.tasks))
+	for _, task := range c.tasks.tasks {
+		res = append(res, task.info)
+	}
+	slices.SortFunc(res, func(a, b ChildTask) int {
+		if n := a.Started.Compare(b.Started); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+// CancelChildren cancels the contexts of the running background tasks of the
+// module children, and waits for them to return.
+func (c *ModuleComponent) CancelChildren() {
+	_, _ = c.cancelChildren(context.Background())
+}
+
+// cancelChildren is like CancelChildren, but stops waiting for the tasks to
+// return once ctx is canceled and returns the context error. The canceled
+// tasks are returned, so that they can be restarted with restartChildTasks.
+func (c *ModuleComponent) cancelChildren(ctx context.Context) ([]*childTask, error) {
+	c.tasks.mut.Lock()
+	tasks := make([]*childTask, 0, len(c.tasks.tasks))
+	for _, task := range c.tasks.tasks {
+		tasks = append(tasks, task)
+	}
+	c.tasks.mut.Unlock()
+
+	for _, task := range tasks {
+		task.cancel()
+	}
+	for _, task := range tasks {
+		select {
+		case <-task.done:
+		case <-ctx.Done():
+			return tasks, ctx.Err()
+		}
+	}
+	return tasks, nil
+}
+
+// restartChildTasks starts tasks canceled by cancelChildren again, once the
+// load they were canceled for failed or was rolled back.
+func (c *ModuleComponent) restartChildTasks(tasks []*childTask) {
+	for _, task := range tasks {
+		c.StartChildTask(task.info.Name, task.fn)
+	}
+}
@@ -0,0 +1,108 @@
+package module
+
+import (
+	"maps"
+	"time"
+)
+
+// exportExpiry clears an export once its TTL elapsed without the module
+// updating it.
+type exportExpiry struct {
+	timer *time.Timer
+}
+
+// SetExportTTL sets how long the export name is kept without the module
+// updating it. Once the TTL elapses, the export is removed from the exports
+// of the component, which are sent to OnStateChange without it, so that
+// consumers stop using a stale value. An export is updated every time the
+// module sends exports including it. A TTL of 0 removes the TTL of the
+// export. Exports don't expire by default.
+func (c *ModuleComponent) SetExportTTL(name string, ttl time.Duration) {
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+
+	if ttl <= 0 {
+		delete(c.exportTTLs, name)
+		c.stopExpiry(name)
+		return
+	}
+
+	if c.exportTTLs == nil {
+		c.exportTTLs = make(map[string]time.Duration)
+	}
+	c.exportTTLs[name] = ttl
+	if _, ok := c.latestExports[name]; ok {
+		c.scheduleExpiry(name, ttl)
+	}
+}
+
+// refreshExpiries restarts the TTLs of the exports of exports, and stops the
+// ones of the exports which were removed. exportMut must be held by the
+// caller.
+func (c *ModuleComponent) refreshExpiries(exports map[string]any) {
+	for name, ttl := range c.exportTTLs {
+		if _, ok := exports[name]; ok {
+			c.scheduleExpiry(name, ttl)
+		} else {
+			c.stopExpiry(name)
+		}
+	}
+}
+
+// scheduleExpiry clears the export name once ttl elapses, replacing its
+// previous expiry. exportMut must be held by the caller.
+func (c *ModuleComponent) scheduleExpiry(name string, ttl time.Duration) {
+	c.stopExpiry(name)
+	if c.expiriesStopped {
+		return
+	}
+
+	if c.expiries == nil {
+		c.expiries = make(map[string]*exportExpiry)
+	}
+	e := &exportExpiry{}
+	e.timer = time.AfterFunc(ttl, func() { c.expire(name, e) })
+	c.expiries[name] = e
+}
+
+// stopExpiry cancels the expiry of the export name, if any. exportMut must be
+// held by the caller.
+func (c *ModuleComponent) stopExpiry(name string) {
+	if e, ok := c.expiries[name]; ok {
+		e.timer.Stop()
+		delete(c.expiries, name)
+	}
+}
+
+// stopAllExpiries cancels the pending expiries of all exports, once the
+// module stopped running. No expiry is scheduled afterwards.
+func (c *ModuleComponent) stopAllExpiries() {
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+
+	c.expiriesStopped = true
+	for name := range c.expiries {
+		c.stopExpiry(name)
+	}
+}
+
+// expire removes the export name from the latest exports and sends them to
+// the export handler, unless e was replaced by a later update of the export
+// or the module stopped running.
+func (c *ModuleComponent) expire(name string, e *exportExpiry) {
+	c.exportMut.Lock()
+	defer c.exportMut.Unlock()
+
+	if c.expiriesStopped || c.expiries[name] != e {
+		return
+	}
+	delete(c.expiries, name)
+
+	// The latest exports may be held by the handler, so they're copied
+	// rather than modified.
+	exports := maps.Clone(c.latestExports)
+	delete(exports, name)
+	c.latestExports = exports
+	c.updateExportsSize(exports)
+	c.exportHandler(exports)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/grafana/alloy/internal/component"
 	"github.com/grafana/alloy/internal/featuregate"
 	"github.com/grafana/alloy/internal/runtime/internal/controller"
+	testmodule "github.com/grafana/alloy/internal/runtime/internal/testcomponents/module"
 	"github.com/grafana/alloy/internal/runtime/internal/worker"
 	"github.com/grafana/alloy/internal/runtime/logging"
 	"github.com/grafana/alloy/internal/service"
@@ -248,6 +249,64 @@ func TestDuplicateIDList(t *testing.T) {
 	})
 }
 
+func TestModuleSet_SharedController(t *testing.T) {
+	defer verifyNoGoroutineLeaks(t)
+	o := testModuleControllerOptions(t)
+	o.ID = "module.set"
+	defer o.WorkerPool.Stop()
+	nc := newModuleController(o)
+
+	s, err := testmodule.NewModuleSet(component.Options{
+		ID:               "module.set",
+		Logger:           o.Logger,
+		Registerer:       prometheus.NewRegistry(),
+		ModuleController: nc,
+		OnStateChange:    func(component.Exports) {},
+	})
+	require.NoError(t, err)
+
+	ctx, cncl := context.WithCancel(t.Context())
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		s.RunAlloyController(ctx)
+	}()
+
+	const content = `
+		argument "value" {}
+		export "out" {
+			value = argument.value.value
+		}`
+	require.NoError(t, s.LoadAlloySource("a", map[string]any{"value": "a"}, content))
+	require.NoError(t, s.LoadAlloySource("b", map[string]any{"value": "b"}, content))
+	require.Error(t, s.LoadAlloySource("c", nil, `export "out" { value = unknown.component.out }`))
+
+	// Each module runs in a namespace of its own, and its failure doesn't
+	// affect the others.
+	require.Eventually(t, func() bool {
+		return s.Exports("a")["out"] == "a" && s.Exports("b")["out"] == "b"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Nil(t, s.Exports("c"))
+	require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth("a").Health)
+	require.Equal(t, component.HealthTypeHealthy, s.ModuleHealth("b").Health)
+	require.Equal(t, component.HealthTypeUnhealthy, s.ModuleHealth("c").Health)
+
+	// Reloading a module only changes its own exports.
+	require.NoError(t, s.LoadAlloySource("b", map[string]any{"value": "b2"}, content))
+	require.Eventually(t, func() bool {
+		return s.Exports("b")["out"] == "b2"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]any{"out": "a"}, s.Exports("a"))
+
+	// A single module of the controller runs all of them.
+	require.Eventually(t, func() bool {
+		return len(nc.ModuleIDs()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cncl()
+	<-runDone
+}
+
 func testModuleControllerOptions(t *testing.T) *moduleControllerOptions {
 	t.Helper()
 
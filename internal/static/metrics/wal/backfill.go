@@ -0,0 +1,293 @@
+package wal
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// BackfillWindow is the time window of a backfill WAL, written by the
+// appenders returned by [Storage.BackfillAppender].
+type BackfillWindow struct {
+	Mint, Maxt int64
+}
+
+// BackfillDirectory returns the directory of the backfill WAL of the window
+// [mint, maxt] of the storage in base.
+func BackfillDirectory(base string, mint, maxt int64) string {
+	return filepath.Join(base, "backfill", fmt.Sprintf("%d_%d", mint, maxt))
+}
+
+// BackfillWindows returns the windows of the backfill WALs of the storage in
+// base, sorted by their mint.
+func BackfillWindows(base string) ([]BackfillWindow, error) {
+	entries, err := os.ReadDir(filepath.Join(base, "backfill"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var windows []BackfillWindow
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		mint, maxt, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+		var window BackfillWindow
+		if window.Mint, err = strconv.ParseInt(mint, 10, 64); err != nil {
+			continue
+		}
+		if window.Maxt, err = strconv.ParseInt(maxt, 10, 64); err != nil {
+			continue
+		}
+		windows = append(windows, window)
+	}
+	slices.SortFunc(windows, func(a, b BackfillWindow) int {
+		if a.Mint != b.Mint {
+			return cmp.Compare(a.Mint, b.Mint)
+		}
+		return cmp.Compare(a.Maxt, b.Maxt)
+	})
+	return windows, nil
+}
+
+// BackfillAppender returns an appender for historical samples in the window
+// [mint, maxt]. They're written to a WAL of their own, in the directory
+// returned by [BackfillDirectory], which can be replayed, shipped or deleted
+// independently of the WAL of the storage. The appenders of a window share a
+// single writer, which is kept open until the storage is truncated or
+// closed.
+//
+// Backfilled samples don't update the series of the storage, so they're
+// never out of order with live samples, and live samples are never out of
+// order with them. Samples outside of the window are rejected. Exemplars and
+// metadata are ignored.
+//
+// Series refs returned by the appender are only valid for this appender.
+func (w *Storage) BackfillAppender(mint, maxt int64) (storage.Appender, error) {
+	if mint > maxt {
+		return nil, fmt.Errorf("invalid backfill window [%d, %d]", mint, maxt)
+	}
+	return &backfillAppender{
+		w:      w,
+		mint:   mint,
+		maxt:   maxt,
+		series: make(map[uint64][]record.RefSeries),
+		byRef:  make(map[chunks.HeadSeriesRef]struct{}),
+	}, nil
+}
+
+type backfillAppender struct {
+	w          *Storage
+	mint, maxt int64
+
+	// series holds the series appended to, by the hash of their labels.
+	series map[uint64][]record.RefSeries
+	byRef  map[chunks.HeadSeriesRef]struct{}
+
+	pendingSeries          []record.RefSeries
+	pendingSamples         []record.RefSample
+	pendingHistograms      []record.RefHistogramSample
+	pendingFloatHistograms []record.RefFloatHistogramSample
+}
+
+var _ storage.Appender = (*backfillAppender)(nil)
+
+// getOrCreate returns the ref of the series with the labels l or given ref,
+// creating it if it doesn't exist. Refs are taken from the storage so that
+// they don't collide with the refs of other backfill appenders.
+func (a *backfillAppender) getOrCreate(ref storage.SeriesRef, l labels.Labels) (chunks.HeadSeriesRef, error) {
+	if _, ok := a.byRef[chunks.HeadSeriesRef(ref)]; ok {
+		return chunks.HeadSeriesRef(ref), nil
+	}
+
+	l, _, err := validateSeriesLabels(l)
+	if err != nil {
+		return 0, err
+	}
+	hash := l.Hash()
+	for _, s := range a.series[hash] {
+		if labels.Equal(s.Labels, l) {
+			return s.Ref, nil
+		}
+	}
+
+	s := record.RefSeries{Ref: chunks.HeadSeriesRef(a.w.nextRef.Inc()), Labels: l}
+	a.series[hash] = append(a.series[hash], s)
+	a.byRef[s.Ref] = struct{}{}
+	a.pendingSeries = append(a.pendingSeries, s)
+	return s.Ref, nil
+}
+
+// checkWindow returns an error if t is outside of the window of the appender.
+func (a *backfillAppender) checkWindow(t int64) error {
+	if t < a.mint || t > a.maxt {
+		return fmt.Errorf("%w: timestamp %d is outside of the backfill window [%d, %d]", storage.ErrOutOfBounds, t, a.mint, a.maxt)
+	}
+	return nil
+}
+
+func (a *backfillAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if err := a.checkWindow(t); err != nil {
+		return 0, err
+	}
+	seriesRef, err := a.getOrCreate(ref, l)
+	if err != nil {
+		return 0, err
+	}
+	a.pendingSamples = append(a.pendingSamples, record.RefSample{Ref: seriesRef, T: t, V: v})
+	return storage.SeriesRef(seriesRef), nil
+}
+
+func (a *backfillAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	if h != nil {
+		if err := h.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	if fh != nil {
+		if err := fh.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	if err := a.checkWindow(t); err != nil {
+		return 0, err
+	}
+	seriesRef, err := a.getOrCreate(ref, l)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case h != nil:
+		a.pendingHistograms = append(a.pendingHistograms, record.RefHistogramSample{Ref: seriesRef, T: t, H: h})
+	case fh != nil:
+		a.pendingFloatHistograms = append(a.pendingFloatHistograms, record.RefFloatHistogramSample{Ref: seriesRef, T: t, FH: fh})
+	}
+	return storage.SeriesRef(seriesRef), nil
+}
+
+func (a *backfillAppender) AppendExemplar(_ storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (a *backfillAppender) AppendCTZeroSample(_ storage.SeriesRef, _ labels.Labels, _ int64, _ int64) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (a *backfillAppender) UpdateMetadata(_ storage.SeriesRef, _ labels.Labels, _ metadata.Metadata) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+// Commit writes the pending series and samples to the backfill WAL of the
+// window.
+func (a *backfillAppender) Commit() error {
+	defer a.clearData()
+
+	if len(a.pendingSamples)+len(a.pendingHistograms)+len(a.pendingFloatHistograms) == 0 {
+		return nil
+	}
+
+	a.w.walMtx.RLock()
+	defer a.w.walMtx.RUnlock()
+
+	if a.w.walClosed {
+		return ErrWALClosed
+	}
+
+	var (
+		encoder record.Encoder
+		recs    [][]byte
+	)
+	if len(a.pendingSeries) > 0 {
+		recs = append(recs, encoder.Series(a.pendingSeries, nil))
+	}
+	if len(a.pendingSamples) > 0 {
+		recs = append(recs, encoder.Samples(a.pendingSamples, nil))
+	}
+	if len(a.pendingHistograms) > 0 {
+		recs = append(recs, encoder.HistogramSamples(a.pendingHistograms, nil))
+	}
+	if len(a.pendingFloatHistograms) > 0 {
+		recs = append(recs, encoder.FloatHistogramSamples(a.pendingFloatHistograms, nil))
+	}
+
+	a.w.backfillMtx.Lock()
+	defer a.w.backfillMtx.Unlock()
+
+	wal, err := a.w.backfillWAL(BackfillWindow{Mint: a.mint, Maxt: a.maxt})
+	if err != nil {
+		return fmt.Errorf("open backfill WAL: %w", err)
+	}
+	if err := wal.Log(recs...); err != nil {
+		return fmt.Errorf("write backfill WAL: %w", err)
+	}
+	a.pendingSeries = a.pendingSeries[:0]
+	return nil
+}
+
+// Rollback discards the pending series and samples. Series created by the
+// appender are written by the next commit instead.
+func (a *backfillAppender) Rollback() error {
+	a.clearData()
+	return nil
+}
+
+// clearData clears the pending samples. Pending series are only cleared once
+// written, since the refs of the appender stay valid after a rollback.
+func (a *backfillAppender) clearData() {
+	a.pendingSamples = a.pendingSamples[:0]
+	a.pendingHistograms = a.pendingHistograms[:0]
+	a.pendingFloatHistograms = a.pendingFloatHistograms[:0]
+}
+
+// backfillWAL returns the backfill WAL of window, opening it if it isn't open
+// yet. backfillMtx must be held by the caller.
+func (w *Storage) backfillWAL(window BackfillWindow) (*wlog.WL, error) {
+	if wal, ok := w.backfillWALs[window]; ok {
+		return wal, nil
+	}
+	wal, err := wlog.NewSize(w.logger, nil, BackfillDirectory(w.path, window.Mint, window.Maxt), wlog.DefaultSegmentSize, wlog.CompressionSnappy)
+	if err != nil {
+		return nil, err
+	}
+	if w.backfillWALs == nil {
+		w.backfillWALs = make(map[BackfillWindow]*wlog.WL)
+	}
+	w.backfillWALs[window] = wal
+	return wal, nil
+}
+
+// closeBackfillWALs closes the open backfill WALs, syncing them to disk. They
+// are opened again, in a new segment, by the next commit to their window.
+func (w *Storage) closeBackfillWALs() error {
+	w.backfillMtx.Lock()
+	defer w.backfillMtx.Unlock()
+
+	var errs []error
+	for window, wal := range w.backfillWALs {
+		if err := wal.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close backfill WAL [%d, %d]: %w", window.Mint, window.Maxt, err))
+		}
+		delete(w.backfillWALs, window)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+)
+
+// Barrier blocks until the appends committed before it was called are synced
+// to disk, so that they survive a crash. Unlike a checkpoint, it doesn't
+// rotate or truncate the WAL. With Options.GroupCommitWindow set, it joins
+// the pending group sync instead of syncing on its own.
+//
+// Barrier returns the context error if ctx is canceled before the sync
+// completes. The sync still completes in the background.
+func (w *Storage) Barrier(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	w.walMtx.RLock()
+	if w.walClosed {
+		w.walMtx.RUnlock()
+		return ErrWALClosed
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// The read lock is held until the sync completes, so the WAL can't be
+		// closed meanwhile.
+		defer w.walMtx.RUnlock()
+		if w.groupCommitter != nil {
+			done <- w.groupCommitter.wait()
+			return
+		}
+		done <- w.writer.Sync()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("sync WAL: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
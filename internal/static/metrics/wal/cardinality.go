@@ -0,0 +1,18 @@
+package wal
+
+// CardinalityByLabel returns the number of series held in memory for each
+// value of the label with the given name, such as the number of series of
+// each job. Series without the label aren't counted.
+func (w *Storage) CardinalityByLabel(labelName string) map[string]int {
+	res := make(map[string]int)
+	for _, series := range w.series.snapshot() {
+		series.Lock()
+		value := series.lset.Get(labelName)
+		series.Unlock()
+
+		if value != "" {
+			res[value]++
+		}
+	}
+	return res
+}
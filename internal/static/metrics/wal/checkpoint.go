@@ -0,0 +1,445 @@
+package wal
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// streamCheckpoint creates a checkpoint of the segments in the range
+// [from, to] of w, including the previous checkpoint if it exists, like
+// wlog.Checkpoint. Series not satisfying keep, samples, exemplars and
+// tombstones not satisfying keepSample, and metadata which isn't the latest
+// for its series are dropped. If maxSamples is positive, only the newest
+// maxSamples float and histogram samples of each series are kept. Only the
+// last maxTokens idempotency tokens are kept.
+//
+// Unlike wlog.Checkpoint, which batches up to 1MB of records before writing
+// them, every record is written to the checkpoint as soon as it's filtered,
+// reusing the same buffer. This bounds the memory used to checkpoint large
+// WALs, while writing the same checkpoint.
+func streamCheckpoint(logger log.Logger, w *wlog.WL, from, to int, keep func(id chunks.HeadSeriesRef) bool, keepSample func(id chunks.HeadSeriesRef, t int64) bool, maxSamples, maxTokens int) error {
+	level.Info(logger).Log("msg", "Creating checkpoint", "from_segment", from, "to_segment", to)
+
+	var ranges []wlog.SegmentRange
+	dir, idx, err := wlog.LastCheckpoint(w.Dir())
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		return fmt.Errorf("find last checkpoint: %w", err)
+	}
+	if err == nil {
+		if from > idx+1 {
+			return fmt.Errorf("unexpected gap to last checkpoint. expected:%v, requested:%v", idx+1, from)
+		}
+		// Ignore WAL files below the checkpoint. They shouldn't exist to
+		// begin with.
+		from = idx + 1
+		ranges = append(ranges, wlog.SegmentRange{Dir: dir, Last: math.MaxInt32})
+	}
+	ranges = append(ranges, wlog.SegmentRange{Dir: w.Dir(), First: from, Last: to})
+
+	// The samples exceeding maxSamples are found before anything is written,
+	// so that samples are trimmed by timestamp rather than in the order they
+	// were written.
+	var limit *sampleLimit
+	if maxSamples > 0 {
+		limit, err = newSampleLimit(ranges, keepSample, maxSamples)
+		if err != nil {
+			return err
+		}
+	}
+	keepLimited := func(id chunks.HeadSeriesRef, t int64) bool {
+		return keepSample(id, t) && limit.keep(id, t)
+	}
+
+	sr, err := wlog.NewSegmentsRangeReader(ranges...)
+	if err != nil {
+		return fmt.Errorf("create segment reader: %w", err)
+	}
+	defer sr.Close()
+
+	cpdir := filepath.Join(w.Dir(), fmt.Sprintf("checkpoint.%08d", to))
+	cpdirtmp := cpdir + ".tmp"
+	if err := os.RemoveAll(cpdirtmp); err != nil {
+		return fmt.Errorf("remove previous temporary checkpoint dir: %w", err)
+	}
+	cp, err := wlog.New(nil, nil, cpdirtmp, w.CompressionType())
+	if err != nil {
+		return fmt.Errorf("open checkpoint: %w", err)
+	}
+	// Ensures that an early return caused by an error doesn't leave any tmp
+	// files.
+	defer func() {
+		cp.Close()
+		os.RemoveAll(cpdirtmp)
+	}()
+
+	var (
+		r   = wlog.NewReader(sr)
+		dec = record.NewDecoder(labels.NewSymbolTable())
+		enc record.Encoder
+		buf []byte
+
+		series                []record.RefSeries
+		samples               []record.RefSample
+		histogramSamples      []record.RefHistogramSample
+		floatHistogramSamples []record.RefFloatHistogramSample
+		tstones               []tombstones.Stone
+		exemplars             []record.RefExemplar
+		metadata              []record.RefMetadata
+
+		latestMetadata = make(map[chunks.HeadSeriesRef]record.RefMetadata)
+		tokens         = newTokenSet(maxTokens)
+	)
+	for r.Next() {
+		rec := r.Record()
+		buf = buf[:0]
+
+		if isIdempotencyTokensRecord(rec) {
+			// Like metadata, only the latest tokens are kept, and written
+			// at the end of the checkpoint.
+			decoded, err := DecodeIdempotencyTokens(rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode idempotency tokens: %w", err)
+			}
+			for _, t := range decoded {
+				tokens.add(t)
+			}
+			continue
+		}
+
+		switch recordType(&dec, rec) {
+		case record.Series:
+			series, err = dec.Series(rec, series[:0])
+			if err != nil {
+				return fmt.Errorf("decode series: %w", err)
+			}
+			repl := series[:0]
+			for _, s := range series {
+				if keep(s.Ref) {
+					repl = append(repl, s)
+				}
+			}
+			if len(repl) > 0 {
+				buf = enc.Series(repl, buf)
+			}
+
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				return fmt.Errorf("decode samples: %w", err)
+			}
+			repl := samples[:0]
+			for _, s := range samples {
+				if keepLimited(s.Ref, s.T) {
+					repl = append(repl, s)
+				}
+			}
+			if len(repl) > 0 {
+				buf = enc.Samples(repl, buf)
+			}
+
+		case record.HistogramSamples:
+			histogramSamples, err = dec.HistogramSamples(rec, histogramSamples[:0])
+			if err != nil {
+				return fmt.Errorf("decode histogram samples: %w", err)
+			}
+			repl := histogramSamples[:0]
+			for _, h := range histogramSamples {
+				if keepLimited(h.Ref, h.T) {
+					repl = append(repl, h)
+				}
+			}
+			if len(repl) > 0 {
+				buf = enc.HistogramSamples(repl, buf)
+			}
+
+		case record.FloatHistogramSamples:
+			floatHistogramSamples, err = dec.FloatHistogramSamples(rec, floatHistogramSamples[:0])
+			if err != nil {
+				return fmt.Errorf("decode float histogram samples: %w", err)
+			}
+			repl := floatHistogramSamples[:0]
+			for _, fh := range floatHistogramSamples {
+				if keepLimited(fh.Ref, fh.T) {
+					repl = append(repl, fh)
+				}
+			}
+			if len(repl) > 0 {
+				buf = enc.FloatHistogramSamples(repl, buf)
+			}
+
+		case record.Tombstones:
+			tstones, err = dec.Tombstones(rec, tstones[:0])
+			if err != nil {
+				return fmt.Errorf("decode deletes: %w", err)
+			}
+			repl := tstones[:0]
+			for _, s := range tstones {
+				for _, iv := range s.Intervals {
+					if keepSample(chunks.HeadSeriesRef(s.Ref), iv.Maxt) {
+						repl = append(repl, s)
+						break
+					}
+				}
+			}
+			if len(repl) > 0 {
+				buf = enc.Tombstones(repl, buf)
+			}
+
+		case record.Exemplars:
+			exemplars, err = decodeExemplars(&dec, rec, exemplars[:0])
+			if err != nil {
+				return fmt.Errorf("decode exemplars: %w", err)
+			}
+			repl := exemplars[:0]
+			for _, e := range exemplars {
+				if keepSample(e.Ref, e.T) {
+					repl = append(repl, e)
+				}
+			}
+			if len(repl) > 0 && isDictExemplarsRecord(rec) {
+				buf = encodeDictExemplars(repl, buf)
+			} else if len(repl) > 0 {
+				buf = enc.Exemplars(repl, buf)
+			}
+
+		case record.Metadata:
+			metadata, err = dec.Metadata(rec, metadata[:0])
+			if err != nil {
+				return fmt.Errorf("decode metadata: %w", err)
+			}
+			// Only the latest metadata of each series is kept, and written
+			// at the end of the checkpoint.
+			for _, m := range metadata {
+				if keep(m.Ref) {
+					latestMetadata[m.Ref] = m
+				}
+			}
+		}
+		if len(buf) == 0 {
+			continue // All contents discarded, or unknown record type.
+		}
+		if err := cp.Log(buf); err != nil {
+			return fmt.Errorf("write checkpoint record: %w", err)
+		}
+	}
+	// If we hit any corruption during checkpointing, repairing is not an
+	// option. The head won't know which series records are lost.
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("read segments: %w", err)
+	}
+
+	if len(latestMetadata) > 0 {
+		metadata = metadata[:0]
+		for _, m := range latestMetadata {
+			metadata = append(metadata, m)
+		}
+		if err := cp.Log(enc.Metadata(metadata, buf[:0])); err != nil {
+			return fmt.Errorf("write metadata records: %w", err)
+		}
+	}
+	if list := tokens.list(); len(list) > 0 {
+		if err := cp.Log(encodeIdempotencyTokens(list, buf[:0])); err != nil {
+			return fmt.Errorf("write idempotency tokens record: %w", err)
+		}
+	}
+
+	if err := cp.Close(); err != nil {
+		return fmt.Errorf("close checkpoint: %w", err)
+	}
+
+	// Sync the temporary directory before renaming it.
+	df, err := fileutil.OpenDir(cpdirtmp)
+	if err != nil {
+		return fmt.Errorf("open temporary checkpoint directory: %w", err)
+	}
+	if err := df.Sync(); err != nil {
+		df.Close()
+		return fmt.Errorf("sync temporary checkpoint directory: %w", err)
+	}
+	if err := df.Close(); err != nil {
+		return fmt.Errorf("close temporary checkpoint directory: %w", err)
+	}
+
+	if err := fileutil.Replace(cpdirtmp, cpdir); err != nil {
+		return fmt.Errorf("rename checkpoint directory: %w", err)
+	}
+	return nil
+}
+
+// samplesSince returns a keepSample function for streamCheckpoint keeping
+// the samples at or after mint.
+func samplesSince(mint int64) func(chunks.HeadSeriesRef, int64) bool {
+	return func(_ chunks.HeadSeriesRef, t int64) bool { return t >= mint }
+}
+
+// sampleLimit limits the float and histogram samples kept for each series
+// by streamCheckpoint to the newest ones. A nil *sampleLimit keeps every
+// sample.
+type sampleLimit struct {
+	// cutoff is the oldest timestamp kept for each series exceeding the
+	// limit, and atCutoff the number of samples at that timestamp which can
+	// still be kept.
+	cutoff   map[chunks.HeadSeriesRef]int64
+	atCutoff map[chunks.HeadSeriesRef]int
+}
+
+// newSampleLimit reads the segments of ranges to find the samples satisfying
+// keepSample beyond the newest max samples of each series. The segments are
+// read once to count the samples of each series, and once more to find the
+// cutoff timestamp of the series exceeding max. It returns nil if no series
+// exceeds max.
+func newSampleLimit(ranges []wlog.SegmentRange, keepSample func(id chunks.HeadSeriesRef, t int64) bool, max int) (*sampleLimit, error) {
+	counts := make(map[chunks.HeadSeriesRef]int)
+	err := forEachSample(ranges, func(id chunks.HeadSeriesRef, t int64) {
+		if keepSample(id, t) {
+			counts[id]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newest := make(map[chunks.HeadSeriesRef]*timestampHeap)
+	for id, count := range counts {
+		if count > max {
+			newest[id] = &timestampHeap{}
+		}
+	}
+	if len(newest) == 0 {
+		return nil, nil
+	}
+
+	err = forEachSample(ranges, func(id chunks.HeadSeriesRef, t int64) {
+		h, ok := newest[id]
+		if !ok || !keepSample(id, t) {
+			return
+		}
+		if h.Len() < max {
+			heap.Push(h, t)
+		} else if t > (*h)[0] {
+			(*h)[0] = t
+			heap.Fix(h, 0)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &sampleLimit{
+		cutoff:   make(map[chunks.HeadSeriesRef]int64, len(newest)),
+		atCutoff: make(map[chunks.HeadSeriesRef]int, len(newest)),
+	}
+	for id, h := range newest {
+		cutoff := (*h)[0]
+		l.cutoff[id] = cutoff
+		for _, t := range *h {
+			if t == cutoff {
+				l.atCutoff[id]++
+			}
+		}
+	}
+	return l, nil
+}
+
+// keep reports whether the sample of id at t is kept. It must be called once
+// for each sample, in the order they're written to the checkpoint.
+func (l *sampleLimit) keep(id chunks.HeadSeriesRef, t int64) bool {
+	if l == nil {
+		return true
+	}
+	cutoff, ok := l.cutoff[id]
+	switch {
+	case !ok || t > cutoff:
+		return true
+	case t < cutoff:
+		return false
+	}
+	if l.atCutoff[id] == 0 {
+		return false
+	}
+	l.atCutoff[id]--
+	return true
+}
+
+// timestampHeap is a min-heap of sample timestamps.
+type timestampHeap []int64
+
+func (h timestampHeap) Len() int           { return len(h) }
+func (h timestampHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h timestampHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *timestampHeap) Push(x any)        { *h = append(*h, x.(int64)) }
+func (h *timestampHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// forEachSample calls fn with the series and timestamp of every float and
+// histogram sample in the segments of ranges.
+func forEachSample(ranges []wlog.SegmentRange, fn func(id chunks.HeadSeriesRef, t int64)) error {
+	sr, err := wlog.NewSegmentsRangeReader(ranges...)
+	if err != nil {
+		return fmt.Errorf("create segment reader: %w", err)
+	}
+	defer sr.Close()
+
+	var (
+		r   = wlog.NewReader(sr)
+		dec = record.NewDecoder(labels.NewSymbolTable())
+
+		samples               []record.RefSample
+		histogramSamples      []record.RefHistogramSample
+		floatHistogramSamples []record.RefFloatHistogramSample
+	)
+	for r.Next() {
+		rec := r.Record()
+		if isIdempotencyTokensRecord(rec) {
+			continue
+		}
+
+		switch recordType(&dec, rec) {
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				return fmt.Errorf("decode samples: %w", err)
+			}
+			for _, s := range samples {
+				fn(s.Ref, s.T)
+			}
+		case record.HistogramSamples:
+			histogramSamples, err = dec.HistogramSamples(rec, histogramSamples[:0])
+			if err != nil {
+				return fmt.Errorf("decode histogram samples: %w", err)
+			}
+			for _, h := range histogramSamples {
+				fn(h.Ref, h.T)
+			}
+		case record.FloatHistogramSamples:
+			floatHistogramSamples, err = dec.FloatHistogramSamples(rec, floatHistogramSamples[:0])
+			if err != nil {
+				return fmt.Errorf("decode float histogram samples: %w", err)
+			}
+			for _, fh := range floatHistogramSamples {
+				fn(fh.Ref, fh.T)
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("read segments: %w", err)
+	}
+	return nil
+}
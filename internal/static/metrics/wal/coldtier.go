@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// truncateSegments removes the segments in the range [first, last] from the
+// WAL, moving them to Options.ColdDir if it's set.
+func (w *Storage) truncateSegments(first, last int) error {
+	if w.options().ColdDir != "" {
+		// Segments are only deleted from the WAL once they're all moved, so
+		// that segments which couldn't be moved are moved at the next
+		// checkpoint instead of being lost.
+		if err := w.moveToColdDir(first, last); err != nil {
+			return fmt.Errorf("move segments to the cold directory: %w", err)
+		}
+	}
+	return w.wal.Truncate(last + 1)
+}
+
+// rename renames files. It's overridden in tests.
+var rename = os.Rename
+
+// moveToColdDir moves the segments in the range [first, last] to
+// Options.ColdDir, keeping their name. Segments which don't exist are
+// skipped. Both directories are synced once the segments are moved, so that
+// the moves are durable before the segments are truncated from the WAL.
+func (w *Storage) moveToColdDir(first, last int) error {
+	coldDir := w.options().ColdDir
+	for i := first; i <= last; i++ {
+		err := moveFile(wlog.SegmentName(w.wal.Dir(), i), wlog.SegmentName(coldDir, i))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := syncDir(coldDir); err != nil {
+		return err
+	}
+	return syncDir(w.wal.Dir())
+}
+
+// moveFile moves the file src to dst. Renaming fails when the cold
+// directory is on another filesystem than the WAL, in which case src is
+// copied to dst and synced before being removed.
+func moveFile(src, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// The copy is written to a temporary file first, so that a partial copy
+	// is never mistaken for a segment.
+	tmp := dst + ".tmp"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("copy %s to %s: %w", src, dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies the file src to dst, syncing dst to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir syncs the directory dir to disk, making the files created,
+// renamed or removed in it durable.
+func syncDir(dir string) error {
+	df, err := fileutil.OpenDir(dir)
+	if err != nil {
+		return fmt.Errorf("open directory %s: %w", dir, err)
+	}
+	if err := df.Sync(); err != nil {
+		df.Close()
+		return fmt.Errorf("sync directory %s: %w", dir, err)
+	}
+	return df.Close()
+}
+
+// ReplayRangeWithColdDir is like [ReplayRange], but also replays the
+// segments moved from the WAL in dir to coldDir, see Options.ColdDir.
+//
+// Segments in coldDir are replayed before the WAL. If they hold every
+// segment up to the latest checkpoint of the WAL, only the series of the
+// checkpoint are replayed, since its samples were already replayed from the
+// segments it was created from.
+func ReplayRangeWithColdDir(dir, coldDir string, mint, maxt int64, w Writer) error {
+	rr := newRangeReplayer(mint, maxt, w)
+
+	coldFirst, coldLast, err := wlog.Segments(coldDir)
+	if err != nil {
+		return fmt.Errorf("list cold segments: %w", err)
+	}
+	if err := rr.replaySegments(coldDir, coldFirst, coldLast); err != nil {
+		return err
+	}
+
+	checkpointDir, checkpointIdx, err := wlog.LastCheckpoint(dir)
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		return fmt.Errorf("find last checkpoint: %w", err)
+	}
+
+	startFrom := coldLast + 1
+	if err == nil {
+		// The checkpoint and the cold segments overlap if the cold
+		// segments go at least up to it.
+		rr.seriesOnly = coldLast >= checkpointIdx
+		if err := rr.replayCheckpoint(checkpointDir, checkpointIdx); err != nil {
+			return err
+		}
+		rr.seriesOnly = false
+		startFrom = max(startFrom, checkpointIdx+1)
+	}
+
+	first, last, err := wlog.Segments(dir)
+	if err != nil {
+		return fmt.Errorf("list segments: %w", err)
+	}
+	return rr.replaySegments(dir, max(startFrom, first), last)
+}
@@ -0,0 +1,41 @@
+package wal
+
+import "github.com/prometheus/prometheus/storage"
+
+// CommitStats describes what a commit wrote to the WAL.
+type CommitStats struct {
+	// Series is the number of series records created by the commit.
+	Series int
+	// Samples is the number of float and native histogram samples written.
+	Samples int
+	// Exemplars is the number of exemplars written.
+	Exemplars int
+	// Bytes is the size of the records written, before compression.
+	Bytes int
+}
+
+// StatsAppender is implemented by the appenders returned by the storage,
+// which can report what each commit wrote to the WAL. It can be used for
+// throughput accounting without keeping separate counters:
+//
+//	app := storage.Appender(ctx).(wal.StatsAppender)
+//	stats, err := app.CommitWithStats()
+type StatsAppender interface {
+	storage.Appender
+
+	// CommitWithStats is like Commit, but also returns what the commit wrote
+	// to the WAL. Stats are empty if the commit fails or is a duplicate of
+	// an idempotent commit.
+	CommitWithStats() (CommitStats, error)
+}
+
+var _ StatsAppender = (*appender)(nil)
+
+// logRecord writes rec to the WAL and adds its size to stats.
+func (a *appender) logRecord(rec []byte, stats *CommitStats) error {
+	if err := a.w.logRecord(rec); err != nil {
+		return err
+	}
+	stats.Bytes += len(rec)
+	return nil
+}
@@ -0,0 +1,132 @@
+package wal
+
+import (
+	"cmp"
+	"io"
+	"slices"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/protobuf/proto"
+)
+
+// Reasons reported by WriteOpenMetrics for the series it leaves out.
+const (
+	OmitReasonLimit     = "limit"
+	OmitReasonHistogram = "histogram"
+	OmitReasonNoSample  = "no_sample"
+)
+
+// debugOmittedSeriesName is the name of the metric written by
+// WriteOpenMetrics to report the series it left out.
+const debugOmittedSeriesName = "prometheus_remote_write_wal_debug_omitted_series"
+
+// debugSeries is a series written by WriteOpenMetrics.
+type debugSeries struct {
+	lset  labels.Labels
+	t     int64
+	value float64
+}
+
+// WriteOpenMetrics writes the series held in memory to out in the
+// OpenMetrics text format, each along with its latest committed sample, so
+// that operators can inspect what the storage holds, for example from a debug
+// HTTP endpoint. Series are written as metrics of unknown type, grouped by
+// metric name.
+//
+// At most Options.DebugMaxSeries series are written, picked in no particular
+// order. Series of native histograms and series without any committed sample
+// aren't written either. The number of series left out is written as the
+// prometheus_remote_write_wal_debug_omitted_series gauge, by reason.
+func (w *Storage) WriteOpenMetrics(out io.Writer) error {
+	limit := w.options().DebugMaxSeries
+
+	var (
+		series  []debugSeries
+		omitted = make(map[string]int)
+	)
+	for i := 0; i < w.series.size; i++ {
+		w.series.locks[i].RLock()
+		for _, s := range w.series.series[i] {
+			s.Lock()
+			switch {
+			case s.lastKind == sampleNone:
+				omitted[OmitReasonNoSample]++
+			case s.lastKind == sampleHistogram:
+				omitted[OmitReasonHistogram]++
+			case limit > 0 && len(series) >= limit:
+				omitted[OmitReasonLimit]++
+			default:
+				series = append(series, debugSeries{lset: s.lset, t: s.lastTs, value: s.lastValue})
+			}
+			s.Unlock()
+		}
+		w.series.locks[i].RUnlock()
+	}
+
+	// Samples of a metric must be written together.
+	slices.SortFunc(series, func(a, b debugSeries) int {
+		return cmp.Or(
+			cmp.Compare(a.lset.Get(labels.MetricName), b.lset.Get(labels.MetricName)),
+			labels.Compare(a.lset, b.lset),
+		)
+	})
+
+	var family *dto.MetricFamily
+	for _, s := range series {
+		name := s.lset.Get(labels.MetricName)
+		if family == nil || family.GetName() != name {
+			if err := writeMetricFamily(out, family); err != nil {
+				return err
+			}
+			family = &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_UNTYPED.Enum()}
+		}
+
+		m := &dto.Metric{
+			Untyped:     &dto.Untyped{Value: proto.Float64(s.value)},
+			TimestampMs: proto.Int64(s.t),
+		}
+		s.lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+			}
+		})
+		family.Metric = append(family.Metric, m)
+	}
+	if err := writeMetricFamily(out, family); err != nil {
+		return err
+	}
+
+	if len(omitted) > 0 {
+		family := &dto.MetricFamily{
+			Name: proto.String(debugOmittedSeriesName),
+			Help: proto.String("Number of series held by the WAL storage which weren't written, by reason."),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		for _, reason := range []string{OmitReasonLimit, OmitReasonHistogram, OmitReasonNoSample} {
+			if n, ok := omitted[reason]; ok {
+				family.Metric = append(family.Metric, &dto.Metric{
+					Label: []*dto.LabelPair{{Name: proto.String("reason"), Value: proto.String(reason)}},
+					Gauge: &dto.Gauge{Value: proto.Float64(float64(n))},
+				})
+			}
+		}
+		if err := writeMetricFamily(out, family); err != nil {
+			return err
+		}
+	}
+
+	_, err := expfmt.FinalizeOpenMetrics(out)
+	return err
+}
+
+// writeMetricFamily writes family to out in the OpenMetrics text format. A
+// nil family isn't written.
+func writeMetricFamily(out io.Writer, family *dto.MetricFamily) error {
+	if family == nil {
+		return nil
+	}
+	_, err := expfmt.MetricFamilyToOpenMetrics(out, family)
+	return err
+}
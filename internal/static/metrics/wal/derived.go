@@ -0,0 +1,137 @@
+package wal
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// DerivedSample is a float sample of a commit given to a DerivedSeriesFunc,
+// or derived from them.
+type DerivedSample struct {
+	Labels labels.Labels
+	T      int64
+	V      float64
+}
+
+// DerivedSeriesFunc is called with the float samples of a commit and returns
+// samples derived from them, such as the sum of a set of series, which are
+// written by the same commit. See Options.DerivedSeries.
+type DerivedSeriesFunc func(committed []DerivedSample) []DerivedSample
+
+// appendDerived calls fn with the float samples of the commit, and appends
+// the samples it derives from them. fn is called once per commit and isn't
+// given the samples it derived, so it can't recurse.
+//
+// Derived samples are dropped when their timestamp isn't after the latest
+// one of their series, including the samples of the commit and the samples
+// derived by other commits which aren't committed yet, so that they're never
+// out of order. If samples were derived, the returned sequence number orders
+// the commit after the commits which derived samples before it, and is 0
+// otherwise.
+func (a *appender) appendDerived(fn DerivedSeriesFunc) (seq uint64) {
+	committed := make([]DerivedSample, len(a.pendingSamples))
+	latest := make(map[chunks.HeadSeriesRef]int64, len(a.pendingSamples))
+	for i, s := range a.pendingSamples {
+		// The labels of the series may be replaced by RelabelSeries.
+		series := a.sampleSeries[i]
+		series.Lock()
+		lset := series.lset
+		series.Unlock()
+
+		committed[i] = DerivedSample{Labels: lset, T: s.T, V: s.V}
+		if t, ok := latest[s.Ref]; !ok || s.T > t {
+			latest[s.Ref] = s.T
+		}
+	}
+
+	derived := fn(committed)
+	if len(derived) == 0 {
+		return 0
+	}
+
+	a.w.derivedMtx.Lock()
+	defer a.w.derivedMtx.Unlock()
+
+	var appended bool
+	for _, s := range derived {
+		if series := a.w.series.GetByHash(s.Labels.Hash(), s.Labels); series != nil {
+			t, ok := latest[series.ref]
+			if !ok {
+				series.Lock()
+				t = series.lastTs
+				series.Unlock()
+			}
+			if series.derived {
+				t = max(t, series.derivedTs)
+			}
+			if s.T <= t {
+				a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonDerivedOutOfOrder).Inc()
+				a.w.sampleDropped(storage.SeriesRef(series.ref), s.Labels, s.T, s.V, DropReasonDerivedOutOfOrder)
+				continue
+			}
+		}
+
+		// Errors are counted as dropped samples by append, and don't fail
+		// the commit of the samples they're derived from.
+		ref, err := a.append(0, s.Labels, s.T, s.V, true)
+		if err != nil {
+			continue
+		}
+		latest[chunks.HeadSeriesRef(ref)] = s.T
+		if series := a.w.series.GetByID(chunks.HeadSeriesRef(ref)); series != nil {
+			series.derivedTs, series.derived = s.T, true
+		}
+		appended = true
+	}
+	if !appended {
+		return 0
+	}
+	return a.w.derivedOrder.next()
+}
+
+// derivedOrder orders the writes of the commits deriving samples by their
+// sequence number, so that samples derived for the same series are written
+// in order even when their commits run concurrently.
+type derivedOrder struct {
+	mut     sync.Mutex
+	cond    *sync.Cond
+	last    uint64 // Last sequence number given to a commit.
+	written uint64 // Sequence number of the last commit which was written.
+}
+
+// next returns the next sequence number. The caller must hold derivedMtx, so
+// that sequence numbers follow the order samples are derived in.
+func (o *derivedOrder) next() uint64 {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	o.last++
+	return o.last
+}
+
+// wait waits for the commits with a lower sequence number than seq to be
+// written. The returned function must be called once the commit with
+// sequence number seq is written or failed, and may be called more than
+// once.
+func (o *derivedOrder) wait(seq uint64) (written func()) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	if o.cond == nil {
+		o.cond = sync.NewCond(&o.mut)
+	}
+	for o.written+1 < seq {
+		o.cond.Wait()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			o.mut.Lock()
+			defer o.mut.Unlock()
+			o.written = seq
+			o.cond.Broadcast()
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package wal
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"golang.org/x/time/rate"
+)
+
+// SampleDroppedFunc is called with a sample dropped by Append or
+// AppendHistogram and the reason it was dropped, which is one of the
+// DropReason constants. See Options.OnSampleDropped.
+type SampleDroppedFunc func(lbls labels.Labels, t int64, v float64, reason string)
+
+// droppedSampleObserver calls Options.OnSampleDropped, at most
+// Options.SampleDroppedRateLimit times per second.
+type droppedSampleObserver struct {
+	fn      SampleDroppedFunc
+	limiter *rate.Limiter
+}
+
+func newDroppedSampleObserver(fn SampleDroppedFunc, limit float64) *droppedSampleObserver {
+	if fn == nil {
+		return nil
+	}
+	burst := max(int(limit), 1)
+	return &droppedSampleObserver{fn: fn, limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+}
+
+// sampleDropped reports the sample dropped by the appender to the
+// Options.OnSampleDropped callback, unless the rate limit is exceeded. l is
+// the labels given to Append, and may be empty if ref is set. The series of
+// ref must not be locked by the caller.
+func (w *Storage) sampleDropped(ref storage.SeriesRef, l labels.Labels, t int64, v float64, reason string) {
+	o := w.droppedSamples.Load()
+	if o == nil || !o.limiter.Allow() {
+		return
+	}
+	if l.IsEmpty() {
+		if series := w.series.GetByID(chunks.HeadSeriesRef(ref)); series != nil {
+			// The labels of a series are replaced under its lock by
+			// RelabelSeries.
+			series.Lock()
+			l = series.lset
+			series.Unlock()
+		}
+	}
+	o.fn(l, t, v, reason)
+}
@@ -0,0 +1,198 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// DryAppender returns an appender which validates samples the same way as
+// the appenders returned by [Storage.Appender], but never writes anything to
+// the WAL nor creates series in the storage. It can be used to pre-flight a
+// batch of samples before appending it for real.
+//
+// Appending to a dry appender returns the same errors as a regular appender.
+// Series which don't exist yet are assigned provisional refs, which can be
+// passed to subsequent calls on the same dry appender but are meaningless to
+// the storage. Commit additionally reports samples which would be out of
+// order, which a regular appender accepts but doesn't count as the latest
+// sample of their series.
+func (w *Storage) DryAppender(_ context.Context) storage.Appender {
+	return &dryAppender{
+		w:       w,
+		nextRef: chunks.HeadSeriesRef(w.nextRef.Load()),
+		series:  make(map[chunks.HeadSeriesRef]*drySeries),
+		byLabel: make(map[string]*drySeries),
+	}
+}
+
+// drySeries tracks the state of a series as seen by a dry appender.
+type drySeries struct {
+	ref            chunks.HeadSeriesRef
+	lastTs         int64
+	stale          bool
+	latestExemplar *exemplar.Exemplar
+}
+
+type drySample struct {
+	series *drySeries
+	t      int64
+	stale  bool
+}
+
+type dryAppender struct {
+	w       *Storage
+	nextRef chunks.HeadSeriesRef
+
+	series  map[chunks.HeadSeriesRef]*drySeries
+	byLabel map[string]*drySeries // Series by the bytes of their labels.
+	samples []drySample
+}
+
+var _ storage.Appender = (*dryAppender)(nil)
+
+// getByID returns the series with the given ref, either from the dry
+// appender or from the storage.
+func (a *dryAppender) getByID(ref chunks.HeadSeriesRef) *drySeries {
+	if s, ok := a.series[ref]; ok {
+		return s
+	}
+	if s := a.w.series.GetByID(ref); s != nil {
+		return a.track(s)
+	}
+	return nil
+}
+
+// getOrCreate returns the series with the labels l, assigning it a
+// provisional ref if it doesn't exist in the storage.
+func (a *dryAppender) getOrCreate(l labels.Labels) *drySeries {
+	key := string(l.Bytes(nil))
+	if s, ok := a.byLabel[key]; ok {
+		return s
+	}
+
+	var s *drySeries
+	if series := a.w.series.GetByHash(l.Hash(), l); series != nil {
+		s = a.track(series)
+	} else {
+		a.nextRef++
+		s = &drySeries{ref: a.nextRef, lastTs: math.MinInt64}
+		a.series[s.ref] = s
+	}
+	a.byLabel[key] = s
+	return s
+}
+
+// track starts tracking the existing series of the storage.
+func (a *dryAppender) track(series *memSeries) *drySeries {
+	series.Lock()
+	s := &drySeries{ref: series.ref, lastTs: series.lastTs, stale: series.stale}
+	series.Unlock()
+
+	s.latestExemplar = a.w.series.GetLatestExemplar(series.ref)
+	a.series[s.ref] = s
+	return s
+}
+
+func (a *dryAppender) append(ref storage.SeriesRef, l labels.Labels, t int64, stale bool) (storage.SeriesRef, error) {
+	t, err := a.w.futureSkew(t)
+	if err != nil {
+		return 0, err
+	}
+
+	s := a.getByID(chunks.HeadSeriesRef(ref))
+	if s == nil && ref != 0 && a.w.options().StrictSeriesRefs {
+		return 0, unknownRefError(ref)
+	}
+	if s == nil {
+		if l, _, err = validateSeriesLabels(l); err != nil {
+			return 0, err
+		}
+		s = a.getOrCreate(l)
+	}
+	if a.w.options().StalePolicy == StalePolicyRejectAfterStale && s.stale && !stale {
+		return 0, &StaleSeriesError{Ref: s.ref, Timestamp: t}
+	}
+
+	a.samples = append(a.samples, drySample{series: s, t: t, stale: stale})
+	return storage.SeriesRef(s.ref), nil
+}
+
+func (a *dryAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	return a.append(ref, l, t, value.IsStaleNaN(v))
+}
+
+func (a *dryAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	if h != nil {
+		if err := h.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	if fh != nil {
+		if err := fh.Validate(); err != nil {
+			return 0, err
+		}
+	}
+	stale := (h != nil && value.IsStaleNaN(h.Sum)) || (fh != nil && value.IsStaleNaN(fh.Sum))
+	return a.append(ref, l, t, stale)
+}
+
+func (a *dryAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	readRef := chunks.HeadSeriesRef(ref)
+
+	s := a.getByID(readRef)
+	if s == nil {
+		return 0, fmt.Errorf("unknown series ref when trying to add exemplar: %d", readRef)
+	}
+
+	var err error
+	e.Labels, err = validateExemplarLabels(e.Labels)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.latestExemplar != nil && (s.latestExemplar.Equals(e) || s.latestExemplar.Ts > e.Ts) {
+		return 0, nil
+	}
+	s.latestExemplar = &e
+	return storage.SeriesRef(s.ref), nil
+}
+
+func (a *dryAppender) AppendCTZeroSample(_ storage.SeriesRef, _ labels.Labels, _ int64, _ int64) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (a *dryAppender) UpdateMetadata(_ storage.SeriesRef, _ labels.Labels, _ metadata.Metadata) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+// Commit discards the appended samples, and returns an error for each
+// sample which would be out of order if committed to the storage.
+func (a *dryAppender) Commit() error {
+	var errs []error
+	for _, sample := range a.samples {
+		if sample.t < sample.series.lastTs {
+			errs = append(errs, fmt.Errorf("series %d: %w", sample.series.ref, outOfOrderError(sample.t)))
+			continue
+		}
+		sample.series.lastTs = sample.t
+		sample.series.stale = sample.stale
+	}
+	a.samples = a.samples[:0]
+	return errors.Join(errs...)
+}
+
+// Rollback discards the appended samples.
+func (a *dryAppender) Rollback() error {
+	a.samples = a.samples[:0]
+	return nil
+}
@@ -0,0 +1,145 @@
+package wal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// RecordDictExemplars is the type of the WAL records holding exemplars whose
+// label names are dictionary encoded, see [Options.DictionaryExemplarLabels].
+//
+// Prometheus doesn't know about this record type: it's skipped by the remote
+// write watcher, which counts it as a record it failed to decode, and by
+// versions of the WAL predating it, which count it as a skipped record.
+const RecordDictExemplars record.Type = 101
+
+// dictExemplarsVersion is the version of the encoding of RecordDictExemplars
+// records, written right after their type. Records of other versions fail to
+// decode.
+const dictExemplarsVersion = 1
+
+// encodeDictExemplars appends a RecordDictExemplars record holding exemplars
+// to b. The distinct label names of the exemplars are written once at the
+// start of the record, and labels refer to them by index.
+func encodeDictExemplars(exemplars []record.RefExemplar, b []byte) []byte {
+	var (
+		names   []string
+		indexes = make(map[string]int)
+	)
+	for _, e := range exemplars {
+		e.Labels.Range(func(l labels.Label) {
+			if _, ok := indexes[l.Name]; !ok {
+				indexes[l.Name] = len(names)
+				names = append(names, l.Name)
+			}
+		})
+	}
+
+	buf := encoding.Encbuf{B: b}
+	buf.PutByte(byte(RecordDictExemplars))
+	buf.PutByte(dictExemplarsVersion)
+	buf.PutUvarint(len(names))
+	for _, name := range names {
+		buf.PutUvarintStr(name)
+	}
+
+	// Refs and timestamps are encoded as deltas from the previous exemplar to
+	// keep the record small.
+	buf.PutUvarint(len(exemplars))
+	var prevRef chunks.HeadSeriesRef
+	var prevT int64
+	for _, e := range exemplars {
+		buf.PutVarint64(int64(e.Ref) - int64(prevRef))
+		buf.PutVarint64(e.T - prevT)
+		buf.PutBE64(math.Float64bits(e.V))
+		prevRef, prevT = e.Ref, e.T
+
+		buf.PutUvarint(e.Labels.Len())
+		e.Labels.Range(func(l labels.Label) {
+			buf.PutUvarint(indexes[l.Name])
+			buf.PutUvarintStr(l.Value)
+		})
+	}
+	return buf.Get()
+}
+
+// DecodeDictExemplars decodes a RecordDictExemplars record and appends the
+// exemplars it holds to dst.
+func DecodeDictExemplars(rec []byte, dst []record.RefExemplar) ([]record.RefExemplar, error) {
+	dec := encoding.Decbuf{B: rec}
+	if t := record.Type(dec.Byte()); t != RecordDictExemplars {
+		return nil, fmt.Errorf("invalid record type %v", t)
+	}
+	if v := dec.Byte(); dec.Err() == nil && v != dictExemplarsVersion {
+		return nil, fmt.Errorf("unsupported dictionary exemplars version %d", v)
+	}
+
+	names := make([]string, dec.Uvarint())
+	for i := range names {
+		names[i] = dec.UvarintStr()
+	}
+
+	n := dec.Uvarint()
+	var prevRef, prevT int64
+	b := labels.NewScratchBuilder(0)
+	for i := 0; i < n && dec.Err() == nil; i++ {
+		prevRef += dec.Varint64()
+		prevT += dec.Varint64()
+		v := math.Float64frombits(dec.Be64())
+
+		b.Reset()
+		numLabels := dec.Uvarint()
+		for j := 0; j < numLabels && dec.Err() == nil; j++ {
+			idx := dec.Uvarint()
+			value := dec.UvarintStr()
+			if idx >= len(names) {
+				return nil, fmt.Errorf("exemplar label name index %d out of range of %d names", idx, len(names))
+			}
+			b.Add(names[idx], value)
+		}
+
+		dst = append(dst, record.RefExemplar{
+			Ref:    chunks.HeadSeriesRef(prevRef),
+			T:      prevT,
+			V:      v,
+			Labels: b.Labels(),
+		})
+	}
+
+	if dec.Err() != nil {
+		return nil, fmt.Errorf("decode error after %d exemplars: %w", len(dst), dec.Err())
+	}
+	if len(dec.B) > 0 {
+		return nil, fmt.Errorf("unexpected %d bytes left in entry", len(dec.B))
+	}
+	return dst, nil
+}
+
+// isDictExemplarsRecord returns true if rec is a RecordDictExemplars record.
+func isDictExemplarsRecord(rec []byte) bool {
+	return len(rec) > 0 && record.Type(rec[0]) == RecordDictExemplars
+}
+
+// recordType returns the type of rec, reporting RecordDictExemplars records
+// as record.Exemplars ones so that they're handled like regular exemplars.
+func recordType(dec *record.Decoder, rec []byte) record.Type {
+	if isDictExemplarsRecord(rec) {
+		return record.Exemplars
+	}
+	return dec.Type(rec)
+}
+
+// decodeExemplars decodes an exemplars record, whether it's a
+// RecordDictExemplars record or a regular one, and appends its exemplars to
+// dst.
+func decodeExemplars(dec *record.Decoder, rec []byte, dst []record.RefExemplar) ([]record.RefExemplar, error) {
+	if isDictExemplarsRecord(rec) {
+		return DecodeDictExemplars(rec, dst)
+	}
+	return dec.Exemplars(rec, dst)
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// ForEachExemplar calls fn for every exemplar held in memory with a timestamp
+// within [mint, maxt]. Only the latest exemplar of each series is held in
+// memory. Iteration stops early if fn returns false. Exemplars aren't visited
+// in any particular order.
+func (w *Storage) ForEachExemplar(mint, maxt int64, fn func(ref storage.SeriesRef, e exemplar.Exemplar) bool) {
+	type entry struct {
+		ref chunks.HeadSeriesRef
+		e   exemplar.Exemplar
+	}
+
+	var entries []entry
+	for i := 0; i < w.series.size; i++ {
+		// Copy the exemplars of the stripe so fn isn't called with the stripe
+		// lock held, which would deadlock if fn appends to the storage.
+		entries = entries[:0]
+		w.series.locks[i].RLock()
+		for ref, e := range w.series.exemplars[i] {
+			if e.Ts >= mint && e.Ts <= maxt {
+				entries = append(entries, entry{ref: ref, e: *e})
+			}
+		}
+		w.series.locks[i].RUnlock()
+
+		for _, en := range entries {
+			if !fn(storage.SeriesRef(en.ref), en.e) {
+				return
+			}
+		}
+	}
+}
+
+// sampleExemplar returns true if the exemplar e of series s is kept by
+// Options.ExemplarSampleRatio. The decision only depends on the labels of s
+// and on e, so it's the same for every append of e.
+func sampleExemplar(s *memSeries, e exemplar.Exemplar, ratio float64) bool {
+	if ratio <= 0 || ratio >= 1 {
+		return true
+	}
+
+	var b [32]byte
+	binary.LittleEndian.PutUint64(b[0:], s.lset.Hash())
+	binary.LittleEndian.PutUint64(b[8:], uint64(e.Ts))
+	binary.LittleEndian.PutUint64(b[16:], math.Float64bits(e.Value))
+	binary.LittleEndian.PutUint64(b[24:], e.Labels.Hash())
+	return float64(xxhash.Sum64(b[:])) < ratio*math.MaxUint64
+}
@@ -0,0 +1,250 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// exportBlockDuration is the time range of the blocks written by
+// ExportBlocks, which is the range of the blocks written by Prometheus.
+const exportBlockDuration = tsdb.DefaultBlockDuration
+
+// ExportBlocks writes the samples of the WAL in the window [mint, maxt] to
+// Prometheus TSDB blocks in dir, which can be loaded by Prometheus or Mimir
+// directly. The WAL is replayed from its latest checkpoint, so samples which
+// were truncated aren't exported. Exemplars and metadata aren't exported.
+//
+// Like promtool tsdb create-blocks-from, samples are split into blocks
+// aligned to 2h ranges. The WAL is replayed once per range, and the blocks of
+// a range are written before the next one is replayed, so that only the
+// samples of one range are held in memory at once.
+//
+// Blocks don't hold out-of-order samples, so a sample older than or as old
+// as a sample of the same series exported before it is written to a separate
+// block of its range. Exporting a WAL written in order produces a single
+// block per range. It's the caller's responsibility to ensure that dir
+// doesn't hold blocks overlapping the exported ones.
+func (w *Storage) ExportBlocks(dir string, mint, maxt int64) error {
+	if mint > maxt {
+		return fmt.Errorf("invalid export window [%d, %d]", mint, maxt)
+	}
+
+	// Truncation deletes the segments being replayed.
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return ErrWALClosed
+	}
+
+	first, last, ok, err := sampleRange(w.wal.Dir(), mint, maxt)
+	if err != nil {
+		return fmt.Errorf("replay WAL: %w", err)
+	} else if !ok {
+		return nil
+	}
+
+	offset := first % exportBlockDuration
+	if offset < 0 {
+		offset += exportBlockDuration
+	}
+	for start := first - offset; ; start += exportBlockDuration {
+		end := start + exportBlockDuration - 1
+		if err := w.exportRange(dir, max(start, first), min(end, last)); err != nil {
+			return err
+		}
+		if end >= last {
+			return nil
+		}
+	}
+}
+
+// exportRange writes the samples of the WAL in the window [mint, maxt], which
+// is within a single block range, to blocks in dir.
+func (w *Storage) exportRange(dir string, mint, maxt int64) error {
+	e := &blockExporter{
+		w:         w,
+		dir:       dir,
+		mint:      mint,
+		maxt:      maxt,
+		blockSize: 2 * exportBlockDuration,
+		series:    make(map[chunks.HeadSeriesRef]labels.Labels),
+	}
+	defer e.close()
+
+	if err := ReplayOrdered(w.wal.Dir(), e.export); err != nil {
+		return fmt.Errorf("replay WAL: %w", err)
+	}
+	return e.flush()
+}
+
+// sampleRange returns the timestamps of the oldest and newest samples of the
+// WAL in dir within the window [mint, maxt]. ok is false if there's none.
+func sampleRange(dir string, mint, maxt int64) (first, last int64, ok bool, err error) {
+	first, last = math.MaxInt64, math.MinInt64
+	observe := func(t int64) {
+		if t < mint || t > maxt {
+			return
+		}
+		first, last = min(first, t), max(last, t)
+		ok = true
+	}
+	err = ReplayOrdered(dir, func(rec OrderedRecord) error {
+		for _, s := range rec.Samples {
+			observe(s.T)
+		}
+		for _, h := range rec.Histograms {
+			observe(h.T)
+		}
+		for _, fh := range rec.FloatHistograms {
+			observe(fh.T)
+		}
+		return nil
+	})
+	return first, last, ok, err
+}
+
+type blockExporter struct {
+	w          *Storage
+	dir        string
+	mint, maxt int64
+	blockSize  int64
+
+	// series holds the labels of the series of the WAL by ref.
+	series map[chunks.HeadSeriesRef]labels.Labels
+	// blocks are the blocks being written, the first one holding the samples
+	// in order and each of the next ones the samples out of order with the
+	// previous block.
+	blocks []*exportBlock
+}
+
+// exportBlock is a block written by ExportBlocks.
+type exportBlock struct {
+	writer *tsdb.BlockWriter
+	app    storage.Appender
+	// latest holds the timestamp of the latest sample of each series of the
+	// block.
+	latest map[chunks.HeadSeriesRef]int64
+}
+
+// export appends the samples of rec to the blocks.
+func (e *blockExporter) export(rec OrderedRecord) error {
+	switch rec.Type {
+	case record.Series:
+		for _, s := range rec.Series {
+			e.series[s.Ref] = s.Labels
+		}
+	case record.Samples:
+		for _, s := range rec.Samples {
+			err := e.append(s.Ref, s.T, func(app storage.Appender, l labels.Labels) error {
+				_, err := app.Append(0, l, s.T, s.V)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case record.HistogramSamples:
+		for _, h := range rec.Histograms {
+			err := e.append(h.Ref, h.T, func(app storage.Appender, l labels.Labels) error {
+				_, err := app.AppendHistogram(0, l, h.T, h.H, nil)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case record.FloatHistogramSamples:
+		for _, fh := range rec.FloatHistograms {
+			err := e.append(fh.Ref, fh.T, func(app storage.Appender, l labels.Labels) error {
+				_, err := app.AppendHistogram(0, l, fh.T, nil, fh.FH)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// append appends the sample of the series ref at t with fn to the first
+// block in which it isn't out of order. Samples outside of the export window
+// and samples of unknown series are skipped.
+func (e *blockExporter) append(ref chunks.HeadSeriesRef, t int64, fn func(app storage.Appender, l labels.Labels) error) error {
+	if t < e.mint || t > e.maxt {
+		return nil
+	}
+	l, ok := e.series[ref]
+	if !ok {
+		return nil
+	}
+
+	block, err := e.blockFor(ref, t)
+	if err != nil {
+		return err
+	}
+	if err := fn(block.app, l); err != nil {
+		return fmt.Errorf("append sample of %s at %d: %w", l, t, err)
+	}
+	block.latest[ref] = t
+	return nil
+}
+
+// blockFor returns the first block in which a sample of the series ref at t
+// isn't out of order, creating it if needed.
+func (e *blockExporter) blockFor(ref chunks.HeadSeriesRef, t int64) (*exportBlock, error) {
+	for _, block := range e.blocks {
+		if latest, ok := block.latest[ref]; !ok || t > latest {
+			return block, nil
+		}
+	}
+
+	writer, err := tsdb.NewBlockWriter(e.w.logger, e.dir, e.blockSize)
+	if err != nil {
+		return nil, fmt.Errorf("create block writer: %w", err)
+	}
+	block := &exportBlock{
+		writer: writer,
+		app:    writer.Appender(context.Background()),
+		latest: make(map[chunks.HeadSeriesRef]int64),
+	}
+	e.blocks = append(e.blocks, block)
+	return block, nil
+}
+
+// flush commits the samples of the blocks and writes them to disk.
+func (e *blockExporter) flush() error {
+	for _, block := range e.blocks {
+		if err := block.app.Commit(); err != nil {
+			return fmt.Errorf("commit block samples: %w", err)
+		}
+		if _, err := block.writer.Flush(context.Background()); err != nil {
+			return fmt.Errorf("write block: %w", err)
+		}
+	}
+	return nil
+}
+
+// close releases the resources of the block writers.
+func (e *blockExporter) close() {
+	var errs []error
+	for _, block := range e.blocks {
+		errs = append(errs, block.writer.Close())
+	}
+	if err := errors.Join(errs...); err != nil {
+		level.Warn(e.w.logger).Log("msg", "failed to close block writers", "err", err)
+	}
+}
@@ -0,0 +1,54 @@
+package wal
+
+import (
+	"sync"
+	"time"
+)
+
+// groupCommitter makes commits wait for their data to be synced to disk. The
+// commits waiting within the same window share a single sync.
+type groupCommitter struct {
+	window time.Duration
+	sync   func() error
+
+	mut     sync.Mutex
+	pending *syncGroup
+}
+
+// syncGroup is a set of commits waiting for the same sync.
+type syncGroup struct {
+	done chan struct{}
+	err  error
+}
+
+func newGroupCommitter(window time.Duration, sync func() error) *groupCommitter {
+	return &groupCommitter{window: window, sync: sync}
+}
+
+// wait blocks until the data written before it was called is synced to disk.
+// The first commit of a group schedules a sync once the window elapsed, and
+// the commits arriving in the meantime join its group.
+func (g *groupCommitter) wait() error {
+	g.mut.Lock()
+	group := g.pending
+	if group == nil {
+		group = &syncGroup{done: make(chan struct{})}
+		g.pending = group
+		time.AfterFunc(g.window, func() { g.flush(group) })
+	}
+	g.mut.Unlock()
+
+	<-group.done
+	return group.err
+}
+
+// flush syncs the data of group. Commits calling wait after the group is
+// flushed join the next group, as their data may not be covered by the sync.
+func (g *groupCommitter) flush(group *syncGroup) {
+	g.mut.Lock()
+	g.pending = nil
+	g.mut.Unlock()
+
+	group.err = g.sync()
+	close(group.done)
+}
@@ -0,0 +1,193 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// RecordIdempotencyTokens is the type of the WAL records holding the
+// idempotency tokens of commits, see [Storage.IdempotentAppender]. They're
+// replayed on startup and kept in checkpoints, so that a commit retried
+// after a restart is still deduplicated.
+//
+// Like RecordSampleSources, Prometheus doesn't know about this record type
+// and the remote write watcher skips it.
+const RecordIdempotencyTokens record.Type = 102
+
+// tokenSet is a bounded set of idempotency tokens. Once full, the oldest
+// token is evicted when a new one is added.
+type tokenSet struct {
+	mut    sync.Mutex
+	max    int
+	tokens map[string]struct{}
+	order  []string // Tokens in insertion order, used as a ring buffer.
+	next   int      // Index in order of the next token to evict.
+
+	// pending holds the tokens of the commits being written, closing their
+	// channel once the commit is done.
+	pending map[string]chan struct{}
+}
+
+func newTokenSet(max int) *tokenSet {
+	return &tokenSet{
+		max:    max,
+		tokens: make(map[string]struct{}, max),
+		order:  make([]string, 0, max),
+
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+func (s *tokenSet) contains(token string) bool {
+	_, ok := s.tokens[token]
+	return ok
+}
+
+func (s *tokenSet) add(token string) {
+	if s.max <= 0 || s.contains(token) {
+		return
+	}
+
+	if len(s.order) < s.max {
+		s.order = append(s.order, token)
+	} else {
+		delete(s.tokens, s.order[s.next])
+		s.order[s.next] = token
+		s.next = (s.next + 1) % s.max
+	}
+	s.tokens[token] = struct{}{}
+}
+
+// list returns the tokens of s, from the oldest to the most recent.
+func (s *tokenSet) list() []string {
+	res := make([]string, 0, len(s.order))
+	res = append(res, s.order[s.next:]...)
+	return append(res, s.order[:s.next]...)
+}
+
+// reserve reserves token for a commit. It returns false if token has
+// already been committed. If another commit with the same token is being
+// written, reserve waits for it to be done first.
+//
+// The caller must call release once the commit is done.
+func (s *tokenSet) reserve(token string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for {
+		if s.contains(token) {
+			return false
+		}
+		done, ok := s.pending[token]
+		if !ok {
+			break
+		}
+		s.mut.Unlock()
+		<-done
+		s.mut.Lock()
+	}
+	s.pending[token] = make(chan struct{})
+	return true
+}
+
+// release releases a token reserved with reserve, adding it to s if the
+// commit succeeded.
+func (s *tokenSet) release(token string, committed bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if committed {
+		s.add(token)
+	}
+	close(s.pending[token])
+	delete(s.pending, token)
+}
+
+// encodeIdempotencyTokens appends a RecordIdempotencyTokens record holding
+// tokens to b.
+func encodeIdempotencyTokens(tokens []string, b []byte) []byte {
+	buf := encoding.Encbuf{B: b}
+	buf.PutByte(byte(RecordIdempotencyTokens))
+	buf.PutUvarint(len(tokens))
+	for _, t := range tokens {
+		buf.PutUvarintStr(t)
+	}
+	return buf.Get()
+}
+
+// DecodeIdempotencyTokens decodes a RecordIdempotencyTokens record and
+// appends the tokens it holds to dst.
+func DecodeIdempotencyTokens(rec []byte, dst []string) ([]string, error) {
+	dec := encoding.Decbuf{B: rec}
+	if t := record.Type(dec.Byte()); t != RecordIdempotencyTokens {
+		return nil, fmt.Errorf("invalid record type %v", t)
+	}
+
+	n := dec.Uvarint()
+	for i := 0; i < n && dec.Err() == nil; i++ {
+		dst = append(dst, dec.UvarintStr())
+	}
+
+	if dec.Err() != nil {
+		return nil, fmt.Errorf("decode error after %d idempotency tokens: %w", len(dst), dec.Err())
+	}
+	if len(dec.B) > 0 {
+		return nil, fmt.Errorf("unexpected %d bytes left in entry", len(dec.B))
+	}
+	return dst, nil
+}
+
+// isIdempotencyTokensRecord returns true if rec is a RecordIdempotencyTokens
+// record.
+func isIdempotencyTokensRecord(rec []byte) bool {
+	return len(rec) > 0 && record.Type(rec[0]) == RecordIdempotencyTokens
+}
+
+// IdempotentAppender returns a new appender against the storage whose commit
+// is identified by token. Committing an appender with a token which has
+// already been committed is a no-op: its samples are discarded as if it was
+// rolled back. Only the last Options.MaxIdempotencyTokens tokens are
+// remembered.
+//
+// The token is written to the WAL after the samples of the commit, so that
+// it's remembered across restarts. If the process crashes after the samples
+// were written but before the token was, a retried commit writes its samples
+// again: commits are deduplicated at least once, not exactly once.
+//
+// An empty token returns a regular appender.
+func (w *Storage) IdempotentAppender(ctx context.Context, token string) storage.Appender {
+	app := w.Appender(ctx).(*appender)
+	app.token = token
+	return app
+}
+
+// commitWithToken commits a, unless its token has already been committed.
+func (a *appender) commitWithToken() (CommitStats, error) {
+	// The token is reserved rather than holding the lock of the token set
+	// while writing, so that commits with other tokens aren't serialized
+	// behind the sync of the WAL.
+	tokens := a.w.tokens
+	if !tokens.reserve(a.token) {
+		a.w.metrics.totalDuplicateCommits.Inc()
+		return CommitStats{}, a.Rollback()
+	}
+
+	stats, err := a.log()
+	tokens.release(a.token, err == nil)
+	if err != nil {
+		return CommitStats{}, err
+	}
+
+	if a.w.notifier != nil {
+		a.w.notifier.Notify()
+	}
+
+	a.clearData()
+	a.w.appenderPool.Put(a)
+	return stats, nil
+}
@@ -0,0 +1,82 @@
+package wal
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// symbolTable interns the label names and values of the series, so that
+// series sharing a name or value share a single copy of its string. Strings
+// are reference counted, and dropped once no series uses them anymore.
+type symbolTable struct {
+	mut     sync.Mutex
+	symbols map[string]symbol
+}
+
+// symbol is an interned string and its number of references. The string is
+// kept in the value since map lookups don't return the stored key.
+type symbol struct {
+	s    string
+	refs int64
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{symbols: make(map[string]symbol)}
+}
+
+// intern returns labels equal to l, whose names and values are the interned
+// copies of their strings. Each call must be balanced by a call to release
+// with the returned labels once they're not used anymore.
+func (t *symbolTable) intern(l labels.Labels) labels.Labels {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	b := labels.NewScratchBuilder(l.Len())
+	l.Range(func(lbl labels.Label) {
+		b.Add(t.internString(lbl.Name), t.internString(lbl.Value))
+	})
+	return b.Labels()
+}
+
+func (t *symbolTable) internString(s string) string {
+	sym, ok := t.symbols[s]
+	if !ok {
+		sym.s = s
+	}
+	sym.refs++
+	t.symbols[sym.s] = sym
+	return sym.s
+}
+
+// release drops the references of l, which must have been returned by
+// intern, to its strings.
+func (t *symbolTable) release(l labels.Labels) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	l.Range(func(lbl labels.Label) {
+		t.releaseString(lbl.Name)
+		t.releaseString(lbl.Value)
+	})
+}
+
+func (t *symbolTable) releaseString(s string) {
+	sym, ok := t.symbols[s]
+	if !ok {
+		return
+	}
+	sym.refs--
+	if sym.refs <= 0 {
+		delete(t.symbols, s)
+		return
+	}
+	t.symbols[s] = sym
+}
+
+// len returns the number of interned strings.
+func (t *symbolTable) len() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return len(t.symbols)
+}
@@ -0,0 +1,146 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+const (
+	// ManifestFilename is the name of the manifest written to the WAL
+	// directory when Options.WriteManifest is set.
+	ManifestFilename = "manifest.json"
+
+	// ManifestVersion is the version of the WAL format described by the
+	// manifests written by this package.
+	ManifestVersion = 1
+)
+
+// Manifest describes a WAL directory, so that tools can inspect it without
+// reading its segments.
+type Manifest struct {
+	// Version is the version of the WAL format.
+	Version int `json:"version"`
+	// CreatedAt is the time the manifest was first written to the WAL
+	// directory.
+	CreatedAt time.Time `json:"created_at"`
+	// AgentID is the Options.AgentID of the storage writing the WAL.
+	AgentID string `json:"agent_id,omitempty"`
+	// FirstSegment and LastSegment are the range of segments in the WAL
+	// directory. LastSegment is the segment being written.
+	FirstSegment int `json:"first_segment"`
+	LastSegment  int `json:"last_segment"`
+}
+
+// ReadManifest reads the manifest of the WAL directory dir.
+func ReadManifest(dir string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFilename))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("decode manifest: %w", err)
+	}
+	return m, nil
+}
+
+// writeManifest atomically replaces the manifest of the WAL directory dir
+// with m, so that readers and crashes never observe a partial manifest.
+func writeManifest(dir string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, ManifestFilename+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temporary manifest: %w", err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write temporary manifest: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync temporary manifest: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temporary manifest: %w", err)
+	}
+	return fileutil.Replace(tmp, filepath.Join(dir, ManifestFilename))
+}
+
+// updateManifest writes the manifest of the WAL with its current segment
+// range, if Options.WriteManifest is set. The creation time of an existing
+// manifest is kept.
+func (w *Storage) updateManifest() error {
+	if !w.options().WriteManifest {
+		return nil
+	}
+
+	first, last, err := wlog.Segments(w.wal.Dir())
+	if err != nil {
+		return fmt.Errorf("get segment range: %w", err)
+	}
+
+	w.manifestMtx.Lock()
+	defer w.manifestMtx.Unlock()
+
+	if w.manifest.CreatedAt.IsZero() {
+		existing, err := ReadManifest(w.wal.Dir())
+		switch {
+		case err == nil && !existing.CreatedAt.IsZero():
+			w.manifest.CreatedAt = existing.CreatedAt
+		case err != nil && !errors.Is(err, fs.ErrNotExist):
+			level.Warn(w.logger).Log("msg", "ignoring unreadable WAL manifest", "err", err)
+			fallthrough
+		default:
+			w.manifest.CreatedAt = w.now().UTC()
+		}
+	}
+
+	m := w.manifest
+	m.Version = ManifestVersion
+	m.AgentID = w.options().AgentID
+	m.FirstSegment, m.LastSegment = first, last
+	if err := writeManifest(w.wal.Dir(), m); err != nil {
+		return err
+	}
+	w.manifest = m
+	return nil
+}
+
+// updateManifestOnRotation updates the manifest of the WAL if the segment
+// being written changed since it was last written.
+func (w *Storage) updateManifestOnRotation() {
+	if !w.options().WriteManifest {
+		return
+	}
+
+	seg, _, err := w.writer.LastSegmentAndOffset()
+	if err != nil {
+		return
+	}
+	w.manifestMtx.Lock()
+	rotated := seg != w.manifest.LastSegment
+	w.manifestMtx.Unlock()
+	if !rotated {
+		return
+	}
+
+	if err := w.updateManifest(); err != nil {
+		level.Warn(w.logger).Log("msg", "failed to update WAL manifest", "err", err)
+	}
+}
@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"unsafe"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// MemStats holds an approximation of the memory used by a Storage, in bytes.
+type MemStats struct {
+	// SeriesBytes is the memory used by the in-memory series, including their
+	// labels and the maps indexing them.
+	SeriesBytes int64
+	// ExemplarBytes is the memory used by the latest exemplar kept for each
+	// series.
+	ExemplarBytes int64
+	// PendingBytes is the memory used by the buffers of appenders which have
+	// not been committed or rolled back yet.
+	PendingBytes int64
+}
+
+// Total returns the sum of all the estimates in m.
+func (m MemStats) Total() int64 {
+	return m.SeriesBytes + m.ExemplarBytes + m.PendingBytes
+}
+
+// Per-entry size estimates. They intentionally ignore allocator and map
+// bucket details; the goal is a cheap approximation which grows linearly with
+// the amount of data held.
+const (
+	pointerBytes    = int64(unsafe.Sizeof(uintptr(0)))
+	mapEntryBytes   = 4 * pointerBytes
+	labelEntryBytes = int64(unsafe.Sizeof(labels.Label{}))
+)
+
+var (
+	// A series is stored in both the ref map and the hash map.
+	seriesBytes   = int64(unsafe.Sizeof(memSeries{})) + 2*mapEntryBytes + pointerBytes
+	exemplarBytes = int64(unsafe.Sizeof(exemplar.Exemplar{})) + mapEntryBytes
+
+	// Pending samples are also tracked by a pointer to their series.
+	pendingSeriesBytes         = int64(unsafe.Sizeof(record.RefSeries{}))
+	pendingSampleBytes         = int64(unsafe.Sizeof(record.RefSample{})) + pointerBytes
+	pendingExemplarBytes       = int64(unsafe.Sizeof(record.RefExemplar{}))
+	pendingHistogramBytes      = int64(unsafe.Sizeof(record.RefHistogramSample{})) + pointerBytes
+	pendingFloatHistogramBytes = int64(unsafe.Sizeof(record.RefFloatHistogramSample{})) + pointerBytes
+)
+
+// MemoryEstimate returns an approximation of the memory used by the series,
+// the exemplars, and the pending appender buffers of the storage. The
+// estimate is computed from counters and doesn't inspect the stored data.
+func (w *Storage) MemoryEstimate() MemStats {
+	return MemStats{
+		SeriesBytes:   w.series.numSeries.Load()*seriesBytes + w.series.labelBytes.Load(),
+		ExemplarBytes: w.series.numExemplars.Load() * exemplarBytes,
+		PendingBytes:  w.pendingBytes.Load(),
+	}
+}
+
+// pendingSize returns the estimated memory used by the pending buffers of a.
+func (a *appender) pendingSize() int64 {
+	return int64(len(a.pendingSeries))*pendingSeriesBytes +
+		int64(len(a.pendingSamples))*pendingSampleBytes +
+		int64(len(a.pendingExamplars))*pendingExemplarBytes +
+		int64(len(a.pendingHistograms))*pendingHistogramBytes +
+		int64(len(a.pendingFloatHistograms))*pendingFloatHistogramBytes
+}
+
+// labelsSize returns the estimated memory used by ls.
+func labelsSize(ls labels.Labels) int64 {
+	size := int64(ls.Len()) * labelEntryBytes
+	ls.Range(func(l labels.Label) {
+		size += int64(len(l.Name) + len(l.Value))
+	})
+	return size
+}
@@ -0,0 +1,255 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// MergeConflict is a sample dropped by Merge because a sample of the same
+// series and timestamp, but with a different value, was merged before it.
+type MergeConflict struct {
+	Labels    labels.Labels
+	Timestamp int64
+	// Source is the WAL directory of the dropped sample.
+	Source string
+}
+
+// MergeConflictError is returned by Merge when it dropped conflicting
+// samples. The merged WAL is still written.
+type MergeConflictError struct {
+	Conflicts []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d conflicting samples were dropped", len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		if i == 10 {
+			fmt.Fprintf(&sb, ", and %d more", len(e.Conflicts)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "; %s at %d from %s", c.Labels, c.Timestamp, c.Source)
+	}
+	return sb.String()
+}
+
+// Merge replays the WAL directories srcs in order, starting from their latest
+// checkpoint, and writes their records into a new WAL in dst, which must not
+// hold any segment.
+//
+// Series with the same labels are deduplicated, and series are assigned new
+// refs so that the refs of different sources don't collide. Samples of the
+// same series and timestamp are only written once. If they have different
+// values, the first one merged is kept and the others are reported by a
+// *MergeConflictError. Sample sources are not merged.
+func Merge(dst string, srcs ...string) error {
+	if _, last, err := wlog.Segments(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("list segments of %s: %w", dst, err)
+	} else if err == nil && last >= 0 {
+		return fmt.Errorf("%s already holds a WAL", dst)
+	}
+
+	out, err := wlog.NewSize(nil, nil, dst, wlog.DefaultSegmentSize, wlog.CompressionSnappy)
+	if err != nil {
+		return fmt.Errorf("create merged WAL: %w", err)
+	}
+
+	m := &merger{
+		out:     out,
+		series:  make(map[uint64][]record.RefSeries),
+		samples: make(map[mergeKey]mergedSample),
+	}
+	for _, src := range srcs {
+		m.src = src
+		m.refs = make(map[chunks.HeadSeriesRef]record.RefSeries)
+		if err := ReplayOrdered(src, m.merge); err != nil {
+			_ = out.Close()
+			return fmt.Errorf("merge %s: %w", src, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close merged WAL: %w", err)
+	}
+
+	if len(m.conflicts) > 0 {
+		return &MergeConflictError{Conflicts: m.conflicts}
+	}
+	return nil
+}
+
+// mergeKey identifies a sample of the merged WAL.
+type mergeKey struct {
+	ref chunks.HeadSeriesRef
+	t   int64
+}
+
+// mergedSample is the value of a merged sample, only one of which is set
+// depending on its type.
+type mergedSample struct {
+	v  float64
+	h  *histogram.Histogram
+	fh *histogram.FloatHistogram
+}
+
+// equal returns true if s and other have the same type and value. Float
+// values are compared by their bits, so that NaNs such as staleness markers
+// are equal.
+func (s mergedSample) equal(other mergedSample) bool {
+	switch {
+	case s.h != nil || other.h != nil:
+		return s.h != nil && other.h != nil && s.h.Equals(other.h)
+	case s.fh != nil || other.fh != nil:
+		return s.fh != nil && other.fh != nil && s.fh.Equals(other.fh)
+	default:
+		return math.Float64bits(s.v) == math.Float64bits(other.v)
+	}
+}
+
+type merger struct {
+	out *wlog.WL
+	buf []byte
+
+	// series holds the series of the merged WAL by the hash of their labels.
+	series  map[uint64][]record.RefSeries
+	nextRef chunks.HeadSeriesRef
+	samples map[mergeKey]mergedSample
+
+	// src is the WAL being merged, and refs maps its series refs to the
+	// series of the merged WAL.
+	src  string
+	refs map[chunks.HeadSeriesRef]record.RefSeries
+
+	conflicts []MergeConflict
+}
+
+// merge writes rec to the merged WAL, translating its refs.
+func (m *merger) merge(rec OrderedRecord) error {
+	var enc record.Encoder
+	m.buf = m.buf[:0]
+
+	switch rec.Type {
+	case record.Series:
+		var created []record.RefSeries
+		for _, s := range rec.Series {
+			merged, isNew := m.getOrCreate(s.Labels)
+			m.refs[s.Ref] = merged
+			if isNew {
+				created = append(created, merged)
+			}
+		}
+		if len(created) == 0 {
+			return nil
+		}
+		m.buf = enc.Series(created, m.buf)
+
+	case record.Samples:
+		var samples []record.RefSample
+		for _, s := range rec.Samples {
+			series, ok := m.refs[s.Ref]
+			if !ok {
+				continue
+			}
+			if m.add(series, s.T, mergedSample{v: s.V}) {
+				samples = append(samples, record.RefSample{Ref: series.Ref, T: s.T, V: s.V})
+			}
+		}
+		if len(samples) == 0 {
+			return nil
+		}
+		m.buf = enc.Samples(samples, m.buf)
+
+	case record.HistogramSamples:
+		var histograms []record.RefHistogramSample
+		for _, h := range rec.Histograms {
+			series, ok := m.refs[h.Ref]
+			if !ok {
+				continue
+			}
+			h.Ref = series.Ref
+			if m.add(series, h.T, mergedSample{h: h.H}) {
+				histograms = append(histograms, h)
+			}
+		}
+		if len(histograms) == 0 {
+			return nil
+		}
+		m.buf = enc.HistogramSamples(histograms, m.buf)
+
+	case record.FloatHistogramSamples:
+		var histograms []record.RefFloatHistogramSample
+		for _, fh := range rec.FloatHistograms {
+			series, ok := m.refs[fh.Ref]
+			if !ok {
+				continue
+			}
+			fh.Ref = series.Ref
+			if m.add(series, fh.T, mergedSample{fh: fh.FH}) {
+				histograms = append(histograms, fh)
+			}
+		}
+		if len(histograms) == 0 {
+			return nil
+		}
+		m.buf = enc.FloatHistogramSamples(histograms, m.buf)
+
+	case record.Exemplars:
+		var exemplars []record.RefExemplar
+		for _, e := range rec.Exemplars {
+			series, ok := m.refs[e.Ref]
+			if !ok {
+				continue
+			}
+			e.Ref = series.Ref
+			exemplars = append(exemplars, e)
+		}
+		if len(exemplars) == 0 {
+			return nil
+		}
+		m.buf = enc.Exemplars(exemplars, m.buf)
+
+	default:
+		return nil
+	}
+	return m.out.Log(m.buf)
+}
+
+// getOrCreate returns the series of the merged WAL with the labels l,
+// creating it if it doesn't exist.
+func (m *merger) getOrCreate(l labels.Labels) (record.RefSeries, bool) {
+	hash := l.Hash()
+	for _, s := range m.series[hash] {
+		if labels.Equal(s.Labels, l) {
+			return s, false
+		}
+	}
+
+	m.nextRef++
+	s := record.RefSeries{Ref: m.nextRef, Labels: l}
+	m.series[hash] = append(m.series[hash], s)
+	return s, true
+}
+
+// add records the sample of series at t, and returns true if it must be
+// written. Samples merged before with the same value are skipped, and with a
+// different value are reported as conflicts.
+func (m *merger) add(series record.RefSeries, t int64, sample mergedSample) bool {
+	key := mergeKey{ref: series.Ref, t: t}
+	prev, ok := m.samples[key]
+	if !ok {
+		m.samples[key] = sample
+		return true
+	}
+	if !prev.equal(sample) {
+		m.conflicts = append(m.conflicts, MergeConflict{Labels: series.Labels, Timestamp: t, Source: m.src})
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// OrderedRecord is a record of the WAL replayed by ReplayOrdered. Only the
+// field matching Type is set.
+type OrderedRecord struct {
+	// Type is the type of the record, which is RecordSampleSources for
+	// sample source records.
+	Type record.Type
+	// Segment is the segment the record was read from, or the segment the
+	// checkpoint it was read from was created up to.
+	Segment int
+
+	Series          []record.RefSeries
+	Samples         []record.RefSample
+	Histograms      []record.RefHistogramSample
+	FloatHistograms []record.RefFloatHistogramSample
+	Exemplars       []record.RefExemplar
+	SampleSources   []RefSampleSource
+}
+
+// ReplayOrdered reads the WAL in dir, starting from its latest checkpoint,
+// and calls fn with each of its records in the exact order they were written.
+// Replay stops at the first error returned by fn.
+//
+// Records are written by a commit in order: series, samples, histograms,
+// float histograms, sample sources and exemplars. A commit referencing a
+// series created by another commit which hasn't been written yet writes the
+// series record too, and checkpoints hold their series before anything else.
+// As a result, the series record of a series is always delivered before the
+// samples and exemplars referencing it, unless it was truncated from the WAL.
+// A series can be delivered several times with the same labels, or again with
+// new labels if it was relabeled.
+//
+// Unlike [ReplayRange] and replays through a [Writer], which batch records by
+// type, ReplayOrdered is meant for consumers requiring the write order.
+func ReplayOrdered(dir string, fn func(rec OrderedRecord) error) error {
+	var dec record.Decoder
+	return replayDir(dir, func(r *wlog.Reader, segment int) error {
+		for r.Next() {
+			rec, err := decodeOrderedRecord(&dec, r.Record())
+			if err != nil {
+				return err
+			}
+			if rec.Type == record.Unknown {
+				// Other records don't hold anything to replay.
+				continue
+			}
+			rec.Segment = segment
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return r.Err()
+	})
+}
+
+func decodeOrderedRecord(dec *record.Decoder, rec []byte) (OrderedRecord, error) {
+	var (
+		res OrderedRecord
+		err error
+	)
+	if isSampleSourcesRecord(rec) {
+		res.Type = RecordSampleSources
+		res.SampleSources, err = DecodeSampleSources(rec, nil)
+		if err != nil {
+			return res, fmt.Errorf("decode sample sources: %w", err)
+		}
+		return res, nil
+	}
+
+	res.Type = recordType(dec, rec)
+	switch res.Type {
+	case record.Series:
+		res.Series, err = dec.Series(rec, nil)
+	case record.Samples:
+		res.Samples, err = dec.Samples(rec, nil)
+	case record.HistogramSamples:
+		res.Histograms, err = dec.HistogramSamples(rec, nil)
+	case record.FloatHistogramSamples:
+		res.FloatHistograms, err = dec.FloatHistogramSamples(rec, nil)
+	case record.Exemplars:
+		res.Exemplars, err = decodeExemplars(dec, rec, nil)
+	default:
+		res.Type = record.Unknown
+	}
+	if err != nil {
+		return res, fmt.Errorf("decode %s record: %w", res.Type, err)
+	}
+	return res, nil
+}
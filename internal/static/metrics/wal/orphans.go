@@ -0,0 +1,133 @@
+package wal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// orphanExemplars buffers the exemplars appended before their series exists,
+// until the series is created, see Options.MaxOrphanExemplars.
+type orphanExemplars struct {
+	max int
+	ttl time.Duration
+
+	mut    sync.Mutex
+	count  int
+	byHash map[uint64][]orphanExemplar
+}
+
+type orphanExemplar struct {
+	lset  labels.Labels
+	e     exemplar.Exemplar
+	added time.Time
+}
+
+func newOrphanExemplars(max int, ttl time.Duration) *orphanExemplars {
+	return &orphanExemplars{
+		max:    max,
+		ttl:    ttl,
+		byHash: make(map[uint64][]orphanExemplar),
+	}
+}
+
+// enabled returns true if orphan exemplars are buffered.
+func (o *orphanExemplars) enabled() bool {
+	return o.max > 0
+}
+
+// add buffers e until the series with labels lset is created. It returns
+// false if the buffer is full, even after expiring old orphans.
+func (o *orphanExemplars) add(lset labels.Labels, e exemplar.Exemplar, now time.Time) bool {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if o.count >= o.max {
+		o.expire(now)
+		if o.count >= o.max {
+			return false
+		}
+	}
+
+	hash := lset.Hash()
+	o.byHash[hash] = append(o.byHash[hash], orphanExemplar{lset: lset, e: e, added: now})
+	o.count++
+	return true
+}
+
+// take removes and returns the unexpired exemplars buffered for the series
+// with labels lset, in the order they were added.
+func (o *orphanExemplars) take(lset labels.Labels, now time.Time) []exemplar.Exemplar {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	if o.count == 0 {
+		return nil
+	}
+
+	hash := lset.Hash()
+	orphans := o.byHash[hash]
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	var (
+		res  []exemplar.Exemplar
+		kept = orphans[:0]
+	)
+	for _, orphan := range orphans {
+		switch {
+		case o.expired(orphan, now):
+			o.count--
+		case labels.Equal(orphan.lset, lset):
+			res = append(res, orphan.e)
+			o.count--
+		default:
+			// Hash collision with another series.
+			kept = append(kept, orphan)
+		}
+	}
+	if len(kept) == 0 {
+		delete(o.byHash, hash)
+	} else {
+		o.byHash[hash] = kept
+	}
+	return res
+}
+
+// expire drops the orphans buffered for longer than the TTL. mut must be held
+// by the caller.
+func (o *orphanExemplars) expire(now time.Time) {
+	for hash, orphans := range o.byHash {
+		kept := orphans[:0]
+		for _, orphan := range orphans {
+			if o.expired(orphan, now) {
+				o.count--
+				continue
+			}
+			kept = append(kept, orphan)
+		}
+		if len(kept) == 0 {
+			delete(o.byHash, hash)
+		} else {
+			o.byHash[hash] = kept
+		}
+	}
+}
+
+func (o *orphanExemplars) expired(orphan orphanExemplar, now time.Time) bool {
+	return now.Sub(orphan.added) > o.ttl
+}
+
+// attachOrphanExemplars appends the exemplars buffered for series, which was
+// just created with labels lset.
+func (a *appender) attachOrphanExemplars(series *memSeries, lset labels.Labels) {
+	if !a.w.orphanExemplars.enabled() {
+		return
+	}
+	for _, e := range a.w.orphanExemplars.take(lset, a.w.now()) {
+		a.appendExemplar(series, e)
+	}
+}
@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"os"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// walRecordHeaderSize is the size of the header of each fragment of a record
+// in a WAL page.
+const walRecordHeaderSize = 7
+
+// preallocatingWriter writes records to a WAL when Options.PreallocateSegments
+// is set. It starts a new segment itself when records don't fit in the
+// current one, rather than letting the WAL start one as it writes them, so
+// that every segment is pre-allocated as soon as it's created.
+type preallocatingWriter struct {
+	*wlog.WL
+	segmentSize int
+	logger      log.Logger
+
+	// mtx serializes writes with the rotations they cause.
+	mtx sync.Mutex
+}
+
+// newPreallocatingWriter returns a preallocatingWriter writing to wl, whose
+// segments are segmentSize bytes, and pre-allocates its current segment.
+func newPreallocatingWriter(logger log.Logger, wl *wlog.WL, segmentSize int) (*preallocatingWriter, error) {
+	w := &preallocatingWriter{WL: wl, segmentSize: segmentSize, logger: logger}
+	seg, _, err := wl.LastSegmentAndOffset()
+	if err != nil {
+		return nil, err
+	}
+	w.preallocate(seg)
+	return w, nil
+}
+
+func (w *preallocatingWriter) Log(recs ...[]byte) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	_, offset, err := w.WL.LastSegmentAndOffset()
+	if err != nil {
+		return err
+	}
+
+	// The WAL starts a new segment if a record doesn't fit in the free
+	// space left by the header of each remaining page. Records are
+	// compressed, so their uncompressed size is an upper bound.
+	size := 0
+	for _, rec := range recs {
+		size += len(rec) + walRecordHeaderSize
+	}
+	donePages := offset / walPageSize
+	left := w.segmentSize - offset - walRecordHeaderSize*(w.segmentSize/walPageSize-donePages)
+	if offset > 0 && size > left {
+		if _, err := w.NextSegment(); err != nil {
+			return err
+		}
+	}
+	return w.WL.Log(recs...)
+}
+
+// NextSegment starts a new segment and pre-allocates it.
+func (w *preallocatingWriter) NextSegment() (int, error) {
+	seg, err := w.WL.NextSegment()
+	if err != nil {
+		return 0, err
+	}
+	w.preallocate(seg)
+	return seg, nil
+}
+
+// preallocate pre-allocates the disk space of the segment seg.
+func (w *preallocatingWriter) preallocate(seg int) {
+	if err := preallocateSegment(wlog.SegmentName(w.Dir(), seg), int64(w.segmentSize)); err != nil {
+		level.Warn(w.logger).Log("msg", "failed to pre-allocate WAL segment", "segment", seg, "err", err)
+	}
+}
+
+// preallocateSegment allocates size bytes of disk space to the segment file
+// at path without changing its size, so that the WAL reader doesn't see the
+// allocated space. Filesystems which don't support it are left untouched.
+func preallocateSegment(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0o666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fileutil.Preallocate(f, size, false)
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package wal
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_PreallocateSegments(t *testing.T) {
+	// allocated returns the apparent size of the segment and the disk space
+	// allocated to it.
+	allocated := func(s *Storage, seg int) (int64, int64) {
+		fi, err := os.Stat(wlog.SegmentName(s.wal.Dir(), seg))
+		require.NoError(t, err)
+		return fi.Size(), fi.Sys().(*syscall.Stat_t).Blocks * 512
+	}
+
+	// Small segments are rotated by the writes below.
+	defer func(size int) { walSegmentSize = size }(walSegmentSize)
+	walSegmentSize = 4 * walPageSize
+
+	opts := DefaultOptions()
+	opts.PreallocateSegments = true
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// The segment created on startup is allocated right away, without
+	// changing its size.
+	size, disk := allocated(s, 0)
+	require.Zero(t, size)
+	require.GreaterOrEqual(t, disk, int64(walSegmentSize))
+
+	// So are the next segments, as soon as they're created.
+	seg, err := s.writer.NextSegment()
+	require.NoError(t, err)
+	size, disk = allocated(s, seg)
+	require.Zero(t, size)
+	require.GreaterOrEqual(t, disk, int64(walSegmentSize))
+
+	// Including the segments started because a record doesn't fit in the
+	// current one. Random label values keep the records from compressing
+	// into a single segment.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		app := s.Appender(t.Context())
+		for j := 0; j < 100; j++ {
+			_, err = app.Append(0, labels.FromStrings("__name__", "foo", "id", strconv.FormatUint(r.Uint64(), 36)), 1, 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+	}
+	first, last, err := wlog.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Greater(t, last, seg)
+	for seg := first; seg <= last; seg++ {
+		size, disk := allocated(s, seg)
+		require.LessOrEqual(t, size, int64(walSegmentSize))
+		require.GreaterOrEqual(t, disk, int64(walSegmentSize))
+	}
+
+	// Segments aren't allocated without the option.
+	s2, err := NewStorage(log.NewNopLogger(), nil, t.TempDir())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s2.Close())
+	}()
+	_, disk = allocated(s2, 0)
+	require.Less(t, disk, int64(walSegmentSize))
+}
@@ -0,0 +1,92 @@
+package wal
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateWindow counts events per second over a sliding window, in a ring of
+// per-second buckets.
+type rateWindow struct {
+	mut     sync.Mutex
+	buckets []int64
+	// last is the Unix second of the most recent bucket, and first the Unix
+	// second of the first event counted.
+	last, first int64
+}
+
+func newRateWindow(window time.Duration) *rateWindow {
+	seconds := int(math.Ceil(window.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	// The bucket of the current second is still being filled, so keep one
+	// more bucket than the window.
+	return &rateWindow{buckets: make([]int64, seconds+1), first: -1}
+}
+
+// add counts n events at now.
+func (r *rateWindow) add(now time.Time, n int) {
+	if n == 0 {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	sec := now.Unix()
+	if sec <= r.last-int64(len(r.buckets)) {
+		// The clock went back further than the window.
+		return
+	}
+	r.advance(sec)
+	if r.first < 0 {
+		r.first = sec
+	}
+	r.buckets[sec%int64(len(r.buckets))] += int64(n)
+}
+
+// rate returns the events per second over the complete seconds of the window
+// before now. Until the window is filled, the rate is computed over the
+// seconds elapsed since the first event.
+func (r *rateWindow) rate(now time.Time) float64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	sec := now.Unix()
+	r.advance(sec)
+	if r.first < 0 || sec <= r.first {
+		return 0
+	}
+
+	window := int64(len(r.buckets) - 1)
+	seconds := min(sec-r.first, window)
+
+	var total int64
+	for s := sec - seconds; s < sec; s++ {
+		total += r.buckets[s%int64(len(r.buckets))]
+	}
+	return float64(total) / float64(seconds)
+}
+
+// advance clears the buckets of the seconds between the last bucket and sec,
+// which are reused from seconds that left the window. mut must be held by the
+// caller.
+func (r *rateWindow) advance(sec int64) {
+	if sec <= r.last {
+		return
+	}
+	n := int64(len(r.buckets))
+	for s := max(r.last+1, sec-n+1); s <= sec; s++ {
+		r.buckets[s%n] = 0
+	}
+	r.last = sec
+}
+
+// AppendRate returns the samples committed per second over the last
+// Options.AppendRateWindow, excluding the current second. It's meant for
+// adaptive throttling, without scraping the storage metrics.
+func (w *Storage) AppendRate() (samplesPerSec float64) {
+	return w.appendRate.rate(w.now())
+}
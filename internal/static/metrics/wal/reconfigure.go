@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRestartRequired is returned by Reconfigure when options which can only
+// be set when the storage is created are changed.
+var ErrRestartRequired = errors.New("options can't be changed without restarting the storage")
+
+// restartOptions are the options which size or create internal state of the
+// storage when it's created, and can't be changed by Reconfigure.
+var restartOptions = []struct {
+	name string
+	get  func(o *Options) any
+}{
+	{"MaxIdempotencyTokens", func(o *Options) any { return o.MaxIdempotencyTokens }},
+	{"MaxSeriesErrors", func(o *Options) any { return o.MaxSeriesErrors }},
+	{"GroupCommitWindow", func(o *Options) any { return o.GroupCommitWindow }},
+	{"ColdDir", func(o *Options) any { return o.ColdDir }},
+	{"PreallocSeries", func(o *Options) any { return o.PreallocSeries }},
+	{"WriteBufferSize", func(o *Options) any { return o.WriteBufferSize }},
+	{"WritePressureHighWater", func(o *Options) any { return o.WritePressureHighWater }},
+	{"WriteManifest", func(o *Options) any { return o.WriteManifest }},
+	{"AgentID", func(o *Options) any { return o.AgentID }},
+	{"MaxOrphanExemplars", func(o *Options) any { return o.MaxOrphanExemplars }},
+	{"OrphanExemplarTTL", func(o *Options) any { return o.OrphanExemplarTTL }},
+	{"AppendRateWindow", func(o *Options) any { return o.AppendRateWindow }},
+	{"InternLabels", func(o *Options) any { return o.InternLabels }},
+	{"DictionaryExemplarLabels", func(o *Options) any { return o.DictionaryExemplarLabels }},
+	{"PreallocateSegments", func(o *Options) any { return o.PreallocateSegments }},
+}
+
+// options returns the current options of the storage.
+func (w *Storage) options() *Options {
+	return w.opts.Load()
+}
+
+// Reconfigure applies opts to the running storage. The options which are
+// safe to change live, such as the retention policy, the downsampling
+// interval, the staleness and future skew policies and the dropped samples
+// callback, take effect for the next appends, commits and truncations.
+//
+// Other options can only be set when the storage is created. If any of them
+// differs from the current options, Reconfigure returns an error wrapping
+// ErrRestartRequired and nothing is applied.
+func (w *Storage) Reconfigure(opts Options) error {
+	w.reconfigureMtx.Lock()
+	defer w.reconfigureMtx.Unlock()
+
+	cur := w.options()
+
+	var changed []string
+	for _, o := range restartOptions {
+		if o.get(cur) != o.get(&opts) {
+			changed = append(changed, o.name)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%w: %s", ErrRestartRequired, strings.Join(changed, ", "))
+	}
+
+	// The callback can't be compared, so its observer is always replaced.
+	w.droppedSamples.Store(newDroppedSampleObserver(opts.OnSampleDropped, opts.SampleDroppedRateLimit))
+	w.opts.Store(&opts)
+	return nil
+}
@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// RelabelSeries replaces the labels of every series in memory with the
+// labels returned by fn, and writes a series record with the new labels to
+// the WAL for each series which changed. Series keep their ref, so samples
+// appended with either label set before and after the call belong to the
+// same series.
+//
+// A series isn't relabeled if another series already has the new labels,
+// and an error is returned for it. The number of relabeled series is
+// returned.
+func (w *Storage) RelabelSeries(fn func(labels.Labels) labels.Labels) (n int, err error) {
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return 0, ErrWALClosed
+	}
+
+	// Prevent series from being garbage collected while they're relabeled.
+	w.series.gcMut.Lock()
+	defer w.series.gcMut.Unlock()
+
+	var (
+		errs    []error
+		records []record.RefSeries
+	)
+	for _, series := range w.series.snapshot() {
+		series.Lock()
+		oldLset := series.lset
+		series.Unlock()
+
+		newLset := fn(oldLset)
+		if labels.Equal(oldLset, newLset) {
+			continue
+		}
+		if !w.series.relabel(series, newLset) {
+			errs = append(errs, fmt.Errorf("relabel series %s to %s: a series with the same labels already exists", oldLset, newLset))
+			continue
+		}
+		records = append(records, record.RefSeries{Ref: series.ref, Labels: newLset})
+	}
+
+	if len(records) > 0 {
+		var encoder record.Encoder
+		buf := w.bufPool.Get().([]byte)
+		defer func() {
+			w.bufPool.Put(buf) //nolint:staticcheck
+		}()
+
+		buf = encoder.Series(records, buf)
+		if err := w.logRecord(buf); err != nil {
+			errs = append(errs, fmt.Errorf("write relabeled series: %w", err))
+		} else if w.notifier != nil {
+			w.notifier.Notify()
+		}
+		buf = buf[:0]
+	}
+
+	return len(records), errors.Join(errs...)
+}
@@ -0,0 +1,288 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// Writer receives the records replayed by [ReplayRange]. It's a subset of
+// [wlog.WriteTo].
+type Writer interface {
+	StoreSeries(series []record.RefSeries, segment int)
+	Append(samples []record.RefSample) bool
+	AppendExemplars(exemplars []record.RefExemplar) bool
+	AppendHistograms(histograms []record.RefHistogramSample) bool
+	AppendFloatHistograms(histograms []record.RefFloatHistogramSample) bool
+}
+
+// ReplayRange reads the WAL in dir, starting from its latest checkpoint, and
+// sends to w the samples, histograms and exemplars whose timestamps are
+// between mint and maxt inclusive. Other records are skipped.
+//
+// The series of the samples sent to w are sent before their first sample.
+// Series without samples in the range aren't sent.
+func ReplayRange(dir string, mint, maxt int64, w Writer) error {
+	return newRangeReplayer(mint, maxt, w).replayDir(dir)
+}
+
+// ReplayMatching reads the WAL in dir, starting from its latest checkpoint,
+// and sends to w the series whose labels match all of matchers, along with
+// their samples, histograms and exemplars. Records of other series are
+// skipped as they're read, and only the labels of the matching series are
+// held in memory.
+//
+// Like with ReplayRange, series are sent before their first sample, and
+// series without samples aren't sent. Samples whose series record was
+// truncated from the WAL are skipped, since their labels are unknown.
+func ReplayMatching(dir string, matchers []*labels.Matcher, w Writer) error {
+	rr := newRangeReplayer(math.MinInt64, math.MaxInt64, w)
+	rr.matchers = matchers
+	return rr.replayDir(dir)
+}
+
+// rangeReplayer sends the records of a WAL within a time range to a Writer.
+type rangeReplayer struct {
+	mint, maxt int64
+	w          Writer
+
+	// seriesOnly skips every record but series records.
+	seriesOnly bool
+
+	// matchers, if set, skip the series whose labels don't match.
+	matchers []*labels.Matcher
+
+	dec record.Decoder
+	// series holds the latest series record of every ref, or only of the
+	// refs whose latest labels match if matchers are set.
+	series  map[chunks.HeadSeriesRef]record.RefSeries
+	emitted map[chunks.HeadSeriesRef]struct{} // Series already sent to w.
+}
+
+func newRangeReplayer(mint, maxt int64, w Writer) *rangeReplayer {
+	return &rangeReplayer{
+		mint:    mint,
+		maxt:    maxt,
+		w:       w,
+		series:  make(map[chunks.HeadSeriesRef]record.RefSeries),
+		emitted: make(map[chunks.HeadSeriesRef]struct{}),
+	}
+}
+
+// replayDir replays the WAL in dir, starting from its latest checkpoint.
+func (rr *rangeReplayer) replayDir(dir string) error {
+	return replayDir(dir, rr.replay)
+}
+
+// replayCheckpoint replays the checkpoint in dir, which was created up to
+// the segment index.
+func (rr *rangeReplayer) replayCheckpoint(dir string, index int) error {
+	return replayCheckpoint(dir, index, rr.replay)
+}
+
+// replaySegments replays the segments of dir in the range [first, last].
+func (rr *rangeReplayer) replaySegments(dir string, first, last int) error {
+	return replaySegments(dir, first, last, rr.replay)
+}
+
+// replayFunc reads the records of r, which reads the segment with the given
+// index or the checkpoint created up to it.
+type replayFunc func(r *wlog.Reader, segment int) error
+
+// replayDir replays the WAL in dir with replay, starting from its latest
+// checkpoint. The checkpoint and segments are replayed in order.
+func replayDir(dir string, replay replayFunc) error {
+	return replayDirUntil(dir, math.MaxInt, replay)
+}
+
+// replayDirUntil is like replayDir, but stops after the segment with the
+// index last.
+func replayDirUntil(dir string, last int, replay replayFunc) error {
+	checkpointDir, startFrom, err := wlog.LastCheckpoint(dir)
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		return fmt.Errorf("find last checkpoint: %w", err)
+	} else if err == nil {
+		if err := replayCheckpoint(checkpointDir, startFrom, replay); err != nil {
+			return err
+		}
+		startFrom++
+	}
+
+	first, lastSegment, err := wlog.Segments(dir)
+	if err != nil {
+		return fmt.Errorf("list segments: %w", err)
+	}
+	return replaySegments(dir, max(startFrom, first), min(last, lastSegment), replay)
+}
+
+// replayCheckpoint replays the checkpoint in dir with replay.
+func replayCheckpoint(dir string, index int, replay replayFunc) error {
+	sr, err := wlog.NewSegmentsReader(dir)
+	if err != nil {
+		return fmt.Errorf("open checkpoint: %w", err)
+	}
+	err = replay(wlog.NewReader(sr), index)
+	if closeErr := sr.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("replay checkpoint: %w", err)
+	}
+	return nil
+}
+
+// replaySegments replays the segments of dir in the range [first, last] with
+// replay.
+func replaySegments(dir string, first, last int, replay replayFunc) error {
+	for i := first; i <= last; i++ {
+		s, err := wlog.OpenReadSegment(wlog.SegmentName(dir, i))
+		if err != nil {
+			return fmt.Errorf("open segment %d: %w", i, err)
+		}
+
+		sr := wlog.NewSegmentBufReader(s)
+		err = replay(wlog.NewReader(sr), i)
+		if closeErr := sr.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("replay segment %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (rr *rangeReplayer) replay(r *wlog.Reader, segment int) error {
+	for r.Next() {
+		rec := r.Record()
+		if isSampleSourcesRecord(rec) {
+			continue
+		}
+		if rr.seriesOnly && rr.dec.Type(rec) != record.Series {
+			continue
+		}
+
+		switch recordType(&rr.dec, rec) {
+		case record.Series:
+			series, err := rr.dec.Series(rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode series: %w", err)
+			}
+			var updated []record.RefSeries
+			for _, s := range series {
+				if rr.matchers != nil && !matchesAll(rr.matchers, s.Labels) {
+					// Series which don't match aren't held, so their samples
+					// are skipped like those of unknown series.
+					delete(rr.series, s.Ref)
+					delete(rr.emitted, s.Ref)
+					continue
+				}
+				prev := rr.series[s.Ref]
+				rr.series[s.Ref] = s
+				// Series which were already sent are sent again if their
+				// labels changed, so w sees their new labels.
+				if _, ok := rr.emitted[s.Ref]; ok && !labels.Equal(prev.Labels, s.Labels) {
+					updated = append(updated, s)
+				}
+			}
+			if len(updated) > 0 {
+				rr.w.StoreSeries(updated, segment)
+			}
+		case record.Samples:
+			samples, err := rr.dec.Samples(rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode samples: %w", err)
+			}
+			samples = inRange(rr, samples, segment, func(s record.RefSample) (chunks.HeadSeriesRef, int64) { return s.Ref, s.T })
+			if len(samples) > 0 {
+				rr.w.Append(samples)
+			}
+		case record.HistogramSamples:
+			histograms, err := rr.dec.HistogramSamples(rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode histogram samples: %w", err)
+			}
+			histograms = inRange(rr, histograms, segment, func(s record.RefHistogramSample) (chunks.HeadSeriesRef, int64) { return s.Ref, s.T })
+			if len(histograms) > 0 {
+				rr.w.AppendHistograms(histograms)
+			}
+		case record.FloatHistogramSamples:
+			histograms, err := rr.dec.FloatHistogramSamples(rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode float histogram samples: %w", err)
+			}
+			histograms = inRange(rr, histograms, segment, func(s record.RefFloatHistogramSample) (chunks.HeadSeriesRef, int64) { return s.Ref, s.T })
+			if len(histograms) > 0 {
+				rr.w.AppendFloatHistograms(histograms)
+			}
+		case record.Exemplars:
+			exemplars, err := decodeExemplars(&rr.dec, rec, nil)
+			if err != nil {
+				return fmt.Errorf("decode exemplars: %w", err)
+			}
+			exemplars = inRange(rr, exemplars, segment, func(e record.RefExemplar) (chunks.HeadSeriesRef, int64) { return e.Ref, e.T })
+			if len(exemplars) > 0 {
+				rr.w.AppendExemplars(exemplars)
+			}
+		default:
+			// Other records don't hold anything to replay.
+		}
+	}
+	return r.Err()
+}
+
+// matches returns true if the series ref matches the matchers of rr, if any.
+func (rr *rangeReplayer) matches(ref chunks.HeadSeriesRef) bool {
+	if rr.matchers == nil {
+		return true
+	}
+	_, ok := rr.series[ref]
+	return ok
+}
+
+// matchesAll returns true if l matches all of matchers.
+func matchesAll(matchers []*labels.Matcher, l labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(l.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRange returns the elements of samples with a timestamp between the mint
+// and maxt of rr and of series matching its matchers, filtering samples in
+// place. The series of the returned
+// samples which haven't been sent yet are sent to the writer of rr.
+func inRange[T any](rr *rangeReplayer, samples []T, segment int, get func(T) (chunks.HeadSeriesRef, int64)) []T {
+	var (
+		res     = samples[:0]
+		pending []record.RefSeries
+	)
+	for _, s := range samples {
+		ref, t := get(s)
+		if t < rr.mint || t > rr.maxt || !rr.matches(ref) {
+			continue
+		}
+		res = append(res, s)
+
+		if _, ok := rr.emitted[ref]; ok {
+			continue
+		}
+		// Samples whose series record was truncated from the WAL are still
+		// sent, even though they can't be interpreted.
+		if series, ok := rr.series[ref]; ok {
+			rr.emitted[ref] = struct{}{}
+			pending = append(pending, series)
+		}
+	}
+	if len(pending) > 0 {
+		rr.w.StoreSeries(pending, segment)
+	}
+	return res
+}
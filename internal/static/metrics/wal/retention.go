@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// RetentionPolicy composes the limits enforced when the WAL is truncated.
+// Every limit is enforced, so data is dropped as soon as it exceeds any of
+// them. A limit of 0 is disabled.
+type RetentionPolicy struct {
+	// MaxAge drops samples older than MaxAge when the WAL is truncated, even
+	// if they're newer than the timestamp given to Truncate.
+	MaxAge time.Duration
+
+	// MaxSamplesPerSeries caps the number of samples retained for each series
+	// when a checkpoint is written. The samples of a series exceeding the cap
+	// with the oldest timestamps are dropped, whatever order they were
+	// written in.
+	MaxSamplesPerSeries int
+
+	// MaxBytes bounds the size of the WAL directory, including its
+	// checkpoint. When the WAL is larger after being truncated, the samples
+	// of its oldest segments are dropped until it fits, keeping the records
+	// of active series. The checkpoint written to drop segments is assumed to
+	// be as large as the current one. The segment being written is never
+	// dropped, so the WAL can exceed MaxBytes until the next truncation.
+	MaxBytes int64
+}
+
+// mint returns the timestamp before which samples are dropped when the WAL
+// is truncated with mint at now.
+func (p RetentionPolicy) mint(mint int64, now time.Time) int64 {
+	if p.MaxAge <= 0 {
+		return mint
+	}
+	return max(mint, timestamp.FromTime(now.Add(-p.MaxAge)))
+}
+
+// enforceMaxBytes drops the samples of the oldest segments of the WAL while
+// it's larger than Options.Retention.MaxBytes. The WAL mutex must be held by
+// the caller.
+func (w *Storage) enforceMaxBytes() error {
+	maxBytes := w.options().Retention.MaxBytes
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	dir := w.wal.Dir()
+	size, err := fileutil.DirSize(dir)
+	if err != nil {
+		return fmt.Errorf("get WAL size: %w", err)
+	}
+	if size <= maxBytes {
+		return nil
+	}
+
+	first, last, err := wlog.Segments(dir)
+	if err != nil {
+		return fmt.Errorf("get segment range: %w", err)
+	}
+	last-- // Never drop the segment being written.
+
+	dropTo := -1
+	for i := first; i <= last && size > maxBytes; i++ {
+		fi, err := os.Stat(wlog.SegmentName(dir, i))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("get segment size: %w", err)
+		}
+		size -= fi.Size()
+		dropTo = i
+	}
+	if dropTo < 0 {
+		return nil
+	}
+
+	if err := w.checkpoint(first, dropTo, samplesSince(math.MaxInt64)); err != nil {
+		return err
+	}
+	level.Warn(w.logger).Log("msg", "dropped WAL segments exceeding the size retention",
+		"first", first, "last", dropTo, "max_bytes", maxBytes)
+	return nil
+}
@@ -0,0 +1,68 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// isRetryableWriteError returns true if err is a transient error of a write
+// to the WAL. I/O errors aren't retried: once a sync failed, the
+// kernel may have dropped the data it didn't write, so a successful retry
+// wouldn't mean the data is durable.
+func isRetryableWriteError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ENOSPC, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryWrite calls fn until it succeeds, retrying it up to
+// Options.CommitRetries times with an exponential backoff as long as it
+// fails with a retryable error and reports that retrying is safe.
+//
+// The read lock of the WAL must be held by the caller. It's released while
+// backing off, so that the WAL can still be truncated or closed meanwhile,
+// in which case ErrWALClosed is returned.
+func (w *Storage) retryWrite(fn func() (safe bool, err error)) error {
+	opts := w.options()
+	backoff := opts.CommitRetryBackoff
+	for attempt := 0; ; attempt++ {
+		safe, err := fn()
+		if err == nil || !safe || attempt >= opts.CommitRetries || !isRetryableWriteError(err) {
+			return err
+		}
+		w.metrics.totalCommitRetries.Inc()
+
+		w.walMtx.RUnlock()
+		time.Sleep(backoff)
+		w.walMtx.RLock()
+		if w.walClosed {
+			return ErrWALClosed
+		}
+		backoff *= 2
+	}
+}
+
+// syncWAL syncs the WAL to disk. Failed syncs aren't retried: once a sync
+// failed, the kernel may have dropped the dirty pages of the segment, and a
+// later sync could succeed without them being written. The segment is
+// rotated instead, so that the next commits are written to a new file, and
+// the error is returned to fail the commits waiting for the sync.
+func (w *Storage) syncWAL() error {
+	err := w.writer.Sync()
+	if err == nil {
+		return nil
+	}
+
+	level.Warn(w.logger).Log("msg", "failed to sync WAL, rotating segment", "err", err)
+	if _, rotateErr := w.writer.NextSegment(); rotateErr != nil {
+		return errors.Join(err, fmt.Errorf("rotate segment: %w", rotateErr))
+	}
+	return err
+}
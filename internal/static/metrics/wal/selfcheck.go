@@ -0,0 +1,108 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// SelfCheck replays the series of the WAL and compares them with the series
+// held in memory, returning an error describing every divergence. It's meant
+// to be run on demand to diagnose the storage.
+//
+// Commits are only blocked while the series held in memory are snapshotted
+// and a new segment is started; the WAL is then replayed up to that segment
+// without blocking them. Series created by appenders which haven't been
+// committed or rolled back yet, and series created after the snapshot, aren't
+// checked.
+func (w *Storage) SelfCheck() error {
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
+	lastRef, snapshot, last, err := w.snapshotSeries()
+	if err != nil {
+		return err
+	}
+
+	rr := newRangeReplayer(0, 0, nil)
+	rr.seriesOnly = true
+	if err := replayDirUntil(w.wal.Dir(), last, rr.replay); err != nil {
+		return fmt.Errorf("replay WAL: %w", err)
+	}
+	onDisk := rr.series
+
+	var errs []error
+	live := make(map[chunks.HeadSeriesRef]struct{})
+	liveHashes := make(map[uint64]struct{})
+	for _, series := range snapshot {
+		ref, lset := series.ref, series.lset
+
+		live[ref] = struct{}{}
+		liveHashes[lset.Hash()] = struct{}{}
+		if series.pending {
+			continue
+		}
+
+		diskSeries, ok := onDisk[ref]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("series %d %s is missing from the WAL", ref, lset))
+		case !labels.Equal(diskSeries.Labels, lset):
+			errs = append(errs, fmt.Errorf("series %d has labels %s in memory but %s in the WAL", ref, lset, diskSeries.Labels))
+		}
+	}
+
+	for ref, diskSeries := range onDisk {
+		if _, ok := live[ref]; ok || ref > lastRef {
+			continue
+		}
+		// Garbage collected series stay in the WAL until they're
+		// checkpointed, and duplicate series records of a live series are
+		// merged with it when the WAL is loaded.
+		if _, ok := w.deleted[ref]; ok {
+			continue
+		}
+		if _, ok := liveHashes[diskSeries.Labels.Hash()]; ok {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("series %d %s of the WAL is missing from memory", ref, diskSeries.Labels))
+	}
+
+	return errors.Join(errs...)
+}
+
+// liveSeries is a copy of a series held in memory.
+type liveSeries struct {
+	ref     chunks.HeadSeriesRef
+	lset    labels.Labels
+	pending bool
+}
+
+// snapshotSeries returns the last series ref, a copy of the series held in
+// memory, and the index of the last segment holding the series of every
+// commit made before the snapshot. It blocks commits while it runs.
+func (w *Storage) snapshotSeries() (chunks.HeadSeriesRef, []liveSeries, int, error) {
+	w.walMtx.Lock()
+	defer w.walMtx.Unlock()
+
+	if w.walClosed {
+		return 0, nil, 0, ErrWALClosed
+	}
+
+	// Start a new segment, so that the segments replayed aren't written to.
+	next, err := w.writer.NextSegment()
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("next segment: %w", err)
+	}
+
+	lastRef := chunks.HeadSeriesRef(w.nextRef.Load())
+	var snapshot []liveSeries
+	for _, series := range w.series.snapshot() {
+		series.Lock()
+		snapshot = append(snapshot, liveSeries{ref: series.ref, lset: series.lset, pending: series.pending})
+		series.Unlock()
+	}
+	return lastRef, snapshot, next - 1, nil
+}
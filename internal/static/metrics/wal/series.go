@@ -1,15 +1,18 @@
 package wal
 
-// This code is copied from
-// prometheus/prometheus@7c2de14b0bd74303c2ca6f932b71d4585a29ca75, with only
-// minor changes for metric names.
+// This code was originally copied from
+// prometheus/prometheus@7c2de14b0bd74303c2ca6f932b71d4585a29ca75, and has
+// since been extended to track the state used by the storage's appenders,
+// such as the latest sample, staleness and downsampling of each series.
 
 import (
 	"sync"
+	"time"
 
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/tsdb/chunks"
+	"go.uber.org/atomic"
 )
 
 // memSeries is a chunkless version of tsdb.memSeries.
@@ -21,15 +24,72 @@ type memSeries struct {
 
 	// Last recorded timestamp. Used by gc to determine if a series is stale.
 	lastTs int64
+
+	// Timestamp from which the next sample of the series is kept when
+	// Options.DownsampleInterval is set.
+	nextKeptTs int64
+
+	// Whether the latest committed sample is a staleness marker.
+	stale bool
+
+	// Whether the series was created by an appender which hasn't been
+	// committed or rolled back yet, so it may not be in the WAL yet.
+	pending bool
+
+	// Kind of the latest committed sample, and its value if it's a float
+	// sample.
+	lastKind  sampleKind
+	lastValue float64
+
+	// Timestamp of the latest sample derived into the series by
+	// Options.DerivedSeries, if derived is set. They're guarded by
+	// Storage.derivedMtx rather than by the lock of the series, and are
+	// updated as soon as a derived sample is appended, before it's committed.
+	derivedTs int64
+	derived   bool
+}
+
+// sampleKind is the kind of a sample of a series.
+type sampleKind uint8
+
+const (
+	sampleNone sampleKind = iota
+	sampleFloat
+	sampleHistogram
+)
+
+// setLatest records the sample at ts as the latest sample of m. v is only
+// meaningful for float samples. The lock of m must be held.
+func (m *memSeries) setLatest(ts int64, kind sampleKind, v float64, stale bool) {
+	m.lastTs = ts
+	m.lastKind = kind
+	m.lastValue = v
+	m.stale = stale
+}
+
+// downsample reports whether the sample of m at ts must be dropped to keep
+// only the first sample of each interval, and records ts as kept otherwise.
+// The lock of m must be held.
+func (m *memSeries) downsample(ts int64, interval time.Duration) bool {
+	if ts < m.nextKeptTs {
+		return true
+	}
+	step := interval.Milliseconds()
+	if step <= 0 {
+		return false
+	}
+	m.nextKeptTs = ts - ts%step + step
+	return false
 }
 
-// updateTimestamp obtains the lock on s and will attempt to update lastTs.
-// fails if newTs < lastTs.
-func (m *memSeries) updateTimestamp(newTs int64) bool {
+// updateTimestamp obtains the lock on s and will attempt to update lastTs,
+// recording the kind and value of the sample at newTs and whether it is a
+// staleness marker. fails if newTs < lastTs.
+func (m *memSeries) updateTimestamp(newTs int64, kind sampleKind, v float64, stale bool) bool {
 	m.Lock()
 	defer m.Unlock()
 	if newTs >= m.lastTs {
-		m.lastTs = newTs
+		m.setLatest(newTs, kind, v, stale)
 		return true
 	}
 	return false
@@ -86,6 +146,15 @@ type stripeSeries struct {
 	exemplars []map[chunks.HeadSeriesRef]*exemplar.Exemplar
 	locks     []stripeLock
 
+	// Counters used to estimate the memory footprint of the series.
+	numSeries    atomic.Int64
+	numExemplars atomic.Int64
+	labelBytes   atomic.Int64
+
+	// symbols interns the labels of the series when Options.InternLabels is
+	// set, and is nil otherwise.
+	symbols *symbolTable
+
 	gcMut sync.Mutex
 }
 
@@ -95,7 +164,9 @@ type stripeLock struct {
 	_ [40]byte
 }
 
-func newStripeSeries(stripeSize int) *stripeSeries {
+// newStripeSeries returns a new stripeSeries, with room for preallocSeries
+// series before its maps need to grow.
+func newStripeSeries(stripeSize int, preallocSeries int) *stripeSeries {
 	s := &stripeSeries{
 		size:      stripeSize,
 		series:    make([]map[chunks.HeadSeriesRef]*memSeries, stripeSize),
@@ -103,11 +174,16 @@ func newStripeSeries(stripeSize int) *stripeSeries {
 		exemplars: make([]map[chunks.HeadSeriesRef]*exemplar.Exemplar, stripeSize),
 		locks:     make([]stripeLock, stripeSize),
 	}
+	// Series are spread evenly over stripes by their ref and hash.
+	var perStripe int
+	if preallocSeries > 0 {
+		perStripe = (preallocSeries + stripeSize - 1) / stripeSize
+	}
 	for i := range s.series {
-		s.series[i] = map[chunks.HeadSeriesRef]*memSeries{}
+		s.series[i] = make(map[chunks.HeadSeriesRef]*memSeries, perStripe)
 	}
 	for i := range s.hashes {
-		s.hashes[i] = seriesHashmap{}
+		s.hashes[i] = make(seriesHashmap, perStripe)
 	}
 	for i := range s.exemplars {
 		s.exemplars[i] = map[chunks.HeadSeriesRef]*exemplar.Exemplar{}
@@ -149,10 +225,18 @@ func (s *stripeSeries) gc(mint int64) map[chunks.HeadSeriesRef]struct{} {
 				deleted[series.ref] = struct{}{}
 				delete(s.series[refLock], series.ref)
 				s.hashes[hashLock].Delete(hash, series.ref)
+				s.numSeries.Dec()
+				s.labelBytes.Sub(labelsSize(series.lset))
+				if s.symbols != nil {
+					s.symbols.release(series.lset)
+				}
 
 				// Since the series is gone, we'll also delete
 				// the latest stored exemplar.
-				delete(s.exemplars[refLock], series.ref)
+				if _, ok := s.exemplars[refLock][series.ref]; ok {
+					delete(s.exemplars[refLock], series.ref)
+					s.numExemplars.Dec()
+				}
 
 				if hashLock != refLock {
 					s.locks[refLock].Unlock()
@@ -193,7 +277,20 @@ func (s *stripeSeries) Set(hash uint64, series *memSeries) {
 	//
 	// We update s.series first because GC expects anything in s.hashes to
 	// already exist in s.series.
+	if s.symbols != nil {
+		series.lset = s.symbols.intern(series.lset)
+	}
+
 	s.locks[refLock].Lock()
+	if prev, ok := s.series[refLock][series.ref]; ok {
+		s.labelBytes.Sub(labelsSize(prev.lset))
+		if s.symbols != nil {
+			s.symbols.release(prev.lset)
+		}
+	} else {
+		s.numSeries.Inc()
+	}
+	s.labelBytes.Add(labelsSize(series.lset))
 	s.series[refLock][series.ref] = series
 	s.locks[refLock].Unlock()
 
@@ -218,11 +315,76 @@ func (s *stripeSeries) SetLatestExemplar(ref chunks.HeadSeriesRef, exemplar *exe
 	// Make sure that's a valid series id and record its latest exemplar
 	s.locks[i].Lock()
 	if s.series[i][ref] != nil {
+		if _, ok := s.exemplars[i][ref]; !ok {
+			s.numExemplars.Inc()
+		}
 		s.exemplars[i][ref] = exemplar
 	}
 	s.locks[i].Unlock()
 }
 
+// snapshot returns every series currently held.
+func (s *stripeSeries) snapshot() []*memSeries {
+	var res []*memSeries
+	for i := 0; i < s.size; i++ {
+		s.locks[i].RLock()
+		for _, series := range s.series[i] {
+			res = append(res, series)
+		}
+		s.locks[i].RUnlock()
+	}
+	return res
+}
+
+// relabel replaces the labels of series with lset, keeping its ref. It
+// returns false and leaves series unchanged if another series already has
+// the labels lset. The caller must hold gcMut.
+func (s *stripeSeries) relabel(series *memSeries, lset labels.Labels) bool {
+	// relabel is the only function changing the labels of existing series,
+	// and calls are serialized by gcMut, so the labels read here can't change
+	// until they're replaced below.
+	series.Lock()
+	oldLset := series.lset
+	series.Unlock()
+
+	var (
+		oldHash     = oldLset.Hash()
+		newHash     = lset.Hash()
+		oldHashLock = int(oldHash & uint64(s.size-1))
+		newHashLock = int(newHash & uint64(s.size-1))
+	)
+
+	// Both hash locks are held so that the series can always be found by
+	// either its old or its new labels. Locks are taken in order; this is
+	// safe because gc, the only other function taking two locks, is
+	// excluded by gcMut. Like everywhere else, stripe locks are taken before
+	// the lock of the series.
+	first, second := min(oldHashLock, newHashLock), max(oldHashLock, newHashLock)
+	s.locks[first].Lock()
+	defer s.locks[first].Unlock()
+	if second != first {
+		s.locks[second].Lock()
+		defer s.locks[second].Unlock()
+	}
+
+	series.Lock()
+	defer series.Unlock()
+
+	if other := s.hashes[newHashLock].Get(newHash, lset); other != nil && other != series {
+		return false
+	}
+
+	s.hashes[oldHashLock].Delete(oldHash, series.ref)
+	s.labelBytes.Add(labelsSize(lset) - labelsSize(series.lset))
+	if s.symbols != nil {
+		s.symbols.release(series.lset)
+		lset = s.symbols.intern(lset)
+	}
+	series.lset = lset
+	s.hashes[newHashLock].Set(newHash, series)
+	return true
+}
+
 func (s *stripeSeries) iterator() *stripeSeriesIterator {
 	return &stripeSeriesIterator{s}
 }
@@ -240,19 +402,13 @@ func (it *stripeSeriesIterator) Channel() <-chan *memSeries {
 		for i := 0; i < it.s.size; i++ {
 			it.s.locks[i].RLock()
 
+			// The series can't be garbage collected while the lock of its
+			// ref is held, so the lock of its hash isn't needed. Taking it
+			// while holding the lock of the series would invert the order
+			// of the locks with relabel.
 			for _, series := range it.s.series[i] {
 				series.Lock()
-
-				j := int(series.lset.Hash()) & (it.s.size - 1)
-				if i != j {
-					it.s.locks[j].RLock()
-				}
-
 				ret <- series
-
-				if i != j {
-					it.s.locks[j].RUnlock()
-				}
 				series.Unlock()
 			}
 
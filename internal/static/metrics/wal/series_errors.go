@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// seriesErrors holds the last append error of a bounded number of series.
+// Once full, the error of the series which failed the longest time ago is
+// evicted to make room for a new series.
+type seriesErrors struct {
+	mut    sync.Mutex
+	max    int
+	errors map[chunks.HeadSeriesRef]error
+	order  []chunks.HeadSeriesRef // Series in insertion order, used as a ring buffer.
+	next   int                    // Index in order of the next series to evict.
+}
+
+func newSeriesErrors(max int) *seriesErrors {
+	return &seriesErrors{
+		max:    max,
+		errors: make(map[chunks.HeadSeriesRef]error),
+	}
+}
+
+// set records err as the last error of the series ref.
+func (e *seriesErrors) set(ref chunks.HeadSeriesRef, err error) {
+	if e.max <= 0 {
+		return
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if _, ok := e.errors[ref]; ok {
+		e.errors[ref] = err
+		return
+	}
+
+	if len(e.order) < e.max {
+		e.order = append(e.order, ref)
+	} else {
+		delete(e.errors, e.order[e.next])
+		e.order[e.next] = ref
+		e.next = (e.next + 1) % e.max
+	}
+	e.errors[ref] = err
+}
+
+// delete forgets the errors of the given series.
+func (e *seriesErrors) delete(refs map[chunks.HeadSeriesRef]struct{}) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if len(e.errors) == 0 {
+		return
+	}
+
+	// Rebuild the ring buffer without the deleted series, keeping the
+	// eviction order of the remaining ones.
+	order := make([]chunks.HeadSeriesRef, 0, len(e.order))
+	for i := range e.order {
+		ref := e.order[(e.next+i)%len(e.order)]
+		if _, ok := refs[ref]; ok {
+			delete(e.errors, ref)
+			continue
+		}
+		order = append(order, ref)
+	}
+	e.order, e.next = order, 0
+}
+
+// SeriesErrors returns the last append error of the series which recently
+// failed to be appended to, such as out of order samples. The number of
+// series tracked is bounded by Options.MaxSeriesErrors, and series are
+// forgotten once they are garbage collected.
+func (w *Storage) SeriesErrors() map[storage.SeriesRef]error {
+	w.seriesErrors.mut.Lock()
+	defer w.seriesErrors.mut.Unlock()
+
+	res := make(map[storage.SeriesRef]error, len(w.seriesErrors.errors))
+	for ref, err := range w.seriesErrors.errors {
+		res[storage.SeriesRef(ref)] = err
+	}
+	return res
+}
+
+// outOfOrderError returns the error recorded when a sample at ts can't be
+// appended to series.
+func outOfOrderError(ts int64) error {
+	return fmt.Errorf("sample timestamp %d: %w", ts, storage.ErrOutOfOrderSample)
+}
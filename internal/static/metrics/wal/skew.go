@@ -0,0 +1,72 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// FutureSkewPolicy defines how samples with timestamps beyond
+// Options.MaxFutureSkew are handled.
+type FutureSkewPolicy int
+
+const (
+	// FutureSkewClamp appends samples too far in the future at the latest
+	// accepted timestamp instead.
+	FutureSkewClamp FutureSkewPolicy = iota
+	// FutureSkewReject rejects samples too far in the future with a
+	// *FutureTimestampError.
+	FutureSkewReject
+)
+
+// FutureTimestampError is returned when a sample is appended with a timestamp
+// beyond Options.MaxFutureSkew, and Options.FutureSkewPolicy is
+// FutureSkewReject.
+type FutureTimestampError struct {
+	// Timestamp is the timestamp of the rejected sample, and Max the latest
+	// timestamp accepted when it was appended.
+	Timestamp, Max int64
+}
+
+func (e *FutureTimestampError) Error() string {
+	return fmt.Sprintf("sample timestamp %d is too far in the future, the latest accepted timestamp is %d", e.Timestamp, e.Max)
+}
+
+// futureSkew returns the timestamp a sample at t must be appended at,
+// following Options.MaxFutureSkew and Options.FutureSkewPolicy, or a
+// *FutureTimestampError if the sample must be rejected. It's shared by
+// regular and dry appenders, and doesn't update any metric.
+func (w *Storage) futureSkew(t int64) (int64, error) {
+	opts := w.options()
+	if opts.MaxFutureSkew <= 0 {
+		return t, nil
+	}
+	maxT := timestamp.FromTime(w.now().Add(opts.MaxFutureSkew))
+	if t <= maxT {
+		return t, nil
+	}
+
+	if opts.FutureSkewPolicy == FutureSkewClamp {
+		return maxT, nil
+	}
+	return 0, &FutureTimestampError{Timestamp: t, Max: maxT}
+}
+
+// checkFutureSkew is like futureSkew, but also counts clamped and rejected
+// samples and records the error of rejected samples of existing series.
+func (a *appender) checkFutureSkew(ref storage.SeriesRef, t int64) (int64, error) {
+	clampedT, err := a.w.futureSkew(t)
+	if err != nil {
+		a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonFutureTimestamp).Inc()
+		if ref != 0 {
+			a.w.seriesErrors.set(chunks.HeadSeriesRef(ref), err)
+		}
+		return 0, err
+	}
+	if clampedT != t {
+		a.w.metrics.totalClampedSamples.Inc()
+	}
+	return clampedT, nil
+}
@@ -0,0 +1,48 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// StalePolicy defines how samples appended to a series after its staleness
+// marker are handled.
+type StalePolicy int
+
+const (
+	// StalePolicyResurrect accepts samples appended after a staleness
+	// marker, which makes the series active again.
+	StalePolicyResurrect StalePolicy = iota
+	// StalePolicyRejectAfterStale rejects samples appended after a staleness
+	// marker with a *StaleSeriesError, until the series is garbage collected
+	// and a new series record is written for its labels. Staleness markers
+	// are still accepted.
+	StalePolicyRejectAfterStale
+)
+
+// StaleSeriesError is returned when a sample is appended to a series whose
+// latest committed sample is a staleness marker, and Options.StalePolicy is
+// StalePolicyRejectAfterStale.
+type StaleSeriesError struct {
+	Ref chunks.HeadSeriesRef
+	// Timestamp is the timestamp of the rejected sample.
+	Timestamp int64
+}
+
+func (e *StaleSeriesError) Error() string {
+	return fmt.Sprintf("sample timestamp %d: series %d was marked stale", e.Timestamp, e.Ref)
+}
+
+// checkStale returns a *StaleSeriesError if a sample at t must be rejected
+// because series is stale, following Options.StalePolicy. The lock of series
+// must be held.
+func (a *appender) checkStale(series *memSeries, t int64, stale bool) error {
+	if a.w.options().StalePolicy != StalePolicyRejectAfterStale || stale || !series.stale {
+		return nil
+	}
+	err := &StaleSeriesError{Ref: series.ref, Timestamp: t}
+	a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonStaleSeries).Inc()
+	a.w.seriesErrors.set(series.ref, err)
+	return err
+}
@@ -0,0 +1,31 @@
+package wal
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// TrustedAppender is implemented by the appenders returned by the storage,
+// which can skip validating the labels of new series for callers which
+// already validated them, such as scrapes:
+//
+//	app := storage.Appender(ctx).(wal.TrustedAppender)
+//	ref, err := app.AppendTrusted(ref, lbls, t, v)
+type TrustedAppender interface {
+	storage.Appender
+
+	// AppendTrusted is like Append, but doesn't check that the labels of a
+	// new series are non-empty and free of duplicate names, and doesn't
+	// strip labels with empty values.
+	//
+	// It's unsafe to call with labels which weren't validated: invalid
+	// labels are written to the WAL as is, and can be rejected downstream,
+	// for example by remote write endpoints.
+	AppendTrusted(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error)
+}
+
+var _ TrustedAppender = (*appender)(nil)
+
+func (a *appender) AppendTrusted(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	return a.append(ref, l, t, v, false)
+}
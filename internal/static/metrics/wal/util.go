@@ -64,12 +64,29 @@ func (r walReplayer) Replay(dir string) error {
 	return nil
 }
 
+// sampleSourcesWriter is implemented by the wlog.WriteTo of a walReplayer
+// which accepts the RecordSampleSources records of the WAL. They're skipped
+// otherwise.
+type sampleSourcesWriter interface {
+	AppendSampleSources([]RefSampleSource)
+}
+
 func (r walReplayer) replayWAL(reader *wlog.Reader) error {
 	var dec record.Decoder
 
 	for reader.Next() {
 		rec := reader.Record()
-		switch dec.Type(rec) {
+		if isSampleSourcesRecord(rec) {
+			sources, err := DecodeSampleSources(rec, nil)
+			if err != nil {
+				return err
+			}
+			if c, ok := r.w.(sampleSourcesWriter); ok {
+				c.AppendSampleSources(sources)
+			}
+			continue
+		}
+		switch recordType(&dec, rec) {
 		case record.Series:
 			series, err := dec.Series(rec, nil)
 			if err != nil {
@@ -82,8 +99,20 @@ func (r walReplayer) replayWAL(reader *wlog.Reader) error {
 				return err
 			}
 			r.w.Append(samples)
+		case record.HistogramSamples:
+			histograms, err := dec.HistogramSamples(rec, nil)
+			if err != nil {
+				return err
+			}
+			r.w.AppendHistograms(histograms)
+		case record.FloatHistogramSamples:
+			floatHistograms, err := dec.FloatHistogramSamples(rec, nil)
+			if err != nil {
+				return err
+			}
+			r.w.AppendFloatHistograms(floatHistograms)
 		case record.Exemplars:
-			exemplars, err := dec.Exemplars(rec, nil)
+			exemplars, err := decodeExemplars(&dec, rec, nil)
 			if err != nil {
 				return err
 			}
@@ -101,6 +130,14 @@ type walDataCollector struct {
 	exemplars       []record.RefExemplar
 	histograms      []record.RefHistogramSample
 	floatHistograms []record.RefFloatHistogramSample
+	sources         []RefSampleSource
+}
+
+func (c *walDataCollector) AppendSampleSources(sources []RefSampleSource) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.sources = append(c.sources, sources...)
 }
 
 func (c *walDataCollector) AppendExemplars(exemplars []record.RefExemplar) bool {
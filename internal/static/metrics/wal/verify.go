@@ -0,0 +1,134 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// walPageSize is the size of the pages of WAL segments. Reading a segment
+// must start at a page boundary for padding to be detected.
+const walPageSize = 32 * 1024
+
+// errVerifyMismatch is returned when a record read back from the WAL doesn't
+// match the record which was written.
+var errVerifyMismatch = errors.New("record read back from the WAL doesn't match the written record")
+
+// logRecord writes rec to the WAL, retrying transient errors following
+// Options.CommitRetries. When Options.VerifyOnAppend is set, rec is read back
+// from disk once written and an error is returned if it doesn't match.
+func (w *Storage) logRecord(rec []byte) error {
+	if w.options().CommitRetries == 0 {
+		return w.logRecordOnce(rec)
+	}
+	return w.retryWrite(func() (bool, error) {
+		seg, offset, err := w.writer.LastSegmentAndOffset()
+		if err != nil {
+			return false, fmt.Errorf("get WAL offset: %w", err)
+		}
+		if err := w.logRecordOnce(rec); err != nil {
+			// The record can only be written again if nothing of it was
+			// buffered by the failed write. Concurrent writes also move the
+			// offset, in which case the write isn't retried either.
+			newSeg, newOffset, offsetErr := w.writer.LastSegmentAndOffset()
+			return offsetErr == nil && newSeg == seg && newOffset == offset, err
+		}
+		return false, nil
+	})
+}
+
+// logRecordOnce writes rec to the WAL, verifying it when
+// Options.VerifyOnAppend is set.
+func (w *Storage) logRecordOnce(rec []byte) error {
+	if !w.options().VerifyOnAppend {
+		return w.writer.Log(rec)
+	}
+
+	// Verified writes are serialized so that the offset of rec is known.
+	w.verifyMtx.Lock()
+	defer w.verifyMtx.Unlock()
+
+	seg, offset, err := w.writer.LastSegmentAndOffset()
+	if err != nil {
+		return fmt.Errorf("get WAL offset: %w", err)
+	}
+	if err := w.writer.Log(rec); err != nil {
+		return err
+	}
+	if err := w.verifyRecord(seg, offset, rec); err != nil {
+		w.metrics.totalVerifyFailures.Inc()
+		return fmt.Errorf("verify WAL record: %w", err)
+	}
+	return nil
+}
+
+// verifyRecord reads the first record written at or after offset in segment
+// seg and checks that it's equal to rec. The record is looked up in later
+// segments if it didn't fit in seg.
+func (w *Storage) verifyRecord(seg, offset int, rec []byte) error {
+	_, last, err := wlog.Segments(w.wal.Dir())
+	if err != nil {
+		return err
+	}
+
+	for i := seg; i <= last; i++ {
+		start := 0
+		if i == seg {
+			start = offset
+		}
+		readBack, found, err := readRecordAt(wlog.SegmentName(w.wal.Dir(), i), start)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if !bytes.Equal(readBack, rec) {
+			return errVerifyMismatch
+		}
+		return nil
+	}
+	return fmt.Errorf("record written at offset %d of segment %d not found", offset, seg)
+}
+
+// readRecordAt returns the first record of the segment file at path which
+// starts at or after offset.
+func readRecordAt(path string, offset int) ([]byte, bool, error) {
+	var rec []byte
+	found, err := scanRecordsFrom(path, offset, func(r []byte) (bool, error) {
+		rec = r
+		return true, nil
+	})
+	return rec, found, err
+}
+
+// scanRecordsFrom calls fn with each record of the segment file at path which
+// starts at or after offset, until fn returns true.
+func scanRecordsFrom(path string, offset int, fn func(rec []byte) (bool, error)) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	pageStart := offset - offset%walPageSize
+	if _, err := f.Seek(int64(pageStart), io.SeekStart); err != nil {
+		return false, err
+	}
+
+	r := wlog.NewReader(f)
+	for r.Next() {
+		// Skip the records of the page which were written before offset.
+		if r.Offset() <= int64(offset-pageStart) {
+			continue
+		}
+		if found, err := fn(r.Record()); err != nil || found {
+			return found, err
+		}
+	}
+	return false, r.Err()
+}
@@ -1,14 +1,16 @@
 package wal
 
-// This code is copied from
-// prometheus/prometheus@7c2de14b0bd74303c2ca6f932b71d4585a29ca75, with only
-// minor changes for metric names.
+// This code was originally copied from
+// prometheus/prometheus@7c2de14b0bd74303c2ca6f932b71d4585a29ca75, and has
+// since been extended with features of its own, such as retention,
+// idempotent commits, group commits and write backpressure.
 
 import (
 	"context"
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -35,6 +37,32 @@ import (
 // storage has already been closed.
 var ErrWALClosed = fmt.Errorf("WAL storage closed")
 
+// ErrSeriesNotFound is returned by appenders when Options.StrictSeriesRefs is
+// set and a sample is appended with a ref which doesn't match any series,
+// for example because the series was garbage collected. The series must be
+// appended again with a zero ref to be registered.
+var ErrSeriesNotFound = errors.New("series not found")
+
+// Reasons for which the appender may drop a sample. They are used as values
+// for the reason label of the samples dropped counter.
+const (
+	DropReasonEmptyLabelset      = "empty_labelset"
+	DropReasonDuplicateLabelName = "duplicate_label_name"
+	DropReasonInvalidHistogram   = "invalid_histogram"
+	DropReasonDownsampled        = "downsampled"
+	DropReasonStaleSeries        = "stale_series"
+	DropReasonFutureTimestamp    = "future_timestamp"
+	DropReasonUnknownRef         = "unknown_ref"
+	DropReasonDerivedOutOfOrder  = "derived_out_of_order"
+)
+
+// Reasons for which records may be skipped when replaying the WAL. They are
+// used as values for the reason label of the replay skipped records counter.
+const (
+	SkipReasonUnknownType = "unknown_type"
+	SkipReasonCorruption  = "corruption"
+)
+
 type storageMetrics struct {
 	r prometheus.Registerer
 
@@ -45,6 +73,13 @@ type storageMetrics struct {
 	totalRemovedSeries     prometheus.Counter
 	totalAppendedSamples   prometheus.Counter
 	totalAppendedExemplars prometheus.Counter
+	totalDroppedSamples    *prometheus.CounterVec
+	totalClampedSamples    prometheus.Counter
+	totalDuplicateCommits  prometheus.Counter
+	totalVerifyFailures    prometheus.Counter
+	totalSkippedRecords    *prometheus.CounterVec
+	totalCommitRetries     prometheus.Counter
+	appendToDurable        prometheus.Histogram
 }
 
 func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
@@ -84,6 +119,42 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 		Help: "Total number of exemplars appended to the WAL",
 	})
 
+	m.totalDroppedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_samples_dropped_total",
+		Help: "Total number of samples dropped by the WAL before being appended, by reason",
+	}, []string{"reason"})
+
+	m.totalClampedSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_samples_clamped_total",
+		Help: "Total number of samples whose timestamp was clamped for being too far in the future",
+	})
+
+	m.totalDuplicateCommits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_duplicate_commits_total",
+		Help: "Total number of commits discarded because their idempotency token was already committed",
+	})
+
+	m.totalVerifyFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_verify_failures_total",
+		Help: "Total number of records which didn't match once read back from the WAL",
+	})
+
+	m.totalSkippedRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_replay_skipped_records_total",
+		Help: "Total number of records skipped while replaying the WAL, by reason",
+	}, []string{"reason"})
+
+	m.totalCommitRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prometheus_remote_write_wal_commit_retries_total",
+		Help: "Total number of WAL writes and syncs retried after a transient error",
+	})
+
+	m.appendToDurable = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prometheus_remote_write_wal_append_to_durable_seconds",
+		Help:    "Time between the first sample appended by a commit and the commit being synced to disk, for commits which sync the WAL",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
 	if r != nil {
 		m.numActiveSeries = util.MustRegisterOrGet(r, m.numActiveSeries).(prometheus.Gauge)
 		m.numDeletedSeries = util.MustRegisterOrGet(r, m.numDeletedSeries).(prometheus.Gauge)
@@ -92,6 +163,13 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 		m.totalRemovedSeries = util.MustRegisterOrGet(r, m.totalRemovedSeries).(prometheus.Counter)
 		m.totalAppendedSamples = util.MustRegisterOrGet(r, m.totalAppendedSamples).(prometheus.Counter)
 		m.totalAppendedExemplars = util.MustRegisterOrGet(r, m.totalAppendedExemplars).(prometheus.Counter)
+		m.totalDroppedSamples = util.MustRegisterOrGet(r, m.totalDroppedSamples).(*prometheus.CounterVec)
+		m.totalClampedSamples = util.MustRegisterOrGet(r, m.totalClampedSamples).(prometheus.Counter)
+		m.totalDuplicateCommits = util.MustRegisterOrGet(r, m.totalDuplicateCommits).(prometheus.Counter)
+		m.totalVerifyFailures = util.MustRegisterOrGet(r, m.totalVerifyFailures).(prometheus.Counter)
+		m.totalSkippedRecords = util.MustRegisterOrGet(r, m.totalSkippedRecords).(*prometheus.CounterVec)
+		m.totalCommitRetries = util.MustRegisterOrGet(r, m.totalCommitRetries).(prometheus.Counter)
+		m.appendToDurable = util.MustRegisterOrGet(r, m.appendToDurable).(prometheus.Histogram)
 	}
 
 	return &m
@@ -109,12 +187,231 @@ func (m *storageMetrics) Unregister() {
 		m.totalRemovedSeries,
 		m.totalAppendedSamples,
 		m.totalAppendedExemplars,
+		m.totalDroppedSamples,
+		m.totalClampedSamples,
+		m.totalDuplicateCommits,
+		m.totalVerifyFailures,
+		m.totalSkippedRecords,
+		m.totalCommitRetries,
+		m.appendToDurable,
 	}
 	for _, c := range cs {
 		m.r.Unregister(c)
 	}
 }
 
+// Options configures the behavior of a Storage.
+type Options struct {
+	// CheckpointOnClose writes a checkpoint of the active series, with the
+	// latest sample of each, when the storage is closed, so that the next
+	// startup replays the checkpoint instead of every segment.
+	CheckpointOnClose bool
+
+	// Retention holds the limits enforced when the WAL is truncated, on top
+	// of the timestamp given to Truncate.
+	Retention RetentionPolicy
+
+	// MaxIdempotencyTokens is the number of most recent idempotency tokens
+	// remembered to deduplicate commits of appenders created with
+	// IdempotentAppender.
+	MaxIdempotencyTokens int
+
+	// MaxSeriesErrors is the maximum number of series for which the last
+	// append error is tracked and reported by SeriesErrors.
+	MaxSeriesErrors int
+
+	// VerifyOnAppend reads back every record written to the WAL and fails the
+	// commit if it doesn't match the record which was written. This detects
+	// silent disk corruption at write time, but serializes writes and adds a
+	// read for each of them.
+	VerifyOnAppend bool
+
+	// GroupCommitWindow makes commits return only once their data is synced
+	// to disk. Commits made within the same window share a single sync. A
+	// value of 0 disables syncing on commit.
+	GroupCommitWindow time.Duration
+
+	// ColdDir is a directory where segments are moved once they're part of a
+	// checkpoint, instead of being deleted, so that they can be archived by
+	// another process. Segments in ColdDir can be replayed with
+	// ReplayRangeWithColdDir. Segments are deleted if ColdDir is empty.
+	ColdDir string
+
+	// PreallocSeries sizes the in-memory series map for this many series
+	// when the storage is opened, avoiding growing it while the WAL is
+	// replayed and series are created. It should be set to the expected
+	// number of active series. A value of 0 doesn't preallocate.
+	PreallocSeries int
+
+	// ConvertHistogramsToFloat converts integer histograms to float
+	// histograms when they're appended, so that every native histogram is
+	// written to the WAL as a float histogram.
+	ConvertHistogramsToFloat bool
+
+	// DownsampleInterval keeps only the first sample of each series in every
+	// interval, aligned to multiples of the interval since the epoch, and
+	// drops the others. Staleness markers are never dropped. Samples are
+	// downsampled as they're appended, so samples of appenders which are
+	// rolled back still count as the kept sample of their interval. A value
+	// of 0 keeps every sample.
+	DownsampleInterval time.Duration
+
+	// StalePolicy defines whether samples appended to a series after its
+	// staleness marker was committed are accepted or rejected. Defaults to
+	// StalePolicyResurrect.
+	StalePolicy StalePolicy
+
+	// WriteBufferSize bounds the bytes of commits in flight, from the time
+	// they start writing to the WAL until they complete, including waiting
+	// for the WAL to be synced with GroupCommitWindow. New commits wait until
+	// they fit in the buffer; a commit larger than the buffer waits for it to
+	// be empty. A value of 0 doesn't bound commits.
+	WriteBufferSize int
+
+	// WritePressureHighWater is the write pressure, between 0 and 1, at
+	// which WritePressureSignal is notified. A value of 0 disables the
+	// signal.
+	WritePressureHighWater float64
+
+	// WriteManifest maintains a manifest.json file in the WAL directory,
+	// describing the WAL for tools which don't read its segments. The
+	// manifest is updated when segments are rotated or truncated, and can be
+	// read with ReadManifest.
+	WriteManifest bool
+
+	// AgentID identifies the agent writing the WAL in its manifest.
+	AgentID string
+
+	// MaxOrphanExemplars is the maximum number of exemplars buffered when
+	// they're appended with the labels of a series which doesn't exist yet.
+	// Buffered exemplars are appended once their series is created, unless
+	// they expire after OrphanExemplarTTL. A value of 0 rejects exemplars of
+	// unknown series.
+	MaxOrphanExemplars int
+
+	// OrphanExemplarTTL is how long exemplars are buffered for their series
+	// to be created when MaxOrphanExemplars is set.
+	OrphanExemplarTTL time.Duration
+
+	// MaxFutureSkew is how far in the future of the current time sample
+	// timestamps may be. Samples beyond it are handled following
+	// FutureSkewPolicy. A value of 0 accepts any timestamp.
+	MaxFutureSkew time.Duration
+
+	// FutureSkewPolicy defines whether samples beyond MaxFutureSkew are
+	// clamped or rejected. Defaults to FutureSkewClamp.
+	FutureSkewPolicy FutureSkewPolicy
+
+	// OnSampleDropped, if set, is called with every sample dropped by Append
+	// or AppendHistogram for auditing, at most SampleDroppedRateLimit times
+	// per second. Histogram samples are reported with their sum as value.
+	// Drops beyond the rate limit are only counted by the dropped samples
+	// metric. It's called synchronously by the appender, so it must not block.
+	OnSampleDropped SampleDroppedFunc
+
+	// SampleDroppedRateLimit is the maximum number of calls per second to
+	// OnSampleDropped.
+	SampleDroppedRateLimit float64
+
+	// AppendRateWindow is the sliding window over which AppendRate computes
+	// the append throughput. It's rounded up to whole seconds.
+	AppendRateWindow time.Duration
+
+	// ExemplarSampleRatio is the ratio of exemplars kept for each series,
+	// between 0 and 1, once duplicates are discarded. Exemplars are sampled
+	// by hashing them along with the labels of their series, so the same
+	// exemplars are always kept. A value of 0, or of 1 and more, keeps every
+	// exemplar.
+	ExemplarSampleRatio float64
+
+	// StrictSeriesRefs rejects samples appended with a nonzero ref which
+	// doesn't match any series with ErrSeriesNotFound, instead of looking up
+	// or creating their series from their labels.
+	StrictSeriesRefs bool
+
+	// CommitRetries is the number of times a write to the WAL failing with a
+	// transient error is retried by Commit, waiting CommitRetryBackoff
+	// before the first retry and twice as long before each next one. Setting
+	// it also makes Commit sync the WAL, like Barrier, so that it only
+	// succeeds once its records are durable. A write is only retried if the
+	// failed attempt didn't buffer any part of its record, so that records
+	// are never written twice. Syncs are never retried: a failed sync fails
+	// the commit and rotates the segment. A value of 0 disables retries.
+	CommitRetries int
+
+	// CommitRetryBackoff is the delay before the first retry of a write to
+	// the WAL when CommitRetries is set.
+	CommitRetryBackoff time.Duration
+
+	// InternLabels makes series share a single copy of each distinct label
+	// name and value, reducing memory when many series have labels in
+	// common, such as job or instance. Interning adds a lookup to the
+	// creation and removal of every series, but not to appends to existing
+	// series.
+	InternLabels bool
+
+	// DictionaryExemplarLabels writes exemplars to RecordDictExemplars
+	// records, which hold the label names of the exemplars of a commit once
+	// rather than once per exemplar, making the WAL smaller when many
+	// exemplars are appended with the same label names. Replaying the WAL
+	// decodes both encodings.
+	//
+	// Prometheus skips RecordDictExemplars records, so remote write doesn't
+	// send any exemplar while it's set: it must not be set for a storage
+	// whose exemplars are remote written. A warning is logged when the
+	// storage is created with it set, and it can't be changed by
+	// Reconfigure.
+	DictionaryExemplarLabels bool
+
+	// PreallocateSegments allocates the disk space of each segment of the WAL
+	// to the full segment size as soon as the segment is created, rather
+	// than as it grows, to reduce fragmentation. Allocation uses fallocate
+	// where available, and keeps the size of the segment files unchanged.
+	// Segments aren't pre-allocated on filesystems which don't support it.
+	// It can't be changed by Reconfigure.
+	PreallocateSegments bool
+
+	// DebugMaxSeries is the maximum number of series written by
+	// WriteOpenMetrics. A value of 0 writes every series.
+	DebugMaxSeries int
+
+	// DerivedSeries, if set, is called on commit with the float samples of
+	// the commit, and the samples it derives from them, such as aggregates,
+	// are written to the WAL by the same commit. Derived samples which
+	// aren't after the latest sample of their series are dropped to keep
+	// them in order, and commits deriving samples write their records in
+	// the order their samples were derived. It's called synchronously by
+	// Commit, so it must be fast.
+	DerivedSeries DerivedSeriesFunc
+}
+
+// DefaultOptions returns the default Options used by NewStorage.
+func DefaultOptions() Options {
+	return Options{
+		MaxIdempotencyTokens:   1024,
+		MaxSeriesErrors:        1024,
+		OrphanExemplarTTL:      time.Minute,
+		SampleDroppedRateLimit: 100,
+		AppendRateWindow:       time.Minute,
+		ExemplarSampleRatio:    1,
+		CommitRetryBackoff:     100 * time.Millisecond,
+		DebugMaxSeries:         1000,
+	}
+}
+
+// walSegmentSize is the size of the segments of the WAL. It's overridden in
+// tests.
+var walSegmentSize = wlog.DefaultSegmentSize
+
+// walWriter writes records to the WAL. It's implemented by *wlog.WL and
+// overridden in tests.
+type walWriter interface {
+	Log(recs ...[]byte) error
+	LastSegmentAndOffset() (seg, offset int, err error)
+	Sync() error
+	NextSegment() (int, error)
+}
+
 // Storage implements storage.Storage, and just writes to the WAL.
 type Storage struct {
 	// Embed Queryable/ChunkQueryable for compatibility, but don't actually implement it.
@@ -128,9 +425,48 @@ type Storage struct {
 	walMtx    sync.RWMutex
 	walClosed bool
 
+	// truncateMtx serializes Truncate, which deletes segments and tracks the
+	// deleted series, with the replays of SelfCheck.
+	truncateMtx sync.Mutex
+
 	path   string
 	wal    *wlog.WL
 	logger log.Logger
+	// opts holds the Options of the storage, which Reconfigure replaces.
+	// reconfigureMtx serializes calls to Reconfigure.
+	opts           atomic.Pointer[Options]
+	reconfigureMtx sync.Mutex
+
+	// writer writes records to wal. verifyMtx serializes writes when
+	// Options.VerifyOnAppend is set.
+	writer    walWriter
+	verifyMtx sync.Mutex
+
+	// groupCommitter syncs the WAL on commit when Options.GroupCommitWindow
+	// is set, and is nil otherwise.
+	groupCommitter *groupCommitter
+
+	// writeBuffer bounds the bytes of commits in flight.
+	writeBuffer *writeBuffer
+
+	// backfillWALs are the open backfill WALs, by window, guarded by
+	// backfillMtx.
+	backfillMtx  sync.Mutex
+	backfillWALs map[BackfillWindow]*wlog.WL
+
+	// orphanExemplars buffers exemplars of series which don't exist yet.
+	orphanExemplars *orphanExemplars
+
+	// manifest is the latest manifest written when Options.WriteManifest is
+	// set.
+	manifestMtx sync.Mutex
+	manifest    Manifest
+
+	// derivedMtx serializes the appends of samples derived with
+	// Options.DerivedSeries, and derivedOrder orders the writes of the
+	// commits deriving samples.
+	derivedMtx   sync.Mutex
+	derivedOrder derivedOrder
 
 	appenderPool sync.Pool
 	bufPool      sync.Pool
@@ -139,26 +475,84 @@ type Storage struct {
 	series  *stripeSeries
 	deleted map[chunks.HeadSeriesRef]int // Deleted series, and what WAL segment they must be kept until.
 
+	// pendingBytes estimates the memory held by the pending buffers of
+	// appenders which have not been committed or rolled back yet.
+	pendingBytes atomic.Int64
+
 	metrics *storageMetrics
 
 	notifier wlog.WriteNotified
+
+	// tokens holds the idempotency tokens of the most recent commits.
+	tokens *tokenSet
+
+	// seriesErrors holds the last append error of recently failing series.
+	seriesErrors *seriesErrors
+
+	// droppedSamples reports dropped samples to Options.OnSampleDropped, and
+	// is nil if it isn't set.
+	droppedSamples atomic.Pointer[droppedSampleObserver]
+
+	// appendRate counts the samples committed over Options.AppendRateWindow.
+	appendRate *rateWindow
+
+	// healthMtx serializes calls to HealthCheck, and healthSeq is the
+	// sequence number of its latest canary record.
+	healthMtx sync.Mutex
+	healthSeq uint64
+
+	// now returns the current time. It's overridden in tests.
+	now func() time.Time
 }
 
-// NewStorage makes a new Storage.
+// NewStorage makes a new Storage using DefaultOptions.
 func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string) (*Storage, error) {
-	w, err := wlog.NewSize(logger, registerer, SubDirectory(path), wlog.DefaultSegmentSize, wlog.CompressionSnappy)
+	return NewStorageWithOptions(logger, registerer, path, DefaultOptions())
+}
+
+// NewStorageWithOptions makes a new Storage configured with the given options.
+func NewStorageWithOptions(logger log.Logger, registerer prometheus.Registerer, path string, opts Options) (*Storage, error) {
+	if opts.ColdDir != "" {
+		if err := os.MkdirAll(opts.ColdDir, 0o777); err != nil {
+			return nil, fmt.Errorf("create cold directory: %w", err)
+		}
+	}
+
+	w, err := wlog.NewSize(logger, registerer, SubDirectory(path), walSegmentSize, wlog.CompressionSnappy)
 	if err != nil {
 		return nil, err
 	}
 
 	storage := &Storage{
-		path:    path,
-		wal:     w,
-		logger:  logger,
-		deleted: map[chunks.HeadSeriesRef]int{},
-		series:  newStripeSeries(tsdb.DefaultStripeSize),
-		metrics: newStorageMetrics(registerer),
-		nextRef: atomic.NewUint64(0),
+		path:         path,
+		wal:          w,
+		writer:       w,
+		logger:       logger,
+		deleted:      map[chunks.HeadSeriesRef]int{},
+		series:       newStripeSeries(tsdb.DefaultStripeSize, opts.PreallocSeries),
+		metrics:      newStorageMetrics(registerer),
+		nextRef:      atomic.NewUint64(0),
+		tokens:       newTokenSet(opts.MaxIdempotencyTokens),
+		now:          time.Now,
+		seriesErrors: newSeriesErrors(opts.MaxSeriesErrors),
+		writeBuffer:  newWriteBuffer(opts.WriteBufferSize, opts.WritePressureHighWater),
+
+		orphanExemplars: newOrphanExemplars(opts.MaxOrphanExemplars, opts.OrphanExemplarTTL),
+		appendRate:      newRateWindow(opts.AppendRateWindow),
+	}
+	storage.opts.Store(&opts)
+	storage.droppedSamples.Store(newDroppedSampleObserver(opts.OnSampleDropped, opts.SampleDroppedRateLimit))
+
+	if opts.InternLabels {
+		storage.series.symbols = newSymbolTable()
+	}
+
+	if opts.DictionaryExemplarLabels {
+		level.Warn(logger).Log("msg", "dictionary encoding of exemplar labels is enabled, exemplars written to the WAL won't be sent by remote write")
+	}
+
+	if opts.GroupCommitWindow > 0 {
+		storage.groupCommitter = newGroupCommitter(opts.GroupCommitWindow, storage.syncWAL)
 	}
 
 	storage.bufPool.New = func() interface{} {
@@ -174,6 +568,7 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 			pendingHistograms:      make([]record.RefHistogramSample, 0, 100),
 			pendingFloatHistograms: make([]record.RefFloatHistogramSample, 0, 100),
 			pendingExamplars:       make([]record.RefExemplar, 0, 10),
+			seriesRefs:             make(map[chunks.HeadSeriesRef]struct{}),
 		}
 	}
 
@@ -184,6 +579,9 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		if ok := errors.As(err, &ce); !ok {
 			return nil, err
 		}
+		// Repairing the WAL drops the corrupted record along with every
+		// record after it.
+		storage.metrics.totalSkippedRecords.WithLabelValues(SkipReasonCorruption).Inc()
 		if err := w.Repair(ce); err != nil {
 			// if repair fails, truncate everything in WAL
 			level.Warn(storage.logger).Log("msg", "WAL repair failed, truncating!", "err", err)
@@ -198,6 +596,17 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		}
 	}
 
+	if err := storage.updateManifest(); err != nil {
+		return nil, fmt.Errorf("write WAL manifest: %w", err)
+	}
+	if opts.PreallocateSegments {
+		writer, err := newPreallocatingWriter(logger, w, walSegmentSize)
+		if err != nil {
+			return nil, fmt.Errorf("pre-allocate WAL segment: %w", err)
+		}
+		storage.writer = writer
+	}
+
 	return storage, nil
 }
 
@@ -291,13 +700,37 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 				return []record.RefFloatHistogramSample{}
 			},
 		}
+		exemplarsPool = sync.Pool{
+			New: func() interface{} {
+				return []record.RefExemplar{}
+			},
+		}
 	)
 
 	go func() {
 		defer close(decoded)
 		for r.Next() {
 			rec := r.Record()
-			switch dec.Type(rec) {
+			if isSampleSourcesRecord(rec) || isHealthCheckRecord(rec) {
+				// Sample sources are only kept for debugging, and health
+				// check records hold no data, neither is needed to load the
+				// WAL.
+				continue
+			}
+			if isIdempotencyTokensRecord(rec) {
+				tokens, err := DecodeIdempotencyTokens(rec, nil)
+				if err != nil {
+					errCh <- &wlog.CorruptionErr{
+						Err:     fmt.Errorf("decode idempotency tokens: %w", err),
+						Segment: r.Segment(),
+						Offset:  r.Offset(),
+					}
+					return
+				}
+				decoded <- tokens
+				continue
+			}
+			switch recordType(&dec, rec) {
 			case record.Series:
 				series := seriesPool.Get().([]record.RefSeries)[:0]
 				series, err = dec.Series(rec, series)
@@ -345,18 +778,30 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 					return
 				}
 				decoded <- floatHistograms
-			case record.Tombstones, record.Exemplars:
-				// We don't care about decoding tombstones or exemplars
-				// TODO: If decide to decode exemplars, we should make sure to prepopulate
-				// stripeSeries.exemplars in the next block by using setLatestExemplar.
+			case record.Exemplars:
+				// Exemplars are decoded to restore the latest exemplar of
+				// each series, which duplicate exemplars are checked against.
+				exemplars := exemplarsPool.Get().([]record.RefExemplar)[:0]
+				exemplars, err = decodeExemplars(&dec, rec, exemplars)
+				if err != nil {
+					errCh <- &wlog.CorruptionErr{
+						Err:     fmt.Errorf("decode exemplars: %w", err),
+						Segment: r.Segment(),
+						Offset:  r.Offset(),
+					}
+					return
+				}
+				decoded <- exemplars
+			case record.Tombstones:
+				// We don't care about decoding tombstones.
 				continue
 			default:
-				errCh <- &wlog.CorruptionErr{
-					Err:     fmt.Errorf("invalid record type %v", dec.Type(rec)),
-					Segment: r.Segment(),
-					Offset:  r.Offset(),
-				}
-				return
+				// Records written by newer versions may have types this
+				// version doesn't know about, skip them rather than
+				// discarding the rest of the WAL.
+				level.Warn(w.logger).Log("msg", "skipping WAL record of unknown type", "segment", r.Segment(), "offset", r.Offset())
+				w.metrics.totalSkippedRecords.WithLabelValues(SkipReasonUnknownType).Inc()
+				continue
 			}
 		}
 	}()
@@ -371,7 +816,15 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 				// If we read in a sample for it, we'll use the timestamp of the latest
 				// sample. Otherwise, the series is stale and will be deleted once
 				// the truncation is performed.
-				if w.series.GetByID(s.Ref) == nil {
+				if existing := w.series.GetByID(s.Ref); existing != nil {
+					// A later record for the same ref holds new labels for the
+					// series, see RelabelSeries.
+					if !labels.Equal(existing.lset, s.Labels) {
+						w.series.gcMut.Lock()
+						w.series.relabel(existing, s.Labels)
+						w.series.gcMut.Unlock()
+					}
+				} else {
 					series := &memSeries{ref: s.Ref, lset: s.Labels, lastTs: 0}
 					w.series.Set(s.Labels.Hash(), series)
 					multiRef[s.Ref] = series.ref
@@ -398,7 +851,7 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 
 				series := w.series.GetByID(ref)
 				if s.T > series.lastTs {
-					series.lastTs = s.T
+					series.setLatest(s.T, sampleFloat, s.V, value.IsStaleNaN(s.V))
 				}
 			}
 
@@ -414,7 +867,7 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 				}
 				series := w.series.GetByID(ref)
 				if entry.T > series.lastTs {
-					series.lastTs = entry.T
+					series.setLatest(entry.T, sampleHistogram, 0, value.IsStaleNaN(entry.H.Sum))
 				}
 			}
 
@@ -430,12 +883,42 @@ func (w *Storage) loadWAL(r *wlog.Reader, multiRef map[chunks.HeadSeriesRef]chun
 				}
 				series := w.series.GetByID(ref)
 				if entry.T > series.lastTs {
-					series.lastTs = entry.T
+					series.setLatest(entry.T, sampleHistogram, 0, value.IsStaleNaN(entry.FH.Sum))
 				}
 			}
 
 			//nolint:staticcheck
 			floatHistogramsPool.Put(v)
+		case []record.RefExemplar:
+			for _, e := range v {
+				// Exemplars of float and histogram series are associated
+				// with their series the same way, by ref.
+				ref, ok := multiRef[e.Ref]
+				if !ok {
+					nonExistentSeriesRefs.Inc()
+					continue
+				}
+				if prev := w.series.GetLatestExemplar(ref); prev != nil && prev.Ts > e.T {
+					continue
+				}
+				w.series.SetLatestExemplar(ref, &exemplar.Exemplar{
+					Labels: e.Labels,
+					Value:  e.V,
+					Ts:     e.T,
+					HasTs:  true,
+				})
+			}
+
+			//nolint:staticcheck
+			exemplarsPool.Put(v)
+		case []string:
+			// Idempotency tokens of past commits, restored so that their
+			// retries are still deduplicated.
+			w.tokens.mut.Lock()
+			for _, t := range v {
+				w.tokens.add(t)
+			}
+			w.tokens.mut.Unlock()
 		default:
 			panic(fmt.Errorf("unexpected decoded type: %T", d))
 		}
@@ -481,8 +964,11 @@ func (*Storage) StartTime() (int64, error) {
 }
 
 // Truncate removes all data from the WAL prior to the timestamp specified by
-// mint.
+// mint, then enforces Options.Retention.
 func (w *Storage) Truncate(mint int64) error {
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
 	w.walMtx.RLock()
 	defer w.walMtx.RUnlock()
 
@@ -491,6 +977,17 @@ func (w *Storage) Truncate(mint int64) error {
 	}
 
 	start := time.Now()
+	mint = w.options().Retention.mint(mint, w.now())
+
+	defer func() {
+		if err := w.updateManifest(); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to update WAL manifest", "err", err)
+		}
+	}()
+
+	if err := w.closeBackfillWALs(); err != nil {
+		level.Warn(w.logger).Log("msg", "failed to close backfill WALs", "err", err)
+	}
 
 	// Garbage collect series that haven't received an update since mint.
 	w.gc(mint)
@@ -503,7 +1000,7 @@ func (w *Storage) Truncate(mint int64) error {
 
 	// Start a new segment, so low ingestion volume instance don't have more WAL
 	// than needed.
-	_, err = w.wal.NextSegment()
+	_, err = w.writer.NextSegment()
 	if err != nil {
 		return fmt.Errorf("next segment: %w", err)
 	}
@@ -516,10 +1013,23 @@ func (w *Storage) Truncate(mint int64) error {
 	// The lower two thirds of segments should contain mostly obsolete samples.
 	// If we have less than two segments, it's not worth checkpointing yet.
 	last = first + (last-first)*2/3
-	if last <= first {
-		return nil
+	if last > first {
+		if err := w.checkpoint(first, last, samplesSince(mint)); err != nil {
+			return err
+		}
+
+		level.Info(w.logger).Log("msg", "WAL checkpoint complete",
+			"first", first, "last", last, "duration", time.Since(start))
 	}
 
+	return w.enforceMaxBytes()
+}
+
+// checkpoint writes a checkpoint of the segments in the range [first, last],
+// keeping series which are still tracked and samples satisfying keepSample,
+// then truncates the checkpointed segments. The WAL mutex must be held by the
+// caller.
+func (w *Storage) checkpoint(first, last int, keepSample func(id chunks.HeadSeriesRef, t int64) bool) error {
 	keep := func(id chunks.HeadSeriesRef) bool {
 		if w.series.GetByID(id) != nil {
 			return true
@@ -528,10 +1038,11 @@ func (w *Storage) Truncate(mint int64) error {
 		seg, ok := w.deleted[id]
 		return ok && seg > last
 	}
-	if _, err = wlog.Checkpoint(w.logger, w.wal, first, last, keep, mint); err != nil {
+	opts := w.options()
+	if err := streamCheckpoint(w.logger, w.wal, first, last, keep, keepSample, opts.Retention.MaxSamplesPerSeries, opts.MaxIdempotencyTokens); err != nil {
 		return fmt.Errorf("create checkpoint: %w", err)
 	}
-	if err := w.wal.Truncate(last + 1); err != nil {
+	if err := w.truncateSegments(first, last); err != nil {
 		// If truncating fails, we'll just try again at the next checkpoint.
 		// Leftover segments will just be ignored in the future if there's a checkpoint
 		// that supersedes them.
@@ -554,8 +1065,50 @@ func (w *Storage) Truncate(mint int64) error {
 		// They will just be ignored since a higher checkpoint exists.
 		level.Error(w.logger).Log("msg", "delete old checkpoints", "err", err)
 	}
+	return nil
+}
+
+// checkpointOnClose checkpoints every segment written so far, keeping only
+// the latest sample of each series, which is all the next replay needs to
+// restore its latest timestamp. The WAL mutex must be held by the caller.
+func (w *Storage) checkpointOnClose() error {
+	start := time.Now()
+	defer func() {
+		if err := w.updateManifest(); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to update WAL manifest", "err", err)
+		}
+	}()
+
+	// Seal the current segment so that it can be part of the checkpoint.
+	if _, err := w.wal.NextSegmentSync(); err != nil {
+		return fmt.Errorf("next segment: %w", err)
+	}
+
+	first, last, err := wlog.Segments(w.wal.Dir())
+	if err != nil {
+		return fmt.Errorf("get segment range: %w", err)
+	}
+	last-- // The newly created segment is empty.
+	if last < first {
+		return nil
+	}
+
+	// Only the latest sample of each series is needed to restore its latest
+	// timestamp. Series replayed without samples have no samples to keep.
+	keepSample := func(id chunks.HeadSeriesRef, t int64) bool {
+		series := w.series.GetByID(id)
+		if series == nil {
+			return false
+		}
+		series.Lock()
+		defer series.Unlock()
+		return t >= series.lastTs
+	}
+	if err := w.checkpoint(first, last, keepSample); err != nil {
+		return err
+	}
 
-	level.Info(w.logger).Log("msg", "WAL checkpoint complete",
+	level.Info(w.logger).Log("msg", "WAL checkpoint on close complete",
 		"first", first, "last", last, "duration", time.Since(start))
 	return nil
 }
@@ -573,6 +1126,7 @@ func (w *Storage) gc(mint int64) {
 	for ref := range deleted {
 		w.deleted[ref] = last
 	}
+	w.seriesErrors.delete(deleted)
 
 	w.metrics.numDeletedSeries.Set(float64(len(w.deleted)))
 }
@@ -585,12 +1139,17 @@ func (w *Storage) WriteStalenessMarkers(remoteTsFunc func() int64) error {
 	app := w.Appender(context.Background())
 	it := w.series.iterator()
 	for series := range it.Channel() {
+		series.Lock()
 		var (
-			ref  = series.ref
-			lset = series.lset
+			ref          = series.ref
+			lset         = series.lset
+			seriesLastTs = series.lastTs
 		)
+		series.Unlock()
 
-		ts := timestamp.FromTime(time.Now())
+		// Never write a stale marker older than the latest sample of the
+		// series, even if the clock went backwards.
+		ts := max(timestamp.FromTime(w.now()), seriesLastTs)
 		_, err := app.Append(storage.SeriesRef(ref), lset, ts, math.Float64frombits(value.StaleNaN))
 		if err != nil {
 			lastErr = err
@@ -598,7 +1157,7 @@ func (w *Storage) WriteStalenessMarkers(remoteTsFunc func() int64) error {
 
 		// Remove millisecond precision; the remote write timestamp we get
 		// only has second precision.
-		lastTs = (ts / 1000) * 1000
+		lastTs = max(lastTs, (ts/1000)*1000)
 	}
 
 	if lastErr == nil {
@@ -647,20 +1206,31 @@ func (w *Storage) Close() error {
 	}
 	w.walClosed = true
 
+	if w.options().CheckpointOnClose {
+		if err := w.checkpointOnClose(); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to checkpoint WAL on close", "err", err)
+		}
+	}
+
 	if w.metrics != nil {
 		w.metrics.Unregister()
 	}
-	return w.wal.Close()
+	return errors.Join(w.closeBackfillWALs(), w.wal.Close())
 }
 
 type appender struct {
 	w                      *Storage
+	token                  string // Idempotency token of the commit, if any.
+	source                 string // Source the samples are attributed to, if any.
 	pendingSeries          []record.RefSeries
 	pendingSamples         []record.RefSample
 	pendingExamplars       []record.RefExemplar
 	pendingHistograms      []record.RefHistogramSample
 	pendingFloatHistograms []record.RefFloatHistogramSample
 
+	// Refs of the series of pendingSeries.
+	seriesRefs map[chunks.HeadSeriesRef]struct{}
+
 	// Pointers to the series referenced by each element of pendingSamples.
 	// Series lock is not held on elements.
 	sampleSeries []*memSeries
@@ -672,40 +1242,82 @@ type appender struct {
 	// Pointers to the series referenced by each element of pendingFloatHistograms.
 	// Series lock is not held on elements.
 	floatHistogramSeries []*memSeries
+
+	// firstAppend is when the first pending sample was appended, and is zero
+	// while there's no pending sample.
+	firstAppend time.Time
 }
 
 var _ storage.Appender = (*appender)(nil)
 
 func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
-	series := a.w.series.GetByID(chunks.HeadSeriesRef(ref))
-	if series == nil {
-		// Ensure no empty or duplicate labels have gotten through. This mirrors the
-		// equivalent validation code in the TSDB's headAppender.
-		l = l.WithoutEmpty()
-		if len(l) == 0 {
-			return 0, fmt.Errorf("empty labelset: %w", tsdb.ErrInvalidSample)
+	return a.append(ref, l, t, v, true)
+}
+
+// append appends a sample, validating the labels of new series if validate
+// is set.
+func (a *appender) append(ref storage.SeriesRef, l labels.Labels, t int64, v float64, validate bool) (storage.SeriesRef, error) {
+	// Dropped samples are reported once the series is unlocked.
+	var dropReason string
+	defer func() {
+		if dropReason != "" {
+			a.w.sampleDropped(ref, l, t, v, dropReason)
 		}
+	}()
+
+	clampedT, err := a.checkFutureSkew(ref, t)
+	if err != nil {
+		dropReason = DropReasonFutureTimestamp
+		return 0, err
+	}
+	t = clampedT
 
-		if lbl, dup := l.HasDuplicateLabelNames(); dup {
-			return 0, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidSample)
+	series := a.w.series.GetByID(chunks.HeadSeriesRef(ref))
+	if series == nil && ref != 0 && a.w.options().StrictSeriesRefs {
+		a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonUnknownRef).Inc()
+		dropReason = DropReasonUnknownRef
+		return 0, unknownRefError(ref)
+	}
+	if series == nil {
+		if validate {
+			var (
+				reason string
+				err    error
+			)
+			l, reason, err = validateSeriesLabels(l)
+			if err != nil {
+				a.w.metrics.totalDroppedSamples.WithLabelValues(reason).Inc()
+				dropReason = reason
+				return 0, err
+			}
 		}
 
 		var created bool
 		series, created = a.getOrCreate(l)
 		if created {
-			a.pendingSeries = append(a.pendingSeries, record.RefSeries{
-				Ref:    series.ref,
-				Labels: l,
-			})
+			a.addPendingSeries(series.ref, l)
 
 			a.w.metrics.numActiveSeries.Inc()
 			a.w.metrics.totalCreatedSeries.Inc()
+			a.attachOrphanExemplars(series, l)
 		}
 	}
 
 	series.Lock()
 	defer series.Unlock()
 
+	a.addIfPending(series)
+
+	stale := value.IsStaleNaN(v)
+	if err := a.checkStale(series, t, stale); err != nil {
+		dropReason = DropReasonStaleSeries
+		return 0, err
+	}
+	if a.downsample(series, t, stale) {
+		dropReason = DropReasonDownsampled
+		return storage.SeriesRef(series.ref), nil
+	}
+
 	// NOTE(rfratto): always modify pendingSamples and sampleSeries together.
 	a.pendingSamples = append(a.pendingSamples, record.RefSample{
 		Ref: series.ref,
@@ -713,11 +1325,138 @@ func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v flo
 		V:   v,
 	})
 	a.sampleSeries = append(a.sampleSeries, series)
+	a.w.pendingBytes.Add(pendingSampleBytes)
+	a.markAppended()
 
 	a.w.metrics.totalAppendedSamples.Inc()
 	return storage.SeriesRef(series.ref), nil
 }
 
+// validateSeriesLabels strips empty labels from l and ensures no empty or
+// duplicate labels have gotten through. This mirrors the equivalent
+// validation code in the TSDB's headAppender. On failure, the drop reason of
+// the sample is returned alongside the error.
+func validateSeriesLabels(l labels.Labels) (labels.Labels, string, error) {
+	l = l.WithoutEmpty()
+	if len(l) == 0 {
+		return l, DropReasonEmptyLabelset, fmt.Errorf("empty labelset: %w", tsdb.ErrInvalidSample)
+	}
+
+	if lbl, dup := l.HasDuplicateLabelNames(); dup {
+		return l, DropReasonDuplicateLabelName, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidSample)
+	}
+	return l, "", nil
+}
+
+// unknownRefError returns the error of a sample appended with a ref which
+// doesn't match any series when Options.StrictSeriesRefs is set.
+func unknownRefError(ref storage.SeriesRef) error {
+	return fmt.Errorf("%w: unknown series ref %d", ErrSeriesNotFound, ref)
+}
+
+// downsample reports whether the sample of series at t must be dropped
+// following Options.DownsampleInterval. The lock of series must be held.
+func (a *appender) downsample(series *memSeries, t int64, stale bool) bool {
+	interval := a.w.options().DownsampleInterval
+	if interval <= 0 || stale {
+		return false
+	}
+	if !series.downsample(t, interval) {
+		return false
+	}
+	a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonDownsampled).Inc()
+	return true
+}
+
+// addPendingSeries adds the series with the given ref and labels to the series
+// records of the commit, unless it's already one of them.
+func (a *appender) addPendingSeries(ref chunks.HeadSeriesRef, l labels.Labels) {
+	if _, ok := a.seriesRefs[ref]; ok {
+		return
+	}
+	a.seriesRefs[ref] = struct{}{}
+	a.pendingSeries = append(a.pendingSeries, record.RefSeries{Ref: ref, Labels: l})
+	a.w.pendingBytes.Add(pendingSeriesBytes)
+}
+
+// addIfPending adds series to the series records of the commit if it was
+// created by another appender which hasn't written it to the WAL yet. Its
+// series record is then written before the samples and exemplars of this
+// commit, even if this commit is written first. The lock of series must be
+// held.
+func (a *appender) addIfPending(series *memSeries) {
+	if series.pending {
+		a.addPendingSeries(series.ref, series.lset)
+	}
+}
+
+// encodeRecords appends the records of the commit to b in the order they're
+// written to the WAL, and returns b along with the end offset of each record.
+func (a *appender) encodeRecords(b []byte) ([]byte, []int) {
+	var (
+		encoder record.Encoder
+		ends    []int
+	)
+
+	if len(a.pendingSeries) > 0 {
+		b = encoder.Series(a.pendingSeries, b)
+		ends = append(ends, len(b))
+	}
+
+	if len(a.pendingSamples) > 0 {
+		b = encoder.Samples(a.pendingSamples, b)
+		ends = append(ends, len(b))
+	}
+
+	if len(a.pendingHistograms) > 0 {
+		b = encoder.HistogramSamples(a.pendingHistograms, b)
+		ends = append(ends, len(b))
+	}
+
+	if len(a.pendingFloatHistograms) > 0 {
+		b = encoder.FloatHistogramSamples(a.pendingFloatHistograms, b)
+		ends = append(ends, len(b))
+	}
+
+	if a.source != "" && len(a.pendingSamples)+len(a.pendingHistograms)+len(a.pendingFloatHistograms) > 0 {
+		b = a.encodeSampleSources(b)
+		ends = append(ends, len(b))
+	}
+
+	// Exemplars should be logged after samples (float/native histogram/etc),
+	// otherwise it might happen that we send the exemplars in a remote write
+	// batch before the samples, which in turn means the exemplar is rejected
+	// for missing series, since series are created due to samples.
+	if len(a.pendingExamplars) > 0 {
+		if a.w.options().DictionaryExemplarLabels {
+			b = encodeDictExemplars(a.pendingExamplars, b)
+		} else {
+			b = encoder.Exemplars(a.pendingExamplars, b)
+		}
+		ends = append(ends, len(b))
+	}
+
+	// The token is written last, so that it's only replayed along with all
+	// the records of the commit.
+	if a.token != "" {
+		b = encodeIdempotencyTokens([]string{a.token}, b)
+		ends = append(ends, len(b))
+	}
+
+	return b, ends
+}
+
+// markSeriesWritten marks the series of pendingSeries as written to the WAL.
+func (a *appender) markSeriesWritten() {
+	for _, s := range a.pendingSeries {
+		if series := a.w.series.GetByID(s.Ref); series != nil {
+			series.Lock()
+			series.pending = false
+			series.Unlock()
+		}
+	}
+}
+
 func (a *appender) getOrCreate(l labels.Labels) (series *memSeries, created bool) {
 	hash := l.Hash()
 
@@ -727,42 +1466,49 @@ func (a *appender) getOrCreate(l labels.Labels) (series *memSeries, created bool
 	}
 
 	ref := chunks.HeadSeriesRef(a.w.nextRef.Inc())
-	series = &memSeries{ref: ref, lset: l, lastTs: math.MinInt64}
+	series = &memSeries{ref: ref, lset: l, lastTs: math.MinInt64, pending: true}
 	a.w.series.Set(l.Hash(), series)
 	return series, true
 }
 
-func (a *appender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+// AppendExemplar appends an exemplar to the series with the given ref. When
+// Options.MaxOrphanExemplars is set, exemplars of unknown refs are appended
+// to the series with labels l instead, and buffered until that series is
+// created if it doesn't exist yet.
+func (a *appender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
 	readRef := chunks.HeadSeriesRef(ref)
 
 	s := a.w.series.GetByID(readRef)
-	if s == nil {
-		return 0, fmt.Errorf("unknown series ref when trying to add exemplar: %d", readRef)
+	if s == nil && a.w.orphanExemplars.enabled() && len(l) > 0 {
+		s = a.w.series.GetByHash(l.Hash(), l)
 	}
+	if s == nil {
+		if !a.w.orphanExemplars.enabled() || len(l) == 0 {
+			return 0, fmt.Errorf("unknown series ref when trying to add exemplar: %d", readRef)
+		}
 
-	// Ensure no empty labels have gotten through.
-	e.Labels = e.Labels.WithoutEmpty()
-
-	if lbl, dup := e.Labels.HasDuplicateLabelNames(); dup {
-		return 0, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidExemplar)
+		var err error
+		if e.Labels, err = validateExemplarLabels(e.Labels); err != nil {
+			return 0, err
+		}
+		if !a.w.orphanExemplars.add(l.Copy(), e, a.w.now()) {
+			return 0, fmt.Errorf("unknown series when trying to add exemplar, and the buffer of orphan exemplars is full: %s", l)
+		}
+		return 0, nil
 	}
 
-	// Exemplar label length does not include chars involved in text rendering such as quotes
-	// equals sign, or commas. See definition of const ExemplarMaxLabelLength.
-	labelSetLen := 0
-	err := e.Labels.Validate(func(l labels.Label) error {
-		labelSetLen += utf8.RuneCountInString(l.Name)
-		labelSetLen += utf8.RuneCountInString(l.Value)
-
-		if labelSetLen > exemplar.ExemplarMaxLabelSetLength {
-			return storage.ErrExemplarLabelLength
-		}
-		return nil
-	})
+	var err error
+	e.Labels, err = validateExemplarLabels(e.Labels)
 	if err != nil {
 		return 0, err
 	}
+	return a.appendExemplar(s, e), nil
+}
 
+// appendExemplar appends the valid exemplar e to series s, unless it's a
+// duplicate of its latest exemplar. It returns the ref of s, or 0 if e was
+// discarded.
+func (a *appender) appendExemplar(s *memSeries, e exemplar.Exemplar) storage.SeriesRef {
 	// Check for duplicate vs last stored exemplar for this series, and discard those.
 	// Otherwise, record the current exemplar as the latest.
 	// Prometheus' TSDB returns 0 when encountering duplicates, so we do the same here.
@@ -773,63 +1519,144 @@ func (a *appender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exem
 	prevExemplar := a.w.series.GetLatestExemplar(s.ref)
 	if prevExemplar != nil && (prevExemplar.Equals(e) || prevExemplar.Ts > e.Ts) {
 		// Duplicate, don't return an error but don't accept the exemplar.
-		return 0, nil
+		return 0
 	}
 	a.w.series.SetLatestExemplar(s.ref, &e)
 
+	// Sampled out exemplars still count as the latest exemplar of their
+	// series, so that sampling doesn't change which exemplars are duplicates.
+	if !sampleExemplar(s, e, a.w.options().ExemplarSampleRatio) {
+		return 0
+	}
+
+	s.Lock()
+	a.addIfPending(s)
+	s.Unlock()
+
 	a.pendingExamplars = append(a.pendingExamplars, record.RefExemplar{
-		Ref:    readRef,
+		Ref:    s.ref,
 		T:      e.Ts,
 		V:      e.Value,
 		Labels: e.Labels,
 	})
+	a.w.pendingBytes.Add(pendingExemplarBytes)
 
 	a.w.metrics.totalAppendedExemplars.Inc()
-	return storage.SeriesRef(s.ref), nil
+	return storage.SeriesRef(s.ref)
+}
+
+// validateExemplarLabels strips empty labels from l and ensures they can be
+// used as the labels of an exemplar.
+func validateExemplarLabels(l labels.Labels) (labels.Labels, error) {
+	// Ensure no empty labels have gotten through.
+	l = l.WithoutEmpty()
+
+	if lbl, dup := l.HasDuplicateLabelNames(); dup {
+		return l, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidExemplar)
+	}
+
+	// Exemplar label length does not include chars involved in text rendering such as quotes
+	// equals sign, or commas. See definition of const ExemplarMaxLabelLength.
+	labelSetLen := 0
+	err := l.Validate(func(l labels.Label) error {
+		labelSetLen += utf8.RuneCountInString(l.Name)
+		labelSetLen += utf8.RuneCountInString(l.Value)
+
+		if labelSetLen > exemplar.ExemplarMaxLabelSetLength {
+			return storage.ErrExemplarLabelLength
+		}
+		return nil
+	})
+	return l, err
 }
 
 func (a *appender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	// Dropped samples are reported once the series is unlocked, with the sum
+	// of the histogram as their value.
+	var dropReason string
+	defer func() {
+		if dropReason != "" {
+			a.w.sampleDropped(ref, l, t, histogramSum(h, fh), dropReason)
+		}
+	}()
+
 	if h != nil {
 		if err := h.Validate(); err != nil {
+			a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonInvalidHistogram).Inc()
+			if ref != 0 {
+				a.w.seriesErrors.set(chunks.HeadSeriesRef(ref), err)
+			}
+			dropReason = DropReasonInvalidHistogram
 			return 0, err
 		}
 	}
 
 	if fh != nil {
 		if err := fh.Validate(); err != nil {
+			a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonInvalidHistogram).Inc()
+			if ref != 0 {
+				a.w.seriesErrors.set(chunks.HeadSeriesRef(ref), err)
+			}
+			dropReason = DropReasonInvalidHistogram
 			return 0, err
 		}
 	}
 
+	clampedT, err := a.checkFutureSkew(ref, t)
+	if err != nil {
+		dropReason = DropReasonFutureTimestamp
+		return 0, err
+	}
+	t = clampedT
+
+	if h != nil && a.w.options().ConvertHistogramsToFloat {
+		fh, h = h.ToFloat(nil), nil
+	}
+
 	series := a.w.series.GetByID(chunks.HeadSeriesRef(ref))
+	if series == nil && ref != 0 && a.w.options().StrictSeriesRefs {
+		a.w.metrics.totalDroppedSamples.WithLabelValues(DropReasonUnknownRef).Inc()
+		dropReason = DropReasonUnknownRef
+		return 0, unknownRefError(ref)
+	}
 	if series == nil {
-		// Ensure no empty or duplicate labels have gotten through. This mirrors the
-		// equivalent validation code in the TSDB's headAppender.
-		l = l.WithoutEmpty()
-		if len(l) == 0 {
-			return 0, fmt.Errorf("empty labelset: %w", tsdb.ErrInvalidSample)
-		}
-
-		if lbl, dup := l.HasDuplicateLabelNames(); dup {
-			return 0, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidSample)
+		var (
+			reason string
+			err    error
+		)
+		l, reason, err = validateSeriesLabels(l)
+		if err != nil {
+			a.w.metrics.totalDroppedSamples.WithLabelValues(reason).Inc()
+			dropReason = reason
+			return 0, err
 		}
 
 		var created bool
 		series, created = a.getOrCreate(l)
 		if created {
-			a.pendingSeries = append(a.pendingSeries, record.RefSeries{
-				Ref:    series.ref,
-				Labels: l,
-			})
+			a.addPendingSeries(series.ref, l)
 
 			a.w.metrics.numActiveSeries.Inc()
 			a.w.metrics.totalCreatedSeries.Inc()
+			a.attachOrphanExemplars(series, l)
 		}
 	}
 
 	series.Lock()
 	defer series.Unlock()
 
+	a.addIfPending(series)
+
+	stale := (h != nil && value.IsStaleNaN(h.Sum)) || (fh != nil && value.IsStaleNaN(fh.Sum))
+	if err := a.checkStale(series, t, stale); err != nil {
+		dropReason = DropReasonStaleSeries
+		return 0, err
+	}
+	if a.downsample(series, t, stale) {
+		dropReason = DropReasonDownsampled
+		return storage.SeriesRef(series.ref), nil
+	}
+
 	switch {
 	case h != nil:
 		// NOTE(rfratto): always modify pendingHistograms and histogramSeries
@@ -840,6 +1667,7 @@ func (a *appender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int
 			H:   h,
 		})
 		a.histogramSeries = append(a.histogramSeries, series)
+		a.w.pendingBytes.Add(pendingHistogramBytes)
 	case fh != nil:
 		// NOTE(rfratto): always modify pendingFloatHistograms and
 		// floatHistogramSeries together.
@@ -849,12 +1677,26 @@ func (a *appender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int
 			FH:  fh,
 		})
 		a.floatHistogramSeries = append(a.floatHistogramSeries, series)
+		a.w.pendingBytes.Add(pendingFloatHistogramBytes)
 	}
+	a.markAppended()
 
 	a.w.metrics.totalAppendedSamples.Inc()
 	return storage.SeriesRef(series.ref), nil
 }
 
+// histogramSum returns the sum of the histogram sample h or fh, whichever is
+// set.
+func histogramSum(h *histogram.Histogram, fh *histogram.FloatHistogram) float64 {
+	switch {
+	case h != nil:
+		return h.Sum
+	case fh != nil:
+		return fh.Sum
+	}
+	return 0
+}
+
 func (a *appender) AppendCTZeroSample(_ storage.SeriesRef, _ labels.Labels, _ int64, _ int64) (storage.SeriesRef, error) {
 	// TODO(ptodev): implement this later
 	return 0, nil
@@ -867,8 +1709,20 @@ func (a *appender) UpdateMetadata(ref storage.SeriesRef, _ labels.Labels, m meta
 
 // Commit submits the collected samples and purges the batch.
 func (a *appender) Commit() error {
-	if err := a.log(); err != nil {
-		return err
+	_, err := a.CommitWithStats()
+	return err
+}
+
+// CommitWithStats is like Commit, but also returns what the commit wrote to
+// the WAL.
+func (a *appender) CommitWithStats() (CommitStats, error) {
+	if a.token != "" {
+		return a.commitWithToken()
+	}
+
+	stats, err := a.log()
+	if err != nil {
+		return CommitStats{}, err
 	}
 
 	if a.w.notifier != nil {
@@ -877,93 +1731,111 @@ func (a *appender) Commit() error {
 
 	a.clearData()
 	a.w.appenderPool.Put(a)
-	return nil
+	return stats, nil
 }
 
-func (a *appender) log() error {
-	a.w.walMtx.RLock()
-	defer a.w.walMtx.RUnlock()
-
-	if a.w.walClosed {
-		return ErrWALClosed
+func (a *appender) log() (CommitStats, error) {
+	var stats CommitStats
+
+	// Commits deriving samples write their records in the order their
+	// samples were derived, so that derived series stay in order in the WAL.
+	// They're only ordered until their records are written, not while the
+	// WAL is synced.
+	written := func() {}
+	if fn := a.w.options().DerivedSeries; fn != nil && len(a.pendingSamples) > 0 {
+		if seq := a.appendDerived(fn); seq != 0 {
+			written = a.w.derivedOrder.wait(seq)
+			defer written()
+		}
 	}
 
-	var encoder record.Encoder
 	buf := a.w.bufPool.Get().([]byte)
 	defer func() {
-		a.w.bufPool.Put(buf) //nolint:staticcheck
+		a.w.bufPool.Put(buf[:0]) //nolint:staticcheck
 	}()
+	buf, ends := a.encodeRecords(buf)
 
-	if len(a.pendingSeries) > 0 {
-		buf = encoder.Series(a.pendingSeries, buf)
-		if err := a.w.wal.Log(buf); err != nil {
-			return err
-		}
-		buf = buf[:0]
-	}
+	// Reserve the size of the commit in the write buffer before holding the
+	// WAL lock, so the WAL can still be closed or truncated while waiting
+	// for space. The reservation is released once the commit completes.
+	done := a.w.writeBuffer.reserve(len(buf))
+	defer done()
 
-	if len(a.pendingSamples) > 0 {
-		buf = encoder.Samples(a.pendingSamples, buf)
-		if err := a.w.wal.Log(buf); err != nil {
-			return err
-		}
-		buf = buf[:0]
-	}
+	a.w.walMtx.RLock()
+	defer a.w.walMtx.RUnlock()
 
-	if len(a.pendingHistograms) > 0 {
-		buf = encoder.HistogramSamples(a.pendingHistograms, buf)
-		if err := a.w.wal.Log(buf); err != nil {
-			return err
-		}
-		buf = buf[:0]
+	if a.w.walClosed {
+		return stats, ErrWALClosed
 	}
 
-	if len(a.pendingFloatHistograms) > 0 {
-		buf = encoder.FloatHistogramSamples(a.pendingFloatHistograms, buf)
-		if err := a.w.wal.Log(buf); err != nil {
-			return err
+	var start int
+	for i, end := range ends {
+		if err := a.logRecord(buf[start:end], &stats); err != nil {
+			return stats, err
+		}
+		start = end
+		if i == 0 && len(a.pendingSeries) > 0 {
+			a.markSeriesWritten()
 		}
-		buf = buf[:0]
 	}
 
-	// Exemplars should be logged after samples (float/native histogram/etc),
-	// otherwise it might happen that we send the exemplars in a remote write
-	// batch before the samples, which in turn means the exemplar is rejected
-	// for missing series, since series are created due to samples.
-	if len(a.pendingExamplars) > 0 {
-		buf = encoder.Exemplars(a.pendingExamplars, buf)
-		if err := a.w.wal.Log(buf); err != nil {
-			return err
+	written()
+
+	a.w.updateManifestOnRotation()
+
+	// The read lock on the WAL is held while waiting, so it can't be closed
+	// before the sync.
+	if a.w.groupCommitter != nil {
+		if err := a.w.groupCommitter.wait(); err != nil {
+			return stats, fmt.Errorf("sync WAL: %w", err)
 		}
-		buf = buf[:0]
+		a.observeDurable()
+	} else if a.w.options().CommitRetries > 0 && stats.Bytes > 0 {
+		if err := a.w.syncWAL(); err != nil {
+			return stats, fmt.Errorf("sync WAL: %w", err)
+		}
+		a.observeDurable()
 	}
 
 	var series *memSeries
 	for i, s := range a.pendingSamples {
 		series = a.sampleSeries[i]
-		if !series.updateTimestamp(s.T) {
+		if !series.updateTimestamp(s.T, sampleFloat, s.V, value.IsStaleNaN(s.V)) {
 			a.w.metrics.totalOutOfOrderSamples.Inc()
+			a.w.seriesErrors.set(series.ref, outOfOrderError(s.T))
 		}
 	}
 	for i, s := range a.pendingHistograms {
 		series = a.histogramSeries[i]
-		if !series.updateTimestamp(s.T) {
+		if !series.updateTimestamp(s.T, sampleHistogram, 0, value.IsStaleNaN(s.H.Sum)) {
 			a.w.metrics.totalOutOfOrderSamples.Inc()
+			a.w.seriesErrors.set(series.ref, outOfOrderError(s.T))
 		}
 	}
 	for i, s := range a.pendingFloatHistograms {
 		series = a.floatHistogramSeries[i]
-		if !series.updateTimestamp(s.T) {
+		if !series.updateTimestamp(s.T, sampleHistogram, 0, value.IsStaleNaN(s.FH.Sum)) {
 			a.w.metrics.totalOutOfOrderSamples.Inc()
+			a.w.seriesErrors.set(series.ref, outOfOrderError(s.T))
 		}
 	}
 
-	return nil
+	stats.Series = len(a.pendingSeries)
+	stats.Samples = len(a.pendingSamples) + len(a.pendingHistograms) + len(a.pendingFloatHistograms)
+	stats.Exemplars = len(a.pendingExamplars)
+	a.w.appendRate.add(a.w.now(), stats.Samples)
+	return stats, nil
 }
 
 // clearData clears all pending data.
 func (a *appender) clearData() {
+	a.w.pendingBytes.Sub(a.pendingSize())
+
+	a.token = ""
+	a.source = ""
+
 	a.pendingSeries = a.pendingSeries[:0]
+	clear(a.seriesRefs)
 	a.pendingSamples = a.pendingSamples[:0]
 	a.pendingHistograms = a.pendingHistograms[:0]
 	a.pendingFloatHistograms = a.pendingFloatHistograms[:0]
@@ -971,6 +1843,23 @@ func (a *appender) clearData() {
 	a.sampleSeries = a.sampleSeries[:0]
 	a.histogramSeries = a.histogramSeries[:0]
 	a.floatHistogramSeries = a.floatHistogramSeries[:0]
+	a.firstAppend = time.Time{}
+}
+
+// markAppended records the time of the first pending sample of the
+// appender.
+func (a *appender) markAppended() {
+	if a.firstAppend.IsZero() {
+		a.firstAppend = time.Now()
+	}
+}
+
+// observeDurable observes the time elapsed since the first pending sample
+// was appended, once the commit writing it is synced to disk.
+func (a *appender) observeDurable() {
+	if !a.firstAppend.IsZero() {
+		a.w.metrics.appendToDurable.Observe(time.Since(a.firstAppend).Seconds())
+	}
 }
 
 func (a *appender) Rollback() error {
@@ -1003,10 +1892,11 @@ func (a *appender) logSeries() error {
 		}()
 
 		buf = encoder.Series(a.pendingSeries, buf)
-		if err := a.w.wal.Log(buf); err != nil {
+		if err := a.w.logRecord(buf); err != nil {
 			return err
 		}
 		buf = buf[:0]
+		a.markSeriesWritten()
 	}
 
 	return nil
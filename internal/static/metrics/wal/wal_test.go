@@ -1,23 +1,44 @@
 package wal
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/grafana/alloy/internal/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 )
 
 func TestStorage_InvalidSeries(t *testing.T) {
@@ -60,6 +81,377 @@ func TestStorage_InvalidSeries(t *testing.T) {
 	require.NoError(t, err, "should not reject valid exemplars")
 }
 
+func TestStorage_OrphanExemplars(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.MaxOrphanExemplars = 2
+	opts.OrphanExemplarTTL = time.Minute
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	var (
+		lbls    = labels.FromStrings("__name__", "traced")
+		expired = labels.FromStrings("__name__", "expired")
+		e       = exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: 10, HasTs: true}
+	)
+
+	// The exemplars arrive before their series, and are buffered.
+	app := s.Appender(t.Context())
+	ref, err := app.AppendExemplar(0, lbls, e)
+	require.NoError(t, err)
+	require.Zero(t, ref)
+	_, err = app.AppendExemplar(0, expired, e)
+	require.NoError(t, err)
+
+	// The buffer is bounded.
+	_, err = app.AppendExemplar(0, labels.FromStrings("__name__", "other"), e)
+	require.ErrorContains(t, err, "buffer of orphan exemplars is full")
+	require.NoError(t, app.Commit())
+
+	// The exemplar is attached once its series arrives.
+	app = s.Appender(t.Context())
+	ref, err = app.Append(0, lbls, 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Orphans expire if their series doesn't arrive in time.
+	now = now.Add(2 * time.Minute)
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, expired, 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	require.Len(t, collector.exemplars, 1)
+	require.Equal(t, record.RefExemplar{Ref: chunks.HeadSeriesRef(ref), T: e.Ts, V: e.Value, Labels: e.Labels}, collector.exemplars[0])
+
+	// Without buffering, exemplars of unknown series are rejected.
+	s.orphanExemplars = newOrphanExemplars(0, 0)
+	_, err = s.Appender(t.Context()).AppendExemplar(0, labels.FromStrings("__name__", "unknown"), e)
+	require.ErrorContains(t, err, "unknown series ref")
+}
+
+func TestStorage_AppendRate(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.AppendRateWindow = 10 * time.Second
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	require.Zero(t, s.AppendRate())
+
+	// Commit 10 samples every 100ms, a steady rate of 100 samples/s.
+	lbls := labels.FromStrings("__name__", "steady")
+	var ts int64
+	for i := 0; i < 300; i++ {
+		app := s.Appender(t.Context())
+		for j := 0; j < 10; j++ {
+			ts++
+			_, err := app.Append(0, lbls, ts, 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+		now = now.Add(100 * time.Millisecond)
+
+		if i >= 20 {
+			require.InDelta(t, 100, s.AppendRate(), 5, "after %s", now.Sub(time.Unix(1000, 0)))
+		}
+	}
+
+	// The rate decays once appends stop, and drops to 0 past the window.
+	now = now.Add(5 * time.Second)
+	require.InDelta(t, 50, s.AppendRate(), 5)
+	now = now.Add(10 * time.Second)
+	require.Zero(t, s.AppendRate())
+}
+
+func TestStorage_MaxFutureSkew(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy FutureSkewPolicy
+	}{
+		{name: "clamp", policy: FutureSkewClamp},
+		{name: "reject", policy: FutureSkewReject},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			walDir := t.TempDir()
+
+			opts := DefaultOptions()
+			opts.MaxFutureSkew = time.Minute
+			opts.FutureSkewPolicy = tc.policy
+
+			s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+			require.NoError(t, err)
+
+			now := time.Unix(1000, 0)
+			s.now = func() time.Time { return now }
+
+			var (
+				lbls   = labels.FromStrings("__name__", "skewed")
+				maxT   = timestamp.FromTime(now.Add(time.Minute))
+				future = timestamp.FromTime(now.Add(24 * time.Hour))
+			)
+
+			// Dry appenders validate timestamps the same way.
+			dry := s.DryAppender(t.Context())
+			_, err = dry.Append(0, lbls, future, 2)
+			switch tc.policy {
+			case FutureSkewClamp:
+				require.NoError(t, err)
+				// The sample is clamped, so a sample at the latest
+				// accepted timestamp isn't out of order with it.
+				_, err = dry.Append(0, lbls, maxT, 3)
+				require.NoError(t, err)
+				require.NoError(t, dry.Commit())
+			case FutureSkewReject:
+				var skewErr *FutureTimestampError
+				require.ErrorAs(t, err, &skewErr)
+				require.NoError(t, dry.Rollback())
+			}
+
+			app := s.Appender(t.Context())
+			_, err = app.Append(0, lbls, maxT, 1)
+			require.NoError(t, err)
+			_, err = app.Append(0, lbls, future, 2)
+			switch tc.policy {
+			case FutureSkewClamp:
+				require.NoError(t, err)
+			case FutureSkewReject:
+				var skewErr *FutureTimestampError
+				require.ErrorAs(t, err, &skewErr)
+				require.Equal(t, FutureTimestampError{Timestamp: future, Max: maxT}, *skewErr)
+			}
+			require.NoError(t, app.Commit())
+			require.NoError(t, s.Close())
+
+			collector := walDataCollector{}
+			replayer := walReplayer{w: &collector}
+			require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+			var got []record.RefSample
+			for _, sample := range collector.samples {
+				got = append(got, record.RefSample{T: sample.T, V: sample.V})
+			}
+			switch tc.policy {
+			case FutureSkewClamp:
+				require.Equal(t, []record.RefSample{{T: maxT, V: 1}, {T: maxT, V: 2}}, got)
+			case FutureSkewReject:
+				require.Equal(t, []record.RefSample{{T: maxT, V: 1}}, got)
+			}
+		})
+	}
+}
+
+func TestStorage_OnSampleDropped(t *testing.T) {
+	type drop struct {
+		lbls   labels.Labels
+		t      int64
+		v      float64
+		reason string
+	}
+	var (
+		mut   sync.Mutex
+		drops []drop
+	)
+
+	opts := DefaultOptions()
+	opts.MaxFutureSkew = time.Minute
+	opts.FutureSkewPolicy = FutureSkewReject
+	opts.DownsampleInterval = time.Minute
+	opts.SampleDroppedRateLimit = 4
+	opts.OnSampleDropped = func(lbls labels.Labels, t int64, v float64, reason string) {
+		mut.Lock()
+		defer mut.Unlock()
+		drops = append(drops, drop{lbls: lbls, t: t, v: v, reason: reason})
+	}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	future := timestamp.FromTime(now.Add(time.Hour))
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.EmptyLabels(), 1, 1)
+	require.Error(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "dup", "__name__", "dup"), 2, 2)
+	require.Error(t, err)
+	ref, err := app.Append(0, labels.FromStrings("__name__", "foo"), 1000, 3)
+	require.NoError(t, err)
+	// The labels of samples appended by ref are looked up.
+	_, err = app.Append(ref, labels.EmptyLabels(), future, 4)
+	require.Error(t, err)
+	_, err = app.Append(ref, labels.EmptyLabels(), 2000, 5)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, []drop{
+		{lbls: labels.EmptyLabels(), t: 1, v: 1, reason: DropReasonEmptyLabelset},
+		{lbls: labels.FromStrings("__name__", "dup", "__name__", "dup"), t: 2, v: 2, reason: DropReasonDuplicateLabelName},
+		{lbls: labels.FromStrings("__name__", "foo"), t: future, v: 4, reason: DropReasonFutureTimestamp},
+		{lbls: labels.FromStrings("__name__", "foo"), t: 2000, v: 5, reason: DropReasonDownsampled},
+	}, drops)
+
+	// Calls are rate limited.
+	app = s.Appender(t.Context())
+	for i := 0; i < 100; i++ {
+		_, err = app.Append(0, labels.EmptyLabels(), int64(i), 1)
+		require.Error(t, err)
+	}
+	require.NoError(t, app.Rollback())
+	require.Less(t, len(drops), 10)
+}
+
+func TestStorage_OnSampleDropped_Histograms(t *testing.T) {
+	type drop struct {
+		lbls   labels.Labels
+		t      int64
+		v      float64
+		reason string
+	}
+	var (
+		mut   sync.Mutex
+		drops []drop
+	)
+
+	opts := DefaultOptions()
+	opts.MaxFutureSkew = time.Minute
+	opts.FutureSkewPolicy = FutureSkewReject
+	opts.DownsampleInterval = time.Minute
+	opts.OnSampleDropped = func(lbls labels.Labels, t int64, v float64, reason string) {
+		mut.Lock()
+		defer mut.Unlock()
+		drops = append(drops, drop{lbls: lbls, t: t, v: v, reason: reason})
+	}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	future := timestamp.FromTime(now.Add(time.Hour))
+
+	h := tsdbutil.GenerateTestHistogram(1)
+	invalid := tsdbutil.GenerateTestHistogram(1)
+	invalid.Count = 0
+
+	app := s.Appender(t.Context())
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 1000, invalid, nil)
+	require.Error(t, err)
+	_, err = app.AppendHistogram(0, labels.EmptyLabels(), 1000, h, nil)
+	require.Error(t, err)
+	ref, err := app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 1000, h, nil)
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(ref, labels.EmptyLabels(), future, nil, h.ToFloat(nil))
+	require.Error(t, err)
+	_, err = app.AppendHistogram(ref, labels.EmptyLabels(), 2000, h, nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, []drop{
+		{lbls: labels.FromStrings("__name__", "hist"), t: 1000, v: invalid.Sum, reason: DropReasonInvalidHistogram},
+		{lbls: labels.EmptyLabels(), t: 1000, v: h.Sum, reason: DropReasonEmptyLabelset},
+		{lbls: labels.FromStrings("__name__", "hist"), t: future, v: h.Sum, reason: DropReasonFutureTimestamp},
+		{lbls: labels.FromStrings("__name__", "hist"), t: 2000, v: h.Sum, reason: DropReasonDownsampled},
+	}, drops)
+}
+
+func TestStorage_Reconfigure(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxFutureSkew = time.Hour
+	opts.FutureSkewPolicy = FutureSkewReject
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	lbls := labels.FromStrings("__name__", "foo")
+	ts := timestamp.FromTime(now.Add(time.Minute))
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, lbls, ts, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// The narrower window applies to the next append.
+	opts.MaxFutureSkew = time.Second
+	require.NoError(t, s.Reconfigure(opts))
+
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, lbls, ts+1, 1)
+	var skewErr *FutureTimestampError
+	require.ErrorAs(t, err, &skewErr)
+	require.NoError(t, app.Rollback())
+
+	// Options sizing the storage are rejected, and nothing is applied.
+	restart := opts
+	restart.MaxFutureSkew = time.Hour
+	restart.MaxIdempotencyTokens = 1
+	restart.ColdDir = t.TempDir()
+	restart.DictionaryExemplarLabels = true
+	err = s.Reconfigure(restart)
+	require.ErrorIs(t, err, ErrRestartRequired)
+	require.ErrorContains(t, err, "MaxIdempotencyTokens, ColdDir, DictionaryExemplarLabels")
+	require.Equal(t, time.Second, s.options().MaxFutureSkew)
+}
+
+func TestStorage_DroppedSamplesByReason(t *testing.T) {
+	walDir := t.TempDir()
+
+	reg := prometheus.NewRegistry()
+	s, err := NewStorage(log.NewNopLogger(), reg, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+
+	_, err = app.Append(0, labels.Labels{}, 0, 0)
+	require.Error(t, err)
+	_, err = app.Append(0, labels.Labels{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}}, 0, 0)
+	require.Error(t, err)
+	_, err = app.Append(0, labels.Labels{{Name: "a", Value: "1"}, {Name: "a", Value: "3"}}, 0, 0)
+	require.Error(t, err)
+	_, err = app.Append(0, labels.Labels{{Name: "a", Value: "1"}}, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	dropped := s.metrics.totalDroppedSamples
+	require.Equal(t, 1.0, testutil.ToFloat64(dropped.WithLabelValues(DropReasonEmptyLabelset)))
+	require.Equal(t, 2.0, testutil.ToFloat64(dropped.WithLabelValues(DropReasonDuplicateLabelName)))
+	require.Equal(t, 0.0, testutil.ToFloat64(dropped.WithLabelValues(DropReasonInvalidHistogram)))
+}
+
 func TestStorage(t *testing.T) {
 	walDir := t.TempDir()
 
@@ -181,6 +573,158 @@ func TestStorage_DuplicateExemplarsIgnored(t *testing.T) {
 	require.Equal(t, 4, len(collector.exemplars))
 }
 
+func TestStorage_ExemplarSampleRatio(t *testing.T) {
+	appendExemplars := func() []record.RefExemplar {
+		opts := DefaultOptions()
+		opts.ExemplarSampleRatio = 0.5
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, s.Close())
+		}()
+
+		app := s.Appender(t.Context())
+		ref, err := app.Append(0, labels.FromStrings("__name__", "foo"), 0, 0)
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			e := exemplar.Exemplar{Labels: labels.FromStrings("trace_id", strconv.Itoa(i)), Value: 1, Ts: int64(i), HasTs: true}
+			_, err := app.AppendExemplar(ref, labels.EmptyLabels(), e)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+
+		collector := walDataCollector{}
+		replayer := walReplayer{w: &collector}
+		require.NoError(t, replayer.Replay(s.wal.Dir()))
+		return collector.exemplars
+	}
+
+	exemplars := appendExemplars()
+	require.InDelta(t, 500, len(exemplars), 75)
+
+	// The same exemplars are kept every time.
+	require.Equal(t, exemplars, appendExemplars())
+}
+
+func TestStorage_DictionaryExemplarLabels(t *testing.T) {
+	lbls := labels.FromStrings("__name__", "foo")
+	appendExemplars := func(dict bool) (string, []record.RefExemplar) {
+		walDir := t.TempDir()
+		opts := DefaultOptions()
+		opts.DictionaryExemplarLabels = dict
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+		require.NoError(t, err)
+
+		app := s.Appender(t.Context())
+		ref, err := app.Append(0, lbls, 0, 0)
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			e := exemplar.Exemplar{
+				Labels: labels.FromStrings(
+					"service_instance_id", "checkout-7d9f8b6c5-x2x4z",
+					"span_id", fmt.Sprintf("%016x", i),
+					"trace_id", fmt.Sprintf("%032x", i),
+				),
+				Value: float64(i),
+				Ts:    int64(i),
+				HasTs: true,
+			}
+			_, err := app.AppendExemplar(ref, lbls, e)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+		require.NoError(t, s.Close())
+
+		collector := walDataCollector{}
+		replayer := walReplayer{w: &collector}
+		require.NoError(t, replayer.Replay(s.wal.Dir()))
+		return walDir, collector.exemplars
+	}
+
+	plainDir, plain := appendExemplars(false)
+	dictDir, dict := appendExemplars(true)
+	require.Len(t, dict, 1000)
+	require.Equal(t, plain, dict)
+
+	// Records are compared before the compression of the WAL pages.
+	recordsSize := func(dir string) int {
+		sr, err := wlog.NewSegmentsReader(filepath.Join(dir, "wal"))
+		require.NoError(t, err)
+		defer sr.Close()
+
+		var size int
+		r := wlog.NewReader(sr)
+		for r.Next() {
+			size += len(r.Record())
+		}
+		require.NoError(t, r.Err())
+		return size
+	}
+	require.Less(t, recordsSize(dictDir), recordsSize(plainDir)*3/4)
+
+	// Loading the WAL restores the latest exemplar from the dictionary
+	// encoded records, so it's detected as a duplicate.
+	s, err := NewStorage(log.NewNopLogger(), nil, dictDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	require.Zero(t, testutil.ToFloat64(s.metrics.totalSkippedRecords.WithLabelValues(SkipReasonUnknownType)))
+	app := s.Appender(t.Context())
+	last := dict[len(dict)-1]
+	ref, err := app.AppendExemplar(storage.SeriesRef(last.Ref), lbls, exemplar.Exemplar{Labels: last.Labels, Value: last.V, Ts: last.T, HasTs: true})
+	require.NoError(t, err)
+	require.Zero(t, ref)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_HistogramExemplars(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	lbls := labels.FromStrings("__name__", "hist")
+	h := tsdbutil.GenerateTestHistogram(1)
+	e := exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 2, Ts: 10, HasTs: true}
+
+	app := s.Appender(t.Context())
+	ref, err := app.AppendHistogram(0, lbls, 10, h, nil)
+	require.NoError(t, err)
+	exemplarRef, err := app.AppendExemplar(ref, lbls, e)
+	require.NoError(t, err)
+	require.Equal(t, ref, exemplarRef)
+	// Duplicates are ignored like for float series.
+	exemplarRef, err = app.AppendExemplar(ref, lbls, e)
+	require.NoError(t, err)
+	require.Zero(t, exemplarRef)
+	require.NoError(t, app.Commit())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+	require.Len(t, collector.histograms, 1)
+	require.Len(t, collector.exemplars, 1)
+	require.Equal(t, chunks.HeadSeriesRef(ref), collector.histograms[0].Ref)
+	require.Equal(t, record.RefExemplar{Ref: chunks.HeadSeriesRef(ref), T: e.Ts, V: e.Value, Labels: e.Labels}, collector.exemplars[0])
+	require.NoError(t, s.Close())
+
+	// The latest exemplar of the histogram series is restored on replay, so
+	// duplicates are still ignored after a restart.
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	require.Equal(t, &e, s.series.GetLatestExemplar(chunks.HeadSeriesRef(ref)))
+
+	app = s.Appender(t.Context())
+	exemplarRef, err = app.AppendExemplar(ref, lbls, e)
+	require.NoError(t, err)
+	require.Zero(t, exemplarRef)
+	require.NoError(t, app.Commit())
+}
+
 func TestStorage_ExistingWAL(t *testing.T) {
 	walDir := t.TempDir()
 
@@ -221,172 +765,2683 @@ func TestStorage_ExistingWAL(t *testing.T) {
 		metric.Write(t, app)
 	}
 
-	require.NoError(t, app.Commit())
+	require.NoError(t, app.Commit())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	names := []string{}
+	for _, series := range collector.series {
+		names = append(names, series.Labels.Get("__name__"))
+	}
+	require.Equal(t, payload.SeriesNames(), names)
+
+	expectedSamples := payload.ExpectedSamples()
+	actualSamples := collector.samples
+	sort.Sort(byRefSample(actualSamples))
+	require.Equal(t, expectedSamples, actualSamples)
+
+	expectedExemplars := payload.ExpectedExemplars()
+	actualExemplars := collector.exemplars
+	sort.Sort(byRefExemplar(actualExemplars))
+	require.Equal(t, expectedExemplars, actualExemplars)
+}
+
+func TestStorage_ExistingWAL_RefID(t *testing.T) {
+	l := util.TestLogger(t)
+
+	walDir := t.TempDir()
+
+	s, err := NewStorage(l, nil, walDir)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+
+	// Write all the samples
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	// Truncate the WAL to force creation of a new segment.
+	require.NoError(t, s.Truncate(0))
+	require.NoError(t, s.Close())
+
+	// Create a new storage and see what the ref ID is initialized to.
+	s, err = NewStorage(l, nil, walDir)
+	require.NoError(t, err)
+	defer require.NoError(t, s.Close())
+
+	require.Equal(t, uint64(len(payload)), s.nextRef.Load(), "cached ref ID should be equal to the number of series written")
+}
+
+func TestStorage_CheckpointOnClose(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.CheckpointOnClose = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+	for _, metric := range payload {
+		app := s.Appender(t.Context())
+		metric.Write(t, app)
+		require.NoError(t, app.Commit())
+
+		// Spread the series over multiple segments.
+		_, err := s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+
+	expectTs := map[string]int64{}
+	for series := range s.series.iterator().Channel() {
+		expectTs[series.lset.String()] = series.lastTs
+	}
+	require.NoError(t, s.Close())
+
+	// Only the checkpoint and the empty segment created on close are left.
+	checkpointDir, checkpointIdx, err := wlog.LastCheckpoint(SubDirectory(walDir))
+	require.NoError(t, err)
+	require.NotEmpty(t, checkpointDir)
+	first, last, err := wlog.Segments(SubDirectory(walDir))
+	require.NoError(t, err)
+	require.Equal(t, checkpointIdx+1, first)
+	require.Equal(t, first, last)
+
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	actualTs := map[string]int64{}
+	for series := range s.series.iterator().Channel() {
+		actualTs[series.lset.String()] = series.lastTs
+	}
+	require.Equal(t, expectTs, actualTs)
+	require.Equal(t, uint64(len(payload)), s.nextRef.Load())
+}
+
+func TestStorage_CheckpointOnCloseReopen(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.CheckpointOnClose = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "old"), 500, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "new"), 1000, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.NoError(t, s.Close())
+
+	latestTs := func() map[string]int64 {
+		ts := map[string]int64{}
+		for series := range s.series.iterator().Channel() {
+			ts[series.lset.String()] = series.lastTs
+		}
+		return ts
+	}
+	expectTs := map[string]int64{`{__name__="old"}`: 500, `{__name__="new"}`: 1000}
+
+	// Reopening and closing the WAL without appending anything keeps the
+	// latest sample of every series.
+	for i := 0; i < 2; i++ {
+		s, err = NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+		require.NoError(t, err)
+		require.Equal(t, expectTs, latestTs())
+		require.NoError(t, s.Close())
+	}
+}
+
+func TestStorage_CheckpointOnCloseIdleSeries(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.CheckpointOnClose = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	// An idle series doesn't keep the samples of the other series in the
+	// checkpoint.
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "idle"), 0, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	for ts := int64(1); ts <= 100; ts++ {
+		app := s.Appender(t.Context())
+		_, err = app.Append(0, labels.FromStrings("__name__", "active"), ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+	require.NoError(t, s.Close())
+
+	dir, _, err := wlog.LastCheckpoint(SubDirectory(walDir))
+	require.NoError(t, err)
+	counts, err := countCheckpointSamples(dir)
+	require.NoError(t, err)
+	require.Len(t, counts, 2)
+	for _, count := range counts {
+		require.Equal(t, 1, count)
+	}
+
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	actualTs := map[string]int64{}
+	for series := range s.series.iterator().Channel() {
+		actualTs[series.lset.String()] = series.lastTs
+	}
+	require.Equal(t, map[string]int64{`{__name__="idle"}`: 0, `{__name__="active"}`: 100}, actualTs)
+}
+
+func TestStreamCheckpoint(t *testing.T) {
+	buffered := newCheckpointTestWAL(t)
+	_, err := wlog.Checkpoint(log.NewNopLogger(), buffered, 0, 3, checkpointTestKeep, checkpointTestMint)
+	require.NoError(t, err)
+
+	streamed := newCheckpointTestWAL(t)
+	require.NoError(t, streamCheckpoint(log.NewNopLogger(), streamed, 0, 3, checkpointTestKeep, samplesSince(checkpointTestMint), 0, 0))
+
+	require.Equal(t, readLastCheckpoint(t, buffered), readLastCheckpoint(t, streamed))
+}
+
+func TestStreamCheckpoint_MaxSamples(t *testing.T) {
+	w, err := wlog.New(log.NewNopLogger(), nil, t.TempDir(), wlog.CompressionNone)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, w.Close())
+	}()
+
+	// The samples of series 1 aren't written in time order, and two of
+	// them share the same timestamp.
+	var enc record.Encoder
+	require.NoError(t, w.Log(enc.Series([]record.RefSeries{
+		{Ref: 1, Labels: labels.FromStrings("__name__", "a")},
+		{Ref: 2, Labels: labels.FromStrings("__name__", "b")},
+	}, nil)))
+	for _, ts := range []int64{5, 1, 4, 2, 4, 3} {
+		require.NoError(t, w.Log(enc.Samples([]record.RefSample{{Ref: 1, T: ts, V: float64(ts)}}, nil)))
+	}
+	require.NoError(t, w.Log(enc.Samples([]record.RefSample{{Ref: 2, T: 1, V: 1}}, nil)))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+
+	keepAll := func(chunks.HeadSeriesRef) bool { return true }
+	require.NoError(t, streamCheckpoint(log.NewNopLogger(), w, 0, 0, keepAll, samplesSince(math.MinInt64), 2, 0))
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(w.Dir()))
+
+	// The newest samples of series 1 are kept, in the order they were
+	// written. Only one of the samples at the cutoff fits in the limit.
+	require.Equal(t, []record.RefSample{
+		{Ref: 1, T: 5, V: 5},
+		{Ref: 1, T: 4, V: 4},
+		{Ref: 2, T: 1, V: 1},
+	}, collector.samples)
+
+	// Both samples at the cutoff fit in a higher limit.
+	dir, _, err := wlog.LastCheckpoint(w.Dir())
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, streamCheckpoint(log.NewNopLogger(), w, 0, 0, keepAll, samplesSince(math.MinInt64), 3, 0))
+	collector = walDataCollector{}
+	replayer = walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(w.Dir()))
+	require.Equal(t, []record.RefSample{
+		{Ref: 1, T: 5, V: 5},
+		{Ref: 1, T: 4, V: 4},
+		{Ref: 1, T: 4, V: 4},
+		{Ref: 2, T: 1, V: 1},
+	}, collector.samples)
+}
+
+// checkpointTestMint and checkpointTestKeep drop a quarter of the series and
+// samples of the WAL written by newCheckpointTestWAL.
+const checkpointTestMint = 100
+
+func checkpointTestKeep(id chunks.HeadSeriesRef) bool { return id%4 != 0 }
+
+// newCheckpointTestWAL writes the same large WAL, spread over several
+// segments, to a new directory.
+func newCheckpointTestWAL(tb testing.TB) *wlog.WL {
+	w, err := wlog.New(log.NewNopLogger(), nil, tb.TempDir(), wlog.CompressionSnappy)
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		require.NoError(tb, w.Close())
+	})
+
+	var enc record.Encoder
+	for seg := 0; seg < 4; seg++ {
+		for i := 0; i < 100; i++ {
+			series := make([]record.RefSeries, 0, 100)
+			samples := make([]record.RefSample, 0, 100)
+			for j := 0; j < 100; j++ {
+				ref := chunks.HeadSeriesRef(seg*10000 + i*100 + j)
+				series = append(series, record.RefSeries{
+					Ref:    ref,
+					Labels: labels.FromStrings("__name__", "a_somewhat_long_metric_name", "instance", fmt.Sprintf("instance-%d", ref)),
+				})
+				samples = append(samples, record.RefSample{Ref: ref, T: int64(seg*100 + i), V: float64(j)})
+			}
+			require.NoError(tb, w.Log(enc.Series(series, nil), enc.Samples(samples, nil)))
+		}
+		_, err := w.NextSegment()
+		require.NoError(tb, err)
+	}
+	return w
+}
+
+// readLastCheckpoint returns the contents of the segments of the last
+// checkpoint of w.
+func readLastCheckpoint(tb testing.TB, w *wlog.WL) []byte {
+	dir, _, err := wlog.LastCheckpoint(w.Dir())
+	require.NoError(tb, err)
+	files, err := os.ReadDir(dir)
+	require.NoError(tb, err)
+
+	var contents []byte
+	for _, f := range files {
+		bb, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(tb, err)
+		contents = append(contents, bb...)
+	}
+	require.NotEmpty(tb, contents)
+	return contents
+}
+
+func TestStorage_MaxSamplesPerSeries(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.Retention.MaxSamplesPerSeries = 3
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	lbls := labels.FromStrings("__name__", "bursty")
+	for ts := int64(1); ts <= 10; ts++ {
+		app := s.Appender(t.Context())
+		_, err := app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+
+	// Forcefully create a bunch of new segments so when we truncate
+	// there's enough segments to be considered for truncation.
+	for i := 0; i < 5; i++ {
+		_, err := s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Truncate(0))
+
+	// Read back the WAL and check that only the last samples survived.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	require.Len(t, collector.series, 1)
+	var actualTs []int64
+	for _, sample := range collector.samples {
+		actualTs = append(actualTs, sample.T)
+	}
+	require.Equal(t, []int64{8, 9, 10}, actualTs)
+}
+
+func TestStorage_RetentionPolicy(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.Retention = RetentionPolicy{
+		MaxAge: time.Hour,
+		// Room for the checkpoint and two segments, which are each written
+		// as a single 32KiB page.
+		MaxBytes: 3 * 32 * 1024,
+	}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Write a sample per segment, all within MaxAge.
+	lbls := labels.FromStrings("__name__", "sized")
+	now := timestamp.FromTime(time.Now())
+	for i := int64(0); i < 10; i++ {
+		app := s.Appender(t.Context())
+		_, err := app.Append(0, lbls, now-10+i, float64(i))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+
+		_, err = s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Truncate(0))
+
+	// The byte limit dropped the oldest samples, even though the time limit
+	// keeps all of them.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	require.Len(t, collector.series, 1)
+	var actualTs []int64
+	for _, sample := range collector.samples {
+		actualTs = append(actualTs, sample.T)
+	}
+	require.Equal(t, []int64{now - 2, now - 1}, actualTs)
+}
+
+func TestStorage_Manifest(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.WriteManifest = true
+	opts.AgentID = "agent-1"
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	m, err := ReadManifest(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, ManifestVersion, m.Version)
+	require.Equal(t, "agent-1", m.AgentID)
+	require.False(t, m.CreatedAt.IsZero())
+	require.Equal(t, 0, m.FirstSegment)
+	require.Equal(t, 0, m.LastSegment)
+	createdAt := m.CreatedAt
+
+	// The manifest is updated by the first commit after segments rotated.
+	lbls := labels.FromStrings("__name__", "manifest")
+	for ts := int64(1); ts <= 5; ts++ {
+		_, err := s.wal.NextSegmentSync()
+		require.NoError(t, err)
+
+		app := s.Appender(t.Context())
+		_, err = app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+	m, err = ReadManifest(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, 0, m.FirstSegment)
+	require.Equal(t, 5, m.LastSegment)
+
+	// Truncation drops the oldest segments and starts a new one.
+	require.NoError(t, s.Truncate(0))
+	first, last, err := wlog.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Greater(t, first, 0)
+
+	m, err = ReadManifest(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, first, m.FirstSegment)
+	require.Equal(t, last, m.LastSegment)
+	require.Equal(t, 6, m.LastSegment)
+	require.Equal(t, createdAt, m.CreatedAt)
+
+	// No temporary manifest is left behind.
+	matches, err := filepath.Glob(filepath.Join(s.wal.Dir(), ManifestFilename+".tmp*"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestStorage_MemoryEstimate(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	before := s.MemoryEstimate()
+
+	app := s.Appender(t.Context())
+	for i := 0; i < 1000; i++ {
+		lbls := labels.FromStrings("__name__", "metric", "series", strconv.Itoa(i))
+		ref, err := app.Append(0, lbls, 10, float64(i))
+		require.NoError(t, err)
+		_, err = app.AppendExemplar(ref, lbls, exemplar.Exemplar{Value: float64(i), Ts: 10, HasTs: true})
+		require.NoError(t, err)
+	}
+
+	pending := s.MemoryEstimate()
+	require.Greater(t, pending.SeriesBytes, before.SeriesBytes)
+	require.Greater(t, pending.ExemplarBytes, before.ExemplarBytes)
+	require.Greater(t, pending.PendingBytes, before.PendingBytes)
+
+	require.NoError(t, app.Commit())
+
+	committed := s.MemoryEstimate()
+	require.Equal(t, pending.SeriesBytes, committed.SeriesBytes)
+	require.Equal(t, before.PendingBytes, committed.PendingBytes)
+	require.Greater(t, committed.Total(), before.Total())
+}
+
+func TestStorage_Truncate(t *testing.T) {
+	// Same as before but now do the following:
+	// after writing all the data, forcefully create 4 more segments,
+	// then do a truncate of a timestamp for _some_ of the data.
+	// then read data back in. Expect to only get the latter half of data.
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+
+	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+
+	require.NoError(t, app.Commit())
+
+	// Forcefully create a bunch of new segments so when we truncate
+	// there's enough segments to be considered for truncation.
+	for i := 0; i < 5; i++ {
+		_, err := s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+
+	// Truncate half of the samples, keeping only the second sample
+	// per series.
+	keepTs := payload[len(payload)-1].samples[0].ts + 1
+	err = s.Truncate(keepTs)
+	require.NoError(t, err)
+
+	payload = payload.Filter(func(s sample) bool {
+		return s.ts >= keepTs
+	}, func(e exemplar.Exemplar) bool {
+		return e.HasTs && e.Ts >= keepTs
+	})
+	expectedSamples := payload.ExpectedSamples()
+	expectedExemplars := payload.ExpectedExemplars()
+
+	// Read back the WAL, collect series and samples.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	names := []string{}
+	for _, series := range collector.series {
+		names = append(names, series.Labels.Get("__name__"))
+	}
+	require.Equal(t, payload.SeriesNames(), names)
+
+	actualSamples := collector.samples
+	sort.Sort(byRefSample(actualSamples))
+	require.Equal(t, expectedSamples, actualSamples)
+
+	actualExemplars := collector.exemplars
+	sort.Sort(byRefExemplar(actualExemplars))
+	require.Equal(t, expectedExemplars, actualExemplars)
+}
+
+func TestStorage_InternLabels(t *testing.T) {
+	walDir := t.TempDir()
+	opts := DefaultOptions()
+	opts.InternLabels = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	lsets := []labels.Labels{
+		labels.FromStrings("__name__", "up", "instance", "a:9090", "job", "node"),
+		labels.FromStrings("__name__", "up", "instance", "b:9090", "job", "node"),
+		labels.FromStrings("__name__", "up", "instance", "c:9090", "job", "node"),
+	}
+	app := s.Appender(t.Context())
+	refs := make([]storage.SeriesRef, len(lsets))
+	for i, l := range lsets {
+		refs[i], err = app.Append(0, l, 10, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	// The labels of the series are unchanged by interning. The 3 names and
+	// the values of __name__ and job are shared.
+	for i, l := range lsets {
+		require.Equal(t, l, s.series.GetByID(chunks.HeadSeriesRef(refs[i])).lset)
+	}
+	require.Equal(t, 3+2+3, s.series.symbols.len())
+	require.NoError(t, s.Close())
+
+	// Series replayed from the WAL are interned too.
+	s, err = NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer s.Close()
+	for i, l := range lsets {
+		require.Equal(t, l, s.series.GetByID(chunks.HeadSeriesRef(refs[i])).lset)
+	}
+	require.Equal(t, 3+2+3, s.series.symbols.len())
+
+	// Strings are dropped once the last series using them is removed.
+	app = s.Appender(t.Context())
+	_, err = app.Append(refs[0], lsets[0], 20, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.NoError(t, s.Truncate(15))
+	require.Nil(t, s.series.GetByID(chunks.HeadSeriesRef(refs[1])))
+	require.Equal(t, 3+2+1, s.series.symbols.len())
+}
+
+func TestStorage_WriteStalenessMarkers(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+
+	// Write some samples
+	payload := seriesList{
+		{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
+		{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
+		{name: "baz", samples: []sample{{3, 30.0}, {30, 300.0}}},
+	}
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+
+	require.NoError(t, app.Commit())
+
+	// Write staleness markers for every series
+	require.NoError(t, s.WriteStalenessMarkers(func() int64 {
+		// Pass math.MaxInt64 so it seems like everything was written already
+		return math.MaxInt64
+	}))
+
+	// Read back the WAL, collect series and samples.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	actual := collector.samples
+	sort.Sort(byRefSample(actual))
+
+	staleMap := map[chunks.HeadSeriesRef]bool{}
+	for _, sample := range actual {
+		if _, ok := staleMap[sample.Ref]; !ok {
+			staleMap[sample.Ref] = false
+		}
+		if value.IsStaleNaN(sample.V) {
+			staleMap[sample.Ref] = true
+		}
+	}
+
+	for ref, v := range staleMap {
+		require.True(t, v, "ref %d doesn't have stale marker", ref)
+	}
+}
+
+func TestStorage_WriteStalenessMarkers_ClockBackwards(t *testing.T) {
+	walDir := t.TempDir()
+
+	reg := prometheus.NewRegistry()
+	s, err := NewStorage(log.NewNopLogger(), reg, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Samples are written an hour after the time reported by the clock when
+	// writing the staleness markers.
+	now := time.Now()
+	s.now = func() time.Time { return now.Add(-time.Hour) }
+
+	app := s.Appender(t.Context())
+	payload := seriesList{
+		{name: "foo", samples: []sample{{timestamp.FromTime(now), 10.0}}},
+		{name: "bar", samples: []sample{{timestamp.FromTime(now) + 1000, 20.0}}},
+	}
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	require.NoError(t, s.WriteStalenessMarkers(func() int64 {
+		return math.MaxInt64
+	}))
+	require.Equal(t, 0.0, testutil.ToFloat64(s.metrics.totalOutOfOrderSamples))
+
+	// Read back the WAL and check that every stale marker is at least as
+	// recent as the last sample of its series.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	lastSample := map[chunks.HeadSeriesRef]int64{}
+	staleMarkers := 0
+	for _, sample := range collector.samples {
+		if !value.IsStaleNaN(sample.V) {
+			lastSample[sample.Ref] = sample.T
+			continue
+		}
+		staleMarkers++
+		require.GreaterOrEqual(t, sample.T, lastSample[sample.Ref])
+	}
+	require.Equal(t, len(payload), staleMarkers)
+}
+
+func TestStorage_StalePolicy(t *testing.T) {
+	lbls := labels.FromStrings("__name__", "foo")
+	staleNaN := math.Float64frombits(value.StaleNaN)
+
+	// newStaleSeries creates a storage with a series whose latest committed
+	// sample is a staleness marker.
+	newStaleSeries := func(t *testing.T, policy StalePolicy) (*Storage, storage.SeriesRef) {
+		opts := DefaultOptions()
+		opts.StalePolicy = policy
+
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, s.Close())
+		})
+
+		app := s.Appender(t.Context())
+		ref, err := app.Append(0, lbls, 10, 1)
+		require.NoError(t, err)
+		_, err = app.Append(ref, lbls, 20, staleNaN)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+		return s, ref
+	}
+
+	t.Run("resurrect", func(t *testing.T) {
+		s, ref := newStaleSeries(t, StalePolicyResurrect)
+
+		app := s.Appender(t.Context())
+		lateRef, err := app.Append(ref, lbls, 30, 3)
+		require.NoError(t, err)
+		require.Equal(t, ref, lateRef)
+		require.NoError(t, app.Commit())
+
+		collector := walDataCollector{}
+		replayer := walReplayer{w: &collector}
+		require.NoError(t, replayer.Replay(s.wal.Dir()))
+		require.Len(t, collector.samples, 3)
+		require.Equal(t, int64(30), collector.samples[2].T)
+	})
+
+	t.Run("reject after stale", func(t *testing.T) {
+		s, ref := newStaleSeries(t, StalePolicyRejectAfterStale)
+
+		app := s.Appender(t.Context())
+		_, err := app.Append(ref, lbls, 30, 3)
+		var staleErr *StaleSeriesError
+		require.ErrorAs(t, err, &staleErr)
+		require.Equal(t, &StaleSeriesError{Ref: chunks.HeadSeriesRef(ref), Timestamp: 30}, staleErr)
+
+		// Looking up the series by its labels doesn't bypass the policy.
+		_, err = app.Append(0, lbls, 30, 3)
+		require.ErrorAs(t, err, &staleErr)
+
+		// The dry appender reports the same error.
+		_, err = s.DryAppender(t.Context()).Append(ref, lbls, 30, 3)
+		require.ErrorAs(t, err, &staleErr)
+
+		// Staleness markers are still accepted.
+		_, err = app.Append(ref, lbls, 40, staleNaN)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+
+		require.Equal(t, 2.0, testutil.ToFloat64(s.metrics.totalDroppedSamples.WithLabelValues(DropReasonStaleSeries)))
+		require.ErrorAs(t, s.SeriesErrors()[ref], &staleErr)
+
+		// Once the series is garbage collected, a new series record is
+		// written for its labels and samples are accepted again.
+		require.NoError(t, s.Truncate(50))
+		app = s.Appender(t.Context())
+		newRef, err := app.Append(0, lbls, 60, 6)
+		require.NoError(t, err)
+		require.NotEqual(t, ref, newRef)
+		require.NoError(t, app.Commit())
+	})
+}
+
+func TestStorage_IdempotentAppender(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.MaxIdempotencyTokens = 1
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	commit := func(token string, ts int64) {
+		app := s.IdempotentAppender(t.Context(), token)
+		_, err := app.Append(0, labels.FromStrings("__name__", "foo"), ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+
+	commit("batch-1", 1)
+	commit("batch-1", 2) // Retry of the same batch is deduplicated.
+	commit("batch-2", 3) // Evicts batch-1.
+	commit("batch-1", 4)
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	var actualTs []int64
+	for _, sample := range collector.samples {
+		actualTs = append(actualTs, sample.T)
+	}
+	require.Equal(t, []int64{1, 3, 4}, actualTs)
+	require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalDuplicateCommits))
+}
+
+func TestStorage_IdempotentAppenderRestart(t *testing.T) {
+	walDir := t.TempDir()
+
+	open := func() *Storage {
+		s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+		require.NoError(t, err)
+		return s
+	}
+	commit := func(s *Storage, token string, ts int64) {
+		app := s.IdempotentAppender(t.Context(), token)
+		_, err := app.Append(0, labels.FromStrings("__name__", "foo"), ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+
+	s := open()
+	commit(s, "batch-1", 1)
+	require.NoError(t, s.Close())
+
+	// Tokens are replayed from the WAL.
+	s = open()
+	commit(s, "batch-1", 2)
+	require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalDuplicateCommits))
+
+	// Tokens are kept in checkpoints.
+	for ts := int64(3); ts <= 4; ts++ {
+		_, err := s.wal.NextSegmentSync()
+		require.NoError(t, err)
+		commit(s, "batch-"+strconv.FormatInt(ts, 10), ts)
+	}
+	require.NoError(t, s.Truncate(3))
+	first, _, err := wlog.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Positive(t, first)
+	require.NoError(t, s.Close())
+
+	s = open()
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	commit(s, "batch-1", 5)
+	commit(s, "batch-3", 6)
+	require.Equal(t, 2.0, testutil.ToFloat64(s.metrics.totalDuplicateCommits))
+}
+
+func TestStorage_IdempotentAppenderConcurrent(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GroupCommitWindow = 100 * time.Millisecond
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Commits with distinct tokens are synced together rather than one
+	// after the other, and only one of the commits with the same token is
+	// written.
+	const commits = 8
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < commits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app := s.IdempotentAppender(t.Context(), "batch-"+strconv.Itoa(i%(commits/2)))
+			_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i)), 1, 1)
+			require.NoError(t, err)
+			require.NoError(t, app.Commit())
+		}()
+	}
+	wg.Wait()
+	require.Less(t, time.Since(start), 4*opts.GroupCommitWindow)
+	require.Equal(t, float64(commits/2), testutil.ToFloat64(s.metrics.totalDuplicateCommits))
+}
+
+func TestStorage_AppendTrusted(t *testing.T) {
+	// write appends the same samples with appendSample to a new storage
+	// and returns the content of its segment.
+	write := func(appendSample func(app storage.Appender, ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error)) []byte {
+		walDir := t.TempDir()
+		s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+		require.NoError(t, err)
+
+		app := s.Appender(t.Context())
+		refs := make(map[string]storage.SeriesRef)
+		for ts := int64(1); ts <= 3; ts++ {
+			for _, name := range []string{"a", "b", "c"} {
+				lbls := labels.FromStrings("__name__", name, "job", "test")
+				refs[name], err = appendSample(app, refs[name], lbls, ts, float64(ts))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, app.Commit())
+
+		// An out of order sample is still detected.
+		app = s.Appender(t.Context())
+		_, err = appendSample(app, refs["a"], labels.FromStrings("__name__", "a", "job", "test"), 2, 2)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+		require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalOutOfOrderSamples))
+
+		require.NoError(t, s.Close())
+		b, err := os.ReadFile(wlog.SegmentName(SubDirectory(walDir), 0))
+		require.NoError(t, err)
+		return b
+	}
+
+	expected := write(func(app storage.Appender, ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+		return app.Append(ref, l, t, v)
+	})
+	actual := write(func(app storage.Appender, ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+		return app.(TrustedAppender).AppendTrusted(ref, l, t, v)
+	})
+	require.Equal(t, expected, actual)
+}
+
+func TestStorage_CommitWithStats(t *testing.T) {
+	s, err := NewStorage(log.NewNopLogger(), nil, t.TempDir())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	writer := &byteCountingWriter{WL: s.wal}
+	s.writer = writer
+
+	app := s.Appender(t.Context()).(StatsAppender)
+	for i := 0; i < 3; i++ {
+		lbls := labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i))
+		ref, err := app.Append(0, lbls, 10, 1)
+		require.NoError(t, err)
+		_, err = app.Append(ref, lbls, 20, 2)
+		require.NoError(t, err)
+		_, err = app.AppendExemplar(ref, lbls, exemplar.Exemplar{Labels: labels.FromStrings("trace_id", strconv.Itoa(i)), Value: 1, Ts: 10, HasTs: true})
+		require.NoError(t, err)
+	}
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 10, tsdbutil.GenerateTestHistogram(1), nil)
+	require.NoError(t, err)
+
+	stats, err := app.CommitWithStats()
+	require.NoError(t, err)
+	require.Equal(t, CommitStats{
+		Series:    4,
+		Samples:   7,
+		Exemplars: 3,
+		Bytes:     writer.bytes,
+	}, stats)
+	require.Positive(t, stats.Bytes)
+
+	// Series records are only written for new series.
+	app = s.Appender(t.Context()).(StatsAppender)
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "i", "0"), 30, 3)
+	require.NoError(t, err)
+	stats, err = app.CommitWithStats()
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Series)
+	require.Equal(t, 1, stats.Samples)
+
+	// Duplicate idempotent commits don't write anything.
+	commit := func() CommitStats {
+		app := s.IdempotentAppender(t.Context(), "batch").(StatsAppender)
+		_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", "0"), 40, 4)
+		require.NoError(t, err)
+		stats, err := app.CommitWithStats()
+		require.NoError(t, err)
+		return stats
+	}
+	require.Equal(t, 1, commit().Samples)
+	require.Equal(t, CommitStats{}, commit())
+}
+
+// byteCountingWriter counts the size of the records written to the WAL.
+type byteCountingWriter struct {
+	*wlog.WL
+	bytes int
+}
+
+func (w *byteCountingWriter) Log(recs ...[]byte) error {
+	for _, rec := range recs {
+		w.bytes += len(rec)
+	}
+	return w.WL.Log(recs...)
+}
+
+func TestStorage_SeriesErrors(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	badRef, err := app.Append(0, labels.FromStrings("__name__", "bad"), 100, 1)
+	require.NoError(t, err)
+	goodRef, err := app.Append(0, labels.FromStrings("__name__", "good"), 100, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Write out of order samples for the bad series only.
+	for _, ts := range []int64{50, 60} {
+		app = s.Appender(t.Context())
+		_, err = app.Append(badRef, labels.EmptyLabels(), ts, 1)
+		require.NoError(t, err)
+		_, err = app.Append(goodRef, labels.EmptyLabels(), 100+ts, 1)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+	}
+
+	errs := s.SeriesErrors()
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[badRef], storage.ErrOutOfOrderSample)
+	require.ErrorContains(t, errs[badRef], "sample timestamp 60")
+	require.NotContains(t, errs, goodRef)
+
+	// The error is forgotten once the series is garbage collected.
+	require.NoError(t, s.Truncate(150))
+	require.Empty(t, s.SeriesErrors())
+}
+
+func TestStorage_StrictSeriesRefs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictSeriesRefs = true
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	lbls := labels.FromStrings("__name__", "foo")
+	app := s.Appender(t.Context())
+	ref, err := app.Append(0, lbls, 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Garbage collect the series, which makes its ref stale.
+	require.NoError(t, s.Truncate(100))
+
+	app = s.Appender(t.Context())
+	_, err = app.Append(ref, lbls, 110, 1)
+	require.ErrorIs(t, err, ErrSeriesNotFound)
+	_, err = app.AppendHistogram(ref, lbls, 110, tsdbutil.GenerateTestHistogram(1), nil)
+	require.ErrorIs(t, err, ErrSeriesNotFound)
+	_, err = s.DryAppender(t.Context()).Append(ref, lbls, 110, 1)
+	require.ErrorIs(t, err, ErrSeriesNotFound)
+	require.Equal(t, 2.0, testutil.ToFloat64(s.metrics.totalDroppedSamples.WithLabelValues(DropReasonUnknownRef)))
+
+	// Appending with a zero ref registers the series again.
+	newRef, err := app.Append(0, lbls, 110, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, ref, newRef)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_ForEachExemplar(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	refs := make(map[int64]storage.SeriesRef)
+	for _, ts := range []int64{10, 20, 30, 40, 50} {
+		ref, err := app.Append(0, labels.FromStrings("__name__", "foo", "ts", strconv.FormatInt(ts, 10)), ts, 1)
+		require.NoError(t, err)
+		_, err = app.AppendExemplar(ref, nil, exemplar.Exemplar{
+			Labels: labels.FromStrings("trace_id", strconv.FormatInt(ts, 10)),
+			Value:  1,
+			Ts:     ts,
+			HasTs:  true,
+		})
+		require.NoError(t, err)
+		refs[ts] = ref
+	}
+	require.NoError(t, app.Commit())
+
+	visited := make(map[storage.SeriesRef]int64)
+	s.ForEachExemplar(20, 40, func(ref storage.SeriesRef, e exemplar.Exemplar) bool {
+		visited[ref] = e.Ts
+		return true
+	})
+	require.Equal(t, map[storage.SeriesRef]int64{
+		refs[20]: 20,
+		refs[30]: 30,
+		refs[40]: 40,
+	}, visited)
+
+	var calls int
+	s.ForEachExemplar(math.MinInt64, math.MaxInt64, func(storage.SeriesRef, exemplar.Exemplar) bool {
+		calls++
+		return false
+	})
+	require.Equal(t, 1, calls)
+}
+
+func TestStorage_WriteOpenMetrics(t *testing.T) {
+	opts := DefaultOptions()
+	opts.DebugMaxSeries = 3
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "b"), 2000, 0)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "a"), 1000, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "http_requests_total", "path", `/"q"`), 1500, 42.5)
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "latency"), 1000, tsdbutil.GenerateTestHistogram(1), nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteOpenMetrics(&buf))
+	require.Equal(t, `# TYPE http_requests_total unknown
+http_requests_total{path="/\"q\""} 42.5 1.5
+# TYPE up unknown
+up{job="a"} 1.0 1.0
+up{job="b"} 0.0 2.0
+# HELP prometheus_remote_write_wal_debug_omitted_series Number of series held by the WAL storage which weren't written, by reason.
+# TYPE prometheus_remote_write_wal_debug_omitted_series gauge
+prometheus_remote_write_wal_debug_omitted_series{reason="histogram"} 1.0
+# EOF
+`, buf.String())
+
+	// Series beyond the limit are left out.
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "up", "job", "c"), 3000, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	buf.Reset()
+	require.NoError(t, s.WriteOpenMetrics(&buf))
+	var samples int
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, debugOmittedSeriesName) {
+			samples++
+		}
+	}
+	require.Equal(t, 3, samples)
+	require.Contains(t, buf.String(), `prometheus_remote_write_wal_debug_omitted_series{reason="limit"} 1.0`)
+}
+
+func TestStorage_DerivedSeries(t *testing.T) {
+	walDir := t.TempDir()
+
+	// sumRequests sums the requests_total series of a commit into a
+	// requests:sum series, at the latest timestamp of the commit.
+	var calls int
+	sumRequests := func(committed []DerivedSample) []DerivedSample {
+		calls++
+		sum := DerivedSample{Labels: labels.FromStrings("__name__", "requests:sum")}
+		for _, s := range committed {
+			require.NotEqual(t, "requests:sum", s.Labels.Get("__name__"), "derived samples must not be given to the hook")
+			if s.Labels.Get("__name__") == "requests_total" {
+				sum.T = max(sum.T, s.T)
+				sum.V += s.V
+			}
+		}
+		return []DerivedSample{sum}
+	}
+
+	opts := DefaultOptions()
+	opts.DerivedSeries = sumRequests
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "requests_total", "instance", "a"), 10, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "requests_total", "instance", "b"), 10, 2)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// The aggregate of an older commit would be out of order, and is dropped.
+	app = s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "requests_total", "instance", "c"), 5, 4)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.Equal(t, 2, calls)
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	var sumRef chunks.HeadSeriesRef
+	for _, series := range collector.series {
+		if series.Labels.Get("__name__") == "requests:sum" {
+			sumRef = series.Ref
+		}
+	}
+	require.NotZero(t, sumRef, "derived series wasn't written to the WAL")
+
+	var sums []record.RefSample
+	for _, sample := range collector.samples {
+		if sample.Ref == sumRef {
+			sums = append(sums, sample)
+		}
+	}
+	require.Equal(t, []record.RefSample{{Ref: sumRef, T: 10, V: 3}}, sums)
+}
+
+func TestStorage_DerivedSeriesConcurrentCommits(t *testing.T) {
+	const (
+		commits = 8
+		window  = 200 * time.Millisecond
+	)
+
+	opts := DefaultOptions()
+	opts.GroupCommitWindow = window
+	opts.DerivedSeries = func(committed []DerivedSample) []DerivedSample {
+		derived := make([]DerivedSample, 0, len(committed))
+		for _, s := range committed {
+			derived = append(derived, DerivedSample{Labels: labels.FromStrings("__name__", "requests:sum"), T: s.T, V: s.V})
+		}
+		return derived
+	}
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Deriving commits share the syncs of the group commit rather than
+	// waiting for one window each.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range commits {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app := s.Appender(context.Background())
+			_, err := app.Append(0, labels.FromStrings("__name__", "requests_total", "instance", strconv.Itoa(i)), int64(i+1), 1)
+			assert.NoError(t, err)
+			assert.NoError(t, app.Commit())
+		}()
+	}
+	wg.Wait()
+	require.Less(t, time.Since(start), commits*window/2)
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	var sumRef chunks.HeadSeriesRef
+	for _, series := range collector.series {
+		if series.Labels.Get("__name__") == "requests:sum" {
+			sumRef = series.Ref
+		}
+	}
+	require.NotZero(t, sumRef)
+
+	// Derived samples are written in order, even though commits ran
+	// concurrently. Samples which would have been out of order are dropped.
+	var timestamps []int64
+	for _, sample := range collector.samples {
+		if sample.Ref == sumRef {
+			timestamps = append(timestamps, sample.T)
+		}
+	}
+	require.NotEmpty(t, timestamps)
+	require.True(t, slices.IsSorted(timestamps), "derived samples out of order: %v", timestamps)
+	require.Len(t, slices.Compact(slices.Clone(timestamps)), len(timestamps))
+}
+
+func TestStorage_RelabelSeries(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	ref, err := app.Append(0, labels.FromStrings("__name__", "foo", "instance", "a"), 1, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "node", "a"), 1, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Rename instance to node. The first series is renamed, while the second
+	// one can't be since it would collide with the renamed one.
+	n, err := s.RelabelSeries(func(lset labels.Labels) labels.Labels {
+		b := labels.NewBuilder(lset)
+		if v := lset.Get("instance"); v != "" {
+			b.Del("instance")
+			b.Set("node", v)
+		}
+		return b.Labels()
+	})
+	require.Error(t, err)
+	require.Equal(t, 0, n)
+
+	n, err = s.RelabelSeries(func(lset labels.Labels) labels.Labels {
+		b := labels.NewBuilder(lset)
+		if v := lset.Get("instance"); v != "" {
+			b.Del("instance")
+			b.Set("node", "b")
+		}
+		return b.Labels()
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// Appending with the new labels continues the existing series.
+	app = s.Appender(t.Context())
+	newRef, err := app.Append(0, labels.FromStrings("__name__", "foo", "node", "b"), 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, ref, newRef)
+	require.NoError(t, app.Commit())
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	var lastLabels labels.Labels
+	for _, series := range collector.series {
+		if series.Ref == chunks.HeadSeriesRef(ref) {
+			lastLabels = series.Labels
+		}
+	}
+	require.Equal(t, labels.FromStrings("__name__", "foo", "node", "b"), lastLabels)
+
+	var timestamps []int64
+	for _, sample := range collector.samples {
+		if sample.Ref == chunks.HeadSeriesRef(ref) {
+			timestamps = append(timestamps, sample.T)
+		}
+	}
+	require.Equal(t, []int64{1, 2}, timestamps)
+
+	// Replaying the WAL into a new storage loads the new labels.
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	series := s.series.GetByID(chunks.HeadSeriesRef(ref))
+	require.NotNil(t, series)
+	require.Equal(t, lastLabels, series.lset)
+	require.Equal(t, series, s.series.GetByHash(lastLabels.Hash(), lastLabels))
+}
+
+func TestStorage_RelabelSeriesWhileIterating(t *testing.T) {
+	s, err := NewStorage(log.NewNopLogger(), nil, t.TempDir())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	for i := range 100 {
+		_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i), "gen", "0"), 1, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for gen := 1; gen <= 50; gen++ {
+			_, err := s.RelabelSeries(func(lset labels.Labels) labels.Labels {
+				return labels.NewBuilder(lset).Set("gen", strconv.Itoa(gen)).Labels()
+			})
+			assert.NoError(t, err)
+		}
+	}()
+
+	// Iterating over the series while they're relabeled mustn't deadlock.
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			t.Fatal("relabeling series deadlocked with the series iterator")
+		default:
+		}
+		for series := range s.series.iterator().Channel() {
+			series.Lock()
+			_ = series.lset.Get("gen")
+			series.Unlock()
+		}
+	}
+}
+
+func TestStorage_SourceAppender(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	appendSamples := func(app storage.Appender, name string, timestamps ...int64) storage.SeriesRef {
+		var ref storage.SeriesRef
+		for _, ts := range timestamps {
+			ref, err = app.Append(ref, labels.FromStrings("__name__", name), ts, 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+		return ref
+	}
+
+	fooRef := appendSamples(s.SourceAppender(t.Context(), "scrape-a"), "foo", 1, 2)
+	barRef := appendSamples(s.SourceAppender(t.Context(), "scrape-b"), "bar", 3)
+	// Samples from regular appenders aren't attributed to any source.
+	appendSamples(s.Appender(t.Context()), "baz", 4)
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(dir))
+	require.Len(t, collector.samples, 4)
+	require.Equal(t, []RefSampleSource{
+		{Ref: chunks.HeadSeriesRef(fooRef), T: 1, Source: "scrape-a"},
+		{Ref: chunks.HeadSeriesRef(fooRef), T: 2, Source: "scrape-a"},
+		{Ref: chunks.HeadSeriesRef(barRef), T: 3, Source: "scrape-b"},
+	}, collector.sources)
+
+	// The storage can still load the WAL.
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+}
+
+func TestStorage_ConvertHistogramsToFloat(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.ConvertHistogramsToFloat = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	h := tsdbutil.GenerateTestHistogram(1)
+
+	app := s.Appender(t.Context())
+	ref, err := app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 10, h, nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(dir))
+
+	require.Empty(t, collector.histograms)
+	require.Equal(t, []record.RefFloatHistogramSample{
+		{Ref: chunks.HeadSeriesRef(ref), T: 10, FH: h.ToFloat(nil)},
+	}, collector.floatHistograms)
+}
+
+func TestStorage_DownsampleInterval(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.DownsampleInterval = 10 * time.Second
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	// Append a sample every second for a minute.
+	lbls := labels.FromStrings("__name__", "foo")
+	app := s.Appender(t.Context())
+	var ref storage.SeriesRef
+	for ts := int64(1_000); ts <= 60_000; ts += 1_000 {
+		ref, err = app.Append(ref, lbls, ts, float64(ts))
+		require.NoError(t, err)
+	}
+	// Staleness markers are never dropped.
+	_, err = app.Append(ref, lbls, 60_500, math.Float64frombits(value.StaleNaN))
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(dir))
+
+	var timestamps []int64
+	for _, sample := range collector.samples {
+		timestamps = append(timestamps, sample.T)
+	}
+	require.Equal(t, []int64{1_000, 10_000, 20_000, 30_000, 40_000, 50_000, 60_000, 60_500}, timestamps)
+	require.Equal(t, 53.0, testutil.ToFloat64(s.metrics.totalDroppedSamples.WithLabelValues(DropReasonDownsampled)))
+}
+
+func TestStorage_DryAppender(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "existing"), 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	replayWAL := func() *walDataCollector {
+		collector := &walDataCollector{}
+		replayer := walReplayer{w: collector}
+		require.NoError(t, replayer.Replay(s.wal.Dir()))
+		return collector
+	}
+	before := replayWAL()
+
+	invalidHistogram := tsdbutil.GenerateTestHistogram(1)
+	invalidHistogram.Count = 0
+
+	appendAll := func(app storage.Appender) []error {
+		var errs []error
+		_, err := app.Append(0, labels.FromStrings("__name__", "existing"), 20, 2)
+		errs = append(errs, err)
+		ref, err := app.Append(0, labels.FromStrings("__name__", "new"), 20, 2)
+		errs = append(errs, err)
+		_, err = app.Append(0, labels.FromStrings("__name__", ""), 20, 2)
+		errs = append(errs, err)
+		_, err = app.Append(0, labels.FromStrings("__name__", "dup", "__name__", "dup"), 20, 2)
+		errs = append(errs, err)
+		_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 20, invalidHistogram, nil)
+		errs = append(errs, err)
+		_, err = app.AppendExemplar(ref, nil, exemplar.Exemplar{Labels: labels.FromStrings("a", "1", "a", "2"), Ts: 20})
+		errs = append(errs, err)
+		_, err = app.AppendExemplar(12345, nil, exemplar.Exemplar{Ts: 20})
+		errs = append(errs, err)
+		return errs
+	}
+
+	dryApp := s.DryAppender(t.Context())
+	dryErrs := appendAll(dryApp)
+	require.NoError(t, dryApp.Commit())
+
+	// Nothing is written by the dry appender, even on commit, and no series
+	// is created.
+	after := replayWAL()
+	require.Equal(t, before.series, after.series)
+	require.Equal(t, before.samples, after.samples)
+	newLabels := labels.FromStrings("__name__", "new")
+	require.Nil(t, s.series.GetByHash(newLabels.Hash(), newLabels))
+
+	realErrs := appendAll(s.Appender(t.Context()))
+	require.Len(t, dryErrs, len(realErrs))
+	for i := range realErrs {
+		// Only the samples of the first two inputs are valid.
+		if i < 2 {
+			require.NoError(t, realErrs[i], "input %d", i)
+			require.NoError(t, dryErrs[i], "input %d", i)
+			continue
+		}
+		require.Error(t, realErrs[i], "input %d", i)
+		require.EqualError(t, dryErrs[i], realErrs[i].Error(), "input %d", i)
+	}
+
+	// Commit reports samples which would be out of order.
+	dryApp = s.DryAppender(t.Context())
+	_, err = dryApp.Append(0, labels.FromStrings("__name__", "existing"), 5, 1)
+	require.NoError(t, err)
+	require.ErrorIs(t, dryApp.Commit(), storage.ErrOutOfOrderSample)
+}
+
+func TestReplayRange(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	refs := make(map[string]storage.SeriesRef)
+	for _, sample := range []struct {
+		name string
+		ts   int64
+	}{
+		{"foo", 10}, {"bar", 10}, {"foo", 20}, {"foo", 30}, {"baz", 30}, {"foo", 40},
+	} {
+		ref, err := app.Append(refs[sample.name], labels.FromStrings("__name__", sample.name), sample.ts, float64(sample.ts))
+		require.NoError(t, err)
+		refs[sample.name] = ref
+	}
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "hist"), 50, tsdbutil.GenerateTestHistogram(1), nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	require.NoError(t, ReplayRange(dir, 20, 30, &collector))
+
+	// Only the series of in-range samples are replayed; bar and hist only
+	// have samples out of range.
+	var names []string
+	for _, series := range collector.series {
+		names = append(names, series.Labels.Get("__name__"))
+	}
+	require.ElementsMatch(t, []string{"foo", "baz"}, names)
+
+	require.Equal(t, []record.RefSample{
+		{Ref: chunks.HeadSeriesRef(refs["foo"]), T: 20, V: 20},
+		{Ref: chunks.HeadSeriesRef(refs["foo"]), T: 30, V: 30},
+		{Ref: chunks.HeadSeriesRef(refs["baz"]), T: 30, V: 30},
+	}, collector.samples)
+	require.Empty(t, collector.histograms)
+}
+
+func TestReplayMatching(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	refs := make(map[string]storage.SeriesRef)
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "http_requests_total", "code", "200"),
+		labels.FromStrings("__name__", "http_requests_total", "code", "500"),
+		labels.FromStrings("__name__", "up"),
+	} {
+		for ts := int64(10); ts <= 20; ts += 10 {
+			ref, err := app.Append(refs[lset.String()], lset, ts, float64(ts))
+			require.NoError(t, err)
+			refs[lset.String()] = ref
+		}
+	}
+	ref200 := refs[`{__name__="http_requests_total", code="200"}`]
+	ref500 := refs[`{__name__="http_requests_total", code="500"}`]
+	_, err = app.AppendExemplar(ref200, labels.EmptyLabels(), exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: 20, HasTs: true})
+	require.NoError(t, err)
+	_, err = app.AppendExemplar(refs[`{__name__="up"}`], labels.EmptyLabels(), exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "def"), Value: 1, Ts: 20, HasTs: true})
+	require.NoError(t, err)
+	_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "http_request_duration_seconds"), 10, tsdbutil.GenerateTestHistogram(1), nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	collector := walDataCollector{}
+	require.NoError(t, ReplayMatching(dir, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "__name__", "http_requests_total"),
+	}, &collector))
+
+	var names []string
+	for _, series := range collector.series {
+		names = append(names, series.Labels.String())
+	}
+	require.ElementsMatch(t, []string{
+		`{__name__="http_requests_total", code="200"}`,
+		`{__name__="http_requests_total", code="500"}`,
+	}, names)
+	require.Equal(t, []record.RefSample{
+		{Ref: chunks.HeadSeriesRef(ref200), T: 10, V: 10},
+		{Ref: chunks.HeadSeriesRef(ref200), T: 20, V: 20},
+		{Ref: chunks.HeadSeriesRef(ref500), T: 10, V: 10},
+		{Ref: chunks.HeadSeriesRef(ref500), T: 20, V: 20},
+	}, collector.samples)
+	require.Len(t, collector.exemplars, 1)
+	require.Equal(t, chunks.HeadSeriesRef(ref200), collector.exemplars[0].Ref)
+	require.Empty(t, collector.histograms)
+
+	// Matchers on other labels narrow the replayed series down.
+	collector = walDataCollector{}
+	require.NoError(t, ReplayMatching(dir, []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, "__name__", "http_.*"),
+		labels.MustNewMatcher(labels.MatchNotEqual, "code", "200"),
+	}, &collector))
+	names = names[:0]
+	for _, series := range collector.series {
+		names = append(names, series.Labels.String())
+	}
+	require.ElementsMatch(t, []string{
+		`{__name__="http_requests_total", code="500"}`,
+		`{__name__="http_request_duration_seconds"}`,
+	}, names)
+	require.Len(t, collector.samples, 2)
+	require.Len(t, collector.histograms, 1)
+	require.Empty(t, collector.exemplars)
+
+	// Only the matching series are held in memory.
+	rr := newRangeReplayer(math.MinInt64, math.MaxInt64, &walDataCollector{})
+	rr.matchers = []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}
+	require.NoError(t, rr.replayDir(dir))
+	require.Len(t, rr.series, 1)
+}
+
+func TestReplayOrdered(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	// Each commit creates a new series alongside samples of existing ones.
+	var refs []storage.SeriesRef
+	for i := 0; i < 3; i++ {
+		app := s.Appender(t.Context())
+		for _, ref := range refs {
+			_, err := app.Append(ref, labels.EmptyLabels(), int64(i), 1)
+			require.NoError(t, err)
+		}
+		ref, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i)), int64(i), 1)
+		require.NoError(t, err)
+		_, err = app.AppendExemplar(ref, labels.EmptyLabels(), exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 1, Ts: int64(i), HasTs: true})
+		require.NoError(t, err)
+		_, err = app.AppendHistogram(0, labels.FromStrings("__name__", "hist", "i", strconv.Itoa(i)), int64(i), tsdbutil.GenerateTestHistogram(1), nil)
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+		refs = append(refs, ref)
+	}
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	var (
+		types []record.Type
+		known = make(map[chunks.HeadSeriesRef]bool)
+	)
+	requireKnown := func(ref chunks.HeadSeriesRef) {
+		require.True(t, known[ref], "series %d referenced before its series record", ref)
+	}
+	require.NoError(t, ReplayOrdered(dir, func(rec OrderedRecord) error {
+		types = append(types, rec.Type)
+		for _, series := range rec.Series {
+			known[series.Ref] = true
+		}
+		for _, sample := range rec.Samples {
+			requireKnown(sample.Ref)
+		}
+		for _, histogram := range rec.Histograms {
+			requireKnown(histogram.Ref)
+		}
+		for _, e := range rec.Exemplars {
+			requireKnown(e.Ref)
+		}
+		return nil
+	}))
+
+	// Records are delivered in the order of the commits that wrote them.
+	commit := []record.Type{record.Series, record.Samples, record.HistogramSamples, record.Exemplars}
+	require.Equal(t, slices.Concat(commit, commit, commit), types)
+
+	// Replay stops at the first error of the callback.
+	errStop := errors.New("stop")
+	var calls int
+	require.ErrorIs(t, ReplayOrdered(dir, func(OrderedRecord) error {
+		calls++
+		return errStop
+	}), errStop)
+	require.Equal(t, 1, calls)
+}
+
+func TestReplayOrdered_ConcurrentCommits(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	// The first appender creates the series, but the second one, which
+	// appends to it, is committed first.
+	lset := labels.FromStrings("__name__", "foo")
+	first := s.Appender(t.Context())
+	_, err = first.Append(0, lset, 1, 1)
+	require.NoError(t, err)
+
+	second := s.Appender(t.Context())
+	ref, err := second.Append(0, lset, 2, 2)
+	require.NoError(t, err)
+	_, err = second.AppendExemplar(ref, labels.EmptyLabels(), exemplar.Exemplar{Labels: labels.FromStrings("trace_id", "abc"), Value: 2, Ts: 2, HasTs: true})
+	require.NoError(t, err)
+	require.NoError(t, second.Commit())
+	require.NoError(t, first.Commit())
+
+	// Once written, the series isn't written again by later commits.
+	third := s.Appender(t.Context())
+	_, err = third.Append(ref, labels.EmptyLabels(), 3, 3)
+	require.NoError(t, err)
+	require.NoError(t, third.Commit())
+
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	var (
+		types []record.Type
+		known = make(map[chunks.HeadSeriesRef]bool)
+	)
+	require.NoError(t, ReplayOrdered(dir, func(rec OrderedRecord) error {
+		types = append(types, rec.Type)
+		for _, series := range rec.Series {
+			require.Equal(t, lset, series.Labels)
+			known[series.Ref] = true
+		}
+		for _, sample := range rec.Samples {
+			require.True(t, known[sample.Ref], "series %d referenced before its series record", sample.Ref)
+		}
+		for _, e := range rec.Exemplars {
+			require.True(t, known[e.Ref], "series %d referenced before its series record", e.Ref)
+		}
+		return nil
+	}))
+	require.Equal(t, []record.Type{
+		record.Series, record.Samples, record.Exemplars,
+		record.Series, record.Samples,
+		record.Samples,
+	}, types)
+}
+
+func TestStorage_BackfillAppender(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	lbls := labels.FromStrings("__name__", "foo")
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, lbls, 1000, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Samples older than the live ones are accepted within the window.
+	backfill, err := s.BackfillAppender(0, 500)
+	require.NoError(t, err)
+	ref, err := backfill.Append(0, lbls, 100, 2)
+	require.NoError(t, err)
+	_, err = backfill.Append(ref, labels.EmptyLabels(), 200, 3)
+	require.NoError(t, err)
+	_, err = backfill.Append(ref, labels.EmptyLabels(), 600, 4)
+	require.ErrorIs(t, err, storage.ErrOutOfBounds)
+	require.NoError(t, backfill.Commit())
+
+	// Backfilling doesn't affect live ingestion.
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, lbls, 2000, 5)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.Equal(t, 0.0, testutil.ToFloat64(s.metrics.totalOutOfOrderSamples))
+
+	_, err = s.BackfillAppender(500, 0)
+	require.Error(t, err)
+	require.NoError(t, s.Close())
+
+	windows, err := BackfillWindows(walDir)
+	require.NoError(t, err)
+	require.Equal(t, []BackfillWindow{{Mint: 0, Maxt: 500}}, windows)
+
+	replaySamples := func(dir string) []record.RefSample {
+		var samples []record.RefSample
+		require.NoError(t, ReplayOrdered(dir, func(rec OrderedRecord) error {
+			for _, sample := range rec.Samples {
+				sample.Ref = 0
+				samples = append(samples, sample)
+			}
+			return nil
+		}))
+		return samples
+	}
+	require.Equal(t, []record.RefSample{{T: 1000, V: 1}, {T: 2000, V: 5}}, replaySamples(SubDirectory(walDir)))
+	require.Equal(t, []record.RefSample{{T: 100, V: 2}, {T: 200, V: 3}}, replaySamples(BackfillDirectory(walDir, 0, 500)))
+}
+
+func TestStorage_BackfillAppenderSharedWriter(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	commit := func(ts int64) {
+		backfill, err := s.BackfillAppender(0, 500)
+		require.NoError(t, err)
+		_, err = backfill.Append(0, labels.FromStrings("__name__", "foo"), ts, 1)
+		require.NoError(t, err)
+		require.NoError(t, backfill.Commit())
+	}
+	segments := func() (int, int) {
+		first, last, err := wlog.Segments(BackfillDirectory(walDir, 0, 500))
+		require.NoError(t, err)
+		return first, last
+	}
+
+	// Commits to the same window share a segment.
+	commit(100)
+	commit(200)
+	first, last := segments()
+	require.Equal(t, first, last)
+
+	// Truncating closes the writer, the next commit opens a new segment.
+	require.NoError(t, s.Truncate(0))
+	commit(300)
+	_, newLast := segments()
+	require.Equal(t, last+1, newLast)
+}
+
+func TestMerge(t *testing.T) {
+	// Each WAL has its own series, and both have the shared series, whose
+	// refs collide with the other WAL.
+	writeWAL := func(dir string, own string, samples map[int64]float64) {
+		s, err := NewStorage(log.NewNopLogger(), nil, dir)
+		require.NoError(t, err)
+		app := s.Appender(t.Context())
+		for _, ts := range slices.Sorted(maps.Keys(samples)) {
+			_, err := app.Append(0, labels.FromStrings("__name__", "shared"), ts, samples[ts])
+			require.NoError(t, err)
+			_, err = app.Append(0, labels.FromStrings("__name__", own), ts, 1)
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+		require.NoError(t, s.Close())
+	}
+	a, b := t.TempDir(), t.TempDir()
+	writeWAL(a, "a", map[int64]float64{1: 1, 2: 2})
+	writeWAL(b, "b", map[int64]float64{2: 2, 3: 3, 1: 10})
+
+	dst := t.TempDir()
+	err := Merge(SubDirectory(dst), SubDirectory(a), SubDirectory(b))
+	var conflictErr *MergeConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	require.Equal(t, []MergeConflict{
+		{Labels: labels.FromStrings("__name__", "shared"), Timestamp: 1, Source: SubDirectory(b)},
+	}, conflictErr.Conflicts)
+
+	var collector walDataCollector
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(SubDirectory(dst)))
+
+	names := make(map[chunks.HeadSeriesRef]string)
+	for _, s := range collector.series {
+		require.NotContains(t, names, s.Ref, "series refs must be unique")
+		names[s.Ref] = s.Labels.Get("__name__")
+	}
+	require.ElementsMatch(t, []string{"shared", "a", "b"}, slices.Collect(maps.Values(names)))
+
+	got := make(map[string][]record.RefSample)
+	for _, s := range collector.samples {
+		name := names[s.Ref]
+		s.Ref = 0
+		got[name] = append(got[name], s)
+	}
+	require.Equal(t, map[string][]record.RefSample{
+		// The conflicting sample of b at 1 is dropped, and the duplicate
+		// sample at 2 is only merged once.
+		"shared": {{T: 1, V: 1}, {T: 2, V: 2}, {T: 3, V: 3}},
+		"a":      {{T: 1, V: 1}, {T: 2, V: 1}},
+		"b":      {{T: 1, V: 1}, {T: 2, V: 1}, {T: 3, V: 1}},
+	}, got)
+
+	// Merging into an existing WAL fails.
+	require.ErrorContains(t, Merge(SubDirectory(dst), SubDirectory(a)), "already holds a WAL")
+}
+
+func TestStorage_ExportBlocks(t *testing.T) {
+	s, err := NewStorage(log.NewNopLogger(), nil, t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender(t.Context())
+	ref, err := app.Append(0, labels.FromStrings("__name__", "a"), 1000, 1)
+	require.NoError(t, err)
+	for ts := int64(2000); ts <= 4000; ts += 1000 {
+		_, err := app.Append(ref, nil, ts, float64(ts/1000))
+		require.NoError(t, err)
+	}
+	_, err = app.Append(0, labels.FromStrings("__name__", "b"), 50_000, 5)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	// Samples older than the latest sample of their series, as written by a
+	// restarted instance with a clock skew, are exported to a separate block.
+	var enc record.Encoder
+	require.NoError(t, s.wal.Log(enc.Samples([]record.RefSample{
+		{Ref: chunks.HeadSeriesRef(ref), T: 2500, V: 25},
+		{Ref: chunks.HeadSeriesRef(ref), T: 3500, V: 35},
+	}, nil)))
+
+	dir := t.TempDir()
+	require.NoError(t, s.ExportBlocks(dir, 0, 10_000))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	got := make(map[int64][]record.RefSample)
+	for _, entry := range entries {
+		block, err := tsdb.OpenBlock(log.NewNopLogger(), filepath.Join(dir, entry.Name()), nil)
+		require.NoError(t, err)
+		q, err := tsdb.NewBlockQuerier(block, math.MinInt64, math.MaxInt64)
+		require.NoError(t, err)
+
+		set := q.Select(t.Context(), false, nil, labels.MustNewMatcher(labels.MatchRegexp, "__name__", ".+"))
+		for set.Next() {
+			// The sample of b is outside of the export window.
+			require.Equal(t, "a", set.At().Labels().Get("__name__"))
+			it := set.At().Iterator(nil)
+			for it.Next() == chunkenc.ValFloat {
+				ts, v := it.At()
+				got[block.MinTime()] = append(got[block.MinTime()], record.RefSample{T: ts, V: v})
+			}
+			require.NoError(t, it.Err())
+		}
+		require.NoError(t, set.Err())
+		require.NoError(t, q.Close())
+		require.NoError(t, block.Close())
+	}
+	require.Equal(t, map[int64][]record.RefSample{
+		1000: {{T: 1000, V: 1}, {T: 2000, V: 2}, {T: 3000, V: 3}, {T: 4000, V: 4}},
+		2500: {{T: 2500, V: 25}, {T: 3500, V: 35}},
+	}, got)
+
+	require.ErrorContains(t, s.ExportBlocks(dir, 10, 0), "invalid export window")
+}
+
+func TestStorage_ExportBlocksRanges(t *testing.T) {
+	s, err := NewStorage(log.NewNopLogger(), nil, t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+
+	const d = exportBlockDuration
+
+	app := s.Appender(t.Context())
+	ref, err := app.Append(0, labels.FromStrings("__name__", "a"), 1000, 1)
+	require.NoError(t, err)
+	for _, ts := range []int64{d - 1, d, 2*d + 5} {
+		_, err := app.Append(ref, nil, ts, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	// Samples are split into blocks aligned to the block ranges.
+	dir := t.TempDir()
+	require.NoError(t, s.ExportBlocks(dir, 0, 3*d))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var ranges [][2]int64
+	for _, entry := range entries {
+		block, err := tsdb.OpenBlock(log.NewNopLogger(), filepath.Join(dir, entry.Name()), nil)
+		require.NoError(t, err)
+		ranges = append(ranges, [2]int64{block.MinTime(), block.MaxTime()})
+		require.NoError(t, block.Close())
+	}
+	slices.SortFunc(ranges, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	require.Equal(t, [][2]int64{{1000, d}, {d, d + 1}, {2*d + 5, 2*d + 6}}, ranges)
+
+	// Windows without samples don't write any block.
+	dir = t.TempDir()
+	require.NoError(t, s.ExportBlocks(dir, 3*d, 4*d))
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestStorage_ColdDir(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.ColdDir = filepath.Join(t.TempDir(), "cold")
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	lbls := labels.FromStrings("__name__", "foo")
+	for ts := int64(1); ts <= 6; ts++ {
+		app := s.Appender(t.Context())
+		_, err := app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+
+		// Write every sample to its own segment.
+		_, err = s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+
+	// Checkpoint the segments holding the first four samples, keeping the
+	// samples newer than 4 in the checkpoint.
+	require.NoError(t, s.Truncate(4))
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	// The checkpointed segments are moved to the cold directory.
+	coldFirst, coldLast, err := wlog.Segments(opts.ColdDir)
+	require.NoError(t, err)
+	require.Equal(t, 0, coldFirst)
+	require.Equal(t, 3, coldLast)
+	first, _, err := wlog.Segments(dir)
+	require.NoError(t, err)
+	require.Equal(t, coldLast+1, first)
+
+	timestamps := func(c *walDataCollector) []int64 {
+		var res []int64
+		for _, s := range c.samples {
+			res = append(res, s.T)
+		}
+		return res
+	}
+
+	// The WAL alone only holds the samples kept by the checkpoint.
+	var hot walDataCollector
+	require.NoError(t, ReplayRange(dir, math.MinInt64, math.MaxInt64, &hot))
+	require.Equal(t, []int64{4, 5, 6}, timestamps(&hot))
+
+	// Sealed segments remain replayable from the cold directory, without
+	// duplicating the samples kept by the checkpoint.
+	var all walDataCollector
+	require.NoError(t, ReplayRangeWithColdDir(dir, opts.ColdDir, math.MinInt64, math.MaxInt64, &all))
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6}, timestamps(&all))
+	require.Len(t, all.series, 1)
+}
+
+func TestStorage_ColdDirCrossDevice(t *testing.T) {
+	// Renames across filesystems fail with EXDEV.
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.ColdDir = filepath.Join(t.TempDir(), "cold")
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	lbls := labels.FromStrings("__name__", "foo")
+	for ts := int64(1); ts <= 4; ts++ {
+		app := s.Appender(t.Context())
+		_, err := app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+		require.NoError(t, app.Commit())
+
+		_, err = s.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Truncate(3))
+	dir := s.wal.Dir()
+	require.NoError(t, s.Close())
+
+	// The segments are copied to the cold directory and removed from the
+	// WAL, without leaving temporary files behind.
+	entries, err := os.ReadDir(opts.ColdDir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"00000000", "00000001", "00000002"}, names)
+	first, _, err := wlog.Segments(dir)
+	require.NoError(t, err)
+	require.Equal(t, 3, first)
+
+	var all walDataCollector
+	require.NoError(t, ReplayRangeWithColdDir(dir, opts.ColdDir, math.MinInt64, math.MaxInt64, &all))
+	var timestamps []int64
+	for _, s := range all.samples {
+		timestamps = append(timestamps, s.T)
+	}
+	require.Equal(t, []int64{1, 2, 3, 4}, timestamps)
+}
+
+func TestStorage_SelfCheck(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	fooRef, err := app.Append(0, labels.FromStrings("__name__", "foo"), 1, 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "bar"), 1, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.NoError(t, s.SelfCheck())
+
+	// Series of in-flight appenders aren't in the WAL yet, and aren't
+	// reported.
+	inflight := s.Appender(t.Context())
+	_, err = inflight.Append(0, labels.FromStrings("__name__", "inflight"), 1, 1)
+	require.NoError(t, err)
+	require.NoError(t, s.SelfCheck())
+	require.NoError(t, inflight.Commit())
+	require.NoError(t, s.SelfCheck())
+
+	// Corrupt the series in memory: rename a series and add one which was
+	// never written to the WAL.
+	foo := s.series.GetByID(chunks.HeadSeriesRef(fooRef))
+	require.True(t, s.series.relabel(foo, labels.FromStrings("__name__", "renamed")))
+	phantom := labels.FromStrings("__name__", "phantom")
+	s.series.Set(phantom.Hash(), &memSeries{ref: 100, lset: phantom})
+
+	err = s.SelfCheck()
+	require.ErrorContains(t, err, `series 100 {__name__="phantom"} is missing from the WAL`)
+	require.ErrorContains(t, err, fmt.Sprintf(`series %d has labels {__name__="renamed"} in memory but {__name__="foo"} in the WAL`, fooRef))
+}
+
+func TestStorage_VerifyOnAppend(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.VerifyOnAppend = true
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	commit := func(ts int64) error {
+		app := s.Appender(t.Context())
+		// Enough series for records to span several pages.
+		for i := 0; i < 1000; i++ {
+			_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i)), ts, float64(ts))
+			require.NoError(t, err)
+		}
+		return app.Commit()
+	}
+
+	require.NoError(t, commit(1))
+	require.NoError(t, commit(2))
+
+	s.writer = &corruptingWriter{WL: s.wal}
+	require.ErrorIs(t, commit(3), errVerifyMismatch)
+	require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalVerifyFailures))
+}
+
+// corruptingWriter flips a bit of every record before writing it, simulating
+// corruption happening between the appender and the disk.
+type corruptingWriter struct {
+	*wlog.WL
+}
+
+func (w *corruptingWriter) Log(recs ...[]byte) error {
+	corrupted := make([][]byte, 0, len(recs))
+	for _, rec := range recs {
+		rec = slices.Clone(rec)
+		rec[len(rec)-1] ^= 0x01
+		corrupted = append(corrupted, rec)
+	}
+	return w.WL.Log(corrupted...)
+}
+
+func TestStorage_GroupCommit(t *testing.T) {
+	walDir := t.TempDir()
+
+	opts := DefaultOptions()
+	opts.GroupCommitWindow = 100 * time.Millisecond
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	writer := &syncCountingWriter{WL: s.wal}
+	s.writer = writer
+
+	const commits = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < commits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app := s.Appender(t.Context())
+			_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i)), 1, 1)
+			assert.NoError(t, err)
+			assert.NoError(t, app.Commit())
+		}()
+	}
+	wg.Wait()
+
+	syncs := writer.syncs.Load()
+	require.GreaterOrEqual(t, syncs, int64(1))
+	require.Less(t, syncs, int64(commits))
+}
+
+func TestStorage_AppendToDurableLatency(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GroupCommitWindow = 100 * time.Millisecond
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), prometheus.NewRegistry(), t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	latency := func() *dto.Histogram {
+		var m dto.Metric
+		require.NoError(t, s.metrics.appendToDurable.Write(&m))
+		return m.GetHistogram()
+	}
+
+	// The latency covers the time the samples were pending before the commit,
+	// and the group commit window.
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo"), 1, 1)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, app.Commit())
+
+	h := latency()
+	require.Equal(t, uint64(1), h.GetSampleCount())
+	require.GreaterOrEqual(t, h.GetSampleSum(), 0.15)
+	require.Less(t, h.GetSampleSum(), 5.0)
+
+	// Commits without samples aren't observed.
+	app = s.Appender(t.Context())
+	require.NoError(t, app.Commit())
+	require.Equal(t, uint64(1), latency().GetSampleCount())
+}
+
+func TestStorage_WritePressure(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GroupCommitWindow = 10 * time.Millisecond
+	opts.WriteBufferSize = 16 * 1024
+	opts.WritePressureHighWater = 0.5
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	// Syncs are slow, so commits pile up while waiting for them.
+	s.writer = &slowSyncWriter{WL: s.wal, delay: 100 * time.Millisecond}
+	require.Zero(t, s.WritePressure())
+
+	var (
+		wg          sync.WaitGroup
+		maxPressure atomic.Float64
+		done        = make(chan struct{})
+	)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				if p := s.WritePressure(); p > maxPressure.Load() {
+					maxPressure.Store(p)
+				}
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				app := s.Appender(t.Context())
+				for k := 0; k < 100; k++ {
+					_, err := app.Append(0, labels.FromStrings("__name__", "foo", "i", strconv.Itoa(i), "k", strconv.Itoa(k)), int64(j), 1)
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, app.Commit())
+			}
+		}()
+	}
+
+	select {
+	case <-s.WritePressureSignal():
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "write pressure signal wasn't sent")
+	}
+	wg.Wait()
+	close(done)
 
-	collector := walDataCollector{}
-	replayer := walReplayer{w: &collector}
-	require.NoError(t, replayer.Replay(s.wal.Dir()))
+	require.GreaterOrEqual(t, maxPressure.Load(), opts.WritePressureHighWater)
+	require.LessOrEqual(t, maxPressure.Load(), 1.0)
+	// Every commit completed, so the buffer is empty again.
+	require.Zero(t, s.WritePressure())
+}
 
-	names := []string{}
-	for _, series := range collector.series {
-		names = append(names, series.Labels.Get("__name__"))
+func TestWriteBuffer_ConcurrentCommits(t *testing.T) {
+	const size = 100
+	b := newWriteBuffer(size, 0)
+
+	var (
+		wg       sync.WaitGroup
+		inFlight atomic.Int64
+		maxUsed  atomic.Int64
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				n := 30 + (i+j)%20
+				done := b.reserve(n)
+				used := inFlight.Add(int64(n))
+				for {
+					prev := maxUsed.Load()
+					if used <= prev || maxUsed.CompareAndSwap(prev, used) {
+						break
+					}
+				}
+				time.Sleep(time.Microsecond)
+				inFlight.Add(-int64(n))
+				done()
+			}
+		}()
 	}
-	require.Equal(t, payload.SeriesNames(), names)
+	wg.Wait()
+
+	// Commits waiting for space at the same time never overcommit the
+	// buffer.
+	require.LessOrEqual(t, maxUsed.Load(), int64(size))
+	require.Zero(t, b.used)
+
+	// A commit larger than the buffer proceeds alone, and the pressure is
+	// capped at 1.
+	done := b.reserve(2 * size)
+	b.mut.Lock()
+	require.Equal(t, 1.0, b.pressure())
+	b.mut.Unlock()
+	done()
+}
 
-	expectedSamples := payload.ExpectedSamples()
-	actualSamples := collector.samples
-	sort.Sort(byRefSample(actualSamples))
-	require.Equal(t, expectedSamples, actualSamples)
+// slowSyncWriter delays every sync of the WAL.
+type slowSyncWriter struct {
+	*wlog.WL
+	delay time.Duration
+}
 
-	expectedExemplars := payload.ExpectedExemplars()
-	actualExemplars := collector.exemplars
-	sort.Sort(byRefExemplar(actualExemplars))
-	require.Equal(t, expectedExemplars, actualExemplars)
+func (w *slowSyncWriter) Sync() error {
+	time.Sleep(w.delay)
+	return w.WL.Sync()
 }
 
-func TestStorage_ExistingWAL_RefID(t *testing.T) {
-	l := util.TestLogger(t)
+// syncCountingWriter counts the number of times the WAL is synced.
+type syncCountingWriter struct {
+	*wlog.WL
+	syncs atomic.Int64
+}
+
+func (w *syncCountingWriter) Sync() error {
+	w.syncs.Add(1)
+	return w.WL.Sync()
+}
 
+func TestStorage_Barrier(t *testing.T) {
 	walDir := t.TempDir()
 
-	s, err := NewStorage(l, nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
 	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
 
-	app := s.Appender(t.Context())
-	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+	// Only the data synced to disk survives a crash, which is simulated by
+	// replaying a copy of the WAL taken on each sync.
+	writer := &durableCopyWriter{WL: s.wal, dir: filepath.Join(t.TempDir(), "durable")}
+	s.writer = writer
 
-	// Write all the samples
-	for _, metric := range payload {
-		metric.Write(t, app)
-	}
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "before"), 1, 1)
+	require.NoError(t, err)
 	require.NoError(t, app.Commit())
 
-	// Truncate the WAL to force creation of a new segment.
-	require.NoError(t, s.Truncate(0))
-	require.NoError(t, s.Close())
+	require.NoError(t, s.Barrier(t.Context()))
 
-	// Create a new storage and see what the ref ID is initialized to.
-	s, err = NewStorage(l, nil, walDir)
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "after"), 2, 2)
 	require.NoError(t, err)
-	defer require.NoError(t, s.Close())
+	require.NoError(t, app.Commit())
 
-	require.Equal(t, uint64(len(payload)), s.nextRef.Load(), "cached ref ID should be equal to the number of series written")
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(writer.dir))
+
+	require.Len(t, collector.series, 1)
+	require.Equal(t, labels.FromStrings("__name__", "before"), collector.series[0].Labels)
+	require.Len(t, collector.samples, 1)
+	require.Equal(t, int64(1), collector.samples[0].T)
+
+	// A canceled barrier returns without waiting for the sync.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, s.Barrier(ctx), context.Canceled)
 }
 
-func TestStorage_Truncate(t *testing.T) {
-	// Same as before but now do the following:
-	// after writing all the data, forcefully create 4 more segments,
-	// then do a truncate of a timestamp for _some_ of the data.
-	// then read data back in. Expect to only get the latter half of data.
-	walDir := t.TempDir()
+// failingSyncWriter fails every sync of the WAL.
+type failingSyncWriter struct {
+	*wlog.WL
+}
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+func (w *failingSyncWriter) Sync() error {
+	return errors.New("disk unavailable")
+}
+
+func TestStorage_HealthCheck(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s, err := NewStorage(log.NewNopLogger(), reg, t.TempDir())
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
 	}()
 
-	app := s.Appender(t.Context())
+	require.NoError(t, s.HealthCheck(t.Context()))
+	require.NoError(t, s.HealthCheck(t.Context()))
 
-	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+	// Canaries don't write any series or sample.
+	var collector walDataCollector
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+	require.Empty(t, collector.series)
+	require.Empty(t, collector.samples)
+	require.Zero(t, testutil.ToFloat64(s.metrics.numActiveSeries))
 
-	for _, metric := range payload {
-		metric.Write(t, app)
+	s.writer = &failingSyncWriter{WL: s.wal}
+	require.ErrorContains(t, s.HealthCheck(t.Context()), "disk unavailable")
+	s.writer = s.wal
+
+	// Canaries are skipped when the WAL is replayed.
+	dir := s.path
+	require.NoError(t, s.Close())
+	s, err = NewStorage(log.NewNopLogger(), prometheus.NewRegistry(), dir)
+	require.NoError(t, err)
+	require.Zero(t, testutil.ToFloat64(s.metrics.totalSkippedRecords.WithLabelValues(SkipReasonUnknownType)))
+	require.Zero(t, testutil.ToFloat64(s.metrics.numActiveSeries))
+}
+
+// flakyWriter fails the first syncFailures syncs and logFailures writes with
+// err, without writing anything.
+type flakyWriter struct {
+	*wlog.WL
+	err          error
+	syncFailures int
+	logFailures  int
+	syncs, logs  int
+}
+
+func (w *flakyWriter) Log(recs ...[]byte) error {
+	w.logs++
+	if w.logs <= w.logFailures {
+		return w.err
 	}
+	return w.WL.Log(recs...)
+}
 
-	require.NoError(t, app.Commit())
+func (w *flakyWriter) Sync() error {
+	w.syncs++
+	if w.syncs <= w.syncFailures {
+		return w.err
+	}
+	return w.WL.Sync()
+}
 
-	// Forcefully create a bunch of new segments so when we truncate
-	// there's enough segments to be considered for truncation.
-	for i := 0; i < 5; i++ {
-		_, err := s.wal.NextSegmentSync()
+func TestStorage_CommitRetries(t *testing.T) {
+	newStorage := func(t *testing.T, writer *flakyWriter) *Storage {
+		opts := DefaultOptions()
+		opts.CommitRetries = 3
+		opts.CommitRetryBackoff = time.Millisecond
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
 		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, s.Close()) })
+		writer.WL = s.wal
+		s.writer = writer
+		return s
 	}
+	commit := func(s *Storage) error {
+		app := s.Appender(t.Context())
+		_, err := app.Append(0, labels.FromStrings("__name__", "a"), 1, 1)
+		require.NoError(t, err)
+		return app.Commit()
+	}
+	transient := &os.PathError{Op: "fsync", Path: "wal", Err: syscall.EAGAIN}
 
-	// Truncate half of the samples, keeping only the second sample
-	// per series.
-	keepTs := payload[len(payload)-1].samples[0].ts + 1
-	err = s.Truncate(keepTs)
-	require.NoError(t, err)
-
-	payload = payload.Filter(func(s sample) bool {
-		return s.ts >= keepTs
-	}, func(e exemplar.Exemplar) bool {
-		return e.HasTs && e.Ts >= keepTs
-	})
-	expectedSamples := payload.ExpectedSamples()
-	expectedExemplars := payload.ExpectedExemplars()
+	t.Run("write fails twice", func(t *testing.T) {
+		writer := &flakyWriter{err: transient, logFailures: 2}
+		s := newStorage(t, writer)
 
-	// Read back the WAL, collect series and samples.
-	collector := walDataCollector{}
-	replayer := walReplayer{w: &collector}
-	require.NoError(t, replayer.Replay(s.wal.Dir()))
+		require.NoError(t, commit(s))
+		require.Equal(t, 2.0, testutil.ToFloat64(s.metrics.totalCommitRetries))
 
-	names := []string{}
-	for _, series := range collector.series {
-		names = append(names, series.Labels.Get("__name__"))
-	}
-	require.Equal(t, payload.SeriesNames(), names)
+		// Retrying the write doesn't write the records twice.
+		var collector walDataCollector
+		replayer := walReplayer{w: &collector}
+		require.NoError(t, replayer.Replay(s.wal.Dir()))
+		require.Len(t, collector.series, 1)
+		require.Len(t, collector.samples, 1)
+	})
 
-	actualSamples := collector.samples
-	sort.Sort(byRefSample(actualSamples))
-	require.Equal(t, expectedSamples, actualSamples)
+	t.Run("sync fails", func(t *testing.T) {
+		writer := &flakyWriter{err: transient, syncFailures: 1}
+		s := newStorage(t, writer)
+		_, before, err := wlog.Segments(s.wal.Dir())
+		require.NoError(t, err)
 
-	actualExemplars := collector.exemplars
-	sort.Sort(byRefExemplar(actualExemplars))
-	require.Equal(t, expectedExemplars, actualExemplars)
-}
+		// Failed syncs aren't retried, the segment is rotated instead.
+		require.ErrorIs(t, commit(s), syscall.EAGAIN)
+		require.Equal(t, 1, writer.syncs)
+		require.Zero(t, testutil.ToFloat64(s.metrics.totalCommitRetries))
+		_, after, err := wlog.Segments(s.wal.Dir())
+		require.NoError(t, err)
+		require.Equal(t, before+1, after)
 
-func TestStorage_WriteStalenessMarkers(t *testing.T) {
-	walDir := t.TempDir()
+		require.NoError(t, commit(s))
+	})
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
-	require.NoError(t, err)
-	defer func() {
+	t.Run("backoff releases the WAL", func(t *testing.T) {
+		writer := &flakyWriter{err: transient, logFailures: 1}
+		opts := DefaultOptions()
+		opts.CommitRetries = 1
+		opts.CommitRetryBackoff = time.Second
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), opts)
+		require.NoError(t, err)
+		writer.WL = s.wal
+		s.writer = writer
+
+		errc := make(chan error, 1)
+		go func() { errc <- commit(s) }()
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(s.metrics.totalCommitRetries) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// The WAL can be closed while the commit is backing off, failing
+		// the commit once it's done.
+		start := time.Now()
 		require.NoError(t, s.Close())
-	}()
-
-	app := s.Appender(t.Context())
+		require.Less(t, time.Since(start), opts.CommitRetryBackoff/2)
+		require.ErrorIs(t, <-errc, ErrWALClosed)
+	})
 
-	// Write some samples
-	payload := seriesList{
-		{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
-		{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
-		{name: "baz", samples: []sample{{3, 30.0}, {30, 300.0}}},
-	}
-	for _, metric := range payload {
-		metric.Write(t, app)
-	}
+	t.Run("non-retryable error", func(t *testing.T) {
+		writer := &flakyWriter{err: &os.PathError{Op: "fsync", Path: "wal", Err: syscall.EIO}, syncFailures: 2}
+		s := newStorage(t, writer)
 
-	require.NoError(t, app.Commit())
+		require.ErrorIs(t, commit(s), syscall.EIO)
+		require.Equal(t, 1, writer.syncs)
+	})
 
-	// Write staleness markers for every series
-	require.NoError(t, s.WriteStalenessMarkers(func() int64 {
-		// Pass math.MaxInt64 so it seems like everything was written already
-		return math.MaxInt64
-	}))
+	t.Run("retries exhausted", func(t *testing.T) {
+		writer := &flakyWriter{err: transient, logFailures: 10}
+		s := newStorage(t, writer)
 
-	// Read back the WAL, collect series and samples.
-	collector := walDataCollector{}
-	replayer := walReplayer{w: &collector}
-	require.NoError(t, replayer.Replay(s.wal.Dir()))
+		require.ErrorIs(t, commit(s), syscall.EAGAIN)
+		require.Equal(t, 4, writer.logs)
+	})
+}
 
-	actual := collector.samples
-	sort.Sort(byRefSample(actual))
+// durableCopyWriter copies the WAL directory to dir on every sync, so that
+// dir holds the data which would survive a crash.
+type durableCopyWriter struct {
+	*wlog.WL
+	dir string
+}
 
-	staleMap := map[chunks.HeadSeriesRef]bool{}
-	for _, sample := range actual {
-		if _, ok := staleMap[sample.Ref]; !ok {
-			staleMap[sample.Ref] = false
-		}
-		if value.IsStaleNaN(sample.V) {
-			staleMap[sample.Ref] = true
-		}
+func (w *durableCopyWriter) Sync() error {
+	if err := w.WL.Sync(); err != nil {
+		return err
 	}
-
-	for ref, v := range staleMap {
-		require.True(t, v, "ref %d doesn't have stale marker", ref)
+	if err := os.RemoveAll(w.dir); err != nil {
+		return err
 	}
+	return os.CopyFS(w.dir, os.DirFS(w.WL.Dir()))
 }
 
 func TestStorage_TruncateAfterClose(t *testing.T) {
@@ -412,8 +3467,70 @@ func TestStorage_Corruption(t *testing.T) {
 	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
 	require.NoError(t, err)
 	require.NotNil(t, s)
+	require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalSkippedRecords.WithLabelValues(SkipReasonCorruption)))
+
+	require.NoError(t, s.Close())
+}
+
+func TestStorage_ReplaySkippedRecords(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	app := s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo"), 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
 
+	// Write a record of a type unknown to the storage, followed by a valid
+	// record which must still be loaded.
+	require.NoError(t, s.wal.Log([]byte{200, 1, 2, 3}))
+	app = s.Appender(t.Context())
+	_, err = app.Append(0, labels.FromStrings("__name__", "bar"), 20, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
 	require.NoError(t, s.Close())
+
+	s, err = NewStorage(log.NewNopLogger(), prometheus.NewRegistry(), walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	skipped := s.metrics.totalSkippedRecords
+	require.Equal(t, 1.0, testutil.ToFloat64(skipped.WithLabelValues(SkipReasonUnknownType)))
+	require.Equal(t, 0.0, testutil.ToFloat64(skipped.WithLabelValues(SkipReasonCorruption)))
+
+	bar := labels.FromStrings("__name__", "bar")
+	require.NotNil(t, s.series.GetByHash(bar.Hash(), bar))
+}
+
+func TestStorage_CardinalityByLabel(t *testing.T) {
+	walDir := t.TempDir()
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(t.Context())
+	for i := 0; i < 6; i++ {
+		job := "api"
+		if i%3 == 0 {
+			job = "db"
+		}
+		_, err := app.Append(0, labels.FromStrings("__name__", "metric", "job", job, "series", strconv.Itoa(i)), 10, 1)
+		require.NoError(t, err)
+	}
+	_, err = app.Append(0, labels.FromStrings("__name__", "metric", "series", "nojob"), 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, map[string]int{"api": 4, "db": 2}, s.CardinalityByLabel("job"))
+	require.Equal(t, map[string]int{"metric": 7}, s.CardinalityByLabel("__name__"))
+	require.Empty(t, s.CardinalityByLabel("missing"))
 }
 
 func TestGlobalReferenceID_Normal(t *testing.T) {
@@ -489,6 +3606,168 @@ func BenchmarkAppendExemplar(b *testing.B) {
 	_ = app.Commit()
 }
 
+func BenchmarkAppendTrusted(b *testing.B) {
+	lbls := make([]labels.Labels, 10_000)
+	for i := range lbls {
+		lbls[i] = labels.FromStrings("__name__", "metric", "instance", "localhost:9090", "job", "test", "series", strconv.Itoa(i))
+	}
+
+	for _, tc := range []struct {
+		name         string
+		appendSample func(app storage.Appender, l labels.Labels) error
+	}{
+		{"Append", func(app storage.Appender, l labels.Labels) error {
+			_, err := app.Append(0, l, 1, 1)
+			return err
+		}},
+		{"AppendTrusted", func(app storage.Appender, l labels.Labels) error {
+			_, err := app.(TrustedAppender).AppendTrusted(0, l, 1, 1)
+			return err
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s, err := NewStorage(log.NewNopLogger(), nil, b.TempDir())
+				require.NoError(b, err)
+				app := s.Appender(b.Context())
+				b.StartTimer()
+
+				// Every sample creates a new series, whose labels are
+				// validated by Append.
+				for _, l := range lbls {
+					require.NoError(b, tc.appendSample(app, l))
+				}
+
+				b.StopTimer()
+				require.NoError(b, app.Rollback())
+				require.NoError(b, s.Close())
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkInternLabels measures the heap held by series replayed from the
+// WAL, whose labels are decoded into strings of their own, with and without
+// Options.InternLabels.
+func BenchmarkInternLabels(b *testing.B) {
+	const numSeries = 100_000
+
+	walDir := b.TempDir()
+	writeInternLabelsWAL(b, walDir, numSeries)
+
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%t", intern), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.InternLabels = intern
+
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+				require.NoError(b, err)
+
+				// Pooled replay buffers survive the first collection.
+				runtime.GC()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/numSeries, "heap-B/series")
+
+				require.NoError(b, s.Close())
+			}
+		})
+	}
+}
+
+// writeInternLabelsWAL writes numSeries series to the WAL in dir, most of
+// their label names and values being shared with other series.
+func writeInternLabelsWAL(b *testing.B, dir string, numSeries int) {
+	s, err := NewStorage(log.NewNopLogger(), nil, dir)
+	require.NoError(b, err)
+	app := s.Appender(b.Context())
+	for i := 0; i < numSeries; i++ {
+		l := labels.FromStrings(
+			"__name__", fmt.Sprintf("http_requests_total_%d", i%10),
+			"instance", fmt.Sprintf("host-%d.example.com:9090", i%100),
+			"job", "kubernetes-pods",
+			"namespace", fmt.Sprintf("namespace-%d", i%20),
+			"pod", fmt.Sprintf("pod-%d", i),
+		)
+		_, err := app.Append(0, l, 1, 1)
+		require.NoError(b, err)
+	}
+	require.NoError(b, app.Commit())
+	require.NoError(b, s.Close())
+}
+
+func BenchmarkReplayPreallocSeries(b *testing.B) {
+	const numSeries = 200_000
+
+	walDir := b.TempDir()
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(b, err)
+	app := s.Appender(b.Context())
+	for i := 0; i < numSeries; i++ {
+		_, err := app.Append(0, labels.FromStrings("__name__", "metric", "series", strconv.Itoa(i)), 1, 1)
+		require.NoError(b, err)
+	}
+	require.NoError(b, app.Commit())
+	require.NoError(b, s.Close())
+
+	for _, prealloc := range []int{0, numSeries} {
+		b.Run(fmt.Sprintf("prealloc=%d", prealloc), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.PreallocSeries = prealloc
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+				require.NoError(b, err)
+				require.NoError(b, s.Close())
+			}
+		})
+	}
+}
+
+// BenchmarkStreamCheckpoint compares the allocations of streamCheckpoint
+// with those of wlog.Checkpoint, which buffers the records it writes.
+func BenchmarkStreamCheckpoint(b *testing.B) {
+	for _, tc := range []struct {
+		name   string
+		create func(w *wlog.WL) error
+	}{
+		{"buffered", func(w *wlog.WL) error {
+			_, err := wlog.Checkpoint(log.NewNopLogger(), w, 0, 3, checkpointTestKeep, checkpointTestMint)
+			return err
+		}},
+		{"streamed", func(w *wlog.WL) error {
+			return streamCheckpoint(log.NewNopLogger(), w, 0, 3, checkpointTestKeep, samplesSince(checkpointTestMint), 0, 0)
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			w := newCheckpointTestWAL(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, tc.create(w))
+
+				// The checkpoint is removed so that the next one is
+				// created from the segments again.
+				b.StopTimer()
+				dir, _, err := wlog.LastCheckpoint(w.Dir())
+				require.NoError(b, err)
+				require.NoError(b, os.RemoveAll(dir))
+				b.StartTimer()
+			}
+		})
+	}
+}
+
 type sample struct {
 	ts  int64
 	val float64
@@ -679,3 +3958,57 @@ func (fn *fakeNotifier) Notify() {
 		fn.NotitfyFunc()
 	}
 }
+
+// countCheckpointSamples returns the number of samples stored for each series
+// in the checkpoint in dir.
+func countCheckpointSamples(dir string) (map[chunks.HeadSeriesRef]int, error) {
+	sr, err := wlog.NewSegmentsReader(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open checkpoint: %w", err)
+	}
+	defer sr.Close()
+
+	var (
+		counts = make(map[chunks.HeadSeriesRef]int)
+		r      = wlog.NewReader(sr)
+		dec    = record.NewDecoder(labels.NewSymbolTable())
+
+		samples               []record.RefSample
+		histogramSamples      []record.RefHistogramSample
+		floatHistogramSamples []record.RefFloatHistogramSample
+	)
+	for r.Next() {
+		rec := r.Record()
+
+		switch dec.Type(rec) {
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				return nil, fmt.Errorf("decode samples: %w", err)
+			}
+			for _, s := range samples {
+				counts[s.Ref]++
+			}
+		case record.HistogramSamples:
+			histogramSamples, err = dec.HistogramSamples(rec, histogramSamples[:0])
+			if err != nil {
+				return nil, fmt.Errorf("decode histogram samples: %w", err)
+			}
+			for _, h := range histogramSamples {
+				counts[h.Ref]++
+			}
+		case record.FloatHistogramSamples:
+			floatHistogramSamples, err = dec.FloatHistogramSamples(rec, floatHistogramSamples[:0])
+			if err != nil {
+				return nil, fmt.Errorf("decode float histogram samples: %w", err)
+			}
+			for _, fh := range floatHistogramSamples {
+				counts[fh.Ref]++
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	return counts, nil
+}
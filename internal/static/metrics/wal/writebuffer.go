@@ -0,0 +1,100 @@
+package wal
+
+import (
+	"sync"
+)
+
+// writeBuffer bounds the bytes of commits in flight, from the time their
+// records start being written to the WAL until the commit completes,
+// including waiting for the WAL to be synced. Commits reserve their size
+// before writing and wait while it doesn't fit in the buffer, so a slow disk
+// applies backpressure to appenders.
+type writeBuffer struct {
+	size      int
+	highWater float64
+
+	mut  sync.Mutex
+	cond *sync.Cond
+	used int
+	// high is set while the pressure is at or above highWater, so that
+	// signal only fires when the pressure crosses it.
+	high   bool
+	signal chan struct{}
+}
+
+func newWriteBuffer(size int, highWater float64) *writeBuffer {
+	b := &writeBuffer{
+		size:      size,
+		highWater: highWater,
+		signal:    make(chan struct{}, 1),
+	}
+	b.cond = sync.NewCond(&b.mut)
+	return b
+}
+
+// reserve blocks until there's space in the buffer for the n bytes of a
+// commit and reserves them, and returns a function releasing them once the
+// commit completes. A commit larger than the buffer proceeds once the buffer
+// is empty. A disabled buffer never blocks.
+func (b *writeBuffer) reserve(n int) (done func()) {
+	if b.size <= 0 || n == 0 {
+		return func() {}
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for b.used > 0 && b.used+n > b.size {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.updateHigh()
+
+	return func() {
+		b.mut.Lock()
+		defer b.mut.Unlock()
+		b.used -= n
+		b.updateHigh()
+		b.cond.Broadcast()
+	}
+}
+
+// updateHigh notifies signal when the pressure crosses the high-water mark.
+// mut must be held by the caller.
+func (b *writeBuffer) updateHigh() {
+	high := b.highWater > 0 && b.pressure() >= b.highWater
+	if high && !b.high {
+		select {
+		case b.signal <- struct{}{}:
+		default:
+			// A signal is already pending.
+		}
+	}
+	b.high = high
+}
+
+// pressure returns how full the buffer is. mut must be held by the caller.
+func (b *writeBuffer) pressure() float64 {
+	if b.size <= 0 {
+		return 0
+	}
+	// A commit larger than the buffer fills it beyond its size.
+	return max(0, min(float64(b.used)/float64(b.size), 1))
+}
+
+// WritePressure returns how full the write buffer of the storage is, from 0
+// when no commit is in flight to 1 when it's full and new commits wait for
+// the commits in flight to complete. Hosts can use it to throttle appends
+// before commits block. It's always 0 if Options.WriteBufferSize isn't set.
+func (w *Storage) WritePressure() float64 {
+	w.writeBuffer.mut.Lock()
+	defer w.writeBuffer.mut.Unlock()
+	return w.writeBuffer.pressure()
+}
+
+// WritePressureSignal returns a channel which receives a value when the
+// write pressure rises to Options.WritePressureHighWater. The signal isn't
+// sent again until the pressure drops below the high-water mark and rises
+// back to it. At most one signal is buffered if it isn't received.
+func (w *Storage) WritePressureSignal() <-chan struct{} {
+	return w.writeBuffer.signal
+}